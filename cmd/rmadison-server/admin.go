@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// maxJobs is the number of finished jobs kept in memory
+const maxJobs = 100
+
+type jobState string
+
+const (
+	jobRunning jobState = "running"
+	jobDone    jobState = "done"
+	jobFailed  jobState = "failed"
+)
+
+// refreshJob is a cache refresh requested via the admin API
+type refreshJob struct {
+	ID              string    `json:"id"`
+	Archive         string    `json:"archive"`
+	State           jobState  `json:"state"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at,omitempty"`
+	Files           int       `json:"files"`
+	UpdatedPackages int       `json:"updated_packages"`
+	Error           string    `json:"error,omitempty"`
+	// Report is the result of each index, once the job is finished
+	Report *archive.RefreshReport `json:"report,omitempty"`
+}
+
+// jobManager keeps track of the refresh jobs
+type jobManager struct {
+	lock sync.Mutex
+	jobs map[string]*refreshJob
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{
+		jobs: make(map[string]*refreshJob),
+	}
+}
+
+// get returns a copy of the job
+func (m *jobManager) get(id string) (refreshJob, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return refreshJob{}, false
+	}
+
+	return *job, true
+}
+
+// start runs a refresh of the archive in the background and returns the
+// job tracking it
+func (m *jobManager) start(cache *archive.Archive) refreshJob {
+	job := &refreshJob{
+		ID:        server.RandomID(),
+		Archive:   cache.Name,
+		State:     jobRunning,
+		StartedAt: time.Now(),
+	}
+
+	m.lock.Lock()
+	m.prune()
+	m.jobs[job.ID] = job
+	started := *job
+	m.lock.Unlock()
+
+	go func() {
+		report, err := cache.RefreshCache(false)
+		if err != nil {
+			log.Errorf("[admin][%v] refresh job %v failed: %v", cache.Name, job.ID, err)
+		} else {
+			log.Infof("[admin][%v] refresh job %v done, %v packages updated", cache.Name, job.ID, report.UpdatedPackages)
+		}
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		job.FinishedAt = time.Now()
+		job.Files = report.Files
+		job.UpdatedPackages = report.UpdatedPackages
+		job.Report = report
+		job.State = jobDone
+		if err != nil {
+			job.State = jobFailed
+			job.Error = err.Error()
+		}
+	}()
+
+	return started
+}
+
+// prune removes the oldest finished jobs to keep at most maxJobs jobs,
+// m.lock must be held
+func (m *jobManager) prune() {
+	if len(m.jobs) < maxJobs {
+		return
+	}
+
+	finished := make([]*refreshJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if job.State != jobRunning {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].StartedAt.Before(finished[j].StartedAt)
+	})
+
+	for i := 0; i < len(finished) && len(m.jobs) >= maxJobs; i++ {
+		delete(m.jobs, finished[i].ID)
+	}
+}
+
+// requireAdmin wraps an admin handler and checks the bearer token
+func (h httpHandler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := []byte("Bearer " + h.AdminToken)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing admin token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// serveAdminRefresh starts a refresh of the archive given in parameter
+func (h httpHandler) serveAdminRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	cache := h.Archives.Get(r.URL.Query().Get("archive"))
+	if cache == nil {
+		writeError(w, http.StatusNotFound, "unknown archive %q", r.URL.Query().Get("archive"))
+		return
+	}
+
+	job := h.Jobs.start(cache)
+	requestLogger(r).Infof("[admin][%v] started refresh job %v", cache.Name, job.ID)
+
+	w.Header().Add("Location", "/admin/jobs/"+job.ID)
+	writeJSON(w, r, http.StatusAccepted, job)
+}
+
+// serveAdminJob returns the state of a refresh job
+func (h httpHandler) serveAdminJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Jobs.get(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, job)
+}
+
+// serveAdminArchives lists the archives (GET) or adds a new one (POST)
+func (h httpHandler) serveAdminArchives(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		registered := h.Archives.All()
+		confs := make([]*archiveYAMLConf, len(registered))
+		for i, entry := range registered {
+			confs[i] = entry.conf
+		}
+
+		writeJSON(w, r, http.StatusOK, confs)
+	case http.MethodPost:
+		archiveConf := new(archiveYAMLConf)
+		err := json.NewDecoder(r.Body).Decode(archiveConf)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		err = h.Archives.Add(archiveConf)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		requestLogger(r).Infof("[admin][%v] archive added", archiveConf.Name)
+
+		writeJSON(w, r, http.StatusCreated, archiveConf)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+	}
+}
+
+// serveAdminArchive disables or enables an archive (POST ?disabled=true|false)
+// or removes it (DELETE)
+func (h httpHandler) serveAdminArchive(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/archives/")
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		disabled, parseErr := strconv.ParseBool(r.URL.Query().Get("disabled"))
+		if parseErr != nil {
+			writeError(w, http.StatusBadRequest, "invalid disabled parameter")
+			return
+		}
+		err = h.Archives.SetDisabled(name, disabled)
+	case http.MethodDelete:
+		err = h.Archives.Remove(name)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	if errors.Is(err, errArchiveNotFound) {
+		writeError(w, http.StatusNotFound, "unknown archive %q", name)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Errorf("[admin][%v] failed to update archive: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	requestLogger(r).Infof("[admin][%v] archive updated (%v)", name, r.Method)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+)
+
+type archiveInfo struct {
+	Name     string   `json:"name"`
+	BaseURL  string   `json:"base_url"`
+	PortsURL string   `json:"ports_url"`
+	Pockets  []string `json:"pockets"`
+	Packages int      `json:"packages"`
+	Disabled bool     `json:"disabled"`
+	archive.RefreshStatus
+}
+
+// serveArchives lists the configured archives and the status of their cache
+func (h httpHandler) serveArchives(w http.ResponseWriter, r *http.Request) {
+	registered := h.Archives.All()
+	archives := make([]archiveInfo, len(registered))
+	for i, cache := range registered {
+		nbPackages := 0
+		if h.Archives.Health(cache) == "" {
+			var err error
+			nbPackages, err = cache.Database.CountPackages()
+			if err != nil {
+				requestLogger(r).Errorf("failed to count packages in %v: %v", cache.Name, err)
+				writeError(w, http.StatusInternalServerError, "failed to count packages of %v", cache.Name)
+				return
+			}
+		}
+
+		pockets := cache.CurrentPockets()
+		if pockets == nil {
+			pockets = cache.Pockets
+		}
+
+		archives[i] = archiveInfo{
+			Name:          cache.Name,
+			BaseURL:       cache.BaseURL.String(),
+			PortsURL:      cache.PortsURL.String(),
+			Pockets:       pockets,
+			Packages:      nbPackages,
+			Disabled:      cache.conf.Disabled,
+			RefreshStatus: cache.Status(),
+		}
+	}
+
+	header := []string{
+		"name", "base_url", "ports_url", "pockets", "packages", "disabled",
+		"last_refresh", "last_refresh_duration", "last_error", "updated_packages",
+	}
+	records := make([][]string, len(archives))
+	for i, info := range archives {
+		records[i] = []string{
+			info.Name,
+			info.BaseURL,
+			info.PortsURL,
+			joinList(info.Pockets),
+			strconv.Itoa(info.Packages),
+			strconv.FormatBool(info.Disabled),
+			info.LastRefresh.Format(time.RFC3339),
+			strconv.FormatFloat(info.LastDuration, 'f', -1, 64),
+			info.LastError,
+			strconv.Itoa(info.UpdatedPackages),
+		}
+	}
+
+	writeList(w, r, archives, header, records)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// maxBatchPackages is the maximum number of packages of a batch lookup
+const maxBatchPackages = 10000
+
+// batchRequest is the body of a batch lookup
+type batchRequest struct {
+	Packages []string `json:"packages"`
+}
+
+// serveBatch looks up several packages at once, the packages are filtered
+// with the same query parameters as the lookup endpoint. The response maps
+// each package to its versions, unknown packages map to an empty list.
+func (h httpHandler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	req := new(batchRequest)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if len(req.Packages) > maxBatchPackages {
+		writeError(w, http.StatusBadRequest, "too many packages (at most %v)", maxBatchPackages)
+		return
+	}
+
+	lookup := h.lookup
+	if withBinaries, _ := strconv.ParseBool(r.URL.Query().Get("source_and_binary")); withBinaries {
+		lookup = h.lookupWithBinaries
+	}
+	results := make(map[string][]*debianpkg.PackageInfo, len(req.Packages))
+	for _, pkg := range req.Packages {
+		allInfo, err := lookup(r, pkg)
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+
+		allInfo, err = filterPackages(r, allInfo)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		h.Overlay.Annotate(allInfo)
+
+		results[pkg] = allInfo
+	}
+
+	writeJSON(w, r, http.StatusOK, results)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// builtBinary is a binary package built from a source package
+type builtBinary struct {
+	Archive      string `json:"archive"`
+	Suite        string `json:"suite"`
+	Name         string `json:"name"`
+	Architecture string `json:"architecture"`
+	Version      string `json:"version"`
+}
+
+// serveBuiltFrom returns the binaries published from an exact version of
+// a source package, e.g. to check that a security rebuild was fully
+// published
+func (h httpHandler) serveBuiltFrom(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	source := query.Get("source")
+	version := query.Get("version")
+	if source == "" || version == "" {
+		writeError(w, http.StatusBadRequest, "source and version are required")
+		return
+	}
+
+	binaries := make([]builtBinary, 0)
+	for _, cache := range h.Archives.Enabled() {
+		endSpan := startSpan(r, "db.GetPackagesBySource "+cache.Name)
+		pkgs, err := cache.Database.GetPackagesBySource(source)
+		endSpan()
+		if err != nil {
+			requestLogger(r).Errorf("failed to get binaries of %v in %v: %v", source, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to get the binaries of %v", source)
+			return
+		}
+
+		pkgs = filterFields(query, pkgs)
+		h.redact(r.Context(), cache, pkgs)
+		for _, pkg := range pkgs {
+			name, sourceVersion := pkg.SourceNameVersion()
+			if name != source || sourceVersion != version {
+				continue
+			}
+
+			binaries = append(binaries, builtBinary{
+				Archive:      cache.Name,
+				Suite:        h.publicSuite(cache, pkg.Suite+pkg.Pocket),
+				Name:         pkg.Name,
+				Architecture: pkg.Architecture,
+				Version:      pkg.Version,
+			})
+		}
+	}
+
+	sort.Slice(binaries, func(i, j int) bool {
+		if binaries[i].Suite != binaries[j].Suite {
+			return binaries[i].Suite < binaries[j].Suite
+		}
+		if binaries[i].Name != binaries[j].Name {
+			return binaries[i].Name < binaries[j].Name
+		}
+		return binaries[i].Architecture < binaries[j].Architecture
+	})
+
+	header := []string{"archive", "suite", "name", "architecture", "version"}
+	records := make([][]string, len(binaries))
+	for i, binary := range binaries {
+		records[i] = []string{binary.Archive, binary.Suite, binary.Name, binary.Architecture, binary.Version}
+	}
+
+	writeList(w, r, binaries, header, records)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// cacheClasses are the classes of endpoints by path prefix (the longest
+// prefix wins) for the Cache-Control headers. The streams have no class,
+// they are never cached.
+var cacheClasses = map[string]string{
+	"/":             "lookups",
+	"/api/dump":     "dumps",
+	"/api/export":   "dumps",
+	"/api/snapshot": "dumps",
+	"/api/archives": "status",
+	"/api/suites":   "status",
+	"/api/stats":    "status",
+	"/api/metrics":  "status",
+	"/admin/":       "admin",
+	"/api/events":   "",
+	"/api/wait":     "",
+
+	// the watchlists change with the requests of their owners
+	"/api/watchlists": "admin",
+}
+
+// defaultCacheControl keeps the admin responses out of the caches
+var defaultCacheControl = server.CacheControlConfig{
+	"admin": 0,
+}
+
+// cacheControlConfig returns the max ages of the classes, the ones of conf
+// override the defaults
+func cacheControlConfig(conf server.CacheControlConfig) server.CacheControlConfig {
+	maxAge := make(server.CacheControlConfig, len(defaultCacheControl)+len(conf))
+	for class, age := range defaultCacheControl {
+		maxAge[class] = age
+	}
+	for class, age := range conf {
+		maxAge[class] = age
+	}
+
+	return maxAge
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// diffEntry is a package that differs between two suites
+type diffEntry struct {
+	Name       string `json:"name"`
+	Change     string `json:"change"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+// suiteVersions returns the highest version of each package of a suite
+// across the archives, the suite of the filter is a public name
+func (h httpHandler) suiteVersions(ctx context.Context, archives []*archive.Archive, filter database.Filter) (map[string]string, error) {
+	versions := make(map[string]string)
+	for _, cache := range archives {
+		err := cache.Database.ScanAll(ctx, database.CurrentGeneration, h.archiveFilter(cache, filter), func(pkg *debianpkg.PackageInfo) error {
+			current, ok := versions[pkg.Name]
+			if !ok || version.Compare(pkg.Version, current) > 0 {
+				versions[pkg.Name] = pkg.Version
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return versions, nil
+}
+
+// diffVersions compares the versions of the packages of two suites
+func diffVersions(from, to map[string]string) []diffEntry {
+	entries := make([]diffEntry, 0)
+	for name, oldVersion := range from {
+		newVersion, ok := to[name]
+		if !ok {
+			entries = append(entries, diffEntry{Name: name, Change: "removed", OldVersion: oldVersion})
+			continue
+		}
+
+		cmp := version.Compare(newVersion, oldVersion)
+		if cmp > 0 {
+			entries = append(entries, diffEntry{name, "upgraded", oldVersion, newVersion})
+		} else if cmp < 0 {
+			entries = append(entries, diffEntry{name, "downgraded", oldVersion, newVersion})
+		}
+	}
+	for name, newVersion := range to {
+		if _, ok := from[name]; !ok {
+			entries = append(entries, diffEntry{Name: name, Change: "added", NewVersion: newVersion})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+// serveDiff returns the packages added, removed, upgraded and downgraded
+// between two suites, optionally restricted to an archive, an
+// architecture and a component. Only the names and versions are returned,
+// they are never redacted.
+func (h httpHandler) serveDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := query.Get("from")
+	to := query.Get("to")
+	if from == "" || to == "" {
+		writeError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	archives := h.Archives.Enabled()
+	if name := query.Get("archive"); name != "" {
+		cache := h.Archives.Get(name)
+		if cache == nil {
+			writeError(w, http.StatusNotFound, "unknown archive %q", name)
+			return
+		}
+		archives = []*archive.Archive{cache}
+	}
+
+	filter := database.Filter{
+		Component:    query.Get("component"),
+		Architecture: query.Get("arch"),
+	}
+
+	filter.Suite = from
+	fromVersions, err := h.suiteVersions(r.Context(), archives, filter)
+	if err != nil {
+		requestLogger(r).Errorf("failed to list packages of %v: %v", from, err)
+		writeError(w, http.StatusInternalServerError, "failed to list the packages of %v", from)
+		return
+	}
+
+	filter.Suite = to
+	toVersions, err := h.suiteVersions(r.Context(), archives, filter)
+	if err != nil {
+		requestLogger(r).Errorf("failed to list packages of %v: %v", to, err)
+		writeError(w, http.StatusInternalServerError, "failed to list the packages of %v", to)
+		return
+	}
+
+	entries := diffVersions(fromVersions, toVersions)
+
+	header := []string{"name", "change", "old_version", "new_version"}
+	records := make([][]string, len(entries))
+	for i, entry := range entries {
+		records[i] = []string{entry.Name, entry.Change, entry.OldVersion, entry.NewVersion}
+	}
+
+	writeList(w, r, entries, header, records)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// errLimitReached stops the iteration over the packages
+var errLimitReached = errors.New("limit reached")
+
+type dumpEntry struct {
+	Archive      string `json:"archive"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	Suite        string `json:"suite"`
+	Component    string `json:"component"`
+}
+
+// serveDump streams every package of a suite as NDJSON (one JSON object
+// per line), CSV, TSV or a stream of msgpack/CBOR objects. The packages
+// are read from the DB and written as they come.
+func (h httpHandler) serveDump(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.Filter{
+		Suite:        query.Get("suite"),
+		Component:    query.Get("component"),
+		Architecture: query.Get("arch"),
+	}
+	if filter.Suite == "" {
+		writeError(w, http.StatusBadRequest, "suite is required")
+		return
+	}
+	limit, err := resultLimit(r, h.Limits.MaxDumpRows)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	format := query.Get("format")
+	var (
+		encode func(dumpEntry) error
+		flush  func()
+	)
+	if _, _, ok := tableFormat(format); ok {
+		writer := newTableWriter(w, format)
+		writer.Write([]string{"archive", "name", "version", "architecture", "suite", "component"})
+		encode = func(entry dumpEntry) error {
+			return writer.Write([]string{entry.Archive, entry.Name, entry.Version, entry.Architecture, entry.Suite, entry.Component})
+		}
+		flush = writer.Flush
+		defer writer.Flush()
+	} else if contentType, newEncoder, ok := binaryEncoding(r, true); ok && format == "" {
+		w.Header().Add("Content-Type", contentType)
+		encoder := newEncoder(w)
+		encode = func(entry dumpEntry) error {
+			return encoder.Encode(entry)
+		}
+		flush = func() {}
+	} else if format == "" || format == "ndjson" {
+		w.Header().Add("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		encode = func(entry dumpEntry) error {
+			return encoder.Encode(entry)
+		}
+		flush = func() {}
+	} else {
+		writeError(w, http.StatusBadRequest, "unsupported format %q", format)
+		return
+	}
+
+	// the stream is truncated when the limit is reached, it's only known
+	// at the end
+	w.Header().Set("Trailer", "X-Truncated")
+	truncated := false
+	defer func() {
+		w.Header().Set("X-Truncated", strconv.FormatBool(truncated))
+	}()
+
+	rc := http.NewResponseController(w)
+
+	n := 0
+	for _, cache := range h.Archives.Enabled() {
+		archiveFilter := h.archiveFilter(cache, filter)
+		err := cache.Database.ScanAll(r.Context(), database.CurrentGeneration, archiveFilter, func(pkg *debianpkg.PackageInfo) error {
+			if limit > 0 && n == limit {
+				truncated = true
+				return errLimitReached
+			}
+
+			n++
+			if n%1000 == 0 {
+				flush()
+				rc.Flush()
+			}
+
+			return encode(dumpEntry{
+				Archive:      cache.Name,
+				Name:         pkg.Name,
+				Version:      pkg.Version,
+				Architecture: pkg.Architecture,
+				Suite:        h.publicSuite(cache, pkg.Suite+pkg.Pocket),
+				Component:    pkg.Component,
+			})
+		})
+		if err == errLimitReached {
+			return
+		}
+		if err != nil {
+			// the status has already been sent, all we can do is
+			// stopping the stream
+			requestLogger(r).Errorf("failed to dump %v: %v", cache.Name, err)
+			return
+		}
+	}
+}
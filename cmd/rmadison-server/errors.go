@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// error codes of the API, clients should rely on them rather than on the
+// messages
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "upstream_error",
+	http.StatusServiceUnavailable:  "unavailable",
+	http.StatusGatewayTimeout:      "timeout",
+}
+
+// apiError is the body of the error responses:
+// {"error": {"code": "not_found", "message": "..."}}
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes an error response, always in JSON so that clients can
+// parse it whatever format they asked for
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{apiError{Code: code, Message: fmt.Sprintf(format, args...)}})
+}
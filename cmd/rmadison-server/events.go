@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+)
+
+// eventBufferSize is the number of events kept for a subscriber that
+// doesn't read them fast enough, further events are dropped
+const eventBufferSize = 1000
+
+// keepAliveInterval is the time between two comments sent on idle event
+// streams, they prevent proxies from closing the connection
+const keepAliveInterval = 30 * time.Second
+
+// eventBroker dispatches the package changes detected by the refreshes to
+// the clients of /api/events
+type eventBroker struct {
+	lock        sync.Mutex
+	subscribers map[chan archive.PackageChange]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[chan archive.PackageChange]struct{}),
+	}
+}
+
+// Publish sends the change to all the subscribers, it never blocks
+func (b *eventBroker) Publish(change archive.PackageChange) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- change:
+		default:
+			log.Warnf("[events] subscriber too slow, dropping event for %v", change.Name)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all the changes published until
+// Unsubscribe is called
+func (b *eventBroker) Subscribe() chan archive.PackageChange {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	events := make(chan archive.PackageChange, eventBufferSize)
+	b.subscribers[events] = struct{}{}
+
+	return events
+}
+
+// Unsubscribe stops sending changes to the channel
+func (b *eventBroker) Unsubscribe(events chan archive.PackageChange) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.subscribers, events)
+}
+
+// serveEvents streams the package changes as server-sent events, they can
+// be filtered with the package and suite parameters
+func (h httpHandler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pkg := query.Get("package")
+	suite := query.Get("suite")
+
+	rc := http.NewResponseController(w)
+	// the stream is never complete, don't apply the server write timeout
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		requestLogger(r).Errorf("cannot stream events: %v", err)
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events := h.Events.Subscribe()
+	defer h.Events.Unsubscribe(events)
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case change := <-events:
+			change = h.publicChange(change)
+			if (pkg != "" && change.Name != pkg) || (suite != "" && change.Suite != suite) {
+				continue
+			}
+
+			data, err := json.Marshal(change)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: package\ndata: %s\n\n", data)
+			if err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		rc.Flush()
+	}
+}
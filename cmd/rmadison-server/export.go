@@ -0,0 +1,80 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// serveExport streams the packages of a suite and an architecture as a
+// Packages index (optionally gzip compressed with compression=gzip), from
+// one archive or merged from all of them. The versions present in several
+// archives are only written once.
+func (h httpHandler) serveExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.Filter{
+		Suite:        query.Get("suite"),
+		Component:    query.Get("component"),
+		Architecture: query.Get("arch"),
+	}
+	if filter.Suite == "" || filter.Architecture == "" {
+		writeError(w, http.StatusBadRequest, "suite and arch are required")
+		return
+	}
+
+	archives := h.Archives.Enabled()
+	if name := query.Get("archive"); name != "" {
+		cache := h.Archives.Get(name)
+		if cache == nil {
+			writeError(w, http.StatusNotFound, "archive %v not found", name)
+			return
+		}
+		archives = []*archive.Archive{cache}
+	}
+
+	var out io.Writer = w
+	switch query.Get("compression") {
+	case "":
+		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	case "gzip":
+		w.Header().Add("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported compression %q", query.Get("compression"))
+		return
+	}
+
+	seen := make(map[[3]string]bool)
+	first := true
+	for _, cache := range archives {
+		archiveFilter := h.archiveFilter(cache, filter)
+		err := cache.Database.ScanAll(r.Context(), database.CurrentGeneration, archiveFilter, func(pkg *debianpkg.PackageInfo) error {
+			key := [3]string{pkg.Name, pkg.Version, pkg.Architecture}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+
+			h.redact(r.Context(), cache, []*debianpkg.PackageInfo{pkg})
+			h.translateSuites(cache, []*debianpkg.PackageInfo{pkg})
+			if !first {
+				io.WriteString(out, "\n")
+			}
+			first = false
+
+			return pkg.WritePackagesStanza(out)
+		})
+		if err != nil {
+			// the status has already been sent, all we can do is
+			// stopping the stream
+			requestLogger(r).Errorf("failed to export %v from %v: %v", filter.Suite, cache.Name, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestExport(t *testing.T) {
+	router := newRouter(newTestHandler(t,
+		&debianpkg.PackageInfo{
+			Name: "python3-six", Version: "1.16.0-4", Suite: "noble", Component: "main", Architecture: "amd64",
+			PackageArchitecture: "all", MultiArch: "foreign", FileName: "pool/main/s/six/python3-six_1.16.0-4_all.deb",
+			Depends: []string{"python3:any"}, Provides: []string{"python3.12-six"},
+			Recommends: []string{"python3-setuptools"}, Breaks: []string{"python-six (<< 1.16)"},
+		},
+		&debianpkg.PackageInfo{
+			Name: "bash", Version: "5.2-1", Suite: "noble", Component: "main", Architecture: "amd64",
+			PackageArchitecture: "amd64", Essential: "yes", BuiltUsing: "glibc (= 2.39-0ubuntu8)",
+		},
+	))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/export?suite=noble&arch=amd64", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %v", w.Code, w.Body.String())
+	}
+
+	stanzas := strings.Split(w.Body.String(), "\n\n")
+	if len(stanzas) != 2 {
+		t.Fatalf("expected 2 stanzas, got %q", w.Body.String())
+	}
+
+	tests := []struct {
+		stanza   int
+		expected []string
+	}{
+		{0, []string{"Package: bash\nArchitecture: amd64\n", "Essential: yes\n", "Built-Using: glibc (= 2.39-0ubuntu8)"}},
+		{1, []string{
+			"Package: python3-six\nArchitecture: all\n",
+			"Multi-Arch: foreign\n",
+			"Provides: python3.12-six\n",
+			"Depends: python3:any\nRecommends: python3-setuptools\n",
+			"Breaks: python-six (<< 1.16)\n",
+		}},
+	}
+
+	for _, test := range tests {
+		for _, expected := range test.expected {
+			if !strings.Contains(stanzas[test.stanza], expected) {
+				t.Errorf("stanza %v: expected %q in %q", test.stanza, expected, stanzas[test.stanza])
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+type fileInfo struct {
+	Archive string `json:"archive"`
+	*database.ContentsEntry
+}
+
+// serveFile returns the packages shipping a file, for the archives with
+// contents indexing enabled. The entries only have names, suites and
+// architectures: none of the redacted fields.
+func (h httpHandler) serveFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filePath := strings.TrimLeft(query.Get("path"), "/")
+	if filePath == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	filter := database.Filter{
+		Suite:        query.Get("suite"),
+		Architecture: query.Get("arch"),
+	}
+
+	files := make([]fileInfo, 0)
+	for _, cache := range h.Archives.Enabled() {
+		if !cache.Contents {
+			continue
+		}
+
+		entries, err := cache.Database.SearchFile(filePath, filter)
+		if err != nil {
+			requestLogger(r).Errorf("failed to search %v in %v: %v", filePath, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to search %v", filePath)
+			return
+		}
+
+		for _, entry := range entries {
+			files = append(files, fileInfo{cache.Name, entry})
+		}
+	}
+
+	header := []string{"archive", "path", "package", "component", "suite", "architecture"}
+	records := make([][]string, len(files))
+	for i, file := range files {
+		records[i] = []string{file.Archive, "/" + file.Path, file.Package, file.Component, file.Suite + file.Pocket, file.Architecture}
+	}
+
+	writeList(w, r, files, header, records)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/render"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// packageHeader is the header of the tables (CSV, TSV) of packages
+var packageHeader = []string{
+	"archive", "name", "version", "architecture", "suite", "component", "source",
+	"section", "size", "installed_size", "filename", "sha256",
+}
+
+// packageRecord is a row of the tables of packages
+func packageRecord(pkg *debianpkg.PackageInfo) []string {
+	return []string{
+		pkg.Archive,
+		pkg.Name,
+		pkg.Version,
+		pkg.Architecture,
+		pkg.Suite + pkg.Pocket,
+		pkg.Component,
+		pkg.Source,
+		pkg.Section,
+		strconv.Itoa(pkg.Size),
+		strconv.Itoa(pkg.InstalledSize),
+		pkg.FileName,
+		pkg.SHA256,
+	}
+}
+
+// tableFormat returns the content type and the separator for the table
+// formats (csv and tsv)
+func tableFormat(format string) (string, rune, bool) {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8", ',', true
+	case "tsv":
+		return "text/tab-separated-values; charset=utf-8", '\t', true
+	}
+
+	return "", 0, false
+}
+
+// newTableWriter returns a CSV writer using the separator of the format
+func newTableWriter(w http.ResponseWriter, format string) *csv.Writer {
+	contentType, separator, _ := tableFormat(format)
+	w.Header().Add("Content-Type", contentType)
+
+	writer := csv.NewWriter(w)
+	writer.Comma = separator
+
+	return writer
+}
+
+// streamEncoder encodes values one after the other in a stream
+type streamEncoder interface {
+	Encode(v interface{}) error
+}
+
+// binaryEncoding returns the content type and a new encoder for the compact
+// binary encoding accepted by the client (msgpack or CBOR), or false if the
+// client didn't ask for one
+func binaryEncoding(r *http.Request, stream bool) (string, func(io.Writer) streamEncoder, bool) {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		switch mediaType {
+		case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+			return mediaType, func(w io.Writer) streamEncoder {
+				encoder := msgpack.NewEncoder(w)
+				// use the same field names as JSON
+				encoder.SetCustomStructTag("json")
+				return encoder
+			}, true
+		case "application/cbor", "application/cbor-seq":
+			contentType := "application/cbor"
+			if stream {
+				// a sequence of CBOR items, see RFC 8742
+				contentType = "application/cbor-seq"
+			}
+			return contentType, func(w io.Writer) streamEncoder {
+				return cbor.NewEncoder(w)
+			}, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// writeJSON writes an object, in JSON by default (see writeResponse)
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	writeResponse(w, r, status, &render.Response{Value: v})
+}
+
+// writeList writes the response of a list-style endpoint: v is used by the
+// structured formats (JSON, msgpack, CBOR), header and records by the
+// table formats (CSV, TSV, text, HTML)
+func writeList(w http.ResponseWriter, r *http.Request, v interface{}, header []string, records [][]string) {
+	writeResponse(w, r, http.StatusOK, &render.Response{Value: v, Header: header, Records: records})
+}
+
+// writePackages writes a list of packages, deb822 is available on top of
+// the formats of writeList
+func writePackages(w http.ResponseWriter, r *http.Request, pkgs []*debianpkg.PackageInfo) {
+	writeResponse(w, r, http.StatusOK, packagesResponse(pkgs))
+}
+
+// packagesResponse is the response for a list of packages
+func packagesResponse(pkgs []*debianpkg.PackageInfo) *render.Response {
+	records := make([][]string, len(pkgs))
+	for i, pkg := range pkgs {
+		records[i] = packageRecord(pkg)
+	}
+
+	return &render.Response{Value: pkgs, Header: packageHeader, Records: records, Packages: pkgs}
+}
+
+// setResultHeaders sets the number of results and the time of the last
+// refresh of the archives, so HEAD requests can check the existence or
+// the freshness of the results without fetching them
+func (h httpHandler) setResultHeaders(w http.ResponseWriter, count int) {
+	w.Header().Set("X-Result-Count", strconv.Itoa(count))
+
+	var lastModified time.Time
+	for _, cache := range h.Archives.Enabled() {
+		if refreshed := cache.Status().LastRefresh; refreshed.After(lastModified) {
+			lastModified = refreshed
+		}
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// joinList formats a list in a table cell
+func joinList(list []string) string {
+	return strings.Join(list, " ")
+}
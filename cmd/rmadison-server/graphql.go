@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// graphqlSchema exposes the packages of the archives, sources can be
+// followed to the binaries they build: source -> binaries -> versions
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	# the package in all the suites, optionally restricted to a suite
+	# (e.g. noble-updates) and an architecture
+	packages(name: String!, suite: String, arch: String): [Package!]!
+	# the source package (all its versions if version is not set)
+	source(name: String!, version: String): Source!
+	suites: [String!]!
+}
+
+type Package {
+	archive: String!
+	name: String!
+	version: String!
+	suite: String!
+	component: String!
+	architecture: String!
+	section: String!
+	priority: String!
+	maintainer: String!
+	filename: String!
+	# the .deb in bytes and the installed size in kB
+	size: Float!
+	installedSize: Float!
+	sha256: String!
+	depends: [String!]!
+	description: String!
+	homepage: String!
+	source: Source!
+}
+
+type Source {
+	name: String!
+	version: String
+	binaries(suite: String, arch: String): [Package!]!
+}
+`
+
+// graphqlResolver is the root resolver of the GraphQL schema
+type graphqlResolver struct {
+	h httpHandler
+}
+
+// newGraphQLHandler returns the handler of the GraphQL endpoint
+func newGraphQLHandler(h httpHandler) *relay.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{h})
+
+	return &relay.Handler{Schema: schema}
+}
+
+type packagesArgs struct {
+	Name  string
+	Suite *string
+	Arch  *string
+}
+
+// matches checks the optional suite and architecture filters
+func matches(pkg *debianpkg.PackageInfo, suite, arch *string) bool {
+	if suite != nil && pkg.Suite+pkg.Pocket != *suite {
+		return false
+	}
+	if arch != nil && pkg.Architecture != *arch {
+		return false
+	}
+
+	return true
+}
+
+func (r *graphqlResolver) Packages(ctx context.Context, args packagesArgs) ([]*packageResolver, error) {
+	packages := make([]*packageResolver, 0)
+	for _, cache := range r.h.Archives.Enabled() {
+		allInfo, err := cache.Database.GetPackage(args.Name)
+		if err != nil {
+			return nil, err
+		}
+		r.h.redact(ctx, cache, allInfo)
+		r.h.translateSuites(cache, allInfo)
+
+		for _, info := range allInfo {
+			if matches(info, args.Suite, args.Arch) {
+				packages = append(packages, &packageResolver{r, cache, info})
+			}
+		}
+	}
+
+	return packages, nil
+}
+
+type sourceArgs struct {
+	Name    string
+	Version *string
+}
+
+func (r *graphqlResolver) Source(args sourceArgs) *sourceResolver {
+	return &sourceResolver{r, args.Name, args.Version}
+}
+
+func (r *graphqlResolver) Suites() ([]string, error) {
+	seen := make(map[string]bool)
+	suites := make([]string, 0)
+	for _, cache := range r.h.Archives.Enabled() {
+		for _, pocket := range cache.CurrentPockets() {
+			if !seen[pocket] {
+				seen[pocket] = true
+				suites = append(suites, pocket)
+			}
+		}
+	}
+
+	return suites, nil
+}
+
+type packageResolver struct {
+	root  *graphqlResolver
+	cache *archive.Archive
+	info  *debianpkg.PackageInfo
+}
+
+func (p *packageResolver) Archive() string      { return p.cache.Name }
+func (p *packageResolver) Name() string         { return p.info.Name }
+func (p *packageResolver) Version() string      { return p.info.Version }
+func (p *packageResolver) Suite() string        { return p.info.Suite + p.info.Pocket }
+func (p *packageResolver) Component() string    { return p.info.Component }
+func (p *packageResolver) Architecture() string { return p.info.Architecture }
+func (p *packageResolver) Section() string      { return p.info.Section }
+func (p *packageResolver) Priority() string     { return p.info.Priority }
+func (p *packageResolver) Filename() string     { return p.info.FileName }
+func (p *packageResolver) Sha256() string       { return p.info.SHA256 }
+func (p *packageResolver) Description() string  { return p.info.Description }
+func (p *packageResolver) Homepage() string     { return p.info.Homepage }
+
+// the sizes of the large packages (firmware, debug symbols) overflow the
+// 32 bits of the GraphQL Int
+func (p *packageResolver) Size() float64          { return float64(p.info.Size) }
+func (p *packageResolver) InstalledSize() float64 { return float64(p.info.InstalledSize) }
+
+func (p *packageResolver) Maintainer() string {
+	if p.info.Maintainer == nil {
+		return ""
+	}
+
+	return p.info.Maintainer.Name + " <" + p.info.Maintainer.Email + ">"
+}
+
+func (p *packageResolver) Depends() []string {
+	depends := make([]string, 0, len(p.info.Depends))
+	for _, depend := range p.info.Depends {
+		if depend != "" {
+			depends = append(depends, depend)
+		}
+	}
+
+	return depends
+}
+
+func (p *packageResolver) Source() *sourceResolver {
+	name, version := p.info.SourceNameVersion()
+
+	return &sourceResolver{p.root, name, &version}
+}
+
+type sourceResolver struct {
+	root    *graphqlResolver
+	name    string
+	version *string
+}
+
+func (s *sourceResolver) Name() string     { return s.name }
+func (s *sourceResolver) Version() *string { return s.version }
+
+type binariesArgs struct {
+	Suite *string
+	Arch  *string
+}
+
+func (s *sourceResolver) Binaries(ctx context.Context, args binariesArgs) ([]*packageResolver, error) {
+	binaries := make([]*packageResolver, 0)
+	for _, cache := range s.root.h.Archives.Enabled() {
+		allInfo, err := cache.Database.GetPackagesBySource(s.name)
+		if err != nil {
+			return nil, err
+		}
+		s.root.h.redact(ctx, cache, allInfo)
+		s.root.h.translateSuites(cache, allInfo)
+
+		for _, info := range allInfo {
+			name, version := info.SourceNameVersion()
+			if name != s.name || (s.version != nil && version != *s.version) {
+				continue
+			}
+			if matches(info, args.Suite, args.Arch) {
+				binaries = append(binaries, &packageResolver{s.root, cache, info})
+			}
+		}
+	}
+
+	return binaries, nil
+}
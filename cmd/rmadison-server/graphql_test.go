@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+func TestGraphQLPackages(t *testing.T) {
+	h := newTestHandler(t,
+		// larger than the 32 bits of a GraphQL Int
+		&debianpkg.PackageInfo{Name: "firmware-big", Version: "1.0", Suite: "noble", Component: "main", Architecture: "amd64", Size: 3 << 30, InstalledSize: 4 << 20, Source: "firmware"},
+		&debianpkg.PackageInfo{Name: "firmware-small", Version: "1.0", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64", Size: 1024, Source: "firmware"},
+	)
+	// the resolvers are checked against the schema
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{h})
+
+	resp := schema.Exec(context.Background(), `{
+		packages(name: "firmware-big", suite: "noble") {
+			version size installedSize
+			source { name binaries(suite: "noble-updates") { name } }
+		}
+	}`, "", nil)
+	if len(resp.Errors) != 0 {
+		t.Fatal(resp.Errors)
+	}
+
+	var data struct {
+		Packages []struct {
+			Version       string
+			Size          float64
+			InstalledSize float64
+			Source        struct {
+				Name     string
+				Binaries []struct{ Name string }
+			}
+		}
+	}
+	err := json.Unmarshal(resp.Data, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Packages) != 1 {
+		t.Fatalf("expected 1 package, got %s", resp.Data)
+	}
+	pkg := data.Packages[0]
+	if pkg.Size != 3<<30 || pkg.InstalledSize != 4<<20 {
+		t.Errorf("unexpected sizes %v and %v", pkg.Size, pkg.InstalledSize)
+	}
+	if pkg.Source.Name != "firmware" || len(pkg.Source.Binaries) != 1 || pkg.Source.Binaries[0].Name != "firmware-small" {
+		t.Errorf("unexpected source %+v", pkg.Source)
+	}
+}
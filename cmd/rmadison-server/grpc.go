@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/rpc"
+	"github.com/gjolly/go-rmadison/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the gRPC service over the same archives as the
+// HTTP API
+type grpcServer struct {
+	rpc.UnimplementedRmadisonServer
+
+	h httpHandler
+}
+
+// newGRPCServer returns the gRPC server of the service, with the load
+// shedding, the timeouts and the access log of the HTTP API
+func newGRPCServer(h httpHandler, conf *Config) *grpc.Server {
+	m := &grpcMiddleware{
+		archives:  h.Archives,
+		limiter:   h.Limiter,
+		timeouts:  server.NewRouteTimeouts(defaultRouteTimeouts, conf.Timeouts),
+		accessLog: conf.AccessLog,
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(m.unary),
+		grpc.ChainStreamInterceptor(m.stream),
+	)
+	rpc.RegisterRmadisonServer(s, &grpcServer{h: h})
+
+	return s
+}
+
+// startGRPCServer serves the gRPC service on addr
+func startGRPCServer(addr string, s *grpc.Server) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("failed to listen on %v: %v", addr, err)
+	}
+
+	log.Infof("starting grpc server on %v\n", addr)
+	log.Fatal(s.Serve(listener))
+}
+
+// privileged marks the context of the calls with a privileged token in
+// the authorization metadata, see withPrivileges
+func (s *grpcServer) privileged(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if s.h.isPrivileged(authorization) {
+			return server.WithPrivileges(ctx)
+		}
+	}
+
+	return ctx
+}
+
+func toFilter(filter *rpc.Filter) database.Filter {
+	return database.Filter{
+		Suite:        filter.GetSuite(),
+		Component:    filter.GetComponent(),
+		Architecture: filter.GetArchitecture(),
+	}
+}
+
+// matchesFilter checks a package against the filter of a request
+func matchesFilter(pkg *debianpkg.PackageInfo, filter database.Filter) bool {
+	return (filter.Suite == "" || pkg.Suite+pkg.Pocket == filter.Suite) &&
+		(filter.Component == "" || pkg.Component == filter.Component) &&
+		(filter.Architecture == "" || pkg.Architecture == filter.Architecture)
+}
+
+func toPackage(archiveName string, pkg *debianpkg.PackageInfo) *rpc.Package {
+	depends := make([]string, 0, len(pkg.Depends))
+	for _, depend := range pkg.Depends {
+		if depend != "" {
+			depends = append(depends, depend)
+		}
+	}
+
+	return &rpc.Package{
+		Archive:       archiveName,
+		Name:          pkg.Name,
+		Version:       pkg.Version,
+		Suite:         pkg.Suite + pkg.Pocket,
+		Component:     pkg.Component,
+		Architecture:  pkg.Architecture,
+		Source:        pkg.Source,
+		Section:       pkg.Section,
+		Priority:      pkg.Priority,
+		Filename:      pkg.FileName,
+		Size:          int64(pkg.Size),
+		InstalledSize: int64(pkg.InstalledSize),
+		Sha256:        pkg.SHA256,
+		Depends:       depends,
+		Description:   pkg.Description,
+		Homepage:      pkg.Homepage,
+	}
+}
+
+func (s *grpcServer) Lookup(ctx context.Context, req *rpc.LookupRequest) (*rpc.LookupResponse, error) {
+	filter := toFilter(req.GetFilter())
+
+	ctx = s.privileged(ctx)
+	resp := new(rpc.LookupResponse)
+	for _, cache := range s.h.Archives.Enabled() {
+		for _, name := range req.GetNames() {
+			allInfo, err := cache.Database.GetPackage(name)
+			if err != nil {
+				log.Errorf("[grpc] failed to get %v from %v: %v", name, cache.Name, err)
+				return nil, status.Error(codes.Internal, "failed to get package")
+			}
+			s.h.redact(ctx, cache, allInfo)
+			s.h.translateSuites(cache, allInfo)
+
+			for _, info := range allInfo {
+				if matchesFilter(info, filter) {
+					resp.Packages = append(resp.Packages, toPackage(cache.Name, info))
+				}
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *grpcServer) Search(req *rpc.SearchRequest, stream rpc.Rmadison_SearchServer) error {
+	if req.GetPattern() == "" {
+		return status.Error(codes.InvalidArgument, "missing pattern")
+	}
+	filter := toFilter(req.GetFilter())
+
+	ctx := s.privileged(stream.Context())
+	sent := 0
+	for _, cache := range s.h.Archives.Enabled() {
+		found, err := cache.Database.SearchPackages(req.GetPattern(), s.h.archiveFilter(cache, filter), s.h.Limits.MaxSearchResults-sent)
+		if err != nil {
+			log.Errorf("[grpc] failed to search %v in %v: %v", req.GetPattern(), cache.Name, err)
+			return status.Error(codes.Internal, "failed to search")
+		}
+		s.h.redact(ctx, cache, found)
+		s.h.translateSuites(cache, found)
+
+		for _, info := range found {
+			err = stream.Send(toPackage(cache.Name, info))
+			if err != nil {
+				return err
+			}
+		}
+
+		sent += len(found)
+		if sent >= s.h.Limits.MaxSearchResults {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *grpcServer) Dump(req *rpc.DumpRequest, stream rpc.Rmadison_DumpServer) error {
+	filter := toFilter(req.GetFilter())
+	if filter.Suite == "" {
+		return status.Error(codes.InvalidArgument, "missing suite")
+	}
+
+	archives := s.h.Archives.Enabled()
+	if req.GetArchive() != "" {
+		cache := s.h.Archives.Get(req.GetArchive())
+		if cache == nil {
+			return status.Error(codes.NotFound, "archive not found")
+		}
+		archives = []*archive.Archive{cache}
+	}
+
+	ctx := s.privileged(stream.Context())
+	for _, cache := range archives {
+		archiveFilter := s.h.archiveFilter(cache, filter)
+		err := cache.Database.ScanAll(ctx, database.CurrentGeneration, archiveFilter, func(pkg *debianpkg.PackageInfo) error {
+			s.h.redact(ctx, cache, []*debianpkg.PackageInfo{pkg})
+			s.h.translateSuites(cache, []*debianpkg.PackageInfo{pkg})
+			return stream.Send(toPackage(cache.Name, pkg))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *grpcServer) WatchUpdates(req *rpc.WatchRequest, stream rpc.Rmadison_WatchUpdatesServer) error {
+	events := s.h.Events.Subscribe()
+	defer s.h.Events.Unsubscribe(events)
+
+	for {
+		select {
+		case change := <-events:
+			if (req.GetName() != "" && change.Name != req.GetName()) || (req.GetSuite() != "" && change.Suite != req.GetSuite()) {
+				continue
+			}
+
+			err := stream.Send(&rpc.PackageChange{
+				Archive:      change.Archive,
+				Name:         change.Name,
+				Suite:        change.Suite,
+				Component:    change.Component,
+				Architecture: change.Architecture,
+				OldVersion:   change.OldVersion,
+				Version:      change.Version,
+				Time:         timestamppb.New(change.Time),
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCLookup(t *testing.T) {
+	h := newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "hello", Version: "2.10-3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "hello", Version: "2.10-3ubuntu1", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+	)
+
+	listener := bufconn.Listen(1 << 20)
+	s := newGRPCServer(h, &Config{})
+	go s.Serve(listener)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := rpc.NewRmadisonClient(conn).Lookup(context.Background(), &rpc.LookupRequest{
+		Names:  []string{"hello"},
+		Filter: &rpc.Filter{Suite: "noble-updates"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Packages) != 1 || resp.Packages[0].Version != "2.10-3ubuntu1" || resp.Packages[0].Archive != "test" {
+		t.Errorf("unexpected packages %v", resp.Packages)
+	}
+}
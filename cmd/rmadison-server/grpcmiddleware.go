@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcRoutes are the HTTP routes whose timeouts apply to the gRPC methods
+var grpcRoutes = map[string]string{
+	"Lookup":       "/",
+	"Search":       "/api/search",
+	"Dump":         "/api/dump",
+	"WatchUpdates": "/api/events",
+}
+
+// unlimitedMethods are not counted in the requests in flight, like
+// unlimitedPaths
+var unlimitedMethods = map[string]bool{
+	"WatchUpdates": true,
+}
+
+// grpcMiddleware applies the limits, the timeouts and the access log of
+// the HTTP API to the gRPC calls
+type grpcMiddleware struct {
+	archives  *archiveRegistry
+	limiter   *server.LoadShedder
+	timeouts  *server.RouteTimeouts
+	accessLog server.AccessLogConfig
+}
+
+// grpcStream is a server stream with the context of the middleware
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+func (m *grpcMiddleware) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var resp interface{}
+	err := m.serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		resp, err = handler(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+func (m *grpcMiddleware) stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return m.serve(stream.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &grpcStream{ServerStream: stream, ctx: ctx})
+	})
+}
+
+// serve calls the method after waiting for a slot, with the deadline of
+// its route, and logs the call
+func (m *grpcMiddleware) serve(ctx context.Context, fullMethod string, call func(ctx context.Context) error) error {
+	start := time.Now()
+	method := path.Base(fullMethod)
+	if m.archives != nil {
+		defer m.archives.users.Leave(m.archives.users.Enter())
+	}
+
+	err := m.limit(ctx, method, func() error {
+		if timeout := m.timeouts.Timeout(grpcRoutes[method]); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return call(ctx)
+	})
+	m.log(ctx, method, start, err)
+
+	return err
+}
+
+// limit calls fn with a slot of the load shedder
+func (m *grpcMiddleware) limit(ctx context.Context, method string, fn func() error) error {
+	if !m.limiter.Enabled() || unlimitedMethods[method] {
+		return fn()
+	}
+
+	err := m.limiter.Acquire(ctx)
+	if err == server.ErrOverloaded {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.FromContextError(err).Err()
+	}
+	defer m.limiter.Release()
+
+	return fn()
+}
+
+// log writes the access log line of a call, the failed calls are always
+// logged
+func (m *grpcMiddleware) log(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	failed := code == codes.Internal || code == codes.Unknown
+	if !m.accessLog.Enabled && !failed {
+		return
+	}
+
+	id := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-request-id"); len(ids) != 0 && server.ValidRequestID(ids[0]) {
+		id = ids[0]
+	}
+	clientIP := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		clientIP = p.Addr.String()
+	}
+
+	log.Infow("grpc request",
+		"request_id", id,
+		"method", method,
+		"code", code.String(),
+		"latency", time.Now().Sub(start),
+		"client_ip", clientIP,
+	)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// firstSeenEntry is a suite where a version was seen
+type firstSeenEntry struct {
+	Archive string `json:"archive"`
+	*database.HistoryEntry
+}
+
+// firstSeen tells when and where a version first appeared, all the suites
+// where it has been seen are listed, the oldest first
+type firstSeen struct {
+	Package   string           `json:"package"`
+	Version   string           `json:"version"`
+	FirstSeen time.Time        `json:"first_seen"`
+	Archive   string           `json:"archive"`
+	Suite     string           `json:"suite"`
+	Seen      []firstSeenEntry `json:"seen"`
+}
+
+// serveFirstSeen returns when and where a version of a package was first
+// published in any of the suites indexed
+func (h httpHandler) serveFirstSeen(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pkg := query.Get("pkg")
+	version := query.Get("version")
+	if pkg == "" || version == "" {
+		writeError(w, http.StatusBadRequest, "pkg and version are required")
+		return
+	}
+
+	result := firstSeen{
+		Package: pkg,
+		Version: version,
+		Seen:    make([]firstSeenEntry, 0),
+	}
+	for _, cache := range h.Archives.Enabled() {
+		entries, err := cache.Database.GetHistory(pkg, version)
+		if err != nil {
+			requestLogger(r).Errorf("failed to get history of %v in %v: %v", pkg, cache.Name, err)
+			h.Archives.Check(cache, err)
+			writeError(w, http.StatusInternalServerError, "failed to get the history of %v", pkg)
+			return
+		}
+
+		for _, entry := range entries {
+			result.Seen = append(result.Seen, firstSeenEntry{cache.Name, entry})
+			if result.FirstSeen.IsZero() || entry.FirstSeen.Before(result.FirstSeen) {
+				result.FirstSeen = entry.FirstSeen
+				result.Archive = cache.Name
+				result.Suite = entry.Suite + entry.Pocket
+			}
+		}
+	}
+
+	if len(result.Seen) == 0 {
+		writeError(w, http.StatusNotFound, "version %v of %v not found", version, pkg)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// lookupAt returns the versions of a package that were published at a
+// given time according to the history. The metadata is only complete for
+// the versions still in the archive.
+func (h httpHandler) lookupAt(r *http.Request, pkg string, at time.Time) ([]*debianpkg.PackageInfo, error) {
+	allInfo := make([]*debianpkg.PackageInfo, 0)
+	for _, cache := range h.Archives.Enabled() {
+		endSpan := startSpan(r, "db.GetHistoryAt "+cache.Name)
+		entries, err := cache.Database.GetHistoryAt(pkg, at)
+		endSpan()
+		if err != nil {
+			h.Archives.Check(cache, err)
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		current, err := cache.Database.GetPackage(pkg)
+		if err != nil {
+			h.Archives.Check(cache, err)
+			return nil, err
+		}
+		known := make(map[string]*debianpkg.PackageInfo, len(current))
+		for _, info := range current {
+			known[historyKey(info.Version, info.Component, info.Suite, info.Pocket, info.Architecture)] = info
+		}
+
+		infoArchive := make([]*debianpkg.PackageInfo, len(entries))
+		for i, entry := range entries {
+			info := known[historyKey(entry.Version, entry.Component, entry.Suite, entry.Pocket, entry.Architecture)]
+			if info == nil {
+				info = &debianpkg.PackageInfo{
+					Name:         entry.Name,
+					Version:      entry.Version,
+					Component:    entry.Component,
+					Suite:        entry.Suite,
+					Pocket:       entry.Pocket,
+					Architecture: entry.Architecture,
+				}
+			}
+			infoArchive[i] = info
+		}
+		h.redact(r.Context(), cache, infoArchive)
+		h.translateSuites(cache, infoArchive)
+		setArchive(cache, infoArchive)
+		allInfo = append(allInfo, infoArchive...)
+	}
+
+	return allInfo, nil
+}
+
+func historyKey(version, component, suite, pocket, arch string) string {
+	return version + "/" + component + "/" + suite + pocket + "/" + arch
+}
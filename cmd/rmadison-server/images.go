@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// maxManifestSize is the maximum size of an uploaded manifest
+const maxManifestSize = 16 << 20
+
+// imageNameRegexp matches the valid image names, they are used as file
+// names
+var imageNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+
+var errImageNotFound = errors.New("image not found")
+
+// image is the manifest of a cloud or ISO image: the packages installed in
+// it and their versions
+type image struct {
+	Name string `json:"name"`
+	// Suite and Architecture are where the versions of the image are
+	// compared with the archive, they can be empty
+	Suite        string            `json:"suite"`
+	Architecture string            `json:"architecture"`
+	Uploaded     time.Time         `json:"uploaded"`
+	Packages     map[string]string `json:"packages"`
+}
+
+// imageStore holds the image manifests uploaded, saved as JSON files in
+// dir when it's set
+type imageStore struct {
+	dir string
+
+	lock   sync.RWMutex
+	images map[string]*image
+}
+
+func newImageStore(dir string) (*imageStore, error) {
+	s := &imageStore{
+		dir:    dir,
+		images: make(map[string]*image),
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		content, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		img := new(image)
+		err = json.Unmarshal(content, img)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image %v: %v", entry.Name(), err)
+		}
+		s.images[img.Name] = img
+	}
+	log.Infof("loaded %v image manifests from %v", len(s.images), dir)
+
+	return s, nil
+}
+
+// Get returns an image, nil if it doesn't exist
+func (s *imageStore) Get(name string) *image {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.images[name]
+}
+
+// All returns the images sorted by name
+func (s *imageStore) All() []*image {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	images := make([]*image, 0, len(s.images))
+	for _, img := range s.images {
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Name < images[j].Name
+	})
+
+	return images
+}
+
+// Put adds or replaces an image
+func (s *imageStore) Put(img *image) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.dir != "" {
+		content, err := json.Marshal(img)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(s.dir, img.Name+".json"), content, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	s.images[img.Name] = img
+
+	return nil
+}
+
+// Remove deletes an image
+func (s *imageStore) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.images[name]; !ok {
+		return errImageNotFound
+	}
+
+	if s.dir != "" {
+		err := os.Remove(path.Join(s.dir, name+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.images, name)
+
+	return nil
+}
+
+// parseManifest parses a manifest as published with the Ubuntu images: a
+// package and its version per line, separated by spaces or a tab. The
+// architecture qualifiers ("libc6:amd64") are removed and the snaps are
+// ignored.
+func parseManifest(r io.Reader) (map[string]string, error) {
+	packages := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "snap:") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid manifest line %v: %q", lineNumber, line)
+		}
+		name, _, _ := strings.Cut(fields[0], ":")
+		packages[name] = fields[1]
+	}
+
+	return packages, scanner.Err()
+}
+
+// imageUpdatePockets are the pockets the images are upgraded from, the
+// updates of an image of noble are in noble, noble-updates and
+// noble-security
+var imageUpdatePockets = map[string]bool{"": true, "-updates": true, "-security": true}
+
+// inImageSuite tells if a package of the archive can be installed in an
+// image of suite: the suite itself, or one of the imageUpdatePockets of its
+// series when the suite is one of them
+func inImageSuite(info *debianpkg.PackageInfo, suite string) bool {
+	if info.Suite+info.Pocket == suite {
+		return true
+	}
+	series, pocket, found := strings.Cut(suite, "-")
+	if found {
+		pocket = "-" + pocket
+	}
+
+	return info.Suite == series && imageUpdatePockets[pocket] && imageUpdatePockets[info.Pocket]
+}
+
+// imageMembership tells if a package is in an image, and how its version
+// compares to the archive
+type imageMembership struct {
+	Image        string `json:"image"`
+	Suite        string `json:"suite"`
+	Architecture string `json:"architecture"`
+	InImage      bool   `json:"in_image"`
+	ImageVersion string `json:"image_version,omitempty"`
+	// ArchiveVersion is the newest version in the suite of the image and
+	// its updates (see inImageSuite)
+	ArchiveVersion string `json:"archive_version,omitempty"`
+	// Delta is up_to_date, outdated (the archive has a newer version) or
+	// ahead (the image has a newer version), empty if the package is not
+	// in the image or not in the archive
+	Delta string `json:"delta,omitempty"`
+}
+
+// serveInImage returns the membership of a package in the images (or in
+// image), /in-image/<pkg>. The suite of the comparison with the archive
+// can be overridden with suite.
+func (h httpHandler) serveInImage(w http.ResponseWriter, r *http.Request) {
+	pkg := strings.TrimPrefix(r.URL.Path, "/in-image/")
+	if pkg == "" || strings.Contains(pkg, "/") {
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+		return
+	}
+
+	query := r.URL.Query()
+	images := h.Images.All()
+	if name := query.Get("image"); name != "" {
+		img := h.Images.Get(name)
+		if img == nil {
+			writeError(w, http.StatusNotFound, "image %v not found", name)
+			return
+		}
+		images = []*image{img}
+	}
+
+	allInfo, err := h.lookup(r, pkg)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+		return
+	}
+
+	memberships := make([]imageMembership, len(images))
+	for i, img := range images {
+		membership := imageMembership{
+			Image:        img.Name,
+			Suite:        img.Suite,
+			Architecture: img.Architecture,
+		}
+		if suite := query.Get("suite"); suite != "" {
+			membership.Suite = suite
+		}
+		membership.ImageVersion, membership.InImage = img.Packages[pkg]
+
+		for _, info := range allInfo {
+			if !inImageSuite(info, membership.Suite) {
+				continue
+			}
+			if membership.Architecture != "" && info.Architecture != membership.Architecture && info.Architecture != "all" {
+				continue
+			}
+			if membership.ArchiveVersion == "" || version.Compare(info.Version, membership.ArchiveVersion) > 0 {
+				membership.ArchiveVersion = info.Version
+			}
+		}
+
+		if membership.InImage && membership.ArchiveVersion != "" {
+			switch cmp := version.Compare(membership.ArchiveVersion, membership.ImageVersion); {
+			case cmp > 0:
+				membership.Delta = "outdated"
+			case cmp < 0:
+				membership.Delta = "ahead"
+			default:
+				membership.Delta = "up_to_date"
+			}
+		}
+
+		memberships[i] = membership
+	}
+
+	header := []string{"image", "suite", "architecture", "in_image", "image_version", "archive_version", "delta"}
+	records := make([][]string, len(memberships))
+	for i, membership := range memberships {
+		records[i] = []string{
+			membership.Image,
+			membership.Suite,
+			membership.Architecture,
+			strconv.FormatBool(membership.InImage),
+			membership.ImageVersion,
+			membership.ArchiveVersion,
+			membership.Delta,
+		}
+	}
+
+	writeList(w, r, memberships, header, records)
+}
+
+// imageSummary is an image without its packages
+type imageSummary struct {
+	Name         string    `json:"name"`
+	Suite        string    `json:"suite"`
+	Architecture string    `json:"architecture"`
+	Uploaded     time.Time `json:"uploaded"`
+	Packages     int       `json:"packages"`
+}
+
+// serveImages lists the images uploaded
+func (h httpHandler) serveImages(w http.ResponseWriter, r *http.Request) {
+	images := h.Images.All()
+	summaries := make([]imageSummary, len(images))
+	records := make([][]string, len(images))
+	for i, img := range images {
+		summaries[i] = imageSummary{
+			Name:         img.Name,
+			Suite:        img.Suite,
+			Architecture: img.Architecture,
+			Uploaded:     img.Uploaded,
+			Packages:     len(img.Packages),
+		}
+		records[i] = []string{img.Name, img.Suite, img.Architecture, img.Uploaded.Format(time.RFC3339), strconv.Itoa(len(img.Packages))}
+	}
+
+	writeList(w, r, summaries, []string{"name", "suite", "architecture", "uploaded", "packages"}, records)
+}
+
+// serveAdminImage uploads the manifest of an image (PUT, with suite and
+// arch in the query) or removes it (DELETE)
+func (h httpHandler) serveAdminImage(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/images/")
+	if !imageNameRegexp.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "invalid image name %q", name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		packages, err := parseManifest(http.MaxBytesReader(w, r.Body, maxManifestSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		img := &image{
+			Name:         name,
+			Suite:        r.URL.Query().Get("suite"),
+			Architecture: r.URL.Query().Get("arch"),
+			Uploaded:     time.Now().UTC(),
+			Packages:     packages,
+		}
+		err = h.Images.Put(img)
+		if err != nil {
+			requestLogger(r).Errorf("[admin][%v] failed to save image: %v", name, err)
+			writeError(w, http.StatusInternalServerError, "failed to save image %v", name)
+			return
+		}
+		requestLogger(r).Infof("[admin][%v] image uploaded (%v packages)", name, len(packages))
+	case http.MethodDelete:
+		err := h.Images.Remove(name)
+		if errors.Is(err, errImageNotFound) {
+			writeError(w, http.StatusNotFound, "image %v not found", name)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Errorf("[admin][%v] failed to remove image: %v", name, err)
+			writeError(w, http.StatusInternalServerError, "failed to remove image %v", name)
+			return
+		}
+		requestLogger(r).Infof("[admin][%v] image removed", name)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestInImage(t *testing.T) {
+	h := newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-security", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3.3", Suite: "noble", Pocket: "-proposed", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu4", Suite: "noble", Component: "main", Architecture: "arm64"},
+		&debianpkg.PackageInfo{Name: "tzdata", Version: "2024a-2", Suite: "noble", Pocket: "-security", Component: "main", Architecture: "all"},
+	)
+	var err error
+	h.Images, err = newImageStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range []*image{
+		{Name: "server", Suite: "noble", Architecture: "amd64", Packages: map[string]string{"openssl": "3.0.13-0ubuntu3.2", "tzdata": "2024a-1"}},
+		{Name: "old", Suite: "noble-updates", Architecture: "amd64", Packages: map[string]string{"openssl": "3.0.13-0ubuntu3"}},
+		{Name: "daily", Suite: "noble-proposed", Architecture: "amd64", Packages: map[string]string{"openssl": "3.0.13-0ubuntu3.3"}},
+		{Name: "future", Suite: "noble", Architecture: "amd64", Packages: map[string]string{"openssl": "3.1.0-1"}},
+		{Name: "minimal", Suite: "noble", Architecture: "amd64", Packages: map[string]string{}},
+	} {
+		err = h.Images.Put(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := newRouter(h)
+
+	tests := []struct {
+		target   string
+		expected imageMembership
+	}{
+		// the version of -updates is the newest of the series
+		{"/in-image/openssl?image=server", imageMembership{Image: "server", Suite: "noble", Architecture: "amd64", InImage: true, ImageVersion: "3.0.13-0ubuntu3.2", ArchiveVersion: "3.0.13-0ubuntu3.2", Delta: "up_to_date"}},
+		// only in -security
+		{"/in-image/tzdata?image=server", imageMembership{Image: "server", Suite: "noble", Architecture: "amd64", InImage: true, ImageVersion: "2024a-1", ArchiveVersion: "2024a-2", Delta: "outdated"}},
+		{"/in-image/openssl?image=old", imageMembership{Image: "old", Suite: "noble-updates", Architecture: "amd64", InImage: true, ImageVersion: "3.0.13-0ubuntu3", ArchiveVersion: "3.0.13-0ubuntu3.2", Delta: "outdated"}},
+		// -proposed is only compared when it's the suite of the image
+		{"/in-image/openssl?image=daily", imageMembership{Image: "daily", Suite: "noble-proposed", Architecture: "amd64", InImage: true, ImageVersion: "3.0.13-0ubuntu3.3", ArchiveVersion: "3.0.13-0ubuntu3.3", Delta: "up_to_date"}},
+		{"/in-image/openssl?image=future", imageMembership{Image: "future", Suite: "noble", Architecture: "amd64", InImage: true, ImageVersion: "3.1.0-1", ArchiveVersion: "3.0.13-0ubuntu3.2", Delta: "ahead"}},
+		{"/in-image/openssl?image=minimal", imageMembership{Image: "minimal", Suite: "noble", Architecture: "amd64", ArchiveVersion: "3.0.13-0ubuntu3.2"}},
+		{"/in-image/openssl?image=server&suite=noble-proposed", imageMembership{Image: "server", Suite: "noble-proposed", Architecture: "amd64", InImage: true, ImageVersion: "3.0.13-0ubuntu3.2", ArchiveVersion: "3.0.13-0ubuntu3.3", Delta: "outdated"}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: expected 200, got %v: %v", test.target, w.Code, w.Body.String())
+			continue
+		}
+
+		var memberships []imageMembership
+		err := json.Unmarshal(w.Body.Bytes(), &memberships)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(memberships) != 1 || memberships[0] != test.expected {
+			t.Errorf("%v: expected %+v, got %+v", test.target, test.expected, memberships)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/in-image/openssl?image=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown image, got %v", w.Code)
+	}
+}
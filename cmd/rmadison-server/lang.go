@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// descriptionLangs are the languages of the descriptions searched for the
+// lang parameter: the language and English as a fallback
+func descriptionLangs(r *http.Request) []string {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" || lang == "en" {
+		return []string{"en"}
+	}
+
+	return []string{lang, "en"}
+}
+
+// translateDescriptions replaces the descriptions of the packages by their
+// translation in the language of the lang parameter, the English
+// description is kept when the archive has no translation. It runs after
+// the redaction: the archives hiding the descriptions are skipped.
+func (h httpHandler) translateDescriptions(r *http.Request, pkgs []*debianpkg.PackageInfo) error {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" || lang == "en" {
+		return nil
+	}
+
+	// the descriptions of each package by archive
+	descriptions := make(map[string]map[string]string)
+	for _, pkg := range pkgs {
+		cache := h.Archives.Get(pkg.Archive)
+		if cache == nil || h.isRedacted(r.Context(), cache, "description") {
+			continue
+		}
+
+		key := pkg.Archive + "/" + pkg.Name
+		translations, ok := descriptions[key]
+		if !ok {
+			var err error
+			translations, err = cache.Database.GetDescriptions(pkg.Name, lang)
+			if err != nil {
+				h.Archives.Check(cache, err)
+				return err
+			}
+			descriptions[key] = translations
+		}
+
+		suite := h.archiveSuite(cache, pkg.Suite+pkg.Pocket)
+		description, ok := translations[suite]
+		if !ok {
+			// the translations are usually only published in the
+			// release pocket
+			series, _, _ := strings.Cut(suite, "-")
+			description, ok = translations[series]
+		}
+		if ok {
+			// only the short description is kept, like in the Packages
+			// indexes
+			pkg.Description, _, _ = strings.Cut(description, "\n")
+		}
+	}
+
+	return nil
+}
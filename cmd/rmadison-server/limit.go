@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// unlimitedPaths are not counted in the requests in flight: event streams
+// would hold a slot forever and metrics must stay available when the
+// server is saturated
+var unlimitedPaths = map[string]bool{
+	"/api/events":  true,
+	"/api/metrics": true,
+	"/api/wait":    true,
+}
+
+// LimitsConfig caps the number of requests processed concurrently and the
+// size of the responses
+type LimitsConfig struct {
+	server.LoadConfig `yaml:",inline"`
+	// MaxSearchResults is the maximum number of packages returned by a
+	// search, across the archives
+	MaxSearchResults int `yaml:"max_search_results"`
+	// MaxDumpRows is the maximum number of packages returned by a dump, 0
+	// disables the limit
+	MaxDumpRows int `yaml:"max_dump_rows"`
+}
+
+// defaultMaxSearchResults is used when max_search_results is not set
+const defaultMaxSearchResults = 1000
+
+// resultLimit returns the number of rows to return: the limit parameter of
+// the query, capped by max. max <= 0 means no cap.
+func resultLimit(r *http.Request, max int) (int, error) {
+	limit := max
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid limit %q", value)
+		}
+		if max <= 0 || n < max {
+			limit = n
+		}
+	}
+
+	return limit, nil
+}
+
+// newLoadShedder returns the load shedder of the API, its errors are
+// answered like the others
+func newLoadShedder(conf LimitsConfig) *server.LoadShedder {
+	return server.NewLoadShedder(conf.LoadConfig, unlimitedPaths, func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		writeError(w, status, "%v", err)
+	})
+}
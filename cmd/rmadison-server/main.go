@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -10,15 +9,17 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gjolly/go-rmadison/pkg/archive"
-	"github.com/gjolly/go-rmadison/pkg/database"
 	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/server"
 	"github.com/go-resty/resty/v2"
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"gopkg.in/yaml.v3"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -33,57 +34,124 @@ func init() {
 }
 
 type httpHandler struct {
-	Caches []*archive.Archive
+	Archives   *archiveRegistry
+	AdminToken string
+	Jobs       *jobManager
+	Overlay    *overlay
+	Events     *eventBroker
+	Images     *imageStore
+	Excuses    *excusesClient
+	Limiter    *server.LoadShedder
+	Limits     LimitsConfig
+	// PrivilegedTokens see the private archives unredacted, like the
+	// admin token
+	PrivilegedTokens []string
+	// WatchlistTokens can manage watchlists, like the admin token and the
+	// privileged tokens
+	WatchlistTokens []string
+	Watchlists      *watchlistStore
+	// MetricsPackages have their versions exported in /api/metrics
+	MetricsPackages []string
+	// Requests are counted by the metrics stage of the middleware
+	Requests *server.RequestMetrics
 }
 
 func (h httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := requestLogger(r)
 	pkg := strings.TrimLeft(r.URL.Path, "/")
 	log.Debugf("lookup for %v", pkg)
 
 	if strings.Contains(pkg, "/") {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
 		return
 	}
 
-	allInfo := make([]*debianpkg.PackageInfo, 0)
-	for _, cache := range h.Caches {
-		allInfoArchive, err := cache.Database.GetPackage(pkg)
+	var (
+		allInfo []*debianpkg.PackageInfo
+		err     error
+	)
+	if at := r.URL.Query().Get("at"); at != "" {
+		var atTime time.Time
+		atTime, err = time.Parse(time.RFC3339, at)
 		if err != nil {
-			log.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, http.StatusBadRequest, "invalid at parameter %q, expected an RFC 3339 date", at)
 			return
 		}
-		allInfo = append(allInfo, allInfoArchive...)
+		allInfo, err = h.lookupAt(r, pkg, atTime)
+	} else if withBinaries, _ := strconv.ParseBool(r.URL.Query().Get("source_and_binary")); withBinaries {
+		allInfo, err = h.lookupWithBinaries(r, pkg)
+	} else {
+		allInfo, err = h.lookup(r, pkg)
 	}
-
-	jsonInfo, err := json.Marshal(allInfo)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+		return
+	}
+	allInfo, err = filterPackages(r, allInfo)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	// an empty list means that the package doesn't exist or that no
+	// version matches the filters
+	if len(allInfo) == 0 {
+		h.setResultHeaders(w, 0)
+		writeError(w, http.StatusNotFound, "package %v not found", pkg)
+		return
+	}
+	err = h.translateDescriptions(r, allInfo)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
 		return
 	}
+	h.Overlay.Annotate(allInfo)
+	h.setResultHeaders(w, len(allInfo))
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(jsonInfo)
+	writeGroupedPackages(w, r, allInfo)
 }
 
-func refreshCaches(archives []*archive.Archive) {
-	for _, cache := range archives {
-		go func(cache *archive.Archive) {
-			t := time.NewTicker(5 * time.Minute)
-			for {
-				now := time.Now()
-				_, pkgStats, err := cache.RefreshCache(false)
-				duration := time.Now().Sub(now)
-				if err != nil {
-					log.Errorf("cache refreshed in %v (with error %v), %v packages updated", duration.Seconds(), err, pkgStats)
-				} else {
-					log.Infof("cache refreshed in %v, %v packages updated", duration.Seconds(), pkgStats)
-				}
-
-				<-t.C
-			}
-		}(cache)
+func newRouter(h httpHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.HandleFunc("/sources/", h.serveSources)
+	mux.HandleFunc("/api/archives", h.serveArchives)
+	mux.HandleFunc("/api/suites", h.serveSuites)
+	mux.HandleFunc("/api/metrics", h.serveMetrics)
+	mux.HandleFunc("/api/stats", h.serveStats)
+	mux.HandleFunc("/api/dump", h.serveDump)
+	mux.HandleFunc("/api/export", h.serveExport)
+	mux.HandleFunc("/api/file", h.serveFile)
+	mux.HandleFunc("/pkg/", h.servePkg)
+	mux.HandleFunc("/api/built-from", h.serveBuiltFrom)
+	mux.HandleFunc("/api/snapshot", h.serveSnapshot)
+	mux.HandleFunc("/api/diff", h.serveDiff)
+	mux.HandleFunc("/api/size-diff", h.serveSizeDiff)
+	mux.HandleFunc("/api/events", h.serveEvents)
+	mux.HandleFunc("/api/batch", h.serveBatch)
+	mux.Handle("/api/graphql", newGraphQLHandler(h))
+	mux.HandleFunc("/api/search", h.serveSearch)
+	mux.HandleFunc("/api/search/estimate", h.serveEstimate)
+	mux.HandleFunc("/api/pin/simulate", h.servePinSimulation)
+	mux.HandleFunc("/api/first-seen", h.serveFirstSeen)
+	mux.HandleFunc("/api/wait", h.serveWait)
+	mux.HandleFunc("/api/images", h.serveImages)
+	mux.HandleFunc("/in-image/", h.serveInImage)
+	mux.HandleFunc("/api/sru-status", h.serveSRUStatus)
+	mux.HandleFunc("/api/watchlists", h.serveWatchlists)
+	mux.HandleFunc("/api/watchlists/", h.serveWatchlist)
+
+	// admin endpoints are only available when a token is configured
+	if h.AdminToken != "" {
+		mux.HandleFunc("/admin/refresh", h.requireAdmin(h.serveAdminRefresh))
+		mux.HandleFunc("/admin/jobs/", h.requireAdmin(h.serveAdminJob))
+		mux.HandleFunc("/admin/archives", h.requireAdmin(h.serveAdminArchives))
+		mux.HandleFunc("/admin/archives/", h.requireAdmin(h.serveAdminArchive))
+		mux.HandleFunc("/admin/images/", h.requireAdmin(h.serveAdminImage))
 	}
+
+	return mux
 }
 
 func startPprofServer(addr string) {
@@ -105,14 +173,87 @@ func startPprofServer(addr string) {
 
 // Config is the configuration of the rmadison server
 type Config struct {
-	Caches []*archive.Archive
+	CacheDirectory string
+	Archives       []*archiveYAMLConf
+	StateFile      string
+	IntegrityCheck string
+	AccessLog      server.AccessLogConfig
+	AdminToken     string
+	// PrivilegedTokens see the private archives unredacted
+	PrivilegedTokens []string
+	OverlayFile      string
+	ImagesDirectory  string
+	Excuses          *ExcusesConfig
+	Publish          *PublishConfig
+	Report           *ReportConfig
+	GRPCAddress      string
+	Limits           LimitsConfig
+	Timeouts         server.TimeoutsConfig
+	CacheControl     server.CacheControlConfig
+	TLS              TLSConfig
+
+	// WatchlistTokens can manage watchlists, saved in WatchlistsDirectory
+	WatchlistTokens     []string
+	WatchlistsDirectory string
+	// MetricsPackages have their versions exported in /api/metrics
+	MetricsPackages []string
+
+	// CORS lets the browsers query the API from other origins
+	CORS server.CORSConfig
+}
+
+// TLSConfig enables HTTPS (and HTTP/2 over TLS) on the API listener
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
 }
 
 type archiveYAMLConf struct {
-	BaseURL  string   `yaml:"base_url"`
-	PortsURL string   `yaml:"ports_url"`
-	Database string   `yaml:"database"`
-	Pockets  []string `yaml:"pockets"`
+	Name     string   `yaml:"name" json:"name"`
+	BaseURL  string   `yaml:"base_url" json:"base_url"`
+	PortsURL string   `yaml:"ports_url" json:"ports_url"`
+	Database string   `yaml:"database" json:"database"`
+	Pockets  []string `yaml:"pockets" json:"pockets"`
+	Discover bool     `yaml:"discover" json:"discover"`
+	Contents bool     `yaml:"contents" json:"contents"`
+	Sources  bool     `yaml:"sources" json:"sources"`
+	SignedBy string   `yaml:"signed_by" json:"signed_by"`
+	// Architectures and Components restrict the indexes downloaded, all
+	// the ones of the Release files are indexed by default
+	Architectures []string `yaml:"architectures" json:"architectures,omitempty"`
+	Components    []string `yaml:"components" json:"components,omitempty"`
+	// Translations are the languages of the descriptions indexed
+	Translations []string `yaml:"translations" json:"translations,omitempty"`
+	// ChangelogURL is a template, see archive.UbuntuChangelogURL
+	ChangelogURL string `yaml:"changelog_url" json:"changelog_url"`
+	// Backfill imports the history of packages from before the archive
+	// was indexed
+	Backfill *archive.BackfillConfig `yaml:"backfill" json:"backfill"`
+	// Spill is auto, always or never, see archive.Archive.Spill
+	Spill string `yaml:"spill" json:"spill"`
+	// Private archives have the fields of Redact (or defaultRedactions)
+	// hidden from the requests without a privileged token
+	Private  bool     `yaml:"private" json:"private"`
+	Redact   []string `yaml:"redact" json:"redact"`
+	Disabled bool     `yaml:"disabled" json:"disabled"`
+	// SuiteNames are the names shown to the clients of the suites of the
+	// archive (e.g. prod: jammy), they are translated in the queries and
+	// the responses
+	SuiteNames map[string]string `yaml:"suite_names" json:"suite_names,omitempty"`
+	// Keyrings verify the signatures of the InRelease files, the invalid
+	// ones are refused or flagged depending on Verify
+	Keyrings []string `yaml:"keyrings" json:"keyrings,omitempty"`
+	Verify   string   `yaml:"verify" json:"verify,omitempty"`
+	// Releases are indexed with each of PocketSuffixes, see
+	// archive.DefaultPocketSuffixes
+	Releases       []string `yaml:"releases" json:"releases,omitempty"`
+	PocketSuffixes []string `yaml:"pocket_suffixes" json:"pocket_suffixes,omitempty"`
+	// Layout is dists (the default) or flat, the pockets of the flat
+	// repositories are their directories
+	Layout string `yaml:"layout" json:"layout,omitempty"`
+	// PPA is a Launchpad PPA (user/name), it sets base_url and the
+	// components and discovers the series when no pockets are given
+	PPA string `yaml:"ppa" json:"ppa,omitempty"`
 }
 
 func parseConfig() (*Config, error) {
@@ -141,49 +282,187 @@ func parseConfig() (*Config, error) {
 		return nil, err
 	}
 	rawConfig := new(struct {
-		CacheDirectory string             `yaml:"cache_directory"`
-		Archives       []*archiveYAMLConf `yaml:"archives"`
+		CacheDirectory   string                    `yaml:"cache_directory"`
+		Archives         []*archiveYAMLConf        `yaml:"archives"`
+		StateFile        string                    `yaml:"state_file"`
+		IntegrityCheck   string                    `yaml:"integrity_check"`
+		AccessLog        server.AccessLogConfig    `yaml:"access_log"`
+		AdminToken       string                    `yaml:"admin_token"`
+		PrivilegedTokens []string                  `yaml:"privileged_tokens"`
+		OverlayFile      string                    `yaml:"overlay_file"`
+		ImagesDirectory  string                    `yaml:"images_directory"`
+		Excuses          *ExcusesConfig            `yaml:"excuses"`
+		Publish          *PublishConfig            `yaml:"publish"`
+		Report           *ReportConfig             `yaml:"report"`
+		GRPCAddress      string                    `yaml:"grpc_address"`
+		Limits           LimitsConfig              `yaml:"limits"`
+		Timeouts         server.TimeoutsConfig     `yaml:"timeouts"`
+		CacheControl     server.CacheControlConfig `yaml:"cache_control"`
+		TLS              TLSConfig                 `yaml:"tls"`
+
+		WatchlistTokens     []string `yaml:"watchlist_tokens"`
+		WatchlistsDirectory string   `yaml:"watchlists_directory"`
+		MetricsPackages     []string `yaml:"metrics_packages"`
+
+		CORS server.CORSConfig `yaml:"cors"`
 	})
 	yaml.Unmarshal(configBytes, rawConfig)
-	conf := new(Config)
-	conf.Caches = make([]*archive.Archive, len(rawConfig.Archives))
-
-	httpClient := resty.New()
-
-	for i, archiveConf := range rawConfig.Archives {
-		if archiveConf.BaseURL == "" {
-			return nil, fmt.Errorf("missing base_url for archive %v", i)
-		}
+	conf := &Config{
+		CacheDirectory:   rawConfig.CacheDirectory,
+		Archives:         rawConfig.Archives,
+		StateFile:        rawConfig.StateFile,
+		IntegrityCheck:   rawConfig.IntegrityCheck,
+		AccessLog:        rawConfig.AccessLog,
+		AdminToken:       rawConfig.AdminToken,
+		PrivilegedTokens: rawConfig.PrivilegedTokens,
+		OverlayFile:      rawConfig.OverlayFile,
+		ImagesDirectory:  rawConfig.ImagesDirectory,
+		Excuses:          rawConfig.Excuses,
+		Publish:          rawConfig.Publish,
+		Report:           rawConfig.Report,
+		GRPCAddress:      rawConfig.GRPCAddress,
+		Limits:           rawConfig.Limits,
+		Timeouts:         rawConfig.Timeouts,
+		CacheControl:     rawConfig.CacheControl,
+		TLS:              rawConfig.TLS,
+
+		WatchlistTokens:     rawConfig.WatchlistTokens,
+		WatchlistsDirectory: rawConfig.WatchlistsDirectory,
+		MetricsPackages:     rawConfig.MetricsPackages,
+
+		CORS: rawConfig.CORS,
+	}
+	return conf, err
+}
 
-		baseURL, err := url.Parse(archiveConf.BaseURL)
+// newArchive validates the configuration of an archive and initializes it,
+// the database is opened by the registry
+func newArchive(archiveConf *archiveYAMLConf, cacheDir string, httpClient *resty.Client) (*archive.Archive, error) {
+	if archiveConf.PPA != "" {
+		err := expandPPA(archiveConf)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if archiveConf.PortsURL == "" {
-			log.Infof("missing ports_url for archive %v, using base url", i)
-			archiveConf.PortsURL = archiveConf.BaseURL
-		}
+	if archiveConf.BaseURL == "" {
+		return nil, fmt.Errorf("missing base_url for archive %v", archiveConf.Name)
+	}
 
-		portsURL, err := url.Parse(archiveConf.PortsURL)
-		if err != nil {
-			return nil, err
-		}
-		db, err := database.NewConn("sqlite3", archiveConf.Database)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to connect to database %v", archiveConf.Database)
-		}
-		conf.Caches[i] = &archive.Archive{
-			BaseURL:  baseURL,
-			PortsURL: portsURL,
-			Pockets:  archiveConf.Pockets,
-			CacheDir: rawConfig.CacheDirectory,
-			Client:   httpClient,
-			Database: db,
+	baseURL, err := url.Parse(archiveConf.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if archiveConf.PortsURL == "" {
+		log.Infof("missing ports_url for archive %v, using base url", archiveConf.Name)
+		archiveConf.PortsURL = archiveConf.BaseURL
+	}
+
+	if len(archiveConf.Pockets) == 0 && len(archiveConf.Releases) == 0 && !archiveConf.Discover {
+		return nil, fmt.Errorf("no pockets configured for archive %v, set pockets, releases or discover", archiveConf.Name)
+	}
+
+	if archiveConf.Backfill != nil && archiveConf.Backfill.Source != archive.BackfillSnapshot && archiveConf.Backfill.Source != archive.BackfillLaunchpad {
+		return nil, fmt.Errorf("unknown backfill source %q for archive %v", archiveConf.Backfill.Source, archiveConf.Name)
+	}
+
+	switch archiveConf.Spill {
+	case "", archive.SpillAuto, archive.SpillAlways, archive.SpillNever:
+	default:
+		return nil, fmt.Errorf("invalid spill %q for archive %v", archiveConf.Spill, archiveConf.Name)
+	}
+
+	switch archiveConf.Verify {
+	case "", archive.VerifyRefuse, archive.VerifyFlag:
+	default:
+		return nil, fmt.Errorf("invalid verify %q for archive %v", archiveConf.Verify, archiveConf.Name)
+	}
+	if archiveConf.Verify != "" && len(archiveConf.Keyrings) == 0 {
+		return nil, fmt.Errorf("verify needs keyrings for archive %v", archiveConf.Name)
+	}
+
+	switch archiveConf.Layout {
+	case "", archive.LayoutDists:
+	case archive.LayoutFlat:
+		if len(archiveConf.Pockets) == 0 || len(archiveConf.Releases) != 0 || archiveConf.Discover {
+			return nil, fmt.Errorf("the flat archive %v needs its directories in pockets, without releases nor discover", archiveConf.Name)
 		}
+	default:
+		return nil, fmt.Errorf("invalid layout %q for archive %v", archiveConf.Layout, archiveConf.Name)
 	}
 
-	return conf, err
+	err = checkRedactions(archiveConf.Redact)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact for archive %v: %v", archiveConf.Name, err)
+	}
+
+	err = checkSuiteNames(archiveConf.SuiteNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid suite_names for archive %v: %v", archiveConf.Name, err)
+	}
+
+	portsURL, err := url.Parse(archiveConf.PortsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archive.Archive{
+		Name:     archiveConf.Name,
+		BaseURL:  baseURL,
+		PortsURL: portsURL,
+		Pockets:  archiveConf.Pockets,
+		Discover: archiveConf.Discover,
+		Contents: archiveConf.Contents,
+		Sources:  archiveConf.Sources,
+		CacheDir: cacheDir,
+		Client:   httpClient,
+		SignedBy: archiveConf.SignedBy,
+
+		Translations: archiveConf.Translations,
+		ChangelogURL: archiveConf.ChangelogURL,
+		Spill:        archiveConf.Spill,
+
+		Keyrings: archiveConf.Keyrings,
+		Verify:   archiveConf.Verify,
+
+		Releases:       archiveConf.Releases,
+		PocketSuffixes: archiveConf.PocketSuffixes,
+
+		Layout: archiveConf.Layout,
+
+		Architectures: archiveConf.Architectures,
+		Components:    archiveConf.Components,
+	}, nil
+}
+
+// expandPPA fills the configuration of a Launchpad PPA: the URLs of its
+// dists directory, its component and its series. The configuration is
+// saved expanded in the state file, a base_url matching the PPA is valid.
+func expandPPA(archiveConf *archiveYAMLConf) error {
+	ppaURL, err := archive.PPABaseURL(archiveConf.PPA)
+	if err != nil {
+		return fmt.Errorf("invalid ppa for archive %v: %v", archiveConf.Name, err)
+	}
+	if archiveConf.BaseURL != "" && archiveConf.BaseURL != ppaURL {
+		return fmt.Errorf("base_url and ppa are both set for archive %v", archiveConf.Name)
+	}
+	if archiveConf.Layout != "" && archiveConf.Layout != archive.LayoutDists {
+		return fmt.Errorf("the ppa archive %v can't have the layout %v", archiveConf.Name, archiveConf.Layout)
+	}
+
+	archiveConf.BaseURL = ppaURL
+	if archiveConf.PortsURL == "" {
+		archiveConf.PortsURL = ppaURL
+	}
+	if len(archiveConf.Components) == 0 {
+		archiveConf.Components = append([]string{}, archive.PPAComponents...)
+	}
+	if len(archiveConf.Pockets) == 0 && len(archiveConf.Releases) == 0 {
+		archiveConf.Discover = true
+	}
+
+	return nil
 }
 
 func main() {
@@ -200,23 +479,106 @@ func main() {
 		log.Fatalf("failed to read config file: %v", err)
 	}
 
-	if len(conf.Caches) == 0 {
+	events := newEventBroker()
+	hooks := archiveHooks{OnChange: events.Publish}
+	if conf.Publish != nil {
+		publisher, err := newSnapshotPublisher(*conf.Publish)
+		if err != nil {
+			log.Fatalf("failed to configure snapshot publishing: %v", err)
+		}
+		hooks.OnRefresh = publisher.OnRefresh
+	}
+	if conf.Report != nil {
+		reporter, err := newRefreshReporter(*conf.Report)
+		if err != nil {
+			log.Fatalf("failed to configure refresh reports: %v", err)
+		}
+		hooks.OnChange = func(change archive.PackageChange) {
+			events.Publish(change)
+			reporter.OnChange(change)
+		}
+		onRefresh := hooks.OnRefresh
+		hooks.OnRefresh = func(cache *archive.Archive, status archive.RefreshStatus) {
+			if onRefresh != nil {
+				onRefresh(cache, status)
+			}
+			reporter.OnRefresh(cache, status)
+		}
+	}
+
+	archives, err := newArchiveRegistry(conf, hooks)
+	if err != nil {
+		log.Fatalf("failed to load archives: %v", err)
+	}
+
+	if len(archives.All()) == 0 {
 		log.Fatal("No archive defined in config file")
 	}
 
-	refreshCaches(conf.Caches)
-	handler := httpHandler{
-		Caches: conf.Caches,
+	var annotations *overlay
+	if conf.OverlayFile != "" {
+		annotations = newOverlay(conf.OverlayFile)
+	}
+
+	images, err := newImageStore(conf.ImagesDirectory)
+	if err != nil {
+		log.Fatalf("failed to load the image manifests: %v", err)
+	}
+
+	watchlists, err := newWatchlistStore(conf.WatchlistsDirectory)
+	if err != nil {
+		log.Fatalf("failed to load the watchlists: %v", err)
+	}
+
+	archives.StartRefresh()
+	h := httpHandler{
+		Archives:   archives,
+		AdminToken: conf.AdminToken,
+		Jobs:       newJobManager(),
+		Overlay:    annotations,
+		Events:     events,
+		Images:     images,
+
+		PrivilegedTokens: conf.PrivilegedTokens,
+		WatchlistTokens:  conf.WatchlistTokens,
+		Watchlists:       watchlists,
+		MetricsPackages:  conf.MetricsPackages,
+		Requests:         new(server.RequestMetrics),
+	}
+	if conf.Excuses != nil {
+		h.Excuses = newExcusesClient(*conf.Excuses)
+	}
+	h.Limiter = newLoadShedder(conf.Limits)
+	h.Limits = conf.Limits
+	if h.Limits.MaxSearchResults <= 0 {
+		h.Limits.MaxSearchResults = defaultMaxSearchResults
+	}
+	stack, err := newMiddlewareStack(h, conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if conf.GRPCAddress != "" {
+		go startGRPCServer(conf.GRPCAddress, newGRPCServer(h, conf))
 	}
 
 	addr := ":8433"
 	s := &http.Server{
-		Addr:           addr,
-		Handler:        handler,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		Addr: addr,
+		// h2c serves HTTP/2 to clients sending cleartext HTTP/2 requests
+		// (prior knowledge or Upgrade), HTTP/1 requests are unchanged
+		Handler:     h2c.NewHandler(stack.Handler(newRouter(h)), &http2.Server{}),
+		ReadTimeout: 10 * time.Second,
+		// the write timeouts are set for each route
 		MaxHeaderBytes: 1 << 20,
 	}
+
+	if conf.TLS.CertFile != "" {
+		// HTTP/2 is negotiated with ALPN
+		log.Infof("starting https server on %v\n", addr)
+		log.Fatal(s.ListenAndServeTLS(conf.TLS.CertFile, conf.TLS.KeyFile))
+	}
+
 	log.Infof("starting http server on %v\n", addr)
 	log.Fatal(s.ListenAndServe())
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// newTestHandler returns a handler serving an archive named test with the
+// packages
+func newTestHandler(t *testing.T, pkgs ...*debianpkg.PackageInfo) httpHandler {
+	db, err := database.NewConn("sqlite3", path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, pkg := range pkgs {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pkgs) != 0 {
+		err = db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	baseURL, _ := url.Parse("http://archive.example.com/ubuntu/dists")
+	registry := &archiveRegistry{
+		archives: []*registeredArchive{{
+			Archive: &archive.Archive{Name: "test", BaseURL: baseURL, PortsURL: baseURL, Database: db},
+			conf:    &archiveYAMLConf{Name: "test"},
+		}},
+	}
+
+	return httpHandler{
+		Archives: registry,
+		Limiter:  newLoadShedder(LimitsConfig{}),
+		Limits:   LimitsConfig{MaxSearchResults: defaultMaxSearchResults},
+	}
+}
+
+func TestLookupNotFound(t *testing.T) {
+	router := newRouter(newTestHandler(t, &debianpkg.PackageInfo{
+		Name: "bash", Version: "5.2-1", Suite: "noble", Component: "main", Architecture: "amd64",
+	}))
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/bash", http.StatusOK},
+		{"/bash?suite=noble", http.StatusOK},
+		{"/curl", http.StatusNotFound},
+		// no version matches the filters
+		{"/bash?suite=jammy", http.StatusNotFound},
+		{"/bash?arch=arm64", http.StatusNotFound},
+		{"/bash?satisfies=>=6", http.StatusNotFound},
+		{"/bash?latest=maybe", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%v: expected %v, got %v: %v", test.target, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusNotFound {
+			continue
+		}
+
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil || body.Error.Code != "not_found" {
+			t.Errorf("%v: expected a not_found error, got %v", test.target, w.Body.String())
+		}
+	}
+}
+
+// TestLookupNamedLikeRoute checks that the endpoints don't shadow the
+// packages with the same name
+func TestLookupNamedLikeRoute(t *testing.T) {
+	names := []string{"file", "diff", "stats", "metrics", "search", "wait", "api"}
+	pkgs := make([]*debianpkg.PackageInfo, 0, len(names))
+	for _, name := range names {
+		pkgs = append(pkgs, &debianpkg.PackageInfo{
+			Name: name, Version: "1.0", Suite: "noble", Component: "main", Architecture: "amd64",
+		})
+	}
+	router := newRouter(newTestHandler(t, pkgs...))
+
+	for _, name := range names {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: expected 200, got %v: %v", name, w.Code, w.Body.String())
+			continue
+		}
+
+		var found []*debianpkg.PackageInfo
+		err := json.Unmarshal(w.Body.Bytes(), &found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Name != name {
+			t.Errorf("%v: expected the package, got %v", name, w.Body.String())
+		}
+	}
+}
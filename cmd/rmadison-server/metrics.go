@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+)
+
+// metricWriter writes metrics in the Prometheus text format
+type metricWriter struct {
+	w io.Writer
+}
+
+// header writes the HELP and TYPE lines of a metric
+func (m metricWriter) header(name, help, metricType string) {
+	fmt.Fprintf(m.w, "# HELP %v %v\n", name, help)
+	fmt.Fprintf(m.w, "# TYPE %v %v\n", name, metricType)
+}
+
+// sample writes a sample, labels are given as key, value pairs
+func (m metricWriter) sample(name string, value float64, labels ...string) {
+	formatedLabels := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		formatedLabels = append(formatedLabels, fmt.Sprintf("%v=%q", labels[i], labels[i+1]))
+	}
+
+	fmt.Fprintf(m.w, "%v{%v} %v\n", name, strings.Join(formatedLabels, ","), value)
+}
+
+// serveMetrics exposes metrics in the Prometheus text format
+func (h httpHandler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	m := metricWriter{w}
+
+	archives := h.Archives.Enabled()
+
+	m.header("rmadison_release_timestamp_seconds", "Date of the last Release file published by the archive.", "gauge")
+	for _, cache := range archives {
+		dates := cache.ReleaseDates()
+		for _, suite := range sortedKeys(dates) {
+			m.sample("rmadison_release_timestamp_seconds", float64(dates[suite].Unix()), "archive", cache.Name, "suite", suite)
+		}
+	}
+
+	m.header("rmadison_release_age_seconds", "Age of the last Release file published by the archive.", "gauge")
+	for _, cache := range archives {
+		// the age is measured with the clock of the mirrors
+		now := cache.Now()
+		dates := cache.ReleaseDates()
+		for _, suite := range sortedKeys(dates) {
+			m.sample("rmadison_release_age_seconds", now.Sub(dates[suite]).Seconds(), "archive", cache.Name, "suite", suite)
+		}
+	}
+
+	m.header("rmadison_mirror_clock_skew_seconds", "Difference between the clock of the mirror and the local clock.", "gauge")
+	for _, cache := range archives {
+		for _, skew := range cache.ClockSkews() {
+			m.sample("rmadison_mirror_clock_skew_seconds", skew.Skew, "archive", cache.Name, "mirror", skew.Mirror)
+		}
+	}
+
+	writeParseMetrics(m, archives)
+	h.writePackageMetrics(m, archives)
+
+	counts := h.Requests.Counts()
+	m.header("rmadison_http_requests_total", "Requests answered, by status.", "counter")
+	for _, count := range counts {
+		m.sample("rmadison_http_requests_total", float64(count.Requests), "code", strconv.Itoa(count.Status))
+	}
+	m.header("rmadison_http_request_duration_seconds_total", "Time spent answering the requests, by status.", "counter")
+	for _, count := range counts {
+		m.sample("rmadison_http_request_duration_seconds_total", count.Duration.Seconds(), "code", strconv.Itoa(count.Status))
+	}
+
+	m.header("rmadison_requests_shed_total", "Requests rejected because too many requests were in flight.", "counter")
+	m.sample("rmadison_requests_shed_total", float64(h.Limiter.Shed()))
+}
+
+func writeParseMetrics(m metricWriter, archives []*archive.Archive) {
+	counters := []struct {
+		name  string
+		help  string
+		value func(archive.ParseStats) int
+	}{
+		{"rmadison_parse_skipped_stanzas", "Stanzas without a Package field skipped during the last refresh.", func(s archive.ParseStats) int { return s.SkippedStanzas }},
+		{"rmadison_parse_unknown_fields", "Unknown fields found during the last refresh.", func(s archive.ParseStats) int { return s.UnknownFields }},
+		{"rmadison_parse_empty_suites", "Suites without package index found during the last refresh.", func(s archive.ParseStats) int { return s.EmptySuites }},
+		{"rmadison_parse_checksum_failures", "Indexes with a wrong checksum during the last refresh.", func(s archive.ParseStats) int { return s.ChecksumFailures }},
+	}
+
+	for _, counter := range counters {
+		m.header(counter.name, counter.help, "gauge")
+		for _, cache := range archives {
+			m.sample(counter.name, float64(counter.value(cache.Status().Parse)), "archive", cache.Name)
+		}
+	}
+}
+
+// writePackageMetrics exports the versions of the packages of the
+// allowlist as an info metric, one sample per suite and architecture
+func (h httpHandler) writePackageMetrics(m metricWriter, archives []*archive.Archive) {
+	if len(h.MetricsPackages) == 0 {
+		return
+	}
+
+	m.header("rmadison_package_version_info", "Current version of the packages of metrics_packages.", "gauge")
+	for _, cache := range archives {
+		for _, name := range h.MetricsPackages {
+			pkgs, err := cache.Database.GetPackage(name)
+			if err != nil {
+				log.Errorf("[metrics][%v] failed to look up %v: %v", cache.Name, name, err)
+				h.Archives.Check(cache, err)
+				continue
+			}
+			sort.SliceStable(pkgs, func(i, j int) bool {
+				if pkgs[i].Suite+pkgs[i].Pocket != pkgs[j].Suite+pkgs[j].Pocket {
+					return pkgs[i].Suite+pkgs[i].Pocket < pkgs[j].Suite+pkgs[j].Pocket
+				}
+				return pkgs[i].Architecture < pkgs[j].Architecture
+			})
+
+			for _, pkg := range pkgs {
+				m.sample("rmadison_package_version_info", 1,
+					"archive", cache.Name,
+					"package", pkg.Name,
+					"suite", h.publicSuite(cache, pkg.Suite+pkg.Pocket),
+					"component", pkg.Component,
+					"arch", pkg.Architecture,
+					"version", pkg.Version)
+			}
+		}
+	}
+}
+
+func sortedKeys(dates map[string]time.Time) []string {
+	keys := make([]string, 0, len(dates))
+	for key := range dates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestWritePackageMetrics(t *testing.T) {
+	h := newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "arm64"},
+		&debianpkg.PackageInfo{Name: "openssl", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "bash", Version: "5.2.21-2ubuntu4", Suite: "noble", Component: "main", Architecture: "amd64"},
+	)
+	h.Archives.archives[0].conf.SuiteNames = map[string]string{"noble": "prod"}
+	archives := []*archive.Archive{h.Archives.archives[0].Archive}
+
+	// nothing is exported without allowlist
+	var out strings.Builder
+	h.writePackageMetrics(metricWriter{&out}, archives)
+	if out.String() != "" {
+		t.Errorf("expected no metrics, got %q", out.String())
+	}
+
+	// the packages not found have no samples, the others are sorted by
+	// suite and architecture
+	h.MetricsPackages = []string{"openssl", "curl"}
+	out.Reset()
+	h.writePackageMetrics(metricWriter{&out}, archives)
+	expected := `# HELP rmadison_package_version_info Current version of the packages of metrics_packages.
+# TYPE rmadison_package_version_info gauge
+rmadison_package_version_info{archive="test",package="openssl",suite="prod",component="main",arch="amd64",version="3.0.13-0ubuntu3"} 1
+rmadison_package_version_info{archive="test",package="openssl",suite="prod",component="main",arch="arm64",version="3.0.13-0ubuntu3"} 1
+rmadison_package_version_info{archive="test",package="openssl",suite="prod-updates",component="main",arch="amd64",version="3.0.13-0ubuntu3.2"} 1
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, out.String())
+	}
+}
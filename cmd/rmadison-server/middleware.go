@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gjolly/go-rmadison/pkg/server"
+	"go.uber.org/zap"
+)
+
+// requestLogger returns the logger for this request. Every line logged
+// with it carries the request ID.
+func requestLogger(r *http.Request) *zap.SugaredLogger {
+	return server.RequestLogger(r, log)
+}
+
+// startSpan starts a child span of the request (e.g. for a DB query), the
+// returned function ends it and logs its duration
+func startSpan(r *http.Request, name string) func() {
+	return server.StartSpan(r, name, log)
+}
+
+// recoverPanic logs the panic of a handler and answers with a 500
+func recoverPanic(w http.ResponseWriter, r *http.Request, err interface{}) {
+	// the request ID stage is inside the recovery, its header is still set
+	log.Errorw("panic serving request",
+		"request_id", w.Header().Get(server.RequestIDHeader),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", fmt.Sprint(err),
+		"stack", string(debug.Stack()),
+	)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// newMiddlewareStack returns the middleware chain of the API, from the
+// outermost stage to the innermost
+func newMiddlewareStack(h httpHandler, conf *Config) (*server.Stack, error) {
+	stack, err := server.NewDefaultStack(server.DefaultConfig{
+		Logger:       log,
+		OnPanic:      recoverPanic,
+		AccessLog:    conf.AccessLog,
+		Metrics:      h.Requests,
+		CORS:         conf.CORS,
+		CacheClasses: cacheClasses,
+		CacheControl: cacheControlConfig(conf.CacheControl),
+		Timeouts:     server.NewRouteTimeouts(defaultRouteTimeouts, conf.Timeouts),
+		Limiter:      h.Limiter,
+		Authorize: func(r *http.Request) bool {
+			return h.isPrivileged(r.Header.Get("Authorization"))
+		},
+		Tracker: &h.Archives.users,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache_control: %w", err)
+	}
+
+	return stack, nil
+}
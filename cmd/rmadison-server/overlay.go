@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"gopkg.in/yaml.v3"
+)
+
+// overlay adds custom annotations (owner team, criticality...) to the
+// packages returned by the server. The overlay file is YAML (or JSON)
+// mapping package names or patterns to annotations:
+//
+//	packages:
+//	  openssl:
+//	    owner: security
+//	  linux-*:
+//	    owner: kernel
+//
+// The file is reloaded when it changes.
+type overlay struct {
+	path string
+
+	lock     sync.Mutex
+	modTime  time.Time
+	packages map[string]map[string]string
+}
+
+func newOverlay(path string) *overlay {
+	return &overlay{
+		path: path,
+	}
+}
+
+// reload reads the overlay file again if it has been modified, o.lock must
+// be held
+func (o *overlay) reload() {
+	info, err := os.Stat(o.path)
+	if err != nil {
+		log.Errorf("cannot read overlay file: %v", err)
+		return
+	}
+	if info.ModTime().Equal(o.modTime) {
+		return
+	}
+
+	content, err := os.ReadFile(o.path)
+	if err != nil {
+		log.Errorf("cannot read overlay file: %v", err)
+		return
+	}
+
+	rawOverlay := new(struct {
+		Packages map[string]map[string]string `yaml:"packages"`
+	})
+	err = yaml.Unmarshal(content, rawOverlay)
+	if err != nil {
+		log.Errorf("cannot parse overlay file %v: %v", o.path, err)
+		return
+	}
+
+	o.modTime = info.ModTime()
+	o.packages = rawOverlay.Packages
+	log.Infof("loaded annotations for %v packages from %v", len(o.packages), o.path)
+}
+
+// annotations returns the annotations for a package, annotations defined
+// for the exact name take precedence over the ones from patterns
+func (o *overlay) annotations(name string) map[string]string {
+	var annotations map[string]string
+	for pattern, values := range o.packages {
+		if pattern == name {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for key, value := range values {
+			annotations[key] = value
+		}
+	}
+
+	if values, ok := o.packages[name]; ok {
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for key, value := range values {
+			annotations[key] = value
+		}
+	}
+
+	return annotations
+}
+
+// Annotate adds the annotations to the packages
+func (o *overlay) Annotate(pkgs []*debianpkg.PackageInfo) {
+	if o == nil {
+		return
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.reload()
+	for _, pkg := range pkgs {
+		pkg.Annotations = o.annotations(pkg.Name)
+	}
+}
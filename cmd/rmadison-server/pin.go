@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gjolly/go-rmadison/pkg/pinning"
+)
+
+// pinRequest is the body of a pinning simulation
+type pinRequest struct {
+	// Preferences is the content of an APT preferences file
+	Preferences string `json:"preferences"`
+	// Suites are the suites in the sources of the machine (e.g. noble,
+	// noble-updates), all the suites are used if empty
+	Suites       []string `json:"suites"`
+	Packages     []string `json:"packages"`
+	Architecture string   `json:"architecture"`
+	// DefaultRelease is APT::Default-Release
+	DefaultRelease string `json:"default_release"`
+}
+
+// pinVersion is a version of a package with its priority
+type pinVersion struct {
+	Archive  string `json:"archive"`
+	Version  string `json:"version"`
+	Suite    string `json:"suite"`
+	Priority int    `json:"priority"`
+}
+
+// pinResult is the version APT would select for a package, Candidate is
+// nil if no version can be installed
+type pinResult struct {
+	Package   string       `json:"package"`
+	Candidate *pinVersion  `json:"candidate"`
+	Versions  []pinVersion `json:"versions"`
+}
+
+// servePinSimulation computes which version APT would select for each
+// package given a preferences file and a set of suites
+func (h httpHandler) servePinSimulation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	req := new(pinRequest)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if len(req.Packages) == 0 || len(req.Packages) > maxBatchPackages {
+		writeError(w, http.StatusBadRequest, "between 1 and %v packages are required", maxBatchPackages)
+		return
+	}
+
+	pins, err := pinning.Parse(req.Preferences)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	suites := make(map[string]bool, len(req.Suites))
+	for _, suite := range req.Suites {
+		suites[suite] = true
+	}
+
+	results := make([]pinResult, 0, len(req.Packages))
+	for _, pkg := range req.Packages {
+		candidates := make([]*pinning.Candidate, 0)
+		archives := make(map[*pinning.Candidate]string)
+		for _, cache := range h.Archives.Enabled() {
+			allInfo, err := cache.Database.GetPackage(pkg)
+			if err != nil {
+				requestLogger(r).Error(err)
+				h.Archives.Check(cache, err)
+				writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+				return
+			}
+			// the suites of the request and of the pins are public names
+			h.translateSuites(cache, allInfo)
+
+			for _, info := range allInfo {
+				if len(suites) != 0 && !suites[info.Suite+info.Pocket] {
+					continue
+				}
+				if req.Architecture != "" && info.Architecture != req.Architecture && info.Architecture != "all" {
+					continue
+				}
+
+				candidate := &pinning.Candidate{PackageInfo: info, Origin: cache.BaseURL.Hostname()}
+				candidate.NotAutomatic, candidate.ButAutomaticUpgrades = cache.Automatic(h.archiveSuite(cache, info.Suite+info.Pocket))
+				candidates = append(candidates, candidate)
+				archives[candidate] = cache.Name
+			}
+		}
+
+		result := pinResult{
+			Package:  pkg,
+			Versions: make([]pinVersion, len(candidates)),
+		}
+		for i, candidate := range candidates {
+			result.Versions[i] = pinVersion{
+				Archive:  archives[candidate],
+				Version:  candidate.Version,
+				Suite:    candidate.Suite + candidate.Pocket,
+				Priority: pinning.Priority(pins, req.DefaultRelease, candidate),
+			}
+		}
+
+		if selected, priority := pinning.Select(pins, req.DefaultRelease, candidates); selected != nil {
+			result.Candidate = &pinVersion{
+				Archive:  archives[selected],
+				Version:  selected.Version,
+				Suite:    selected.Suite + selected.Pocket,
+				Priority: priority,
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	writeJSON(w, r, http.StatusOK, results)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// servePkg routes the /pkg/<name>/<action> endpoints
+func (h httpHandler) servePkg(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/pkg/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+		return
+	}
+	pkg, action := parts[0], parts[1]
+
+	switch action {
+	case "details":
+		h.serveDetails(w, r, pkg)
+	case "changelog":
+		h.serveChangelog(w, r, pkg)
+	case "source":
+		h.serveSourcePackage(w, r, pkg)
+	default:
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+	}
+}
+
+// lookup returns the package from all the archives, with the name of the
+// archive each version was found in
+func (h httpHandler) lookup(r *http.Request, pkg string) ([]*debianpkg.PackageInfo, error) {
+	allInfo := make([]*debianpkg.PackageInfo, 0)
+	for _, cache := range h.Archives.Enabled() {
+		endSpan := startSpan(r, "db.GetPackage "+cache.Name)
+		allInfoArchive, err := cache.Database.GetPackage(pkg)
+		endSpan()
+		if err != nil {
+			h.Archives.Check(cache, err)
+			return nil, err
+		}
+		h.redact(r.Context(), cache, allInfoArchive)
+		h.translateSuites(cache, allInfoArchive)
+		setArchive(cache, allInfoArchive)
+		allInfo = append(allInfo, allInfoArchive...)
+	}
+
+	return allInfo, nil
+}
+
+// lookupWithBinaries is lookup with the binaries built from the source
+// package pkg
+func (h httpHandler) lookupWithBinaries(r *http.Request, pkg string) ([]*debianpkg.PackageInfo, error) {
+	allInfo, err := h.lookup(r, pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(allInfo))
+	for _, info := range allInfo {
+		seen[info.Archive+"/"+info.Suite+info.Pocket+"/"+info.Architecture+"/"+info.Name] = true
+	}
+	for _, cache := range h.Archives.Enabled() {
+		endSpan := startSpan(r, "db.GetPackagesBySource "+cache.Name)
+		binaries, err := cache.Database.GetPackagesBySource(pkg)
+		endSpan()
+		if err != nil {
+			h.Archives.Check(cache, err)
+			return nil, err
+		}
+		h.redact(r.Context(), cache, binaries)
+		h.translateSuites(cache, binaries)
+		setArchive(cache, binaries)
+
+		for _, info := range binaries {
+			key := info.Archive + "/" + info.Suite + info.Pocket + "/" + info.Architecture + "/" + info.Name
+			if name, _ := info.SourceNameVersion(); name != pkg || seen[key] {
+				continue
+			}
+			seen[key] = true
+			allInfo = append(allInfo, info)
+		}
+	}
+
+	return allInfo, nil
+}
+
+// setArchive records the archive the packages were found in, and their
+// download URLs
+func setArchive(cache *archive.Archive, pkgs []*debianpkg.PackageInfo) {
+	for _, pkg := range pkgs {
+		pkg.Archive = cache.Name
+		pkg.URL = cache.PoolURL(pkg)
+	}
+}
+
+// serveDetails returns the complete metadata of a package, usually
+// restricted to a suite and an architecture
+func (h httpHandler) serveDetails(w http.ResponseWriter, r *http.Request, pkg string) {
+	allInfo, err := h.lookup(r, pkg)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+		return
+	}
+
+	allInfo, err = filterPackages(r, allInfo)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if len(allInfo) == 0 {
+		writeError(w, http.StatusNotFound, "package %v not found", pkg)
+		return
+	}
+	err = h.translateDescriptions(r, allInfo)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+		return
+	}
+	h.Overlay.Annotate(allInfo)
+
+	writeGroupedPackages(w, r, allInfo)
+}
+
+// serveChangelog returns the changelog of the package in the suite given
+// in parameter, or of its newest version if there is none
+func (h httpHandler) serveChangelog(w http.ResponseWriter, r *http.Request, pkg string) {
+	suite := r.URL.Query().Get("suite")
+
+	var (
+		newest      *debianpkg.PackageInfo
+		newestCache *archive.Archive
+	)
+	for _, cache := range h.Archives.Enabled() {
+		allInfo, err := cache.Database.GetPackage(pkg)
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+
+		for _, info := range allInfo {
+			if suite != "" && info.Suite+info.Pocket != suite {
+				continue
+			}
+			if newest == nil || version.Compare(info.Version, newest.Version) > 0 {
+				newest = info
+				newestCache = cache
+			}
+		}
+	}
+
+	if newest == nil {
+		writeError(w, http.StatusNotFound, "package %v not found", pkg)
+		return
+	}
+
+	changelog, err := newestCache.Changelog(newest)
+	if err != nil {
+		requestLogger(r).Errorf("failed to get changelog of %v: %v", pkg, err)
+		writeError(w, http.StatusBadGateway, "failed to fetch the changelog of %v", pkg)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(changelog))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// defaultKeepGenerations is the number of snapshots kept in the bucket
+// for each archive when keep is not set
+const defaultKeepGenerations = 5
+
+// PublishConfig configures the upload of the snapshots to an S3
+// compatible object storage (S3, GCS with HMAC keys, MinIO...)
+type PublishConfig struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Insecure  bool   `yaml:"insecure"`
+	// Keep is the number of generations kept for each archive
+	Keep int `yaml:"keep"`
+}
+
+// generation is a snapshot published in the bucket
+type generation struct {
+	Generation string    `json:"generation"`
+	Object     string    `json:"object"`
+	Size       int64     `json:"size"`
+	Packages   int       `json:"packages"`
+	Created    time.Time `json:"created"`
+}
+
+// snapshotManifest lists the snapshots of an archive available in the
+// bucket, the newest one first
+type snapshotManifest struct {
+	Archive     string        `json:"archive"`
+	Latest      string        `json:"latest"`
+	Generations []*generation `json:"generations"`
+}
+
+// snapshotPublisher uploads a snapshot of an archive after each refresh
+// that changed it, with a manifest of the generations
+type snapshotPublisher struct {
+	conf   PublishConfig
+	client *minio.Client
+
+	lock sync.Mutex
+	// published are the archives published since the start
+	published map[string]bool
+}
+
+func newSnapshotPublisher(conf PublishConfig) (*snapshotPublisher, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("endpoint and bucket are required to publish snapshots")
+	}
+	if conf.Keep <= 0 {
+		conf.Keep = defaultKeepGenerations
+	}
+
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure: !conf.Insecure,
+		Region: conf.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotPublisher{
+		conf:      conf,
+		client:    client,
+		published: make(map[string]bool),
+	}, nil
+}
+
+// OnRefresh publishes a new snapshot of the archive if the refresh
+// updated it, or if it hasn't been published yet
+func (p *snapshotPublisher) OnRefresh(cache *archive.Archive, status archive.RefreshStatus) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if status.LastError != "" || (status.UpdatedPackages == 0 && p.published[cache.Name]) {
+		return
+	}
+
+	err := p.publish(cache, status.LastRefresh)
+	if err != nil {
+		log.Errorf("[publish][%v] failed to publish snapshot: %v", cache.Name, err)
+		return
+	}
+	p.published[cache.Name] = true
+}
+
+func (p *snapshotPublisher) objectName(parts ...string) string {
+	return path.Join(append([]string{p.conf.Prefix}, parts...)...)
+}
+
+// publish uploads a snapshot, updates the manifest and deletes the
+// generations that are not kept anymore, p.lock must be held
+func (p *snapshotPublisher) publish(cache *archive.Archive, refreshed time.Time) error {
+	ctx := context.Background()
+
+	tmpDir, err := os.MkdirTemp(cache.CacheDir, "publish")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := path.Join(tmpDir, cache.Name+".db")
+	err = cache.Database.Snapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	nbPackages, err := cache.Database.CountPackages()
+	if err != nil {
+		return err
+	}
+
+	name := refreshed.UTC().Format("20060102T150405Z")
+	object := p.objectName(cache.Name, name+".db")
+	info, err := p.client.FPutObject(ctx, p.conf.Bucket, object, snapshotPath, minio.PutObjectOptions{
+		ContentType: "application/vnd.sqlite3",
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("[publish][%v] uploaded %v (%v bytes)", cache.Name, object, info.Size)
+
+	manifest, err := p.readManifest(ctx, cache.Name)
+	if err != nil {
+		return err
+	}
+
+	manifest.Latest = name
+	manifest.Generations = append([]*generation{{
+		Generation: name,
+		Object:     object,
+		Size:       info.Size,
+		Packages:   nbPackages,
+		Created:    time.Now(),
+	}}, manifest.Generations...)
+
+	var expired []*generation
+	if len(manifest.Generations) > p.conf.Keep {
+		expired = manifest.Generations[p.conf.Keep:]
+		manifest.Generations = manifest.Generations[:p.conf.Keep]
+	}
+
+	err = p.writeManifest(ctx, manifest)
+	if err != nil {
+		return err
+	}
+
+	// the manifest doesn't reference them anymore
+	for _, gen := range expired {
+		err := p.client.RemoveObject(ctx, p.conf.Bucket, gen.Object, minio.RemoveObjectOptions{})
+		if err != nil {
+			log.Errorf("[publish][%v] failed to delete %v: %v", cache.Name, gen.Object, err)
+		}
+	}
+
+	return nil
+}
+
+// readManifest returns the manifest of the archive from the bucket or a
+// new one if it doesn't exist
+func (p *snapshotPublisher) readManifest(ctx context.Context, name string) (*snapshotManifest, error) {
+	manifest := &snapshotManifest{Archive: name}
+
+	object, err := p.client.GetObject(ctx, p.conf.Bucket, p.objectName(name, "manifest.json"), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	err = json.NewDecoder(object).Decode(manifest)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return manifest, nil
+		}
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func (p *snapshotPublisher) writeManifest(ctx context.Context, manifest *snapshotManifest) error {
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = p.client.PutObject(ctx, p.conf.Bucket, p.objectName(manifest.Archive, "manifest.json"),
+		bytes.NewReader(manifestBytes), int64(len(manifestBytes)), minio.PutObjectOptions{
+			ContentType:  "application/json",
+			CacheControl: "no-cache",
+		})
+
+	return err
+}
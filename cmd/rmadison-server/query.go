@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// latestPerArchitecture keeps only the highest version of each
+// architecture, across all the suites and archives
+func latestPerArchitecture(pkgs []*debianpkg.PackageInfo) []*debianpkg.PackageInfo {
+	latest := make(map[string]*debianpkg.PackageInfo)
+	order := make([]string, 0)
+	for _, pkg := range pkgs {
+		current, ok := latest[pkg.Architecture]
+		if !ok {
+			order = append(order, pkg.Architecture)
+		}
+		if !ok || version.Compare(pkg.Version, current.Version) > 0 {
+			latest[pkg.Architecture] = pkg
+		}
+	}
+
+	out := make([]*debianpkg.PackageInfo, len(order))
+	for i, arch := range order {
+		out[i] = latest[arch]
+	}
+
+	return out
+}
+
+// versionFilters maps the query parameters filtering on the version to
+// the comparison they accept
+var versionFilters = map[string]func(cmp int) bool{
+	"version_lt": func(cmp int) bool { return cmp < 0 },
+	"version_le": func(cmp int) bool { return cmp <= 0 },
+	"version_eq": func(cmp int) bool { return cmp == 0 },
+	"version_ge": func(cmp int) bool { return cmp >= 0 },
+	"version_gt": func(cmp int) bool { return cmp > 0 },
+}
+
+// filterVersions keeps the packages whose version satisfies all the
+// version_* parameters of the query
+func filterVersions(query url.Values, pkgs []*debianpkg.PackageInfo) []*debianpkg.PackageInfo {
+	out := make([]*debianpkg.PackageInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		keep := true
+		for param, accept := range versionFilters {
+			if !query.Has(param) {
+				continue
+			}
+			if !accept(version.Compare(pkg.Version, query.Get(param))) {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			out = append(out, pkg)
+		}
+	}
+
+	return out
+}
+
+// filterConstraints keeps the packages whose version satisfies the
+// constraints, the versions that can't be parsed are dropped
+func filterConstraints(constraints version.Constraints, pkgs []*debianpkg.PackageInfo) []*debianpkg.PackageInfo {
+	out := make([]*debianpkg.PackageInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		v, err := version.Parse(pkg.Version)
+		if err == nil && constraints.Satisfies(v) {
+			out = append(out, pkg)
+		}
+	}
+
+	return out
+}
+
+// fieldValues returns the values of a filter parameter, they are comma
+// separated (arch=amd64,arm64), nil if the parameter is not set
+func fieldValues(query url.Values, param string) map[string]bool {
+	if query.Get(param) == "" {
+		return nil
+	}
+
+	values := make(map[string]bool)
+	for _, value := range strings.Split(query.Get(param), ",") {
+		values[strings.TrimSpace(value)] = true
+	}
+
+	return values
+}
+
+// expandOverlay is the value of the expand parameter adding the base
+// suite of the pockets to the suite filter
+const expandOverlay = "overlay"
+
+// overlaySuites adds the base suite of each pocket to the suites: an APT
+// client with jammy-updates enabled also has jammy, and installs the
+// highest version of both. The base suite is found by removing the longest
+// of the pockets, the suite names can contain dashes (my-distro-updates).
+func overlaySuites(suites map[string]bool, pockets []string) map[string]bool {
+	expanded := make(map[string]bool, 2*len(suites))
+	for suite := range suites {
+		expanded[suite] = true
+		base := ""
+		for _, pocket := range pockets {
+			if trimmed, ok := strings.CutSuffix(suite, pocket); ok && pocket != "" && trimmed != "" && (base == "" || len(trimmed) < len(base)) {
+				base = trimmed
+			}
+		}
+		if base != "" {
+			expanded[base] = true
+		}
+	}
+
+	return expanded
+}
+
+// knownPockets returns the default pockets and the ones of the packages
+func knownPockets(pkgs []*debianpkg.PackageInfo) []string {
+	known := make(map[string]bool)
+	pockets := make([]string, 0, len(archive.DefaultPocketSuffixes))
+	for _, pocket := range archive.DefaultPocketSuffixes {
+		known[pocket] = true
+		pockets = append(pockets, pocket)
+	}
+	for _, pkg := range pkgs {
+		if !known[pkg.Pocket] {
+			known[pkg.Pocket] = true
+			pockets = append(pockets, pkg.Pocket)
+		}
+	}
+
+	return pockets
+}
+
+// filterFields keeps the packages matching the suite (e.g. noble-updates),
+// arch and component parameters of the query, each can be a list. With
+// expand=overlay, the base suites of the pockets are kept too.
+func filterFields(query url.Values, pkgs []*debianpkg.PackageInfo) []*debianpkg.PackageInfo {
+	suites := fieldValues(query, "suite")
+	if suites != nil && query.Get("expand") == expandOverlay {
+		suites = overlaySuites(suites, knownPockets(pkgs))
+	}
+	archs := fieldValues(query, "arch")
+	components := fieldValues(query, "component")
+
+	out := make([]*debianpkg.PackageInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if suites != nil && !suites[pkg.Suite+pkg.Pocket] {
+			continue
+		}
+		if archs != nil && !archs[pkg.Architecture] {
+			continue
+		}
+		if components != nil && !components[pkg.Component] {
+			continue
+		}
+
+		out = append(out, pkg)
+	}
+
+	return out
+}
+
+// filterPackages applies the filters from the query parameters of the
+// lookup endpoint to the packages found
+func filterPackages(r *http.Request, pkgs []*debianpkg.PackageInfo) ([]*debianpkg.PackageInfo, error) {
+	query := r.URL.Query()
+
+	if expand := query.Get("expand"); expand != "" && expand != expandOverlay {
+		return nil, fmt.Errorf("invalid expand parameter %q (overlay)", expand)
+	}
+	pkgs = filterFields(query, pkgs)
+	pkgs = filterVersions(query, pkgs)
+
+	if query.Has("satisfies") {
+		constraints, err := version.ParseConstraints(query.Get("satisfies"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid satisfies parameter: %v", err)
+		}
+		pkgs = filterConstraints(constraints, pkgs)
+	}
+
+	err := sortPackages(query, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Has("latest") {
+		latest, err := strconv.ParseBool(query.Get("latest"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid latest parameter %q", query.Get("latest"))
+		}
+		if latest {
+			pkgs = latestPerArchitecture(pkgs)
+		}
+	}
+
+	return pkgs, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestOverlaySuites(t *testing.T) {
+	pockets := knownPockets([]*debianpkg.PackageInfo{
+		{Suite: "bookworm", Pocket: "-proposed-updates"},
+		{Suite: "noble", Pocket: "-updates"},
+	})
+
+	tests := []struct {
+		suites   []string
+		expected []string
+	}{
+		{[]string{"noble"}, []string{"noble"}},
+		{[]string{"noble-updates"}, []string{"noble", "noble-updates"}},
+		{[]string{"noble-security", "jammy-backports"}, []string{"jammy", "jammy-backports", "noble", "noble-security"}},
+		// the suite names can contain dashes
+		{[]string{"my-distro-updates"}, []string{"my-distro", "my-distro-updates"}},
+		{[]string{"my-distro"}, []string{"my-distro"}},
+		// the pockets of the packages are known too
+		{[]string{"bookworm-proposed-updates"}, []string{"bookworm", "bookworm-proposed-updates"}},
+		{[]string{"-updates"}, []string{"-updates"}},
+	}
+
+	for _, test := range tests {
+		suites := make(map[string]bool)
+		for _, suite := range test.suites {
+			suites[suite] = true
+		}
+		expanded := make([]string, 0)
+		for suite := range overlaySuites(suites, pockets) {
+			expanded = append(expanded, suite)
+		}
+		sort.Strings(expanded)
+		if fmt.Sprint(expanded) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.suites, test.expected, expanded)
+		}
+	}
+}
+
+func TestFilterFieldsOverlay(t *testing.T) {
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "hello", Version: "1.0", Suite: "my-distro", Architecture: "amd64"},
+		{Name: "hello", Version: "1.1", Suite: "my-distro", Pocket: "-updates", Architecture: "amd64"},
+		{Name: "hello", Version: "1.2", Suite: "my-distro", Pocket: "-proposed", Architecture: "amd64"},
+		{Name: "hello", Version: "1.0", Suite: "my", Architecture: "amd64"},
+	}
+
+	filtered := filterFields(url.Values{"suite": {"my-distro-updates"}, "expand": {expandOverlay}}, pkgs)
+	if len(filtered) != 2 || filtered[0] != pkgs[0] || filtered[1] != pkgs[1] {
+		t.Errorf("unexpected packages %v", filtered)
+	}
+	filtered = filterFields(url.Values{"suite": {"my-distro-updates"}}, pkgs)
+	if len(filtered) != 1 || filtered[0] != pkgs[1] {
+		t.Errorf("unexpected packages %v", filtered)
+	}
+}
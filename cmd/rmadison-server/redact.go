@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// redactions are the fields that can be hidden from the packages of the
+// private archives
+var redactions = map[string]func(pkg *debianpkg.PackageInfo){
+	// the path in the pool, i.e. the URL to download the package
+	"filename": func(pkg *debianpkg.PackageInfo) { pkg.FileName = "" },
+	"sha256":   func(pkg *debianpkg.PackageInfo) { pkg.SHA256 = "" },
+	"size": func(pkg *debianpkg.PackageInfo) {
+		pkg.Size = 0
+		pkg.InstalledSize = 0
+	},
+	"maintainer": func(pkg *debianpkg.PackageInfo) { pkg.Maintainer = nil },
+	"maintainer_email": func(pkg *debianpkg.PackageInfo) {
+		if pkg.Maintainer != nil {
+			pkg.Maintainer = &debianpkg.PackageMaintainer{Name: pkg.Maintainer.Name}
+		}
+	},
+	"description": func(pkg *debianpkg.PackageInfo) { pkg.Description = "" },
+	"homepage":    func(pkg *debianpkg.PackageInfo) { pkg.Homepage = "" },
+	"depends": func(pkg *debianpkg.PackageInfo) {
+		pkg.Depends = nil
+		pkg.PreDepends = nil
+		pkg.Replaces = nil
+		pkg.Conflicts = nil
+		pkg.Suggests = nil
+		pkg.Recommends = nil
+		pkg.Provides = nil
+		pkg.Breaks = nil
+		pkg.Enhances = nil
+		pkg.BuiltUsing = ""
+	},
+}
+
+// sourceRedactions are the same fields for the source packages, the ones
+// missing from the Sources indexes are not listed
+var sourceRedactions = map[string]func(src *sourcePackageInfo){
+	// the pool directory and the URL of the .dsc
+	"filename": func(src *sourcePackageInfo) {
+		src.Directory = ""
+		src.DSC = ""
+	},
+	"sha256": func(src *sourcePackageInfo) {
+		for i := range src.Files {
+			src.Files[i].SHA256 = ""
+		}
+	},
+	"size": func(src *sourcePackageInfo) {
+		for i := range src.Files {
+			src.Files[i].Size = 0
+		}
+	},
+	"maintainer": func(src *sourcePackageInfo) { src.Maintainer = "" },
+	"maintainer_email": func(src *sourcePackageInfo) {
+		if name, _, ok := strings.Cut(src.Maintainer, " <"); ok {
+			src.Maintainer = name
+		}
+	},
+}
+
+// defaultRedactions are applied to the private archives without redact
+var defaultRedactions = []string{"filename", "sha256", "maintainer_email"}
+
+// checkRedactions returns an error if a field can't be redacted
+func checkRedactions(fields []string) error {
+	for _, field := range fields {
+		if _, ok := redactions[field]; !ok {
+			return fmt.Errorf("unknown redacted field %q", field)
+		}
+	}
+
+	return nil
+}
+
+// isPrivileged checks the value of an Authorization header, the requests
+// with the admin token or one of the privileged tokens see the private
+// archives unredacted
+func (h httpHandler) isPrivileged(authorization string) bool {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	privileged := false
+	for _, expected := range append([]string{h.AdminToken}, h.PrivilegedTokens...) {
+		if expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			privileged = true
+		}
+	}
+
+	return privileged
+}
+
+// isRedacted tells if a field of the packages of the archive is hidden
+// from the request
+func (h httpHandler) isRedacted(ctx context.Context, cache *archive.Archive, field string) bool {
+	if server.Privileged(ctx) {
+		return false
+	}
+
+	for _, redacted := range h.Archives.Redactions(cache) {
+		if redacted == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactSources hides the fields configured for the archive of the source
+// packages, unless the request is privileged
+func (h httpHandler) redactSources(ctx context.Context, cache *archive.Archive, sources []sourcePackageInfo) {
+	if server.Privileged(ctx) {
+		return
+	}
+
+	fields := h.Archives.Redactions(cache)
+	for i := range sources {
+		for _, field := range fields {
+			if redact, ok := sourceRedactions[field]; ok {
+				redact(&sources[i])
+			}
+		}
+	}
+}
+
+// redact hides the fields configured for the archive of the packages,
+// unless the request is privileged
+func (h httpHandler) redact(ctx context.Context, cache *archive.Archive, pkgs []*debianpkg.PackageInfo) {
+	if server.Privileged(ctx) {
+		return
+	}
+
+	fields := h.Archives.Redactions(cache)
+	for _, pkg := range pkgs {
+		for _, field := range fields {
+			redactions[field](pkg)
+		}
+	}
+}
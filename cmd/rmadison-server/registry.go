@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/server"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var errArchiveNotFound = errors.New("archive not found")
+
+// refreshInterval is the time between two refreshes of an archive
+const refreshInterval = 5 * time.Minute
+
+// recoveryInterval is the time between two attempts to re-initialize the
+// database of a quarantined archive
+const recoveryInterval = time.Minute
+
+type registeredArchive struct {
+	*archive.Archive
+
+	conf *archiveYAMLConf
+	stop chan struct{}
+	// unhealthy is the reason the archive is quarantined: its database
+	// can't be used, it's not served until it's re-initialized
+	unhealthy string
+}
+
+// integrity checks of the databases when they are opened, see
+// database.CheckIntegrity
+const (
+	integrityCheckRepair = "repair"
+	integrityCheckRefuse = "refuse"
+	integrityCheckOff    = "off"
+)
+
+// archiveRegistry holds the archives served, they can be added, disabled
+// and removed at runtime. When a state file is configured, the list of
+// archives is saved to it on every change and it takes precedence over
+// the archives from the config file on startup.
+type archiveRegistry struct {
+	lock     sync.RWMutex
+	archives []*registeredArchive
+	// users are the requests in progress, the databases of the archives
+	// removed are closed once the requests that could use them are done
+	users server.RequestTracker
+
+	cacheDir   string
+	stateFile  string
+	httpClient *resty.Client
+	refreshing bool
+	hooks      archiveHooks
+	// integrityCheck is repair, refuse (the archive is quarantined until
+	// its database is fixed) or off
+	integrityCheck string
+}
+
+// archiveHooks are called for the events of all the archives, they can
+// be nil
+type archiveHooks struct {
+	OnChange  func(archive.PackageChange)
+	OnRefresh func(*archive.Archive, archive.RefreshStatus)
+}
+
+type registryState struct {
+	Archives []*archiveYAMLConf `yaml:"archives"`
+}
+
+func newArchiveRegistry(conf *Config, hooks archiveHooks) (*archiveRegistry, error) {
+	r := &archiveRegistry{
+		cacheDir:   conf.CacheDirectory,
+		stateFile:  conf.StateFile,
+		httpClient: resty.New(),
+		hooks:      hooks,
+
+		integrityCheck: conf.IntegrityCheck,
+	}
+	switch r.integrityCheck {
+	case "":
+		r.integrityCheck = integrityCheckRepair
+	case integrityCheckRepair, integrityCheckRefuse, integrityCheckOff:
+	default:
+		return nil, fmt.Errorf("invalid integrity_check %q", conf.IntegrityCheck)
+	}
+
+	archiveConfs := conf.Archives
+	if r.stateFile != "" {
+		stateBytes, err := os.ReadFile(r.stateFile)
+		if err == nil {
+			state := new(registryState)
+			err = yaml.Unmarshal(stateBytes, state)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse state file %v: %v", r.stateFile, err)
+			}
+			log.Infof("loading archives from state file %v", r.stateFile)
+			archiveConfs = state.Archives
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	for i, archiveConf := range archiveConfs {
+		if archiveConf.Name == "" && archiveConf.PPA != "" {
+			archiveConf.Name = strings.ReplaceAll(strings.TrimPrefix(archiveConf.PPA, "ppa:"), "/", "-")
+			log.Infof("missing name for archive %v, using %v", i, archiveConf.Name)
+		}
+		if archiveConf.Name == "" {
+			archiveConf.Name = strings.TrimSuffix(path.Base(archiveConf.Database), path.Ext(archiveConf.Database))
+			log.Infof("missing name for archive %v, using %v", i, archiveConf.Name)
+		}
+
+		err := r.add(archiveConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// add initializes the archive and adds it to the registry, r.lock must be
+// held if the registry is in use
+func (r *archiveRegistry) add(archiveConf *archiveYAMLConf) error {
+	if archiveConf.Name == "" {
+		return fmt.Errorf("missing name for archive")
+	}
+	if r.find(archiveConf.Name) != nil {
+		return fmt.Errorf("duplicated archive name %v", archiveConf.Name)
+	}
+
+	cache, err := newArchive(archiveConf, r.cacheDir, r.httpClient)
+	if err != nil {
+		return err
+	}
+	cache.DBPath = archiveConf.Database
+	cache.OnChange = r.hooks.OnChange
+	if r.hooks.OnRefresh != nil {
+		cache.OnRefresh = func(status archive.RefreshStatus) {
+			r.hooks.OnRefresh(cache, status)
+		}
+	}
+
+	entry := &registeredArchive{
+		Archive: cache,
+		conf:    archiveConf,
+	}
+	r.archives = append(r.archives, entry)
+
+	cache.Database, err = r.openDatabase(cache.Name, archiveConf.Database)
+	if err != nil {
+		// serve the other archives, this one is re-initialized later
+		r.quarantine(entry, errors.Wrapf(err, "failed to open database %v", archiveConf.Database))
+	}
+
+	return nil
+}
+
+// openDatabase opens the database of an archive and checks its integrity,
+// the issues found are logged
+func (r *archiveRegistry) openDatabase(name, dbPath string) (*database.DB, error) {
+	db, err := database.NewConn("sqlite3", dbPath)
+	if err != nil || r.integrityCheck == integrityCheckOff {
+		return db, err
+	}
+
+	start := time.Now()
+	issues, err := db.CheckIntegrity(r.integrityCheck == integrityCheckRepair)
+	for _, issue := range issues {
+		log.Warnf("[%v] integrity check: %v", name, issue)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Infof("[%v] integrity of %v checked in %v", name, dbPath, time.Since(start))
+
+	return db, nil
+}
+
+// find returns the archive with this name, r.lock must be held
+func (r *archiveRegistry) find(name string) *registeredArchive {
+	for _, entry := range r.archives {
+		if entry.Name == name {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// All returns all the archives, including the disabled ones
+func (r *archiveRegistry) All() []*registeredArchive {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return append([]*registeredArchive{}, r.archives...)
+}
+
+// Enabled returns the archives to serve, quarantined archives are
+// excluded
+func (r *archiveRegistry) Enabled() []*archive.Archive {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	archives := make([]*archive.Archive, 0, len(r.archives))
+	for _, entry := range r.archives {
+		if !entry.conf.Disabled && entry.unhealthy == "" {
+			archives = append(archives, entry.Archive)
+		}
+	}
+
+	return archives
+}
+
+// Get returns the enabled archive with this name or nil
+func (r *archiveRegistry) Get(name string) *archive.Archive {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	entry := r.find(name)
+	if entry == nil || entry.conf.Disabled || entry.unhealthy != "" {
+		return nil
+	}
+
+	return entry.Archive
+}
+
+// Redactions returns the fields hidden from the unprivileged requests for
+// the archive, nil if it's not private
+func (r *archiveRegistry) Redactions(cache *archive.Archive) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	entry := r.find(cache.Name)
+	if entry == nil || !entry.conf.Private {
+		return nil
+	}
+	if len(entry.conf.Redact) == 0 {
+		return defaultRedactions
+	}
+
+	return entry.conf.Redact
+}
+
+// SuiteNames returns the public names of the suites of the archive
+func (r *archiveRegistry) SuiteNames(cache *archive.Archive) map[string]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	entry := r.find(cache.Name)
+	if entry == nil {
+		return nil
+	}
+
+	return entry.conf.SuiteNames
+}
+
+// Health returns an empty string if the archive is healthy or the reason
+// it's quarantined
+func (r *archiveRegistry) Health(entry *registeredArchive) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return entry.unhealthy
+}
+
+// Check quarantines the archive if err means its database is corrupt, it's
+// meant to be called with the errors of the queries
+func (r *archiveRegistry) Check(cache *archive.Archive, err error) {
+	if !database.IsCorrupt(err) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := r.find(cache.Name)
+	if entry != nil && entry.Archive == cache && entry.unhealthy == "" {
+		r.quarantine(entry, err)
+	}
+}
+
+// quarantine stops serving and refreshing the archive and tries to
+// re-initialize its database in the background, r.lock must be held
+func (r *archiveRegistry) quarantine(entry *registeredArchive, err error) {
+	log.Errorf("[%v] quarantined: %v", entry.Name, err)
+	entry.unhealthy = err.Error()
+	r.stopRefresh(entry)
+
+	go r.recover(entry, database.IsCorrupt(err))
+}
+
+// recover re-initializes the database of the archive until it succeeds or
+// the archive is removed. A corrupt database file is moved aside and
+// replaced with an empty one, the next refresh re-ingests all the indexes.
+func (r *archiveRegistry) recover(entry *registeredArchive, corrupt bool) {
+	for {
+		time.Sleep(recoveryInterval)
+
+		r.lock.RLock()
+		removed := r.find(entry.Name) != entry
+		r.lock.RUnlock()
+		if removed {
+			return
+		}
+
+		// the database is swapped without r.lock, it waits for the
+		// refresh in progress
+		err := r.reinitialize(entry, corrupt)
+
+		r.lock.Lock()
+		if r.find(entry.Name) != entry {
+			// removed in the meantime
+			r.lock.Unlock()
+			return
+		}
+		if err == nil {
+			log.Infof("[%v] database re-initialized", entry.Name)
+			entry.unhealthy = ""
+			if !entry.conf.Disabled {
+				r.startRefresh(entry)
+			}
+			r.lock.Unlock()
+			return
+		}
+
+		log.Errorf("[%v] failed to re-initialize database: %v", entry.Name, err)
+		entry.unhealthy = err.Error()
+		corrupt = corrupt || database.IsCorrupt(err)
+		r.lock.Unlock()
+	}
+}
+
+// reinitialize opens the database of the archive again, after moving it
+// aside if it's corrupt. The refresh and the requests in progress, if any,
+// are done before the database is closed. r.lock must not be held, the
+// refresh can be long.
+func (r *archiveRegistry) reinitialize(entry *registeredArchive, corrupt bool) error {
+	return entry.Reset(func(old *database.DB) (*database.DB, error) {
+		if old != nil {
+			// the quarantined archive is not served anymore, like
+			// Remove
+			r.users.Wait()
+			old.Close()
+		}
+
+		if corrupt {
+			quarantined := fmt.Sprintf("%v.corrupt-%v", entry.DBPath, time.Now().UTC().Format("20060102T150405Z"))
+			err := os.Rename(entry.DBPath, quarantined)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			log.Infof("[%v] corrupt database moved to %v", entry.Name, quarantined)
+
+			// the journal belongs to the corrupt database
+			for _, suffix := range []string{"-wal", "-shm"} {
+				os.Remove(entry.DBPath + suffix)
+			}
+		}
+
+		return r.openDatabase(entry.Name, entry.DBPath)
+	})
+}
+
+// StartRefresh starts refreshing the enabled archives periodically,
+// archives added or enabled later are refreshed as well
+func (r *archiveRegistry) StartRefresh() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.refreshing = true
+	for _, entry := range r.archives {
+		if !entry.conf.Disabled && entry.unhealthy == "" {
+			r.startRefresh(entry)
+		}
+	}
+}
+
+// startRefresh starts the refresh loop of the archive, r.lock must be held
+func (r *archiveRegistry) startRefresh(entry *registeredArchive) {
+	if !r.refreshing || entry.stop != nil || entry.unhealthy != "" {
+		return
+	}
+
+	stop := make(chan struct{})
+	entry.stop = stop
+
+	go func(cache *archive.Archive, backfill *archive.BackfillConfig) {
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		for {
+			// the ticker and stop can be ready together
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			now := time.Now()
+			report, err := cache.RefreshCache(false)
+			duration := time.Now().Sub(now)
+			failed := report.Failed()
+			for _, index := range failed {
+				log.Errorf("[%v][%v] refresh failed: %v", cache.Name, index, index.Error)
+			}
+			for _, refreshErr := range report.Errors {
+				log.Errorf("[%v] refresh failed: %v", cache.Name, refreshErr)
+			}
+			if err != nil {
+				log.Errorf("[%v] cache refreshed in %v with errors, %v packages updated, %v/%v indexes failed", cache.Name, duration.Seconds(), report.UpdatedPackages, len(failed), len(report.Indexes))
+			} else {
+				log.Infof("[%v] cache refreshed in %v, %v packages updated from %v indexes", cache.Name, duration.Seconds(), report.UpdatedPackages, len(report.Indexes))
+			}
+
+			// corruption doesn't surface in the refresh errors, they are
+			// only reported
+			_, dbErr := cache.Database.CountPackages()
+			r.Check(cache, dbErr)
+
+			// the backfill is resumable, it's started once the history
+			// of the first refresh is recorded
+			if backfill != nil && err == nil && dbErr == nil {
+				go func(conf *archive.BackfillConfig) {
+					err := cache.Backfill(conf)
+					if err != nil {
+						log.Errorf("[%v] backfill failed: %v", cache.Name, err)
+					}
+				}(backfill)
+				backfill = nil
+			}
+
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+		}
+	}(entry.Archive, entry.conf.Backfill)
+}
+
+// stopRefresh stops the refresh loop of the archive, r.lock must be held
+func (r *archiveRegistry) stopRefresh(entry *registeredArchive) {
+	if entry.stop == nil {
+		return
+	}
+
+	close(entry.stop)
+	entry.stop = nil
+}
+
+// Add adds a new archive and starts refreshing it, nothing is changed if
+// the state file can't be saved
+func (r *archiveRegistry) Add(archiveConf *archiveYAMLConf) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := r.add(archiveConf)
+	if err != nil {
+		return err
+	}
+
+	entry := r.archives[len(r.archives)-1]
+	err = r.save()
+	if err != nil {
+		// not refreshed nor served yet, the database can be closed now
+		r.unlink(entry)
+		entry.Close()
+		return err
+	}
+
+	if !archiveConf.Disabled {
+		r.startRefresh(entry)
+	}
+
+	return nil
+}
+
+// SetDisabled disables or re-enables an archive. Disabled archives are
+// neither refreshed nor served but their database is kept.
+func (r *archiveRegistry) SetDisabled(name string, disabled bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := r.find(name)
+	if entry == nil {
+		return errArchiveNotFound
+	}
+
+	entry.conf.Disabled = disabled
+	if disabled {
+		r.stopRefresh(entry)
+	} else {
+		r.startRefresh(entry)
+	}
+
+	return r.save()
+}
+
+// Remove stops serving an archive and closes its database once the
+// requests in progress and its refresh are done. The database file is
+// left on disk.
+func (r *archiveRegistry) Remove(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := r.find(name)
+	if entry == nil {
+		return errArchiveNotFound
+	}
+
+	r.stopRefresh(entry)
+	r.unlink(entry)
+
+	go func() {
+		r.users.Wait()
+		err := entry.Close()
+		if err != nil {
+			log.Errorf("[%v] failed to close database: %v", name, err)
+		}
+	}()
+
+	return r.save()
+}
+
+// unlink removes the archive from the registry, r.lock must be held
+func (r *archiveRegistry) unlink(entry *registeredArchive) {
+	for i, registered := range r.archives {
+		if registered == entry {
+			r.archives = append(r.archives[:i], r.archives[i+1:]...)
+			return
+		}
+	}
+}
+
+// save writes the list of archives to the state file, r.lock must be held
+func (r *archiveRegistry) save() error {
+	if r.stateFile == "" {
+		return nil
+	}
+
+	state := registryState{
+		Archives: make([]*archiveYAMLConf, len(r.archives)),
+	}
+	for i, entry := range r.archives {
+		state.Archives[i] = entry.conf
+	}
+
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first to never leave a truncated state file
+	tmpFile := r.stateFile + ".tmp"
+	err = os.WriteFile(tmpFile, stateBytes, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, r.stateFile)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRemoveWaitsForRequests(t *testing.T) {
+	h := newTestHandler(t)
+	db := h.Archives.Get("test").Database
+
+	// a request that got the archive before the removal
+	epoch := h.Archives.users.Enter()
+	err := h.Archives.Remove("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Archives.Get("test") != nil {
+		t.Fatal("the archive is still served")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("the database was closed during the request: %v", err)
+	}
+
+	h.Archives.users.Leave(epoch)
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the database wasn't closed after the request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReinitializeWaitsForRequests(t *testing.T) {
+	h := newTestHandler(t)
+	entry := h.Archives.archives[0]
+	entry.DBPath = path.Join(t.TempDir(), "test.db")
+	db := entry.Database
+
+	// a request that got the archive before the quarantine
+	epoch := h.Archives.users.Enter()
+	done := make(chan error)
+	go func() {
+		done <- h.Archives.reinitialize(entry, false)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("the database was re-initialized during the request: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("the database was closed during the request: %v", err)
+	}
+
+	h.Archives.users.Leave(epoch)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the database wasn't re-initialized after the request")
+	}
+	if db.Ping() == nil {
+		t.Error("the old database wasn't closed")
+	}
+	if entry.Database == db {
+		t.Error("the database wasn't replaced")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gjolly/go-rmadison/pkg/render"
+)
+
+// writeResponse writes the response in the format requested with the
+// format query parameter, or negotiated with the Accept header (see the
+// render package for the formats). JSON is used if the client accepts
+// anything or only formats that can't represent the response.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, resp *render.Response) {
+	candidates := render.Negotiate(r.Header.Get("Accept"))
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := render.Lookup(format); !ok {
+			writeError(w, http.StatusBadRequest, "unknown format %q", format)
+			return
+		}
+		candidates = []string{format}
+	} else {
+		candidates = append(candidates, render.DefaultFormat)
+	}
+
+	for _, format := range candidates {
+		renderer, ok := render.Lookup(format)
+		if !ok {
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		err := renderer.Render(buf, resp)
+		if err == render.ErrUnsupported {
+			continue
+		}
+		if err != nil {
+			requestLogger(r).Errorf("failed to render response as %v: %v", format, err)
+			writeError(w, http.StatusInternalServerError, "failed to render the response")
+			return
+		}
+
+		w.Header().Add("Content-Type", renderer.ContentType())
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(status)
+		w.Write(buf.Bytes())
+		return
+	}
+
+	// a format was given but it can't represent the response
+	writeError(w, http.StatusBadRequest, "format %q not supported for this response", r.URL.Query().Get("format"))
+}
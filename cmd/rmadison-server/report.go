@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// maxReportedChanges is the number of changes listed for each suite in a
+// report, the others are only counted
+const maxReportedChanges = 200
+
+// ReportConfig enables the Markdown summaries of the refreshes
+type ReportConfig struct {
+	// Path is the directory where the summaries are written, in
+	// <archive>/<date>.md
+	Path string `yaml:"path"`
+	// Git commits each summary in the git repository at Path
+	Git bool `yaml:"git"`
+}
+
+// refreshReporter writes a summary of the refreshes that changed an
+// archive or failed, with the changes collected since the previous one
+type refreshReporter struct {
+	conf ReportConfig
+
+	lock sync.Mutex
+	// changes are the packages changed in the refreshes in progress, by
+	// archive
+	changes map[string][]archive.PackageChange
+}
+
+func newRefreshReporter(conf ReportConfig) (*refreshReporter, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("path is required to write the refresh reports")
+	}
+
+	err := os.MkdirAll(conf.Path, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Git {
+		_, err = git(conf.Path, "rev-parse", "--git-dir")
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a git repository: %v", conf.Path, err)
+		}
+	}
+
+	return &refreshReporter{
+		conf:    conf,
+		changes: make(map[string][]archive.PackageChange),
+	}, nil
+}
+
+// OnChange records a change for the report of the refresh in progress
+func (rep *refreshReporter) OnChange(change archive.PackageChange) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	rep.changes[change.Archive] = append(rep.changes[change.Archive], change)
+}
+
+// OnRefresh writes (and commits) the report of a refresh, unless nothing
+// happened
+func (rep *refreshReporter) OnRefresh(cache *archive.Archive, status archive.RefreshStatus) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	changes := rep.changes[cache.Name]
+	delete(rep.changes, cache.Name)
+	if len(changes) == 0 && status.LastError == "" && len(status.Parse.Errors) == 0 {
+		return
+	}
+
+	name := status.LastRefresh.UTC().Format("20060102T150405Z") + ".md"
+	reportPath := path.Join(rep.conf.Path, cache.Name, name)
+	err := rep.write(reportPath, cache.Name, status, changes)
+	if err != nil {
+		log.Errorf("[report][%v] failed to write %v: %v", cache.Name, reportPath, err)
+		return
+	}
+	log.Infof("[report][%v] refresh summary written to %v", cache.Name, reportPath)
+
+	if !rep.conf.Git {
+		return
+	}
+
+	message := fmt.Sprintf("Refresh of %v on %v", cache.Name, status.LastRefresh.UTC().Format("2006-01-02 15:04:05Z"))
+	_, err = git(rep.conf.Path, "add", path.Join(cache.Name, name))
+	if err == nil {
+		_, err = git(rep.conf.Path, "commit", "--quiet", "-m", message)
+	}
+	if err != nil {
+		log.Errorf("[report][%v] failed to commit %v: %v", cache.Name, reportPath, err)
+	}
+}
+
+func (rep *refreshReporter) write(reportPath, archiveName string, status archive.RefreshStatus, changes []archive.PackageChange) error {
+	err := os.MkdirAll(path.Dir(reportPath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	writeReport(buf, archiveName, status, changes)
+
+	return os.WriteFile(reportPath, buf.Bytes(), 0o644)
+}
+
+// writeReport writes the Markdown summary of a refresh: the changes per
+// suite, the errors and the duration
+func writeReport(w io.Writer, archiveName string, status archive.RefreshStatus, changes []archive.PackageChange) {
+	fmt.Fprintf(w, "# Refresh of %v\n\n", archiveName)
+	fmt.Fprintf(w, "- Started: %v\n", status.LastRefresh.UTC().Format("2006-01-02 15:04:05Z"))
+	fmt.Fprintf(w, "- Duration: %.1fs\n", status.LastDuration)
+	fmt.Fprintf(w, "- Packages updated: %v\n", status.UpdatedPackages)
+
+	if status.LastError != "" || len(status.Parse.Errors) != 0 {
+		fmt.Fprintf(w, "\n## Errors\n\n")
+		if status.LastError != "" {
+			fmt.Fprintf(w, "- %v\n", status.LastError)
+		}
+		for _, parseErr := range status.Parse.Errors {
+			fmt.Fprintf(w, "- %v\n", parseErr)
+		}
+	}
+
+	bySuite := make(map[string][]archive.PackageChange)
+	for _, change := range changes {
+		bySuite[change.Suite] = append(bySuite[change.Suite], change)
+	}
+	suites := make([]string, 0, len(bySuite))
+	for suite := range bySuite {
+		suites = append(suites, suite)
+	}
+	sort.Strings(suites)
+
+	if len(suites) != 0 {
+		fmt.Fprintf(w, "\n## Changes\n\n")
+		fmt.Fprintf(w, "| Suite | New | Upgraded | Downgraded |\n")
+		fmt.Fprintf(w, "|---|---|---|---|\n")
+		for _, suite := range suites {
+			var added, upgraded, downgraded int
+			for _, change := range bySuite[suite] {
+				switch {
+				case change.OldVersion == "":
+					added++
+				case version.Compare(change.Version, change.OldVersion) < 0:
+					downgraded++
+				default:
+					upgraded++
+				}
+			}
+			fmt.Fprintf(w, "| %v | %v | %v | %v |\n", suite, added, upgraded, downgraded)
+		}
+	}
+
+	for _, suite := range suites {
+		suiteChanges := bySuite[suite]
+		sort.Slice(suiteChanges, func(i, j int) bool {
+			if suiteChanges[i].Name != suiteChanges[j].Name {
+				return suiteChanges[i].Name < suiteChanges[j].Name
+			}
+			return suiteChanges[i].Architecture < suiteChanges[j].Architecture
+		})
+
+		fmt.Fprintf(w, "\n### %v\n\n", suite)
+		for i, change := range suiteChanges {
+			if i == maxReportedChanges {
+				fmt.Fprintf(w, "- ... and %v more\n", len(suiteChanges)-maxReportedChanges)
+				break
+			}
+
+			oldVersion := "new"
+			if change.OldVersion != "" {
+				oldVersion = change.OldVersion
+			}
+			fmt.Fprintf(w, "- `%v` (%v, %v): %v → %v\n", change.Name, change.Architecture, change.Component, oldVersion, change.Version)
+		}
+	}
+}
+
+// git runs a git command in the repository at dir
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v (%v)", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+)
+
+func TestWriteReport(t *testing.T) {
+	status := archive.RefreshStatus{
+		LastRefresh:     time.Date(2024, 12, 3, 10, 15, 0, 0, time.FixedZone("CET", 3600)),
+		LastDuration:    12.345,
+		LastError:       "failed to refresh noble-proposed",
+		UpdatedPackages: 3,
+		Parse:           archive.ParseStats{Errors: []string{"invalid stanza in main/binary-amd64/Packages.gz"}},
+	}
+	changes := []archive.PackageChange{
+		{Name: "openssl", Suite: "noble-updates", Component: "main", Architecture: "arm64", OldVersion: "3.0.13-0ubuntu3.1", Version: "3.0.13-0ubuntu3.2"},
+		{Name: "openssl", Suite: "noble-updates", Component: "main", Architecture: "amd64", OldVersion: "3.0.13-0ubuntu3.1", Version: "3.0.13-0ubuntu3.2"},
+		{Name: "curl", Suite: "noble-security", Component: "main", Architecture: "amd64", Version: "8.5.0-2ubuntu10.1"},
+		{Name: "hello", Suite: "noble-updates", Component: "universe", Architecture: "all", OldVersion: "2.10-4", Version: "2.10-3"},
+	}
+
+	expected := "# Refresh of ubuntu\n\n" +
+		"- Started: 2024-12-03 09:15:00Z\n" +
+		"- Duration: 12.3s\n" +
+		"- Packages updated: 3\n\n" +
+		"## Errors\n\n" +
+		"- failed to refresh noble-proposed\n" +
+		"- invalid stanza in main/binary-amd64/Packages.gz\n\n" +
+		"## Changes\n\n" +
+		"| Suite | New | Upgraded | Downgraded |\n" +
+		"|---|---|---|---|\n" +
+		"| noble-security | 1 | 0 | 0 |\n" +
+		"| noble-updates | 0 | 2 | 1 |\n\n" +
+		"### noble-security\n\n" +
+		"- `curl` (amd64, main): new → 8.5.0-2ubuntu10.1\n\n" +
+		"### noble-updates\n\n" +
+		"- `hello` (all, universe): 2.10-4 → 2.10-3\n" +
+		"- `openssl` (amd64, main): 3.0.13-0ubuntu3.1 → 3.0.13-0ubuntu3.2\n" +
+		"- `openssl` (arm64, main): 3.0.13-0ubuntu3.1 → 3.0.13-0ubuntu3.2\n"
+
+	out := new(bytes.Buffer)
+	writeReport(out, "ubuntu", status, changes)
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+
+	// no sections without errors nor changes
+	out.Reset()
+	writeReport(out, "ubuntu", archive.RefreshStatus{LastRefresh: status.LastRefresh}, nil)
+	if strings.Contains(out.String(), "##") {
+		t.Errorf("unexpected sections in\n%v", out.String())
+	}
+}
+
+func TestWriteReportTruncated(t *testing.T) {
+	changes := make([]archive.PackageChange, maxReportedChanges+5)
+	for i := range changes {
+		changes[i] = archive.PackageChange{Name: fmt.Sprintf("pkg%03d", i), Suite: "noble", Component: "main", Architecture: "amd64", Version: "1.0"}
+	}
+
+	out := new(bytes.Buffer)
+	writeReport(out, "ubuntu", archive.RefreshStatus{}, changes)
+	if n := strings.Count(out.String(), "\n- `pkg"); n != maxReportedChanges {
+		t.Errorf("expected %v changes listed, got %v", maxReportedChanges, n)
+	}
+	if !strings.Contains(out.String(), fmt.Sprintf("| noble | %v | 0 | 0 |\n", len(changes))) || !strings.HasSuffix(out.String(), "- ... and 5 more\n") {
+		t.Errorf("unexpected report\n%v", out.String())
+	}
+}
+
+func TestRefreshReporter(t *testing.T) {
+	dir := t.TempDir()
+	reporter, err := newRefreshReporter(ReportConfig{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &archive.Archive{Name: "ubuntu"}
+	refresh := time.Date(2024, 12, 3, 10, 15, 0, 0, time.UTC)
+
+	// nothing is written when nothing happened
+	reporter.OnRefresh(cache, archive.RefreshStatus{LastRefresh: refresh})
+	if _, err := os.Stat(path.Join(dir, "ubuntu")); !os.IsNotExist(err) {
+		t.Errorf("unexpected report: %v", err)
+	}
+
+	reporter.OnChange(archive.PackageChange{Archive: "ubuntu", Name: "curl", Suite: "noble", Version: "8.5.0-2"})
+	reporter.OnChange(archive.PackageChange{Archive: "debian", Name: "curl", Suite: "sid", Version: "8.11.0-1"})
+	reporter.OnRefresh(cache, archive.RefreshStatus{LastRefresh: refresh})
+	content, err := os.ReadFile(path.Join(dir, "ubuntu", "20241203T101500Z.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "`curl`") || strings.Contains(string(content), "sid") {
+		t.Errorf("unexpected report\n%s", content)
+	}
+
+	// the changes of the other archives are kept for their refresh
+	if len(reporter.changes["ubuntu"]) != 0 || len(reporter.changes["debian"]) != 1 {
+		t.Errorf("unexpected changes %v", reporter.changes)
+	}
+
+	_, err = newRefreshReporter(ReportConfig{})
+	if err == nil {
+		t.Error("expected an error without path")
+	}
+	_, err = newRefreshReporter(ReportConfig{Path: t.TempDir(), Git: true})
+	if err == nil {
+		t.Error("expected an error outside of a git repository")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// cost classes of the queries, based on the estimated number of rows
+const (
+	cheapQueryRows    = 1000
+	moderateQueryRows = 20000
+)
+
+// queryEstimate is the estimated cost of a search
+type queryEstimate struct {
+	EstimatedRows int      `json:"estimated_rows"`
+	Cost          string   `json:"cost"`
+	Hints         []string `json:"hints"`
+}
+
+// searchResult is the response of a search, Truncated is set when more
+// packages than the limit match
+type searchResult struct {
+	Results   interface{} `json:"results"`
+	Truncated bool        `json:"truncated"`
+}
+
+// searchFilter returns the pattern and the filter of a search
+func searchFilter(query url.Values) (string, database.Filter) {
+	return query.Get("q"), database.Filter{
+		Suite:        query.Get("suite"),
+		Component:    query.Get("component"),
+		Architecture: query.Get("arch"),
+	}
+}
+
+// estimateQuery returns the cost of a search across the archives given
+func estimateQuery(estimates []*database.Estimate, filter database.Filter) queryEstimate {
+	estimate := queryEstimate{
+		Hints: make([]string, 0),
+	}
+
+	indexScan := true
+	for _, e := range estimates {
+		estimate.EstimatedRows += e.Rows
+		indexScan = indexScan && e.IndexScan
+	}
+
+	switch {
+	case indexScan && estimate.EstimatedRows <= cheapQueryRows:
+		estimate.Cost = "cheap"
+	case indexScan && estimate.EstimatedRows <= moderateQueryRows:
+		estimate.Cost = "moderate"
+	default:
+		estimate.Cost = "expensive"
+	}
+
+	if !indexScan {
+		estimate.Hints = append(estimate.Hints, "start the pattern with a literal prefix (e.g. libssl*) to use the index")
+	}
+	if estimate.Cost != "cheap" {
+		if filter.Suite == "" {
+			estimate.Hints = append(estimate.Hints, "filter by suite")
+		}
+		if filter.Architecture == "" {
+			estimate.Hints = append(estimate.Hints, "filter by arch")
+		}
+		if filter.Component == "" {
+			estimate.Hints = append(estimate.Hints, "filter by component")
+		}
+	}
+
+	return estimate
+}
+
+// serveEstimate returns the estimated number of rows and the cost class of
+// a search without running it
+func (h httpHandler) serveEstimate(w http.ResponseWriter, r *http.Request) {
+	pattern, filter := searchFilter(r.URL.Query())
+	if pattern == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	estimates := make([]*database.Estimate, 0)
+	for _, cache := range h.Archives.Enabled() {
+		estimate, err := cache.Database.EstimateSearch(pattern, h.archiveFilter(cache, filter))
+		if err != nil {
+			requestLogger(r).Errorf("failed to estimate %v in %v: %v", pattern, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to search %v", pattern)
+			return
+		}
+		estimates = append(estimates, estimate)
+	}
+
+	writeJSON(w, r, http.StatusOK, estimateQuery(estimates, filter))
+}
+
+// serveSearch returns the packages whose name matches a glob pattern, or
+// whose description matches the full text query of the text parameter
+func (h httpHandler) serveSearch(w http.ResponseWriter, r *http.Request) {
+	pattern, filter := searchFilter(r.URL.Query())
+	text := r.URL.Query().Get("text")
+	if pattern == "" && text == "" {
+		writeError(w, http.StatusBadRequest, "q or text is required")
+		return
+	}
+	// what was searched, for the errors
+	query := pattern
+	if text != "" {
+		query = text
+	}
+
+	limit, err := resultLimit(r, h.Limits.MaxSearchResults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	pkgs := make([]*debianpkg.PackageInfo, 0)
+	truncated := false
+	for _, cache := range h.Archives.Enabled() {
+		remaining := limit - len(pkgs)
+
+		// one more row tells if the results are truncated
+		var found []*debianpkg.PackageInfo
+		if text != "" {
+			endSpan := startSpan(r, "db.SearchDescriptions "+cache.Name)
+			found, err = cache.Database.SearchDescriptions(text, descriptionLangs(r), pattern, h.archiveFilter(cache, filter), remaining+1)
+			endSpan()
+		} else {
+			endSpan := startSpan(r, "db.SearchPackages "+cache.Name)
+			found, err = cache.Database.SearchPackages(pattern, h.archiveFilter(cache, filter), remaining+1)
+			endSpan()
+		}
+		if err != nil {
+			requestLogger(r).Errorf("failed to search %v in %v: %v", query, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to search %v", query)
+			return
+		}
+		if len(found) > remaining {
+			found = found[:remaining]
+			truncated = true
+		}
+		h.redact(r.Context(), cache, found)
+		h.translateSuites(cache, found)
+		setArchive(cache, found)
+		pkgs = append(pkgs, found...)
+		if truncated {
+			break
+		}
+	}
+	err = h.translateDescriptions(r, pkgs)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to search %v", query)
+		return
+	}
+	h.Overlay.Annotate(pkgs)
+
+	err = sortPackages(r.URL.Query(), pkgs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	resp, err := groupedResponse(r.URL.Query(), pkgs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	// the tables have no room for the indicator
+	w.Header().Set("X-Truncated", strconv.FormatBool(truncated))
+	h.setResultHeaders(w, len(pkgs))
+	resp.Value = searchResult{Results: resp.Value, Truncated: truncated}
+
+	writeResponse(w, r, http.StatusOK, resp)
+}
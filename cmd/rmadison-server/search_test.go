@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestEstimateQuery(t *testing.T) {
+	tests := []struct {
+		estimates []*database.Estimate
+		filter    database.Filter
+		expected  queryEstimate
+	}{
+		{
+			[]*database.Estimate{{Rows: 600, IndexScan: true}, {Rows: 400, IndexScan: true}},
+			database.Filter{},
+			queryEstimate{EstimatedRows: 1000, Cost: "cheap", Hints: []string{}},
+		},
+		{
+			[]*database.Estimate{{Rows: 600, IndexScan: true}, {Rows: 401, IndexScan: true}},
+			database.Filter{Suite: "noble"},
+			queryEstimate{EstimatedRows: 1001, Cost: "moderate", Hints: []string{"filter by arch", "filter by component"}},
+		},
+		{
+			[]*database.Estimate{{Rows: 20001, IndexScan: true}},
+			database.Filter{Suite: "noble", Component: "main", Architecture: "amd64"},
+			queryEstimate{EstimatedRows: 20001, Cost: "expensive", Hints: []string{}},
+		},
+		// a single archive without the index makes the search expensive
+		{
+			[]*database.Estimate{{Rows: 10, IndexScan: true}, {Rows: 10}},
+			database.Filter{Architecture: "amd64"},
+			queryEstimate{EstimatedRows: 20, Cost: "expensive", Hints: []string{
+				"start the pattern with a literal prefix (e.g. libssl*) to use the index",
+				"filter by suite",
+				"filter by component",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		estimate := estimateQuery(test.estimates, test.filter)
+		if fmt.Sprint(estimate) != fmt.Sprint(test.expected) {
+			t.Errorf("%+v: expected %+v, got %+v", test.filter, test.expected, estimate)
+		}
+	}
+}
+
+func TestServeSearch(t *testing.T) {
+	router := newRouter(newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "libssl3t64", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "libssl-dev", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "libssl3", Version: "3.0.2-0ubuntu1", Suite: "jammy", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "bash", Version: "5.2.21-2ubuntu4", Suite: "noble", Component: "main", Architecture: "amd64"},
+	))
+
+	tests := []struct {
+		target    string
+		status    int
+		names     []string
+		truncated bool
+	}{
+		{"/api/search?q=libssl*", http.StatusOK, []string{"libssl-dev", "libssl3", "libssl3t64"}, false},
+		{"/api/search?q=libssl*&suite=noble", http.StatusOK, []string{"libssl-dev", "libssl3t64"}, false},
+		{"/api/search?q=libssl*&limit=2", http.StatusOK, []string{"libssl-dev", "libssl3"}, true},
+		{"/api/search?q=libssl*&limit=3", http.StatusOK, []string{"libssl-dev", "libssl3", "libssl3t64"}, false},
+		{"/api/search?q=curl*", http.StatusOK, []string{}, false},
+		{"/api/search", http.StatusBadRequest, nil, false},
+		{"/api/search?q=libssl*&limit=0", http.StatusBadRequest, nil, false},
+		{"/api/search?q=libssl*&limit=many", http.StatusBadRequest, nil, false},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%v: expected %v, got %v: %v", test.target, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var result struct {
+			Results   []debianpkg.PackageInfo `json:"results"`
+			Truncated bool                    `json:"truncated"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &result)
+		if err != nil {
+			t.Fatalf("%v: %v", test.target, err)
+		}
+		names := make([]string, len(result.Results))
+		for i, pkg := range result.Results {
+			names[i] = pkg.Name
+		}
+		if fmt.Sprint(names) != fmt.Sprint(test.names) {
+			t.Errorf("%v: expected %v, got %v", test.target, test.names, names)
+		}
+		if result.Truncated != test.truncated {
+			t.Errorf("%v: expected truncated %v, got %v", test.target, test.truncated, result.Truncated)
+		}
+		if header := w.Header().Get("X-Truncated"); header != fmt.Sprint(test.truncated) {
+			t.Errorf("%v: expected X-Truncated %v, got %v", test.target, test.truncated, header)
+		}
+	}
+}
+
+func TestServeEstimate(t *testing.T) {
+	router := newRouter(newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "libssl3t64", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "bash", Version: "5.2.21-2ubuntu4", Suite: "noble", Component: "main", Architecture: "amd64"},
+	))
+
+	tests := []struct {
+		target   string
+		status   int
+		expected queryEstimate
+	}{
+		{"/api/search/estimate?q=libssl3t64", http.StatusOK, queryEstimate{EstimatedRows: 1, Cost: "cheap", Hints: []string{}}},
+		{"/api/search/estimate?q=*ssl*&suite=noble", http.StatusOK, queryEstimate{Cost: "expensive", Hints: []string{
+			"start the pattern with a literal prefix (e.g. libssl*) to use the index",
+			"filter by arch",
+			"filter by component",
+		}}},
+		{"/api/search/estimate", http.StatusBadRequest, queryEstimate{}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%v: expected %v, got %v: %v", test.target, test.status, w.Code, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var estimate queryEstimate
+		err := json.Unmarshal(w.Body.Bytes(), &estimate)
+		if err != nil {
+			t.Fatalf("%v: %v", test.target, err)
+		}
+		if fmt.Sprint(estimate) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %+v, got %+v", test.target, test.expected, estimate)
+		}
+	}
+}
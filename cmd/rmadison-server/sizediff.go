@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+// defaultSizeDiffSince is the period of /api/size-diff when since is not
+// given
+const defaultSizeDiffSince = 30 * 24 * time.Hour
+
+// sizeDiffEntry is a package whose size changed with its version
+type sizeDiffEntry struct {
+	Archive          string `json:"archive"`
+	Name             string `json:"name"`
+	Suite            string `json:"suite"`
+	Component        string `json:"component"`
+	Architecture     string `json:"architecture"`
+	OldVersion       string `json:"old_version"`
+	Version          string `json:"version"`
+	OldSize          int    `json:"old_size"`
+	Size             int    `json:"size"`
+	OldInstalledSize int    `json:"old_installed_size"`
+	InstalledSize    int    `json:"installed_size"`
+	// Growth is the difference of the size chosen with by
+	Growth int `json:"growth"`
+}
+
+// parseSince parses a time given as RFC3339 or as a duration before now,
+// in days (30d) or as a Go duration (12h)
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * 24 * time.Hour), nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return time.Time{}, fmt.Errorf("invalid since %q (RFC3339, 30d or 12h)", value)
+	}
+
+	return now.Add(-duration), nil
+}
+
+// serveSizeDiff lists the packages of a suite whose size grew the most
+// since a given time (the last 30 days by default), by installed_size or
+// by size (of the .deb) with by. The archives whose sizes are redacted are
+// skipped.
+func (h httpHandler) serveSizeDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	suite := query.Get("suite")
+	if suite == "" {
+		writeError(w, http.StatusBadRequest, "suite is required")
+		return
+	}
+
+	since := time.Now().Add(-defaultSizeDiffSince)
+	if value := query.Get("since"); value != "" {
+		var err error
+		since, err = parseSince(value, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+
+	by := query.Get("by")
+	if by == "" {
+		by = "installed_size"
+	}
+	if by != "installed_size" && by != "size" {
+		writeError(w, http.StatusBadRequest, "invalid by %q (installed_size or size)", by)
+		return
+	}
+
+	limit, err := resultLimit(r, h.Limits.MaxSearchResults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	entries := make([]sizeDiffEntry, 0)
+	for _, cache := range h.Archives.Enabled() {
+		if h.isRedacted(r.Context(), cache, "size") {
+			continue
+		}
+
+		filter := h.archiveFilter(cache, database.Filter{
+			Suite:        suite,
+			Component:    query.Get("component"),
+			Architecture: query.Get("arch"),
+		})
+		changes, err := cache.Database.GetSizeChanges(filter, since, by, limit)
+		if err != nil {
+			h.Archives.Check(cache, err)
+			requestLogger(r).Errorf("failed to get the size changes of %v: %v", cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to get the size changes")
+			return
+		}
+
+		for _, change := range changes {
+			entry := sizeDiffEntry{
+				Archive:          cache.Name,
+				Name:             change.Name,
+				Suite:            h.publicSuite(cache, change.Suite+change.Pocket),
+				Component:        change.Component,
+				Architecture:     change.Architecture,
+				OldVersion:       change.OldVersion,
+				Version:          change.Version,
+				OldSize:          change.OldSize,
+				Size:             change.Size,
+				OldInstalledSize: change.OldInstalledSize,
+				InstalledSize:    change.InstalledSize,
+			}
+			entry.Growth = entry.InstalledSize - entry.OldInstalledSize
+			if by == "size" {
+				entry.Growth = entry.Size - entry.OldSize
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Growth > entries[j].Growth
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	header := []string{"archive", "name", "suite", "component", "architecture", "old_version", "version",
+		"old_size", "size", "old_installed_size", "installed_size", "growth"}
+	records := make([][]string, len(entries))
+	for i, entry := range entries {
+		records[i] = []string{entry.Archive, entry.Name, entry.Suite, entry.Component, entry.Architecture,
+			entry.OldVersion, entry.Version, strconv.Itoa(entry.OldSize), strconv.Itoa(entry.Size),
+			strconv.Itoa(entry.OldInstalledSize), strconv.Itoa(entry.InstalledSize), strconv.Itoa(entry.Growth)}
+	}
+
+	writeList(w, r, entries, header, records)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+func TestSizeDiffRedacted(t *testing.T) {
+	h := newTestHandler(t)
+	db := h.Archives.archives[0].Database
+
+	seen := time.Now().Add(-48 * time.Hour)
+	for _, version := range []string{"1.0", "1.1"} {
+		pkg := &debianpkg.PackageInfo{
+			Name: "linux-firmware", Version: version, Suite: "noble", Component: "main", Architecture: "amd64",
+			Size: 1000, InstalledSize: 5000,
+		}
+		if version == "1.1" {
+			pkg.InstalledSize = 9000
+			seen = seen.Add(24 * time.Hour)
+		}
+		err := db.PrepareInsertHistory(pkg, seen, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sizeDiff := func(r *http.Request) []sizeDiffEntry {
+		w := httptest.NewRecorder()
+		h.serveSizeDiff(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %v: %v", w.Code, w.Body.String())
+		}
+
+		var entries []sizeDiffEntry
+		err := json.Unmarshal(w.Body.Bytes(), &entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/size-diff?suite=noble&since=36h", nil)
+	entries := sizeDiff(r)
+	if len(entries) != 1 || entries[0].Growth != 4000 {
+		t.Errorf("expected linux-firmware growing by 4000, got %+v", entries)
+	}
+
+	h.Archives.archives[0].conf.Private = true
+	h.Archives.archives[0].conf.Redact = []string{"size"}
+	entries = sizeDiff(r)
+	if len(entries) != 0 {
+		t.Errorf("expected the sizes to be redacted, got %+v", entries)
+	}
+
+	r = r.WithContext(server.WithPrivileges(r.Context()))
+	entries = sizeDiff(r)
+	if len(entries) != 1 {
+		t.Errorf("expected the privileged request to see the sizes, got %+v", entries)
+	}
+}
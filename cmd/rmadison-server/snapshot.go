@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// serveSnapshot returns a snapshot of the database of an archive, it can
+// be used offline with the resolver package. The database has all the
+// fields, the snapshots of the private archives need a privileged token.
+func (h httpHandler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("archive")
+	cache := h.Archives.Get(name)
+	if cache == nil {
+		writeError(w, http.StatusNotFound, "unknown archive %q", name)
+		return
+	}
+	if h.Archives.Redactions(cache) != nil && !server.Privileged(r.Context()) {
+		writeError(w, http.StatusForbidden, "the snapshot of the private archive %v needs a privileged token", name)
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp(cache.CacheDir, "snapshot")
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to create the snapshot of %v", name)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := path.Join(tmpDir, name+".db")
+	err = cache.Database.Snapshot(snapshotPath)
+	if err != nil {
+		requestLogger(r).Errorf("[%v] failed to create snapshot: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "failed to create the snapshot of %v", name)
+		return
+	}
+
+	snapshot, err := os.Open(snapshotPath)
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to create the snapshot of %v", name)
+		return
+	}
+	defer snapshot.Close()
+
+	stat, err := snapshot.Stat()
+	if err != nil {
+		requestLogger(r).Error(err)
+		writeError(w, http.StatusInternalServerError, "failed to create the snapshot of %v", name)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/vnd.sqlite3")
+	w.Header().Add("Content-Disposition", "attachment; filename=\""+name+".db\"")
+	http.ServeContent(w, r, name+".db", stat.ModTime(), snapshot)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/render"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// sortKeys are the values of the sort parameter
+var sortKeys = map[string]func(a, b *debianpkg.PackageInfo) int{
+	"name": func(a, b *debianpkg.PackageInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	},
+	"version": func(a, b *debianpkg.PackageInfo) int {
+		return version.Compare(a.Version, b.Version)
+	},
+	"suite": func(a, b *debianpkg.PackageInfo) int {
+		return strings.Compare(a.Suite+a.Pocket, b.Suite+b.Pocket)
+	},
+	"arch": func(a, b *debianpkg.PackageInfo) int {
+		return strings.Compare(a.Architecture, b.Architecture)
+	},
+}
+
+// tieBreakers make the order deterministic when the keys requested are
+// equal
+var tieBreakers = []string{"name", "suite", "arch", "version"}
+
+// sortPackages sorts the packages with the sort parameter of the query: a
+// comma separated list of keys (version, suite, arch or name), prefixed
+// with - for a descending order. Without it, the packages are sorted by
+// the tie-breakers so the order doesn't depend on the archives.
+func sortPackages(query url.Values, pkgs []*debianpkg.PackageInfo) error {
+	type key struct {
+		compare    func(a, b *debianpkg.PackageInfo) int
+		descending bool
+	}
+	keys := make([]key, 0)
+	if query.Has("sort") {
+		for _, name := range strings.Split(query.Get("sort"), ",") {
+			descending := strings.HasPrefix(name, "-")
+			compare, ok := sortKeys[strings.TrimPrefix(name, "-")]
+			if !ok {
+				return fmt.Errorf("invalid sort key %q", name)
+			}
+			keys = append(keys, key{compare, descending})
+		}
+	}
+	for _, name := range tieBreakers {
+		keys = append(keys, key{sortKeys[name], false})
+	}
+
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		for _, k := range keys {
+			cmp := k.compare(pkgs[i], pkgs[j])
+			if k.descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+// packageGroup is a group of packages of a response with group_by
+type packageGroup struct {
+	Group    string                   `json:"group"`
+	Packages []*debianpkg.PackageInfo `json:"packages"`
+}
+
+// groupPackages groups the packages by suite or archive, the groups are
+// sorted by name and the packages keep their order
+func groupPackages(groupBy string, pkgs []*debianpkg.PackageInfo) ([]*packageGroup, error) {
+	var groupOf func(pkg *debianpkg.PackageInfo) string
+	switch groupBy {
+	case "suite":
+		groupOf = func(pkg *debianpkg.PackageInfo) string { return pkg.Suite + pkg.Pocket }
+	case "archive":
+		groupOf = func(pkg *debianpkg.PackageInfo) string { return pkg.Archive }
+	default:
+		return nil, fmt.Errorf("invalid group_by %q", groupBy)
+	}
+
+	groups := make(map[string]*packageGroup)
+	for _, pkg := range pkgs {
+		name := groupOf(pkg)
+		group, ok := groups[name]
+		if !ok {
+			group = &packageGroup{Group: name, Packages: make([]*debianpkg.PackageInfo, 0)}
+			groups[name] = group
+		}
+		group.Packages = append(group.Packages, pkg)
+	}
+
+	out := make([]*packageGroup, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, group)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Group < out[j].Group })
+
+	return out, nil
+}
+
+// writeGroupedPackages writes the packages grouped with the group_by
+// parameter of the query, or as a list without it
+func writeGroupedPackages(w http.ResponseWriter, r *http.Request, pkgs []*debianpkg.PackageInfo) {
+	resp, err := groupedResponse(r.URL.Query(), pkgs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, resp)
+}
+
+// groupedResponse is the response for packages grouped with the group_by
+// parameter of the query
+func groupedResponse(query url.Values, pkgs []*debianpkg.PackageInfo) (*render.Response, error) {
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		return packagesResponse(pkgs), nil
+	}
+
+	groups, err := groupPackages(groupBy, pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tables have a column with the group
+	header := append([]string{"group"}, packageHeader...)
+	records := make([][]string, 0, len(pkgs))
+	ordered := make([]*debianpkg.PackageInfo, 0, len(pkgs))
+	for _, group := range groups {
+		for _, pkg := range group.Packages {
+			records = append(records, append([]string{group.Group}, packageRecord(pkg)...))
+			ordered = append(ordered, pkg)
+		}
+	}
+
+	return &render.Response{Value: groups, Header: header, Records: records, Packages: ordered}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestSortPackages(t *testing.T) {
+	newPkgs := func() []*debianpkg.PackageInfo {
+		// in the order of the archives
+		return []*debianpkg.PackageInfo{
+			{Name: "hello", Version: "2.10-3", Suite: "noble", Architecture: "amd64"},
+			{Name: "bash", Version: "5.2", Suite: "noble", Architecture: "amd64"},
+			{Name: "hello", Version: "2.10-2", Suite: "jammy", Architecture: "amd64"},
+			{Name: "hello", Version: "2.10-3", Suite: "noble", Architecture: "arm64"},
+		}
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"bash 5.2 amd64", "hello 2.10-2 amd64", "hello 2.10-3 amd64", "hello 2.10-3 arm64"}},
+		{"sort=-version", []string{"bash 5.2 amd64", "hello 2.10-3 amd64", "hello 2.10-3 arm64", "hello 2.10-2 amd64"}},
+		{"sort=-arch", []string{"hello 2.10-3 arm64", "bash 5.2 amd64", "hello 2.10-2 amd64", "hello 2.10-3 amd64"}},
+	}
+
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkgs := newPkgs()
+		err = sortPackages(query, pkgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, pkg := range pkgs {
+			got := pkg.Name + " " + pkg.Version + " " + pkg.Architecture
+			if got != test.expected[i] {
+				t.Errorf("%q: expected %v at %v, got %v", test.query, test.expected[i], i, got)
+			}
+		}
+	}
+
+	err := sortPackages(url.Values{"sort": {"size"}}, newPkgs())
+	if err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+// sourcePackageInfo is a source package of the Sources indexes, with the
+// URL of its .dsc
+type sourcePackageInfo struct {
+	Archive string `json:"archive"`
+	*database.SourcePackage
+	DSC string `json:"dsc,omitempty"`
+}
+
+// serveSourcePackage returns the source package pkg from the Sources
+// indexes of the archives with sources indexing enabled, usually
+// restricted to a suite
+func (h httpHandler) serveSourcePackage(w http.ResponseWriter, r *http.Request, pkg string) {
+	suite := r.URL.Query().Get("suite")
+
+	sources := make([]sourcePackageInfo, 0)
+	for _, cache := range h.Archives.Enabled() {
+		if !cache.Sources {
+			continue
+		}
+
+		endSpan := startSpan(r, "db.GetSources "+cache.Name)
+		srcs, err := cache.Database.GetSources(pkg, h.archiveSuite(cache, suite))
+		endSpan()
+		if err != nil {
+			h.Archives.Check(cache, err)
+			requestLogger(r).Errorf("failed to get the source package %v in %v: %v", pkg, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+
+		names := h.Archives.SuiteNames(cache)
+		archiveSources := make([]sourcePackageInfo, 0, len(srcs))
+		for _, src := range srcs {
+			if name, ok := names[src.Suite]; ok {
+				src.Suite = name
+			}
+			info := sourcePackageInfo{Archive: cache.Name, SourcePackage: src}
+			if dsc := src.DSC(); dsc != nil {
+				info.DSC = cache.SourceFileURL(src, dsc.Name)
+			}
+			archiveSources = append(archiveSources, info)
+		}
+		h.redactSources(r.Context(), cache, archiveSources)
+		sources = append(sources, archiveSources...)
+	}
+	if len(sources) == 0 {
+		writeError(w, http.StatusNotFound, "source package %v not found", pkg)
+		return
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Archive != sources[j].Archive {
+			return sources[i].Archive < sources[j].Archive
+		}
+		return sources[i].Suite+sources[i].Pocket < sources[j].Suite+sources[j].Pocket
+	})
+
+	header := []string{"archive", "name", "version", "suite", "component", "maintainer", "binaries", "dsc"}
+	records := make([][]string, len(sources))
+	for i, src := range sources {
+		records[i] = []string{src.Archive, src.Name, src.Version, src.Suite + src.Pocket, src.Component, src.Maintainer, strings.Join(src.Binaries, " "), src.DSC}
+	}
+
+	writeList(w, r, sources, header, records)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// serveSources returns the sources.list entries needed to install a package.
+// The output can be restricted to a suite (e.g. noble-updates) and formated
+// as deb822 with format=deb822.
+func (h httpHandler) serveSources(w http.ResponseWriter, r *http.Request) {
+	pkg := strings.TrimPrefix(r.URL.Path, "/sources/")
+	if pkg == "" || strings.Contains(pkg, "/") {
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+		return
+	}
+
+	suite := r.URL.Query().Get("suite")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "list" && format != "deb822" {
+		writeError(w, http.StatusBadRequest, "unsupported format %q", format)
+		return
+	}
+
+	entries := make([]string, 0)
+	for _, cache := range h.Archives.Enabled() {
+		allInfo, err := cache.Database.GetPackage(pkg)
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+
+		pkgs := make([]*debianpkg.PackageInfo, 0, len(allInfo))
+		for _, info := range allInfo {
+			if suite == "" || info.Suite+info.Pocket == suite {
+				pkgs = append(pkgs, info)
+			}
+		}
+
+		for _, entry := range cache.SourcesEntries(pkgs) {
+			if format == "deb822" {
+				entries = append(entries, entry.Deb822())
+			} else {
+				entries = append(entries, entry.String()+"\n")
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		writeError(w, http.StatusNotFound, "package %v not found", pkg)
+		return
+	}
+
+	separator := ""
+	if format == "deb822" {
+		separator = "\n"
+	}
+
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, strings.Join(entries, separator))
+}
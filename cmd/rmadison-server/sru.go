@@ -0,0 +1,328 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+	"github.com/go-resty/resty/v2"
+	"github.com/ulikunitz/xz"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultExcusesURL is where the proposed-migration excuses of the
+	// Ubuntu series are published
+	DefaultExcusesURL = "https://ubuntu-archive-team.ubuntu.com/proposed-migration/{series}/update_excuses.yaml.xz"
+	// defaultExcusesTTL is how long the excuses of a series are cached
+	defaultExcusesTTL = 15 * time.Minute
+	// excusesRetryInterval is how long a failed download is cached, the
+	// requests made meanwhile don't wait for the upstream again
+	excusesRetryInterval = time.Minute
+)
+
+// ExcusesConfig enables the block reasons of the proposed-migration in
+// /sru-status
+type ExcusesConfig struct {
+	// URL is a template where {series} is replaced, the file can be
+	// compressed with xz or gzip
+	URL string        `yaml:"url"`
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// excuse is why a source package in -proposed migrates or not
+type excuse struct {
+	Source     string   `yaml:"source" json:"-"`
+	NewVersion string   `yaml:"new-version" json:"new_version"`
+	Verdict    string   `yaml:"migration-policy-verdict" json:"verdict"`
+	Candidate  bool     `yaml:"is-candidate" json:"candidate"`
+	Reasons    []string `yaml:"reason" json:"reasons"`
+	Excuses    []string `yaml:"excuses" json:"excuses"`
+}
+
+// seriesExcuses are the excuses of a series, by source package
+type seriesExcuses struct {
+	fetched time.Time
+	sources map[string]*excuse
+	// err is the error of the last download if it failed, at failed
+	err    error
+	failed time.Time
+	// fetching is closed when the download in progress is done
+	fetching chan struct{}
+}
+
+// excusesClient downloads the excuses of the series and caches them for
+// the TTL
+type excusesClient struct {
+	conf   ExcusesConfig
+	client *resty.Client
+
+	lock   sync.Mutex
+	series map[string]*seriesExcuses
+}
+
+func newExcusesClient(conf ExcusesConfig) *excusesClient {
+	if conf.URL == "" {
+		conf.URL = DefaultExcusesURL
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = defaultExcusesTTL
+	}
+
+	return &excusesClient{
+		conf:   conf,
+		client: resty.New().SetTimeout(time.Minute),
+		series: make(map[string]*seriesExcuses),
+	}
+}
+
+// Get returns the excuses of a series, fetched again when they are older
+// than the TTL. Concurrent calls for a series wait for the same download,
+// the other series are not blocked. A failure is returned again until
+// excusesRetryInterval.
+func (c *excusesClient) Get(series string) (map[string]*excuse, error) {
+	c.lock.Lock()
+	cached := c.series[series]
+	if cached == nil {
+		cached = new(seriesExcuses)
+		c.series[series] = cached
+	}
+	for cached.fetching != nil {
+		fetching := cached.fetching
+		c.lock.Unlock()
+		<-fetching
+		c.lock.Lock()
+	}
+
+	if cached.sources != nil && time.Since(cached.fetched) < c.conf.TTL {
+		c.lock.Unlock()
+		return cached.sources, nil
+	}
+	if cached.err != nil && time.Since(cached.failed) < excusesRetryInterval {
+		defer c.lock.Unlock()
+		return cached.stale(cached.err)
+	}
+
+	fetching := make(chan struct{})
+	cached.fetching = fetching
+	c.lock.Unlock()
+
+	excusesURL := strings.ReplaceAll(c.conf.URL, "{series}", series)
+	sources, err := c.fetch(excusesURL)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached.fetching = nil
+	close(fetching)
+	if err != nil {
+		log.Errorf("[excuses][%v] failed to fetch the excuses: %v", series, err)
+		cached.err = err
+		cached.failed = time.Now()
+		return cached.stale(err)
+	}
+	cached.fetched = time.Now()
+	cached.sources = sources
+	cached.err = nil
+
+	return sources, nil
+}
+
+// stale returns the excuses of the last successful download, stale
+// excuses are better than none, or err if there are none
+func (s *seriesExcuses) stale(err error) (map[string]*excuse, error) {
+	if s.sources == nil {
+		return nil, err
+	}
+
+	return s.sources, nil
+}
+
+func (c *excusesClient) fetch(excusesURL string) (map[string]*excuse, error) {
+	resp, err := c.client.R().SetDoNotParseResponse(true).Get(excusesURL)
+	if err != nil {
+		return nil, err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if resp.IsError() {
+		return nil, fmt.Errorf("failed to fetch %v (%v)", excusesURL, resp.Status())
+	}
+
+	var reader io.Reader = body
+	switch {
+	case strings.HasSuffix(excusesURL, ".xz"):
+		reader, err = xz.NewReader(body)
+	case strings.HasSuffix(excusesURL, ".gz"):
+		reader, err = gzip.NewReader(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rawExcuses := new(struct {
+		Sources []*excuse `yaml:"sources"`
+	})
+	err = yaml.NewDecoder(reader).Decode(rawExcuses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", excusesURL, err)
+	}
+
+	sources := make(map[string]*excuse, len(rawExcuses.Sources))
+	for _, e := range rawExcuses.Sources {
+		sources[e.Source] = e
+	}
+	log.Infof("[excuses] %v excuses loaded from %v", len(sources), excusesURL)
+
+	return sources, nil
+}
+
+// sruStatus is the state of a source package in the pockets of a series
+type sruStatus struct {
+	Source string `json:"source"`
+	Series string `json:"series"`
+	// Pockets are the versions of the source by pocket ("release" for
+	// the release pocket)
+	Pockets map[string]string `json:"pockets"`
+	// ProposedSince is when the version in -proposed was first seen
+	ProposedSince *time.Time `json:"proposed_since,omitempty"`
+	ProposedAge   float64    `json:"proposed_age_days,omitempty"`
+	Excuse        *excuse    `json:"excuse,omitempty"`
+}
+
+// pocketName is the name of a pocket in the SRU status
+func pocketName(pocket string) string {
+	if pocket == "" {
+		return "release"
+	}
+
+	return strings.TrimPrefix(pocket, "-")
+}
+
+// serveSRUStatus aggregates the versions in each pocket of a series, the
+// age of the version in -proposed and its migration excuses (when
+// configured) of the source packages matching a glob pattern. The
+// statuses only have versions and dates, none of the redacted fields.
+func (h httpHandler) serveSRUStatus(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pattern := query.Get("source")
+	series := query.Get("series")
+	if pattern == "" || series == "" {
+		writeError(w, http.StatusBadRequest, "source and series are required")
+		return
+	}
+
+	statuses := make(map[string]*sruStatus)
+	// a binary of the version in -proposed, to find when it was first
+	// seen, by source
+	proposed := make(map[string]*debianpkg.PackageInfo)
+	archives := make(map[string]*archive.Archive)
+	for _, cache := range h.Archives.Enabled() {
+		endSpan := startSpan(r, "db.SearchSources "+cache.Name)
+		pkgs, err := cache.Database.SearchSources(pattern, series)
+		endSpan()
+		if err != nil {
+			h.Archives.Check(cache, err)
+			requestLogger(r).Errorf("failed to search sources %v in %v: %v", pattern, cache.Name, err)
+			writeError(w, http.StatusInternalServerError, "failed to search the sources")
+			return
+		}
+
+		for _, pkg := range pkgs {
+			source, sourceVersion := pkg.SourceNameVersion()
+			status := statuses[source]
+			if status == nil {
+				status = &sruStatus{
+					Source:  source,
+					Series:  series,
+					Pockets: make(map[string]string),
+				}
+				statuses[source] = status
+			}
+
+			pocket := pocketName(pkg.Pocket)
+			if current := status.Pockets[pocket]; current == "" || version.Compare(sourceVersion, current) > 0 {
+				status.Pockets[pocket] = sourceVersion
+				if pocket == "proposed" {
+					proposed[source] = pkg
+					archives[source] = cache
+				}
+			}
+		}
+	}
+
+	var excuses map[string]*excuse
+	if h.Excuses != nil {
+		var err error
+		excuses, err = h.Excuses.Get(series)
+		if err != nil {
+			// the versions are still useful without the excuses
+			requestLogger(r).Errorf("failed to get the excuses of %v: %v", series, err)
+		}
+	}
+
+	sources := make([]string, 0, len(statuses))
+	for source := range statuses {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	result := make([]*sruStatus, len(sources))
+	for i, source := range sources {
+		status := statuses[source]
+		if pkg := proposed[source]; pkg != nil {
+			entries, err := archives[source].Database.GetHistory(pkg.Name, pkg.Version)
+			if err != nil {
+				requestLogger(r).Errorf("failed to get the history of %v: %v", pkg.Name, err)
+				writeError(w, http.StatusInternalServerError, "failed to get the history of %v", source)
+				return
+			}
+			for _, entry := range entries {
+				if entry.Suite == series && entry.Pocket == pkg.Pocket && entry.Architecture == pkg.Architecture {
+					since := entry.FirstSeen
+					status.ProposedSince = &since
+					status.ProposedAge = time.Since(since).Hours() / 24
+				}
+			}
+
+			if e := excuses[source]; e != nil && e.NewVersion == status.Pockets["proposed"] {
+				status.Excuse = e
+			}
+		}
+
+		result[i] = status
+	}
+
+	header := []string{"source", "series", "release", "security", "updates", "proposed", "proposed_age_days", "verdict", "reasons"}
+	records := make([][]string, len(result))
+	for i, status := range result {
+		age, verdict, reasons := "", "", ""
+		if status.ProposedSince != nil {
+			age = fmt.Sprintf("%.1f", status.ProposedAge)
+		}
+		if status.Excuse != nil {
+			verdict = status.Excuse.Verdict
+			reasons = joinList(status.Excuse.Reasons)
+		}
+		records[i] = []string{
+			status.Source,
+			status.Series,
+			status.Pockets["release"],
+			status.Pockets["security"],
+			status.Pockets["updates"],
+			status.Pockets["proposed"],
+			age,
+			verdict,
+			reasons,
+		}
+	}
+
+	writeList(w, r, result, header, records)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testExcuses = `sources:
+- source: hello
+  new-version: 2.10-3ubuntu1
+  migration-policy-verdict: REJECTED_TEMPORARILY
+  is-candidate: false
+  reason: [autopkgtest]
+`
+
+func TestExcusesClient(t *testing.T) {
+	var (
+		hits    sync.Map
+		release = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		series := strings.Trim(r.URL.Path, "/")
+		n, _ := hits.LoadOrStore(series, new(int64))
+		atomic.AddInt64(n.(*int64), 1)
+
+		switch series {
+		case "slow":
+			<-release
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(testExcuses))
+	}))
+	defer server.Close()
+
+	client := newExcusesClient(ExcusesConfig{URL: server.URL + "/{series}"})
+	count := func(series string) int64 {
+		n, ok := hits.Load(series)
+		if !ok {
+			return 0
+		}
+		return atomic.LoadInt64(n.(*int64))
+	}
+
+	// the concurrent requests of a series share the download
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			excuses, err := client.Get("slow")
+			if err != nil || excuses["hello"] == nil {
+				t.Errorf("slow: expected the excuse of hello, got %v (%v)", excuses, err)
+			}
+		}()
+	}
+
+	// and don't block the other series
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		excuses, err := client.Get("noble")
+		if err != nil || excuses["hello"] == nil || excuses["hello"].Verdict != "REJECTED_TEMPORARILY" {
+			t.Errorf("noble: expected the excuse of hello, got %v (%v)", excuses, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the download of a series blocked the others")
+	}
+
+	close(release)
+	wg.Wait()
+	if count("slow") != 1 {
+		t.Errorf("slow: expected 1 download, got %v", count("slow"))
+	}
+
+	// cached for the TTL
+	if _, err := client.Get("noble"); err != nil {
+		t.Fatal(err)
+	}
+	if count("noble") != 1 {
+		t.Errorf("noble: expected 1 download, got %v", count("noble"))
+	}
+
+	// the failures are cached too
+	for i := 0; i < 3; i++ {
+		if _, err := client.Get("broken"); err == nil {
+			t.Error("broken: expected an error")
+		}
+	}
+	if count("broken") != 1 {
+		t.Errorf("broken: expected 1 download, got %v", count("broken"))
+	}
+
+	// and retried after excusesRetryInterval
+	client.lock.Lock()
+	client.series["broken"].failed = time.Now().Add(-excusesRetryInterval)
+	client.lock.Unlock()
+	client.Get("broken")
+	if count("broken") != 2 {
+		t.Errorf("broken: expected 2 downloads, got %v", count("broken"))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+type archiveStats struct {
+	Archive string `json:"archive"`
+	// Healthy is false when the database of the archive is quarantined,
+	// Error is the reason
+	Healthy bool               `json:"healthy"`
+	Error   string             `json:"error,omitempty"`
+	Parse   archive.ParseStats `json:"parse"`
+	// Packages and Sources are the numbers of binary and source packages
+	// in the archive
+	Packages int                    `json:"packages"`
+	Sources  int                    `json:"sources"`
+	Suites   []*database.SuiteStats `json:"suites"`
+	// LastRefresh is the result of each index in the last refresh
+	LastRefresh *archive.RefreshReport `json:"last_refresh,omitempty"`
+	// ClockSkew is the difference between the clock of each mirror and
+	// the local clock
+	ClockSkew []archive.MirrorSkew `json:"clock_skew"`
+}
+
+// serveStats returns statistics about the content of each archive (per
+// suite and architecture) and the anomalies found during the last refresh
+func (h httpHandler) serveStats(w http.ResponseWriter, r *http.Request) {
+	allStats := make([]archiveStats, 0)
+	for _, entry := range h.Archives.All() {
+		if entry.conf.Disabled {
+			continue
+		}
+
+		health := h.Archives.Health(entry)
+		stats := archiveStats{
+			Archive:     entry.Name,
+			Healthy:     health == "",
+			Error:       health,
+			Parse:       entry.Status().Parse,
+			Suites:      make([]*database.SuiteStats, 0),
+			LastRefresh: entry.LastReport(),
+			ClockSkew:   entry.ClockSkews(),
+		}
+
+		// the database of a quarantined archive may not be usable
+		if stats.Healthy {
+			var err error
+			stats.Suites, err = entry.Database.GetSuiteStats()
+			if err == nil {
+				stats.Sources, err = entry.Database.CountSources()
+			}
+			if err != nil {
+				requestLogger(r).Errorf("failed to get the stats of %v: %v", entry.Name, err)
+				writeError(w, http.StatusInternalServerError, "failed to get the stats of %v", entry.Name)
+				return
+			}
+			for _, suite := range stats.Suites {
+				stats.Packages += suite.Packages
+			}
+		}
+
+		allStats = append(allStats, stats)
+	}
+
+	header := []string{"archive", "healthy", "packages", "sources", "skipped_stanzas", "unknown_fields", "empty_suites", "checksum_failures", "errors", "failed_indexes", "max_clock_skew_seconds"}
+	records := make([][]string, len(allStats))
+	for i, stats := range allStats {
+		failedIndexes := 0
+		if stats.LastRefresh != nil {
+			failedIndexes = len(stats.LastRefresh.Failed())
+		}
+		// the largest skew, in either direction
+		maxSkew := 0.0
+		for _, skew := range stats.ClockSkew {
+			if math.Abs(skew.Skew) > math.Abs(maxSkew) {
+				maxSkew = skew.Skew
+			}
+		}
+		records[i] = []string{
+			stats.Archive,
+			strconv.FormatBool(stats.Healthy),
+			strconv.Itoa(stats.Packages),
+			strconv.Itoa(stats.Sources),
+			strconv.Itoa(stats.Parse.SkippedStanzas),
+			strconv.Itoa(stats.Parse.UnknownFields),
+			strconv.Itoa(stats.Parse.EmptySuites),
+			strconv.Itoa(stats.Parse.ChecksumFailures),
+			strconv.Itoa(len(stats.Parse.Errors)),
+			strconv.Itoa(failedIndexes),
+			strconv.FormatFloat(maxSkew, 'f', 0, 64),
+		}
+	}
+
+	writeList(w, r, allStats, header, records)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// checkSuiteNames returns an error if the suite names of an archive can't
+// be translated both ways: the names can't contain a pocket and two
+// suites can't have the same public name
+func checkSuiteNames(names map[string]string) error {
+	public := make(map[string]string, len(names))
+	for suite, name := range names {
+		if suite == "" || name == "" || strings.Contains(suite, "-") || strings.Contains(name, "-") {
+			return fmt.Errorf("invalid suite name %q: %q", suite, name)
+		}
+		if other, ok := public[name]; ok {
+			return fmt.Errorf("suites %v and %v are both named %v", other, suite, name)
+		}
+		public[name] = suite
+	}
+
+	return nil
+}
+
+// translateSuite replaces the suite of a suite and pocket (prod-updates)
+// using names
+func translateSuite(names map[string]string, suitePocket string) string {
+	suite, pocket, hasPocket := strings.Cut(suitePocket, "-")
+	name, ok := names[suite]
+	if !ok {
+		return suitePocket
+	}
+	if !hasPocket {
+		return name
+	}
+
+	return name + "-" + pocket
+}
+
+// reverseNames returns the suites of an archive by public name
+func reverseNames(names map[string]string) map[string]string {
+	suites := make(map[string]string, len(names))
+	for suite, name := range names {
+		suites[name] = suite
+	}
+
+	return suites
+}
+
+// publicSuite returns the name of a suite of the archive (with its pocket)
+// shown to the clients
+func (h httpHandler) publicSuite(cache *archive.Archive, suitePocket string) string {
+	return translateSuite(h.Archives.SuiteNames(cache), suitePocket)
+}
+
+// archiveSuite returns the name in the archive of a suite given by a
+// client, it's the reverse of publicSuite
+func (h httpHandler) archiveSuite(cache *archive.Archive, suitePocket string) string {
+	names := h.Archives.SuiteNames(cache)
+	if len(names) == 0 {
+		return suitePocket
+	}
+
+	return translateSuite(reverseNames(names), suitePocket)
+}
+
+// publicChange renames the suite of a change to its public name
+func (h httpHandler) publicChange(change archive.PackageChange) archive.PackageChange {
+	if cache := h.Archives.Get(change.Archive); cache != nil {
+		change.Suite = h.publicSuite(cache, change.Suite)
+	}
+
+	return change
+}
+
+// archiveFilter translates the suite of a filter for the database of the
+// archive
+func (h httpHandler) archiveFilter(cache *archive.Archive, filter database.Filter) database.Filter {
+	if filter.Suite != "" {
+		filter.Suite = h.archiveSuite(cache, filter.Suite)
+	}
+
+	return filter
+}
+
+// translateSuites renames the suites of the packages of an archive to
+// their public names
+func (h httpHandler) translateSuites(cache *archive.Archive, pkgs []*debianpkg.PackageInfo) {
+	names := h.Archives.SuiteNames(cache)
+	if len(names) == 0 {
+		return
+	}
+
+	for _, pkg := range pkgs {
+		if name, ok := names[pkg.Suite]; ok {
+			pkg.Suite = name
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// TestSuiteNamesOutputs checks that the endpoints show the public names of
+// the suites and accept them in their parameters
+func TestSuiteNamesOutputs(t *testing.T) {
+	h := newTestHandler(t,
+		&debianpkg.PackageInfo{Name: "bash", Version: "5.2-1", Suite: "noble", Component: "main", Architecture: "amd64"},
+		&debianpkg.PackageInfo{Name: "bash", Version: "5.2-2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+	)
+	h.Archives.archives[0].conf.SuiteNames = map[string]string{"noble": "prod"}
+	router := newRouter(h)
+
+	get := func(target string, body string, value interface{}) {
+		method := http.MethodGet
+		if body != "" {
+			method = http.MethodPost
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: expected 200, got %v: %v", target, w.Code, w.Body.String())
+		}
+		err := json.Unmarshal(w.Body.Bytes(), value)
+		if err != nil {
+			t.Fatalf("%v: %v", target, err)
+		}
+	}
+
+	var binaries []builtBinary
+	get("/api/built-from?source=bash&version=5.2-2", "", &binaries)
+	if len(binaries) != 1 || binaries[0].Suite != "prod-updates" {
+		t.Errorf("built-from: expected prod-updates, got %+v", binaries)
+	}
+
+	var entries []diffEntry
+	get("/api/diff?from=prod&to=prod-updates", "", &entries)
+	if len(entries) != 1 || entries[0].Change != "upgraded" {
+		t.Errorf("diff: expected bash to be upgraded, got %+v", entries)
+	}
+
+	var results []pinResult
+	get("/api/pin/simulate", `{"packages": ["bash"], "suites": ["prod", "prod-updates"]}`, &results)
+	if len(results) != 1 || len(results[0].Versions) != 2 {
+		t.Fatalf("pin: expected 2 versions, got %+v", results)
+	}
+	for _, version := range results[0].Versions {
+		if !strings.HasPrefix(version.Suite, "prod") {
+			t.Errorf("pin: expected a public suite, got %v", version.Suite)
+		}
+	}
+	if results[0].Candidate == nil || results[0].Candidate.Suite != "prod-updates" {
+		t.Errorf("pin: expected the candidate of prod-updates, got %+v", results[0].Candidate)
+	}
+
+	change := h.publicChange(archive.PackageChange{Archive: "test", Name: "bash", Suite: "noble-updates"})
+	if change.Suite != "prod-updates" {
+		t.Errorf("change: expected prod-updates, got %v", change.Suite)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+)
+
+type suitesInfo struct {
+	Archive       string   `json:"archive"`
+	Suites        []string `json:"suites"`
+	Pockets       []string `json:"pockets"`
+	Components    []string `json:"components"`
+	Architectures []string `json:"architectures"`
+}
+
+// serveSuites lists the suites, pockets, components and architectures
+// present in the database of each archive
+func (h httpHandler) serveSuites(w http.ResponseWriter, r *http.Request) {
+	archives := h.Archives.Enabled()
+	allSuites := make([]suitesInfo, len(archives))
+	for i, cache := range archives {
+		info := suitesInfo{
+			Archive: cache.Name,
+		}
+
+		columns := map[string]*[]string{
+			"suite":        &info.Suites,
+			"pocket":       &info.Pockets,
+			"component":    &info.Components,
+			"architecture": &info.Architectures,
+		}
+		for column, values := range columns {
+			var err error
+			*values, err = cache.Database.ListDistinct(column)
+			if err != nil {
+				requestLogger(r).Errorf("failed to list %v in %v: %v", column, cache.Name, err)
+				writeError(w, http.StatusInternalServerError, "failed to list the suites of %v", cache.Name)
+				return
+			}
+		}
+		for j, suite := range info.Suites {
+			info.Suites[j] = h.publicSuite(cache, suite)
+		}
+
+		allSuites[i] = info
+	}
+
+	header := []string{"archive", "suites", "pockets", "components", "architectures"}
+	records := make([][]string, len(allSuites))
+	for i, info := range allSuites {
+		records[i] = []string{
+			info.Archive,
+			joinList(info.Suites),
+			joinList(info.Pockets),
+			joinList(info.Components),
+			joinList(info.Architectures),
+		}
+	}
+
+	writeList(w, r, allSuites, header, records)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/server"
+)
+
+// defaultRouteTimeouts are the maximum durations of the responses by path
+// prefix, the longest prefix wins. 0 means no limit, for the streaming
+// routes.
+var defaultRouteTimeouts = server.TimeoutsConfig{
+	"/":               10 * time.Second,
+	"/api/batch":      time.Minute,
+	"/api/diff":       time.Minute,
+	"/api/graphql":    time.Minute,
+	"/api/search":     time.Minute,
+	"/api/size-diff":  time.Minute,
+	"/api/sru-status": time.Minute,
+	"/api/dump":       0,
+	"/api/export":     0,
+	"/api/events":     0,
+	"/api/snapshot":   0,
+	// the requests have their own timeout
+	"/api/wait": 0,
+}
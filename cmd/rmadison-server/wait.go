@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// timeouts of the /api/wait requests
+const (
+	defaultWaitTimeout = time.Minute
+	maxWaitTimeout     = 30 * time.Minute
+)
+
+// waitMatches checks if a version of the package satisfies a wait
+func waitMatches(suite, arch, minVersion, pkgSuite, pkgArch, pkgVersion string) bool {
+	return (suite == "" || pkgSuite == suite) &&
+		(arch == "" || pkgArch == arch) &&
+		(minVersion == "" || version.Compare(pkgVersion, minVersion) >= 0)
+}
+
+// serveWait blocks until a version of a package at least min_version is
+// published in a suite (and optionally for an arch), or the timeout
+// expires. The refreshes wake the requests up.
+func (h httpHandler) serveWait(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pkg := query.Get("pkg")
+	suite := query.Get("suite")
+	arch := query.Get("arch")
+	minVersion := query.Get("min_version")
+	if pkg == "" {
+		writeError(w, http.StatusBadRequest, "pkg is required")
+		return
+	}
+
+	timeout := defaultWaitTimeout
+	if value := query.Get("timeout"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout %q", value)
+			return
+		}
+		if timeout > maxWaitTimeout {
+			timeout = maxWaitTimeout
+		}
+	}
+
+	// subscribe first, the version could be published between the lookup
+	// and the subscription
+	events := h.Events.Subscribe()
+	defer h.Events.Unsubscribe(events)
+
+	found := func() ([]*debianpkg.PackageInfo, error) {
+		allInfo, err := h.lookup(r, pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		matching := make([]*debianpkg.PackageInfo, 0)
+		for _, info := range allInfo {
+			if waitMatches(suite, arch, minVersion, info.Suite+info.Pocket, info.Architecture, info.Version) {
+				matching = append(matching, info)
+			}
+		}
+
+		return matching, nil
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		matching, err := found()
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+		if len(matching) != 0 {
+			h.Overlay.Annotate(matching)
+			writePackages(w, r, matching)
+			return
+		}
+
+		// wait for a change of the package that satisfies the request
+	wait:
+		for {
+			select {
+			case change := <-events:
+				change = h.publicChange(change)
+				if change.Name == pkg && waitMatches(suite, arch, minVersion, change.Suite, change.Architecture, change.Version) {
+					break wait
+				}
+			case <-deadline.C:
+				writeError(w, http.StatusGatewayTimeout, "%v not published after %v", pkg, timeout)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
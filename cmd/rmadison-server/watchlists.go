@@ -0,0 +1,503 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/server"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// limits of the watchlists, they are stored in memory
+const (
+	maxWatchlistPackages = 500
+	maxWatchlistsByOwner = 100
+	maxWatchlistSize     = 1 << 20
+)
+
+// defaultWatchlistSince is how far back the changes of the status of a
+// watchlist go by default
+const defaultWatchlistSince = 7 * 24 * time.Hour
+
+var (
+	errWatchlistNotFound = errors.New("watchlist not found")
+	errWatchlistLimit    = fmt.Errorf("at most %v watchlists by token", maxWatchlistsByOwner)
+)
+
+// watchlist is a named set of packages followed in some suites (all of
+// them when Suites is empty)
+type watchlist struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Packages []string `json:"packages"`
+	Suites   []string `json:"suites"`
+	// Owner is the SHA-256 of the token that created the watchlist, only
+	// this token can change it
+	Owner   string    `json:"-"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// storedWatchlist is a watchlist saved on disk, with its owner
+type storedWatchlist struct {
+	*watchlist
+	Owner string `json:"owner"`
+}
+
+// validate checks the fields set by the clients
+func (l *watchlist) validate() error {
+	if l.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(l.Packages) == 0 || len(l.Packages) > maxWatchlistPackages {
+		return fmt.Errorf("between 1 and %v packages are required", maxWatchlistPackages)
+	}
+	for _, pkg := range l.Packages {
+		if pkg == "" || strings.Contains(pkg, "/") {
+			return fmt.Errorf("invalid package name %q", pkg)
+		}
+	}
+	if l.Suites == nil {
+		l.Suites = []string{}
+	}
+
+	return nil
+}
+
+// watchlistStore holds the watchlists, saved as JSON files in dir when
+// it's set
+type watchlistStore struct {
+	dir string
+
+	lock       sync.RWMutex
+	watchlists map[string]*watchlist
+}
+
+func newWatchlistStore(dir string) (*watchlistStore, error) {
+	s := &watchlistStore{
+		dir:        dir,
+		watchlists: make(map[string]*watchlist),
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		content, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		stored := storedWatchlist{watchlist: new(watchlist)}
+		err = json.Unmarshal(content, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watchlist %v: %v", entry.Name(), err)
+		}
+		stored.watchlist.Owner = stored.Owner
+		s.watchlists[stored.ID] = stored.watchlist
+	}
+	log.Infof("loaded %v watchlists from %v", len(s.watchlists), dir)
+
+	return s, nil
+}
+
+// Get returns a copy of a watchlist, nil if it doesn't exist
+func (s *watchlistStore) Get(id string) *watchlist {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	l, ok := s.watchlists[id]
+	if !ok {
+		return nil
+	}
+	copied := *l
+
+	return &copied
+}
+
+// ByOwner returns the watchlists of an owner sorted by name
+func (s *watchlistStore) ByOwner(owner string) []*watchlist {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	watchlists := make([]*watchlist, 0)
+	for _, l := range s.watchlists {
+		if l.Owner == owner {
+			copied := *l
+			watchlists = append(watchlists, &copied)
+		}
+	}
+	sort.Slice(watchlists, func(i, j int) bool {
+		if watchlists[i].Name != watchlists[j].Name {
+			return watchlists[i].Name < watchlists[j].Name
+		}
+		return watchlists[i].ID < watchlists[j].ID
+	})
+
+	return watchlists
+}
+
+// Put adds or replaces a watchlist
+func (s *watchlistStore) Put(l *watchlist) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.watchlists[l.ID]; !ok {
+		owned := 0
+		for _, other := range s.watchlists {
+			if other.Owner == l.Owner {
+				owned++
+			}
+		}
+		if owned >= maxWatchlistsByOwner {
+			return errWatchlistLimit
+		}
+	}
+
+	if s.dir != "" {
+		content, err := json.Marshal(storedWatchlist{watchlist: l, Owner: l.Owner})
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(s.dir, l.ID+".json"), content, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	copied := *l
+	s.watchlists[l.ID] = &copied
+
+	return nil
+}
+
+// Remove deletes a watchlist
+func (s *watchlistStore) Remove(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.watchlists[id]; !ok {
+		return errWatchlistNotFound
+	}
+
+	if s.dir != "" {
+		err := os.Remove(path.Join(s.dir, id+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(s.watchlists, id)
+
+	return nil
+}
+
+// watchlistOwner returns the owner of the watchlists of the request: the
+// SHA-256 of its token if it's the admin token, a privileged token or one
+// of the watchlist tokens, false otherwise
+func (h httpHandler) watchlistOwner(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	known := h.isPrivileged(authorization)
+	for _, expected := range h.WatchlistTokens {
+		if expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			known = true
+		}
+	}
+	if !known {
+		return "", false
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// decodeWatchlist reads the name, the packages and the suites of a
+// watchlist from the body of a request
+func decodeWatchlist(w http.ResponseWriter, r *http.Request) (*watchlist, error) {
+	l := new(watchlist)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWatchlistSize)).Decode(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, l.validate()
+}
+
+// serveWatchlists lists the watchlists of the token (GET) or creates a new
+// one (POST)
+func (h httpHandler) serveWatchlists(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.watchlistOwner(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		watchlists := h.Watchlists.ByOwner(owner)
+		records := make([][]string, len(watchlists))
+		for i, l := range watchlists {
+			records[i] = []string{l.ID, l.Name, strconv.Itoa(len(l.Packages)), strings.Join(l.Suites, ","), l.Updated.Format(time.RFC3339)}
+		}
+
+		writeList(w, r, watchlists, []string{"id", "name", "packages", "suites", "updated"}, records)
+	case http.MethodPost:
+		l, err := decodeWatchlist(w, r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		l.ID = server.RandomID()
+		l.Owner = owner
+		l.Created = time.Now().UTC()
+		l.Updated = l.Created
+
+		err = h.Watchlists.Put(l)
+		if errors.Is(err, errWatchlistLimit) {
+			writeError(w, http.StatusForbidden, "%v", err)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Errorf("[watchlists][%v] failed to save watchlist: %v", l.ID, err)
+			writeError(w, http.StatusInternalServerError, "failed to save watchlist %v", l.Name)
+			return
+		}
+		requestLogger(r).Infof("[watchlists][%v] watchlist %v created (%v packages)", l.ID, l.Name, len(l.Packages))
+
+		w.Header().Add("Location", "/api/watchlists/"+l.ID)
+		writeJSON(w, r, http.StatusCreated, l)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+	}
+}
+
+// serveWatchlist routes /api/watchlists/<id>: anyone with the ID can read the
+// watchlist and its status, only its owner can replace (PUT) or remove it
+// (DELETE)
+func (h httpHandler) serveWatchlist(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/watchlists/"), "/")
+	l := h.Watchlists.Get(id)
+	if l == nil {
+		writeError(w, http.StatusNotFound, "watchlist %v not found", id)
+		return
+	}
+
+	switch {
+	case action == "status":
+		h.serveWatchlistStatus(w, r, l)
+		return
+	case action != "":
+		writeError(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+		return
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		writeJSON(w, r, http.StatusOK, l)
+		return
+	case r.Method != http.MethodPut && r.Method != http.MethodDelete:
+		writeError(w, http.StatusMethodNotAllowed, "%v not allowed", r.Method)
+		return
+	}
+
+	owner, ok := h.watchlistOwner(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+	if owner != l.Owner {
+		writeError(w, http.StatusForbidden, "watchlist %v belongs to another token", id)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err := h.Watchlists.Remove(id)
+		if err != nil && !errors.Is(err, errWatchlistNotFound) {
+			requestLogger(r).Errorf("[watchlists][%v] failed to remove watchlist: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "failed to remove watchlist %v", id)
+			return
+		}
+		requestLogger(r).Infof("[watchlists][%v] watchlist removed", id)
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	updated, err := decodeWatchlist(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	l.Name, l.Packages, l.Suites = updated.Name, updated.Packages, updated.Suites
+	l.Updated = time.Now().UTC()
+	err = h.Watchlists.Put(l)
+	if err != nil {
+		requestLogger(r).Errorf("[watchlists][%v] failed to save watchlist: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to save watchlist %v", id)
+		return
+	}
+	requestLogger(r).Infof("[watchlists][%v] watchlist updated (%v packages)", id, len(l.Packages))
+
+	writeJSON(w, r, http.StatusOK, l)
+}
+
+// watchedVersion is the current version of a package of a watchlist in a
+// suite of an archive
+type watchedVersion struct {
+	Package string `json:"package"`
+	Archive string `json:"archive"`
+	Suite   string `json:"suite"`
+	Version string `json:"version"`
+	// Updated is when the version was first seen in the suite, nil if it
+	// was before since
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// watchedChange is a version of a package of a watchlist published in one
+// of its suites
+type watchedChange struct {
+	Archive string `json:"archive"`
+	*database.HistoryEntry
+}
+
+// watchlistStatus is the status of the packages of a watchlist
+type watchlistStatus struct {
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Since    time.Time        `json:"since"`
+	Versions []watchedVersion `json:"versions"`
+	Changes  []watchedChange  `json:"changes"`
+}
+
+// serveWatchlistStatus returns the current versions of the packages of a
+// watchlist in its suites and the versions published since a time (since,
+// RFC3339, or in the last week by default). The tables list the current
+// versions, e.g. for a page for the browsers with format=html.
+func (h httpHandler) serveWatchlistStatus(w http.ResponseWriter, r *http.Request, l *watchlist) {
+	since := time.Now().Add(-defaultWatchlistSince).UTC()
+	if param := r.URL.Query().Get("since"); param != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since: %v", err)
+			return
+		}
+	}
+
+	suites := make(map[string]bool, len(l.Suites))
+	for _, suite := range l.Suites {
+		suites[suite] = true
+	}
+	watched := func(suite string) bool {
+		return len(suites) == 0 || suites[suite]
+	}
+
+	status := watchlistStatus{
+		ID:       l.ID,
+		Name:     l.Name,
+		Since:    since,
+		Versions: make([]watchedVersion, 0),
+		Changes:  make([]watchedChange, 0),
+	}
+	for _, pkg := range l.Packages {
+		allInfo, err := h.lookup(r, pkg)
+		if err != nil {
+			requestLogger(r).Error(err)
+			writeError(w, http.StatusInternalServerError, "failed to look up %v", pkg)
+			return
+		}
+
+		// the newest version in each suite of each archive
+		newest := make(map[string]*watchedVersion)
+		keys := make([]string, 0)
+		for _, info := range allInfo {
+			suite := info.Suite + info.Pocket
+			if !watched(suite) {
+				continue
+			}
+
+			key := info.Archive + "/" + suite
+			current, ok := newest[key]
+			if !ok {
+				keys = append(keys, key)
+				current = &watchedVersion{Package: pkg, Archive: info.Archive, Suite: suite, Version: info.Version}
+				newest[key] = current
+			}
+			if version.Compare(info.Version, current.Version) > 0 {
+				current.Version = info.Version
+			}
+		}
+		sort.Strings(keys)
+
+		for _, cache := range h.Archives.Enabled() {
+			changes, err := cache.Database.GetChanges(pkg, since)
+			if err != nil {
+				requestLogger(r).Errorf("failed to get the changes of %v in %v: %v", pkg, cache.Name, err)
+				h.Archives.Check(cache, err)
+				writeError(w, http.StatusInternalServerError, "failed to get the history of %v", pkg)
+				return
+			}
+
+			for _, change := range changes {
+				change.Suite = h.publicSuite(cache, change.Suite)
+				suite := change.Suite + change.Pocket
+				if !watched(suite) {
+					continue
+				}
+				status.Changes = append(status.Changes, watchedChange{cache.Name, change})
+
+				current, ok := newest[cache.Name+"/"+suite]
+				if ok && current.Version == change.Version {
+					firstSeen := change.FirstSeen
+					if current.Updated == nil || firstSeen.Before(*current.Updated) {
+						current.Updated = &firstSeen
+					}
+				}
+			}
+		}
+
+		for _, key := range keys {
+			status.Versions = append(status.Versions, *newest[key])
+		}
+	}
+	sort.SliceStable(status.Changes, func(i, j int) bool {
+		return status.Changes[i].FirstSeen.After(status.Changes[j].FirstSeen)
+	})
+
+	header := []string{"package", "archive", "suite", "version", "updated"}
+	records := make([][]string, len(status.Versions))
+	for i, current := range status.Versions {
+		updated := ""
+		if current.Updated != nil {
+			updated = current.Updated.Format(time.RFC3339)
+		}
+		records[i] = []string{current.Package, current.Archive, current.Suite, current.Version, updated}
+	}
+
+	writeList(w, r, status, header, records)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestWatchlists(t *testing.T) {
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "bash", Version: "5.2-1", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Name: "bash", Version: "5.2-2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		{Name: "bash", Version: "5.2-2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "arm64"},
+	}
+	h := newTestHandler(t, pkgs...)
+	h.Archives.archives[0].conf.SuiteNames = map[string]string{"noble": "prod"}
+	h.WatchlistTokens = []string{"alice", "bob"}
+	var err error
+	h.Watchlists, err = newWatchlistStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the update of noble-updates was published an hour ago
+	db := h.Archives.archives[0].Database
+	for _, pkg := range pkgs[1:] {
+		err = db.PrepareInsertHistory(pkg, time.Now().Add(-time.Hour), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(h)
+
+	do := func(method, target, token, body string, status int, value interface{}) http.Header {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatalf("%v %v: expected %v, got %v: %v", method, target, status, w.Code, w.Body.String())
+		}
+		if value != nil {
+			err := json.Unmarshal(w.Body.Bytes(), value)
+			if err != nil {
+				t.Fatalf("%v %v: %v", method, target, err)
+			}
+		}
+		return w.Header()
+	}
+
+	// only the known tokens manage watchlists
+	do(http.MethodGet, "/api/watchlists", "", "", http.StatusUnauthorized, nil)
+	do(http.MethodPost, "/api/watchlists", "eve", `{"name": "shell", "packages": ["bash"]}`, http.StatusUnauthorized, nil)
+	do(http.MethodPost, "/api/watchlists", "alice", `{"name": "shell"}`, http.StatusBadRequest, nil)
+	do(http.MethodPost, "/api/watchlists", "alice", `{"name": "shell", "packages": ["a/b"]}`, http.StatusBadRequest, nil)
+
+	created := new(watchlist)
+	header := do(http.MethodPost, "/api/watchlists", "alice", `{"name": "shell", "packages": ["bash"], "suites": ["prod-updates"]}`, http.StatusCreated, created)
+	if created.ID == "" || header.Get("Location") != "/api/watchlists/"+created.ID {
+		t.Fatalf("unexpected watchlist %+v (%v)", created, header.Get("Location"))
+	}
+
+	// the watchlists are listed by owner
+	var listed []*watchlist
+	do(http.MethodGet, "/api/watchlists", "alice", "", http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].ID != created.ID {
+		t.Errorf("expected the watchlist of alice, got %+v", listed)
+	}
+	do(http.MethodGet, "/api/watchlists", "bob", "", http.StatusOK, &listed)
+	if len(listed) != 0 {
+		t.Errorf("expected no watchlist for bob, got %+v", listed)
+	}
+
+	// anyone with the ID can read it, only its owner can change it
+	target := "/api/watchlists/" + created.ID
+	read := new(watchlist)
+	do(http.MethodGet, target, "", "", http.StatusOK, read)
+	if read.Name != "shell" {
+		t.Errorf("unexpected watchlist %+v", read)
+	}
+	do(http.MethodPut, target, "", `{"name": "bash", "packages": ["bash"]}`, http.StatusUnauthorized, nil)
+	do(http.MethodPut, target, "bob", `{"name": "bash", "packages": ["bash"]}`, http.StatusForbidden, nil)
+	do(http.MethodDelete, target, "bob", "", http.StatusForbidden, nil)
+	do(http.MethodGet, target+"/unknown", "", "", http.StatusNotFound, nil)
+
+	// the versions and the changes are in the public suites
+	status := new(watchlistStatus)
+	do(http.MethodGet, target+"/status", "", "", http.StatusOK, status)
+	if len(status.Versions) != 1 || status.Versions[0].Suite != "prod-updates" || status.Versions[0].Version != "5.2-2" || status.Versions[0].Updated == nil {
+		t.Errorf("unexpected versions %+v", status.Versions)
+	}
+	if len(status.Changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", status.Changes)
+	}
+	for _, change := range status.Changes {
+		if change.Archive != "test" || change.Suite != "prod" || change.Pocket != "-updates" {
+			t.Errorf("unexpected change %+v", change.HistoryEntry)
+		}
+	}
+	status = new(watchlistStatus)
+	do(http.MethodGet, target+"/status?since="+time.Now().Format(time.RFC3339), "", "", http.StatusOK, status)
+	if len(status.Changes) != 0 || status.Versions[0].Updated != nil {
+		t.Errorf("expected no change since now, got %+v", status)
+	}
+	do(http.MethodGet, target+"/status?since=yesterday", "", "", http.StatusBadRequest, nil)
+
+	// all the suites are watched when there are none
+	updated := new(watchlist)
+	do(http.MethodPut, target, "alice", `{"name": "bash", "packages": ["bash"]}`, http.StatusOK, updated)
+	if updated.Name != "bash" || len(updated.Suites) != 0 || updated.ID != created.ID {
+		t.Errorf("unexpected watchlist %+v", updated)
+	}
+	status = new(watchlistStatus)
+	do(http.MethodGet, target+"/status", "", "", http.StatusOK, status)
+	if len(status.Versions) != 2 || status.Versions[0].Suite != "prod" || status.Versions[1].Suite != "prod-updates" {
+		t.Errorf("unexpected versions %+v", status.Versions)
+	}
+
+	do(http.MethodDelete, target, "alice", "", http.StatusNoContent, nil)
+	do(http.MethodGet, target, "", "", http.StatusNotFound, nil)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// checkCopyCommand checks that packages can be copied from a suite to
+// another, before an SRU copy for instance
+const checkCopyCommand = "check-copy"
+
+// checkCopyArgs are the arguments of check-copy
+type checkCopyArgs struct {
+	From     string
+	To       string
+	Packages []string
+}
+
+// parseCheckCopyArgs parses -from SUITE -to SUITE PACKAGE...
+func parseCheckCopyArgs(args []string, output io.Writer) (*checkCopyArgs, error) {
+	flags := flag.NewFlagSet(checkCopyCommand, flag.ContinueOnError)
+	flags.SetOutput(output)
+	from := flags.String("from", "", "suite the packages are copied from (e.g. jammy-proposed)")
+	to := flags.String("to", "", "suite the packages are copied to (e.g. jammy-updates)")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %v [options] %v -from SUITE -to SUITE PACKAGE...\n\nCheck that the versions of the packages in -from are newer than the ones\nin -to, for all the architectures.\n\n", os.Args[0], checkCopyCommand)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if *from == "" || *to == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return nil, fmt.Errorf("-from, -to and the packages are required")
+	}
+
+	return &checkCopyArgs{From: *from, To: *to, Packages: flags.Args()}, nil
+}
+
+// copyCheck is the result of the check of a package
+type copyCheck struct {
+	Package string `json:"package"`
+	// FromVersion and ToVersion are the highest versions of the source
+	// package in both suites
+	FromVersion string   `json:"from_version"`
+	ToVersion   string   `json:"to_version"`
+	Conflicts   []string `json:"conflicts"`
+}
+
+// checkCopy compares the binaries of a package (and the ones built from it)
+// in both suites. Every binary of from must be newer than the one in to,
+// and every binary of to must have a build in from.
+func checkCopy(pkg, from, to string, pkgs []debianpkg.PackageInfo) copyCheck {
+	check := copyCheck{Package: pkg, Conflicts: make([]string, 0)}
+
+	// the highest version of each binary and architecture in each suite
+	type binary struct{ name, arch string }
+	fromVersions := make(map[binary]string)
+	toVersions := make(map[binary]string)
+	fromSources := make(map[string]bool)
+	for _, info := range pkgs {
+		suite := info.Suite + info.Pocket
+		if suite != from && suite != to {
+			continue
+		}
+		versions, sourceVersion := toVersions, &check.ToVersion
+		_, srcVersion := info.SourceNameVersion()
+		if suite == from {
+			versions, sourceVersion = fromVersions, &check.FromVersion
+			fromSources[srcVersion] = true
+		}
+
+		key := binary{info.Name, info.Architecture}
+		if current, ok := versions[key]; !ok || version.Compare(info.Version, current) > 0 {
+			versions[key] = info.Version
+		}
+		if *sourceVersion == "" || version.Compare(srcVersion, *sourceVersion) > 0 {
+			*sourceVersion = srcVersion
+		}
+	}
+
+	if len(fromVersions) == 0 {
+		check.Conflicts = append(check.Conflicts, fmt.Sprintf("not published in %v", from))
+		return check
+	}
+	if len(fromSources) > 1 {
+		check.Conflicts = append(check.Conflicts, fmt.Sprintf("several source versions in %v", from))
+	}
+	if check.ToVersion != "" && version.Compare(check.FromVersion, check.ToVersion) <= 0 {
+		check.Conflicts = append(check.Conflicts, fmt.Sprintf("source %v in %v is not newer than %v in %v", check.FromVersion, from, check.ToVersion, to))
+	}
+
+	keys := make([]binary, 0, len(fromVersions)+len(toVersions))
+	for key := range fromVersions {
+		keys = append(keys, key)
+	}
+	for key := range toVersions {
+		if _, ok := fromVersions[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].arch < keys[j].arch
+	})
+
+	for _, key := range keys {
+		fromVersion, inFrom := fromVersions[key]
+		toVersion, inTo := toVersions[key]
+		switch {
+		case !inFrom:
+			check.Conflicts = append(check.Conflicts, fmt.Sprintf("%v/%v: no build in %v, %v stays in %v", key.name, key.arch, from, toVersion, to))
+		case inTo && version.Compare(fromVersion, toVersion) <= 0:
+			check.Conflicts = append(check.Conflicts, fmt.Sprintf("%v/%v: %v in %v is not newer than %v in %v", key.name, key.arch, fromVersion, from, toVersion, to))
+		}
+	}
+
+	return check
+}
+
+// writeCopyChecks writes the results of the checks, as text or as JSON. It
+// returns false if a check failed.
+func writeCopyChecks(w io.Writer, format string, args *checkCopyArgs, checks []copyCheck) (bool, error) {
+	ok := true
+	for _, check := range checks {
+		ok = ok && len(check.Conflicts) == 0
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return ok, encoder.Encode(checks)
+	}
+
+	for _, check := range checks {
+		if len(check.Conflicts) == 0 {
+			toVersion := "not published"
+			if check.ToVersion != "" {
+				toVersion = check.ToVersion
+			}
+			fmt.Fprintf(w, "%v: ok, %v (%v) > %v (%v)\n", check.Package, check.FromVersion, args.From, toVersion, args.To)
+			continue
+		}
+		for _, conflict := range check.Conflicts {
+			fmt.Fprintf(w, "%v: conflict: %v\n", check.Package, conflict)
+		}
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestCheckCopy(t *testing.T) {
+	build := func(name, version, source, suitePocket, arch string) debianpkg.PackageInfo {
+		suite, pocket, _ := strings.Cut(suitePocket, "-")
+		if pocket != "" {
+			pocket = "-" + pocket
+		}
+		return debianpkg.PackageInfo{Name: name, Version: version, Source: source, Suite: suite, Pocket: pocket, Architecture: arch}
+	}
+
+	tests := []struct {
+		name     string
+		pkgs     []debianpkg.PackageInfo
+		from, to string
+		expected copyCheck
+	}{
+		{
+			name: "newer in every architecture",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-3", "hello", "jammy-updates", "amd64"),
+				build("hello", "2.10-3", "hello", "jammy-updates", "arm64"),
+				build("hello", "2.10-4", "hello", "jammy-proposed", "amd64"),
+				build("hello", "2.10-4", "hello", "jammy-proposed", "arm64"),
+				// the other suites are ignored
+				build("hello", "2.12-1", "hello", "noble", "amd64"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-4", ToVersion: "2.10-3"},
+		},
+		{
+			name: "not published in to",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-4", "hello", "jammy-proposed", "amd64"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-4"},
+		},
+		{
+			name: "not published in from",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-3", "hello", "jammy-updates", "amd64"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{ToVersion: "2.10-3", Conflicts: []string{"not published in jammy-proposed"}},
+		},
+		{
+			name: "missing build",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-3", "hello", "jammy-updates", "amd64"),
+				build("hello", "2.10-3", "hello", "jammy-updates", "s390x"),
+				build("hello", "2.10-4", "hello", "jammy-proposed", "amd64"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-4", ToVersion: "2.10-3", Conflicts: []string{"hello/s390x: no build in jammy-proposed, 2.10-3 stays in jammy-updates"}},
+		},
+		{
+			// a binNMU in to is newer than the binary of from
+			name: "binary not newer",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-3+b1", "hello (2.10-3)", "jammy-updates", "amd64"),
+				build("hello", "2.10-3.1", "hello", "jammy-proposed", "amd64"),
+				build("libhello1", "2.10-3+b1", "hello (2.10-3)", "jammy-updates", "amd64"),
+				build("libhello1", "2.10-3", "hello (2.10-3.1)", "jammy-proposed", "amd64"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-3.1", ToVersion: "2.10-3", Conflicts: []string{"libhello1/amd64: 2.10-3 in jammy-proposed is not newer than 2.10-3+b1 in jammy-updates"}},
+		},
+		{
+			name: "source not newer",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-4", "hello", "jammy-updates", "amd64"),
+				build("hello", "2.10-4", "hello", "jammy-proposed", "amd64"),
+			},
+			from: "jammy-proposed",
+			to:   "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-4", ToVersion: "2.10-4", Conflicts: []string{
+				"source 2.10-4 in jammy-proposed is not newer than 2.10-4 in jammy-updates",
+				"hello/amd64: 2.10-4 in jammy-proposed is not newer than 2.10-4 in jammy-updates",
+			}},
+		},
+		{
+			// an older build wasn't removed yet
+			name: "several sources",
+			pkgs: []debianpkg.PackageInfo{
+				build("hello", "2.10-4", "hello", "jammy-proposed", "amd64"),
+				build("hello-doc", "2.10-3", "hello", "jammy-proposed", "all"),
+			},
+			from:     "jammy-proposed",
+			to:       "jammy-updates",
+			expected: copyCheck{FromVersion: "2.10-4", Conflicts: []string{"several source versions in jammy-proposed"}},
+		},
+	}
+
+	for _, test := range tests {
+		check := checkCopy("hello", test.from, test.to, test.pkgs)
+		test.expected.Package = "hello"
+		if test.expected.Conflicts == nil {
+			test.expected.Conflicts = []string{}
+		}
+		if fmt.Sprintf("%q", check) != fmt.Sprintf("%q", test.expected) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.expected, check)
+		}
+	}
+}
+
+func TestWriteCopyChecks(t *testing.T) {
+	args := &checkCopyArgs{From: "jammy-proposed", To: "jammy-updates"}
+	checks := []copyCheck{
+		{Package: "hello", FromVersion: "2.10-4", ToVersion: "2.10-3", Conflicts: []string{}},
+		{Package: "world", FromVersion: "1.0-1", Conflicts: []string{}},
+	}
+
+	out := new(bytes.Buffer)
+	ok, err := writeCopyChecks(out, formatTable, args, checks)
+	expected := "hello: ok, 2.10-4 (jammy-proposed) > 2.10-3 (jammy-updates)\n" +
+		"world: ok, 1.0-1 (jammy-proposed) > not published (jammy-updates)\n"
+	if err != nil || !ok || out.String() != expected {
+		t.Errorf("expected %q, got %q (%v, %v)", expected, out.String(), ok, err)
+	}
+
+	checks[1].Conflicts = []string{"not published in jammy-proposed"}
+	ok, err = writeCopyChecks(io.Discard, formatJSON, args, checks)
+	if err != nil || ok {
+		t.Errorf("expected a failed check, got %v (%v)", ok, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSI styles of the tables
+const (
+	styleBold   = "1"
+	styleDim    = "2"
+	styleRed    = "31"
+	styleGreen  = "32"
+	styleYellow = "33"
+)
+
+// useColor tells if the tables are colored: stdout is a terminal, -no-color
+// is not given and NO_COLOR is not set (see https://no-color.org)
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	stat, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// paint returns text with the styles, text is returned as is without
+// styles
+func paint(text string, styles ...string) string {
+	if len(styles) == 0 {
+		return text
+	}
+
+	return "\x1b[" + strings.Join(styles, ";") + "m" + text + "\x1b[0m"
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// suitesCommand prints the suites known by the server, it's called by the
+// completion scripts
+const suitesCommand = "__suites"
+
+// completionValues are the values completed for the options, suites are
+// fetched from the server
+var completionValues = map[string][]string{
+	"format":  {formatTable, formatJSON, formatCSV},
+	"sources": {"list", "deb822"},
+}
+
+// isSuiteFlag tells if an option takes suites
+func isSuiteFlag(name string) bool {
+	return name == "s" || name == "suite"
+}
+
+// isBoolFlag tells if an option takes no value
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// shellQuote quotes a string for the shells between single quotes
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// writeCompletion writes the completion script of a shell (bash, zsh or
+// fish) for the options of flags
+func writeCompletion(w io.Writer, shell string, flags *flag.FlagSet) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	default:
+		return fmt.Errorf("unknown shell %q (bash, zsh or fish)", shell)
+	}
+
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, flags *flag.FlagSet) {
+	options := make([]string, 0)
+	flags.VisitAll(func(f *flag.Flag) {
+		options = append(options, "-"+f.Name)
+	})
+
+	fmt.Fprintf(w, `_rmadison() {
+    local cur prev prefix=""
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    # the values of the filters are comma separated
+    [[ "$cur" == *,* ]] && prefix="${cur%%,*},"
+    case "${prev#-}" in
+        s|suite|-s|-suite)
+            COMPREPLY=($(compgen -P "$prefix" -W "$("${COMP_WORDS[0]}" %v 2>/dev/null)" -- "${cur##*,}"))
+            return
+            ;;
+`, suitesCommand)
+	for _, name := range sortedCompletionFlags() {
+		fmt.Fprintf(w, "        %v|-%v)\n            COMPREPLY=($(compgen -W %v -- \"$cur\"))\n            return\n            ;;\n",
+			name, name, shellQuote(strings.Join(completionValues[name], " ")))
+	}
+	fmt.Fprintf(w, `    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W %v -- "$cur"))
+    fi
+}
+complete -F _rmadison rmadison
+`, shellQuote(strings.Join(options, " ")))
+}
+
+func writeZshCompletion(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, `#compdef rmadison
+
+_rmadison_suites() {
+    local -a suites
+    suites=(${(f)"$(rmadison %v 2>/dev/null)"})
+    _values -s , suite $suites
+}
+
+_arguments \
+`, suitesCommand)
+	flags.VisitAll(func(f *flag.Flag) {
+		usage := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(f.Usage)
+		spec := fmt.Sprintf("-%v[%v]", f.Name, usage)
+		switch {
+		case isBoolFlag(f):
+		case isSuiteFlag(f.Name):
+			spec += ":suite:_rmadison_suites"
+		case completionValues[f.Name] != nil:
+			spec += fmt.Sprintf(":%v:(%v)", f.Name, strings.Join(completionValues[f.Name], " "))
+		default:
+			spec += fmt.Sprintf(":%v: ", f.Name)
+		}
+		fmt.Fprintf(w, "    %v \\\n", shellQuote(spec))
+	})
+	fmt.Fprint(w, "    '1:package: '\n")
+}
+
+func writeFishCompletion(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "function __rmadison_suites\n    rmadison %v 2>/dev/null\nend\n\n", suitesCommand)
+	fmt.Fprint(w, "complete -c rmadison -f\n")
+	flags.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c rmadison -o %v -d %v", f.Name, shellQuote(f.Usage))
+		switch {
+		case isBoolFlag(f):
+		case isSuiteFlag(f.Name):
+			line += " -x -a '(__rmadison_suites)'"
+		case completionValues[f.Name] != nil:
+			line += " -x -a " + shellQuote(strings.Join(completionValues[f.Name], " "))
+		default:
+			line += " -r"
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// sortedCompletionFlags returns the options of completionValues sorted
+func sortedCompletionFlags() []string {
+	names := make([]string, 0, len(completionValues))
+	for name := range completionValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// suitesInfo is the part of the response of /api/suites used by the
+// completion
+type suitesInfo struct {
+	Suites  []string `json:"suites"`
+	Pockets []string `json:"pockets"`
+}
+
+// printSuites prints the suites (with their pockets) of all the archives
+// of the first server that answers, one per line
+func printSuites(w io.Writer, client *resty.Client, servers []string) error {
+	var allSuites []suitesInfo
+	_, err := get(client, servers, "api/suites", nil, &allSuites)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, info := range allSuites {
+		for _, suite := range info.Suites {
+			for _, pocket := range info.Pockets {
+				seen[suite+pocket] = true
+			}
+		}
+	}
+	suites := make([]string, 0, len(seen))
+	for suite := range seen {
+		suites = append(suites, suite)
+	}
+	sort.Strings(suites)
+
+	for _, suite := range suites {
+		fmt.Fprintln(w, suite)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// testCompletionFlags returns options of each kind
+func testCompletionFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("rmadison", flag.ContinueOnError)
+	flags.String("s", "", "suites")
+	flags.String("format", formatTable, "output format: table, json or csv")
+	flags.Bool("json", false, "same as -format json")
+	flags.String("u", "", "servers [URL]")
+
+	return flags
+}
+
+func TestWriteCompletion(t *testing.T) {
+	tests := []struct {
+		shell    string
+		expected []string
+	}{
+		{"bash", []string{
+			`COMPREPLY=($(compgen -P "$prefix" -W "$("${COMP_WORDS[0]}" __suites 2>/dev/null)" -- "${cur##*,}"))`,
+			"        format|-format)\n            COMPREPLY=($(compgen -W 'table json csv' -- \"$cur\"))",
+			"        sources|-sources)\n            COMPREPLY=($(compgen -W 'list deb822' -- \"$cur\"))",
+			`COMPREPLY=($(compgen -W '-format -json -s -u' -- "$cur"))`,
+			"complete -F _rmadison rmadison\n",
+		}},
+		{"zsh", []string{
+			"#compdef rmadison\n",
+			`suites=(${(f)"$(rmadison __suites 2>/dev/null)"})`,
+			`    '-s[suites]:suite:_rmadison_suites' \`,
+			`    '-format[output format\: table, json or csv]:format:(table json csv)' \`,
+			`    '-json[same as -format json]' \`,
+			`    '-u[servers \[URL\]]:u: ' \`,
+			"    '1:package: '\n",
+		}},
+		{"fish", []string{
+			"function __rmadison_suites\n    rmadison __suites 2>/dev/null\nend\n",
+			"complete -c rmadison -f\n",
+			"complete -c rmadison -o s -d 'suites' -x -a '(__rmadison_suites)'\n",
+			"complete -c rmadison -o format -d 'output format: table, json or csv' -x -a 'table json csv'\n",
+			"complete -c rmadison -o json -d 'same as -format json'\n",
+			"complete -c rmadison -o u -d 'servers [URL]' -r\n",
+		}},
+	}
+
+	for _, test := range tests {
+		out := new(bytes.Buffer)
+		err := writeCompletion(out, test.shell, testCompletionFlags())
+		if err != nil {
+			t.Fatalf("%v: %v", test.shell, err)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("%v: %q not in\n%v", test.shell, expected, out.String())
+			}
+		}
+	}
+
+	err := writeCompletion(io.Discard, "tcsh", testCompletionFlags())
+	if err == nil || !strings.Contains(err.Error(), "unknown shell") {
+		t.Errorf("expected an unknown shell error, got %v", err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":            "''",
+		"table json":  "'table json'",
+		"the 'suite'": `'the '\''suite'\'''`,
+	}
+	for value, expected := range tests {
+		if quoted := shellQuote(value); quoted != expected {
+			t.Errorf("%q: expected %v, got %v", value, expected, quoted)
+		}
+	}
+}
+
+func TestPrintSuites(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/suites" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"suites": ["noble", "jammy"], "pockets": ["", "-updates"]},
+			{"suites": ["noble"], "pockets": ["", "-security"]}
+		]`))
+	}))
+	defer server.Close()
+
+	out := new(bytes.Buffer)
+	err := printSuites(out, resty.New(), []string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "jammy\njammy-updates\nnoble\nnoble-security\nnoble-updates\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// defaultMaxAge is how long the indexes downloaded by the direct mode are
+// used before being refreshed
+const defaultMaxAge = 24 * time.Hour
+
+// directArchive is an archive queried by the direct mode
+type directArchive struct {
+	Name     string   `yaml:"name"`
+	BaseURL  string   `yaml:"base_url"`
+	PortsURL string   `yaml:"ports_url"`
+	Pockets  []string `yaml:"pockets"`
+	// Releases are indexed with each of PocketSuffixes (all the usual
+	// pockets by default)
+	Releases       []string `yaml:"releases"`
+	PocketSuffixes []string `yaml:"pocket_suffixes"`
+	// Architectures and Components restrict the indexes downloaded
+	Architectures []string `yaml:"architectures"`
+	Components    []string `yaml:"components"`
+	// Keyrings verify the signatures of the InRelease files, the invalid
+	// ones are refused
+	Keyrings []string `yaml:"keyrings"`
+	// Layout is dists (the default) or flat
+	Layout string `yaml:"layout"`
+	// PPA is a Launchpad PPA (user/name), it sets base_url and the
+	// components
+	PPA string `yaml:"ppa"`
+}
+
+// defaultDirectArchives are the archives of the direct mode when none is
+// configured: the supported LTS releases of Ubuntu
+var defaultDirectArchives = []directArchive{
+	{
+		Name:     "ubuntu",
+		BaseURL:  "http://archive.ubuntu.com/ubuntu/dists",
+		PortsURL: "http://ports.ubuntu.com/ubuntu-ports/dists",
+		Pockets: []string{
+			"jammy", "jammy-updates", "jammy-security",
+			"noble", "noble-updates", "noble-security",
+		},
+	},
+}
+
+// directQuery is the lookup of the direct mode, with the filters of the
+// command line
+type directQuery struct {
+	Architectures   []string
+	Suites          []string
+	Components      []string
+	SourceAndBinary bool
+}
+
+// matches tells if a package passes the filters
+func (q directQuery) matches(pkg *debianpkg.PackageInfo) bool {
+	return (len(q.Architectures) == 0 || contains(pkg.Architecture, q.Architectures)) &&
+		(len(q.Suites) == 0 || contains(pkg.Suite+pkg.Pocket, q.Suites)) &&
+		(len(q.Components) == 0 || contains(pkg.Component, q.Components))
+}
+
+// splitList splits a comma separated list of the command line
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, elmt := range strings.Split(value, ",") {
+		if elmt = strings.TrimSpace(elmt); elmt != "" {
+			list = append(list, elmt)
+		}
+	}
+
+	return list
+}
+
+// directCacheDir returns the directory where the indexes and the database
+// of an archive are kept
+func directCacheDir(name string) (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(userCacheDir, "rmadison", name), nil
+}
+
+// openDirectArchive initializes an archive and its database in its cache
+// directory, with the Release files seen during its last refresh
+func openDirectArchive(conf directArchive, client *resty.Client) (*archive.Archive, error) {
+	if conf.Name == "" || strings.ContainsAny(conf.Name, "/.") {
+		return nil, fmt.Errorf("invalid archive name %q", conf.Name)
+	}
+	if conf.Layout != "" && conf.Layout != archive.LayoutDists && conf.Layout != archive.LayoutFlat {
+		return nil, fmt.Errorf("invalid layout %q for archive %v", conf.Layout, conf.Name)
+	}
+	// the series of the PPAs are discovered when none is configured
+	discover := false
+	if conf.PPA != "" {
+		if conf.BaseURL != "" || conf.Layout == archive.LayoutFlat {
+			return nil, fmt.Errorf("the ppa archive %v can't have a base_url nor the flat layout", conf.Name)
+		}
+		ppaURL, err := archive.PPABaseURL(conf.PPA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ppa for archive %v: %v", conf.Name, err)
+		}
+		conf.BaseURL = ppaURL
+		if len(conf.Components) == 0 {
+			conf.Components = archive.PPAComponents
+		}
+		discover = len(conf.Pockets) == 0 && len(conf.Releases) == 0
+	}
+	if len(conf.Pockets) == 0 && len(conf.Releases) == 0 && !discover {
+		return nil, fmt.Errorf("no pockets configured for archive %v, set pockets or releases", conf.Name)
+	}
+
+	baseURL, err := url.Parse(conf.BaseURL)
+	if err != nil || conf.BaseURL == "" {
+		return nil, fmt.Errorf("invalid base_url for archive %v", conf.Name)
+	}
+	portsURL := baseURL
+	if conf.PortsURL != "" {
+		portsURL, err = url.Parse(conf.PortsURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ports_url for archive %v", conf.Name)
+		}
+	}
+
+	cacheDir, err := directCacheDir(conf.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cacheDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.NewConn("sqlite3", path.Join(cacheDir, "archive.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &archive.Archive{
+		Name:     conf.Name,
+		BaseURL:  baseURL,
+		PortsURL: portsURL,
+		Pockets:  conf.Pockets,
+		Discover: discover,
+		CacheDir: cacheDir,
+		Client:   client,
+		Database: db,
+
+		Architectures: conf.Architectures,
+		Components:    conf.Components,
+		Keyrings:      conf.Keyrings,
+
+		Releases:       conf.Releases,
+		PocketSuffixes: conf.PocketSuffixes,
+
+		Layout: conf.Layout,
+	}
+
+	content, err := os.ReadFile(path.Join(cacheDir, "release.json"))
+	if err == nil {
+		err = json.Unmarshal(content, &cache.ReleaseInfo)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// everything is downloaded and parsed again
+		cache.ReleaseInfo = nil
+	}
+	if nbPackages, err := db.CountPackages(); err != nil || nbPackages == 0 {
+		// the database was removed
+		cache.ReleaseInfo = nil
+	}
+
+	return cache, nil
+}
+
+// refreshDirectArchive refreshes the archive if it's older than maxAge, or
+// if it was never refreshed. The Release files are saved for the next
+// refresh, only the indexes that changed are downloaded.
+func refreshDirectArchive(cache *archive.Archive, maxAge time.Duration, offline bool) error {
+	releasePath := path.Join(cache.CacheDir, "release.json")
+	info, err := os.Stat(releasePath)
+	if err == nil && (offline || time.Since(info.ModTime()) < maxAge) {
+		return nil
+	}
+	if offline {
+		return fmt.Errorf("archive %v was never downloaded, run without -offline first", cache.Name)
+	}
+
+	fmt.Fprintf(os.Stderr, "refreshing %v...\n", cache.Name)
+	_, err = cache.RefreshCache(false)
+	if err != nil {
+		// the packages that were indexed are still usable, the next run
+		// refreshes the archive again
+		fmt.Fprintf(os.Stderr, "failed to refresh %v: %v\n", cache.Name, err)
+		return nil
+	}
+
+	content, err := json.Marshal(cache.ReleaseInfo)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(releasePath, content, 0o644)
+}
+
+// packageStore is where the packages are looked up without a server: the
+// database of an archive or a snapshot
+type packageStore interface {
+	GetPackage(name string) ([]*debianpkg.PackageInfo, error)
+	GetPackagesBySource(source string) ([]*debianpkg.PackageInfo, error)
+}
+
+// lookupDirect returns the versions of a package in a store, with the
+// binaries built from it if withBinaries is set
+func lookupDirect(store packageStore, pkg string, withBinaries bool) ([]*debianpkg.PackageInfo, error) {
+	found, err := store.GetPackage(pkg)
+	if err != nil || !withBinaries {
+		return found, err
+	}
+
+	binaries, err := store.GetPackagesBySource(pkg)
+	if err != nil {
+		return nil, err
+	}
+	for _, binary := range binaries {
+		if name, _ := binary.SourceNameVersion(); name == pkg && binary.Name != pkg {
+			found = append(found, binary)
+		}
+	}
+
+	return found, nil
+}
+
+// queryDirect looks up the packages in the archives, downloading their
+// indexes when needed. The versions are returned by package.
+func queryDirect(client *resty.Client, archives []directArchive, pkgs []string, query directQuery, maxAge time.Duration, offline bool) (map[string][]debianpkg.PackageInfo, error) {
+	// the refreshes are reported on the standard error
+	archive.SetLogger(zap.NewNop().Sugar())
+
+	results := make(map[string][]debianpkg.PackageInfo, len(pkgs))
+	for _, conf := range archives {
+		cache, err := openDirectArchive(conf, client)
+		if err != nil {
+			return nil, err
+		}
+		defer cache.Database.Close()
+
+		err = refreshDirectArchive(cache, maxAge, offline)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range pkgs {
+			found, err := lookupDirect(cache.Database, pkg, query.SourceAndBinary)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, info := range found {
+				if query.matches(info) {
+					info.Archive = cache.Name
+					info.URL = cache.PoolURL(info)
+					results[pkg] = append(results[pkg], *info)
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/archive"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := map[string][]string{
+		"":                     {},
+		"noble":                {"noble"},
+		"noble, jammy-updates": {"noble", "jammy-updates"},
+		",amd64,,arm64 ,":      {"amd64", "arm64"},
+	}
+	for value, expected := range tests {
+		if list := splitList(value); fmt.Sprint(list) != fmt.Sprint(expected) {
+			t.Errorf("%q: expected %v, got %v", value, expected, list)
+		}
+	}
+}
+
+func TestDirectQueryMatches(t *testing.T) {
+	pkg := &debianpkg.PackageInfo{Name: "bash", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"}
+	tests := []struct {
+		query    directQuery
+		expected bool
+	}{
+		{directQuery{}, true},
+		{directQuery{Suites: []string{"noble-updates"}}, true},
+		{directQuery{Suites: []string{"noble"}}, false},
+		{directQuery{Architectures: []string{"arm64", "amd64"}, Components: []string{"main"}}, true},
+		{directQuery{Architectures: []string{"arm64"}}, false},
+		{directQuery{Components: []string{"universe"}}, false},
+	}
+	for _, test := range tests {
+		if matches := test.query.matches(pkg); matches != test.expected {
+			t.Errorf("%+v: expected %v, got %v", test.query, test.expected, matches)
+		}
+	}
+}
+
+func TestOpenDirectArchive(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	client := resty.New()
+
+	tests := []struct {
+		name string
+		conf directArchive
+		err  string
+	}{
+		{"no name", directArchive{BaseURL: "http://archive.ubuntu.com/ubuntu/dists", Pockets: []string{"noble"}}, "invalid archive name"},
+		{"name with a path", directArchive{Name: "../ubuntu", BaseURL: "http://archive.ubuntu.com/ubuntu/dists", Pockets: []string{"noble"}}, "invalid archive name"},
+		{"no pockets", directArchive{Name: "ubuntu", BaseURL: "http://archive.ubuntu.com/ubuntu/dists"}, "no pockets"},
+		{"invalid layout", directArchive{Name: "ubuntu", BaseURL: "http://archive.ubuntu.com/ubuntu/dists", Pockets: []string{"noble"}, Layout: "pool"}, "invalid layout"},
+		{"no base_url", directArchive{Name: "ubuntu", Pockets: []string{"noble"}}, "invalid base_url"},
+		{"invalid ports_url", directArchive{Name: "ubuntu", BaseURL: "http://archive.ubuntu.com/ubuntu/dists", PortsURL: "http://[::1", Pockets: []string{"noble"}}, "invalid ports_url"},
+		{"ppa with a base_url", directArchive{Name: "ppa", PPA: "user/name", BaseURL: "http://archive.ubuntu.com/ubuntu/dists", Pockets: []string{"noble"}}, "can't have a base_url"},
+		{"invalid ppa", directArchive{Name: "ppa", PPA: "user", Pockets: []string{"noble"}}, "invalid ppa"},
+	}
+	for _, test := range tests {
+		_, err := openDirectArchive(test.conf, client)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// the ports default to the base URL
+	cache, err := openDirectArchive(directArchive{Name: "vendor", BaseURL: "http://example.com/debian/dists", Releases: []string{"bookworm"}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Database.Close()
+	if cache.PortsURL.String() != "http://example.com/debian/dists" || cache.CacheDir != path.Join(os.Getenv("XDG_CACHE_HOME"), "rmadison", "vendor") {
+		t.Errorf("unexpected archive %+v", cache)
+	}
+
+	// the PPAs have their URL and components
+	ppa, err := openDirectArchive(directArchive{Name: "ppa", PPA: "user/name", Pockets: []string{"noble"}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ppa.Database.Close()
+	if !strings.HasPrefix(ppa.BaseURL.String(), "https://ppa.launchpadcontent.net/user/name/") || fmt.Sprint(ppa.Components) != fmt.Sprint(archive.PPAComponents) {
+		t.Errorf("unexpected ppa %v %v", ppa.BaseURL, ppa.Components)
+	}
+	if ppa.Discover {
+		t.Errorf("the series of ppa are configured, expected no discovery")
+	}
+
+	// the series of the PPAs without pockets nor releases are discovered
+	discovered, err := openDirectArchive(directArchive{Name: "discovered", PPA: "user/name"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discovered.Database.Close()
+	if !discovered.Discover {
+		t.Errorf("expected the series of discovered to be discovered")
+	}
+}
+
+// fakeStore is a packageStore of packages in memory
+type fakeStore []*debianpkg.PackageInfo
+
+func (s fakeStore) GetPackage(name string) ([]*debianpkg.PackageInfo, error) {
+	found := make([]*debianpkg.PackageInfo, 0)
+	for _, pkg := range s {
+		if pkg.Name == name {
+			found = append(found, pkg)
+		}
+	}
+	return found, nil
+}
+
+func (s fakeStore) GetPackagesBySource(source string) ([]*debianpkg.PackageInfo, error) {
+	found := make([]*debianpkg.PackageInfo, 0)
+	for _, pkg := range s {
+		if name, _ := pkg.SourceNameVersion(); name == source {
+			found = append(found, pkg)
+		}
+	}
+	return found, nil
+}
+
+func TestLookupDirect(t *testing.T) {
+	store := fakeStore{
+		{Name: "openssl", Version: "3.0.13-0ubuntu3", Source: "openssl"},
+		{Name: "libssl3t64", Version: "3.0.13-0ubuntu3", Source: "openssl"},
+		{Name: "openssl-doc", Version: "3.0.13-0ubuntu3+b1", Source: "openssl (3.0.13-0ubuntu3)"},
+		{Name: "curl", Version: "8.5.0-2", Source: "curl"},
+	}
+
+	tests := []struct {
+		withBinaries bool
+		expected     []string
+	}{
+		{false, []string{"openssl"}},
+		// the source package itself isn't repeated
+		{true, []string{"openssl", "libssl3t64", "openssl-doc"}},
+	}
+	for _, test := range tests {
+		found, err := lookupDirect(store, "openssl", test.withBinaries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(found))
+		for i, pkg := range found {
+			names[i] = pkg.Name
+		}
+		if fmt.Sprint(names) != fmt.Sprint(test.expected) {
+			t.Errorf("with binaries %v: expected %v, got %v", test.withBinaries, test.expected, names)
+		}
+	}
+}
+
+func TestQueryDirect(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	packages := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(packages)
+	fmt.Fprint(gzipWriter, "Package: hello\nVersion: 1.0\nArchitecture: amd64\nFilename: ./hello_1.0_amd64.deb\n\n")
+	fmt.Fprint(gzipWriter, "Package: hello\nVersion: 1.0\nArchitecture: arm64\nFilename: ./hello_1.0_arm64.deb\n")
+	gzipWriter.Close()
+	release := fmt.Sprintf("Origin: vendor\nSHA256:\n %x %v Packages.gz\n", sha256.Sum256(packages.Bytes()), packages.Len())
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/repo/Release":
+			w.Write([]byte(release))
+		case "/repo/Packages.gz":
+			w.Write(packages.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	archives := []directArchive{{Name: "vendor", BaseURL: server.URL + "/repo", Pockets: []string{"."}, Layout: archive.LayoutFlat}}
+	client := resty.New()
+	query := directQuery{Architectures: []string{"arm64"}}
+
+	// nothing to look up before the first download
+	_, err := queryDirect(client, archives, []string{"hello"}, query, time.Hour, true)
+	if err == nil || !strings.Contains(err.Error(), "never downloaded") {
+		t.Fatalf("expected an error offline, got %v", err)
+	}
+
+	results, err := queryDirect(client, archives, []string{"hello", "world"}, query, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results["hello"]) != 1 || len(results["world"]) != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	hello := results["hello"][0]
+	if hello.Archive != "vendor" || hello.Architecture != "arm64" || hello.URL != server.URL+"/repo/hello_1.0_arm64.deb" {
+		t.Errorf("unexpected package %+v", hello)
+	}
+
+	// the archive isn't refreshed before maxAge, nor offline
+	downloaded := requests.Load()
+	for _, offline := range []bool{false, true} {
+		results, err = queryDirect(client, archives, []string{"hello"}, directQuery{}, time.Hour, offline)
+		if err != nil || len(results["hello"]) != 2 {
+			t.Errorf("offline %v: unexpected results %+v (%v)", offline, results, err)
+		}
+	}
+	if requests.Load() != downloaded {
+		t.Errorf("expected no download, got %v", requests.Load()-downloaded)
+	}
+}
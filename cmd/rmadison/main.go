@@ -1,16 +1,39 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"sort"
+	"net/http"
+	"os"
 	"strings"
 
 	"github.com/gjolly/go-rmadison/pkg/debianpkg"
 	"github.com/go-resty/resty/v2"
 )
 
+// The exit codes, so scripts can check whether packages are published
+// without parsing the output
+const (
+	exitOK = iota
+	// exitNotFound is used when at least one package has no version
+	// matching the filters, or when check-copy fails
+	exitNotFound
+	exitUsage
+	// exitError is used when the servers (or the archives in direct mode)
+	// can't be queried
+	exitError
+)
+
+// fatal logs err and exits with code
+func fatal(code int, err interface{}) {
+	log.Print(err)
+	os.Exit(code)
+}
+
 func contains(elmt string, slice []string) bool {
 	for _, e := range slice {
 		if elmt == e {
@@ -21,84 +44,534 @@ func contains(elmt string, slice []string) bool {
 	return false
 }
 
-func sortArch(line []string) {
-	archs := line[3]
-	archList := strings.Split(archs, ", ")
-	sort.Strings(archList)
+// errNotFound is returned when a server doesn't know the requested
+// package
+var errNotFound = errors.New("not found")
+
+// errorResponse is the body of the error responses of the server
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// get queries the servers in order and returns the response from the
+// first one that answers successfully
+func get(client *resty.Client, servers []string, urlPath string, query map[string]string, result interface{}) (*resty.Response, error) {
+	return request(client, servers, http.MethodGet, urlPath, query, nil, result)
+}
+
+// request sends a request to the servers in order, with an optional JSON
+// body, and returns the response from the first one that answers
+// successfully
+func request(client *resty.Client, servers []string, method, urlPath string, query map[string]string, body, result interface{}) (*resty.Response, error) {
+	var lastErr error
+	for _, server := range servers {
+		queryURL := fmt.Sprintf("%v/%v", server, urlPath)
+
+		apiErr := new(errorResponse)
+		req := client.R().SetQueryParams(query).SetError(apiErr)
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+		resp, err := req.Execute(method, queryURL)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// the other servers index the same archives
+		if apiErr.Error.Code == "not_found" {
+			return nil, errNotFound
+		}
+		if resp.IsError() {
+			lastErr = fmt.Errorf("%v: %v", server, resp.Status())
+			if apiErr.Error.Message != "" {
+				lastErr = fmt.Errorf("%v: %v", server, apiErr.Error.Message)
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// queryPackage returns the information about pkg from the first server
+// that answers, filtered by the query parameters
+func queryPackage(client *resty.Client, servers []string, pkg string, query map[string]string) ([]debianpkg.PackageInfo, error) {
+	var pkgInfo []debianpkg.PackageInfo
+	_, err := get(client, servers, pkg, query, &pkgInfo)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pkgInfo, nil
+}
+
+// queryPackages looks up several packages with a single batch request, the
+// versions are returned by package
+func queryPackages(client *resty.Client, servers []string, pkgs []string, query map[string]string) (map[string][]debianpkg.PackageInfo, error) {
+	results := make(map[string][]debianpkg.PackageInfo)
+	body := map[string][]string{"packages": pkgs}
+	_, err := request(client, servers, http.MethodPost, "api/batch", query, body, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// collectResults returns the versions of the packages in the order of
+// pkgs, in the archives given (all of them if empty), and the packages
+// without any version
+func collectResults(pkgs []string, results map[string][]debianpkg.PackageInfo, archives []string) ([]debianpkg.PackageInfo, []string) {
+	pkgInfo := make([]debianpkg.PackageInfo, 0)
+	missing := make([]string, 0)
+	for _, pkg := range pkgs {
+		found := filterArchives(results[pkg], archives)
+		if len(found) == 0 {
+			missing = append(missing, pkg)
+		}
+		pkgInfo = append(pkgInfo, found...)
+	}
 
-	line[3] = strings.Join(archList, ", ")
+	return pkgInfo, missing
 }
 
-func groupByComponent(lines [][]string) [][]string {
-	linesBySeries := make(map[string][]string)
-	for _, line := range lines {
-		key := line[2]
-		version := line[1]
-		if newLine, ok := linesBySeries[key]; ok && newLine[1] == version {
-			newLine[3] += ", " + line[3]
+// packageArgs returns the packages given on the command line, "-" is
+// replaced by the names read from stdin (separated by spaces or new
+// lines, lines starting with # are ignored). Duplicates are removed.
+func packageArgs(args []string, stdin io.Reader) ([]string, error) {
+	pkgs := make([]string, 0, len(args))
+	seen := make(map[string]bool)
+	add := func(pkg string) {
+		if !seen[pkg] {
+			seen[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	for _, arg := range args {
+		if arg != "-" {
+			add(arg)
 			continue
 		}
-		linesBySeries[key] = line
+
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			for _, pkg := range strings.Fields(line) {
+				add(pkg)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read the packages from stdin: %w", err)
+		}
 	}
 
-	out := make([][]string, len(linesBySeries))
-	i := 0
-	for _, line := range linesBySeries {
-		sortArch(line)
-		out[i] = line
+	return pkgs, nil
+}
 
-		i++
+// exitMissing reports the packages not found and exits with exitNotFound,
+// it returns if all the packages were found
+func exitMissing(missing []string) {
+	if len(missing) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "not found: %v\n", strings.Join(missing, ", "))
+	os.Exit(exitNotFound)
+}
+
+// filterArchives keeps the packages found in one of the archives, all of
+// them if archives is empty
+func filterArchives(pkgs []debianpkg.PackageInfo, archives []string) []debianpkg.PackageInfo {
+	if len(archives) == 0 {
+		return pkgs
+	}
+
+	filtered := make([]debianpkg.PackageInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if contains(pkg.Archive, archives) {
+			filtered = append(filtered, pkg)
+		}
 	}
 
-	return out
+	return filtered
+}
+
+// serverLookup returns a function looking up the packages on the servers
+func serverLookup(client *resty.Client, servers []string, pkgs []string, query map[string]string) func() (map[string][]debianpkg.PackageInfo, error) {
+	return func() (map[string][]debianpkg.PackageInfo, error) {
+		// a single package keeps using the lookup endpoint, for the
+		// servers without /api/batch
+		if len(pkgs) == 1 {
+			pkgInfo, err := queryPackage(client, servers, pkgs[0], query)
+			if err != nil {
+				return nil, err
+			}
+			return map[string][]debianpkg.PackageInfo{pkgs[0]: pkgInfo}, nil
+		}
+		return queryPackages(client, servers, pkgs, query)
+	}
+}
+
+// printSources prints the sources.list entries needed to install pkg
+func printSources(client *resty.Client, servers []string, pkg, format string) error {
+	resp, err := get(client, servers, "sources/"+pkg, map[string]string{"format": format}, nil)
+	if err != nil {
+		return fmt.Errorf("%v: %w", pkg, err)
+	}
+
+	fmt.Print(resp.String())
+	return nil
 }
 
 func main() {
 	client := resty.New()
 
+	// the config gives the defaults of the options
+	conf, err := readClientConfig()
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+	if conf.Format == "" {
+		conf.Format = formatTable
+	}
+
+	flagServers := flag.String("server", "", "comma separated list of server URLs to query")
+	// -u is the option of devscripts' rmadison
+	flag.StringVar(flagServers, "u", "", "alias of -server")
+	sourcesFormat := flag.String("sources", "", "print the APT sources (list or deb822) to install the package")
+	// the filters of devscripts' rmadison, the values are comma separated
+	arch := flag.String("a", conf.Architecture, "only show the versions for these architectures")
+	flag.StringVar(arch, "architecture", conf.Architecture, "alias of -a")
+	suite := flag.String("s", conf.Suite, "only show the versions in these suites")
+	flag.StringVar(suite, "suite", conf.Suite, "alias of -s")
+	component := flag.String("c", conf.Component, "only show the versions in these components")
+	flag.StringVar(component, "component", conf.Component, "alias of -c")
+	archiveNames := flag.String("archive", conf.Archive, "only show the versions in these archives")
+	sourceAndBinary := flag.Bool("S", false, "show the binaries built from the source package PACKAGE too")
+	flag.BoolVar(sourceAndBinary, "source-and-binary", false, "alias of -S")
+	format := flag.String("format", conf.Format, "output format: table, json or csv")
+	jsonOutput := flag.Bool("json", false, "alias of -format json")
+	noColor := flag.Bool("no-color", false, "don't color the tables, even on a terminal (or set NO_COLOR)")
+	urlList := flag.Bool("url-list", false, "print the download URLs of the .deb files instead of the versions")
+	watchMode := flag.Bool("watch", false, "keep looking up the packages and print the versions that change")
+	interval := flag.Duration("interval", defaultWatchInterval, "time between two lookups of -watch")
+	until := flag.String("until", "", "stop -watch when this version (or a higher one) is published")
+	direct := flag.Bool("direct", false, "download the indexes of the archives instead of querying a server")
+	offline := flag.Bool("offline", false, "like -direct, but only use the indexes already downloaded")
+	maxAge := flag.Duration("max-age", defaultMaxAge, "refresh the indexes of -direct older than this")
+	snapshotPaths := flag.String("snapshot", conf.Snapshot, "query these snapshots of the archives (comma separated [ARCHIVE=]PATH) instead of a server")
+	compare := flag.String("compare", "", "compare the highest versions of two archives or servers (LEFT:RIGHT, e.g. ubuntu:debian)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [options] PACKAGE...\n       %v [options] pull-source [-download] SOURCE SUITE\n       %v [options] check-copy -from SUITE -to SUITE PACKAGE...\n       %v [options] verify [-suite SUITES] FILE.changes|FILE.deb...\n       %v completion bash|zsh|fish\n\nShow the versions of the packages in each suite of the archives, - reads\nthe packages from stdin.\n\nOptions:\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	pkg := flag.Arg(0)
+	args := flag.Args()
+	var pullArgs *pullSourceArgs
+	var copyArgs *checkCopyArgs
+	var uploads []*upload
+	switch flag.Arg(0) {
+	case checkCopyCommand:
+		copyArgs, err = parseCheckCopyArgs(flag.Args()[1:], flag.CommandLine.Output())
+		if err != nil {
+			os.Exit(exitUsage)
+		}
+		// the binaries built from the sources are copied with them
+		args = copyArgs.Packages
+		*suite = copyArgs.From + "," + copyArgs.To
+		*sourceAndBinary = true
+	case verifyCommand:
+		verify, err := parseVerifyArgs(flag.Args()[1:], flag.CommandLine.Output())
+		if err != nil {
+			os.Exit(exitUsage)
+		}
+		args = []string{}
+		for _, file := range verify.Files {
+			u, err := parseUpload(file, verify.Suites)
+			if err != nil {
+				fatal(exitUsage, err)
+			}
+			uploads = append(uploads, u)
+			if !contains(u.Name, args) {
+				args = append(args, u.Name)
+			}
+		}
+		// the suites are filtered locally, a series targets all its pockets
+		*sourceAndBinary = true
+	case pullSourceCommand:
+		pullArgs, err = parsePullSourceArgs(flag.Args()[1:], flag.CommandLine.Output())
+		if err != nil {
+			os.Exit(exitUsage)
+		}
+		args = []string{pullArgs.Source}
+	case "completion":
+		err := writeCompletion(os.Stdout, flag.Arg(1), flag.CommandLine)
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			os.Exit(exitUsage)
+		}
+		return
+	case suitesCommand:
+		err := printSuites(os.Stdout, client, serverList(*flagServers, conf))
+		if err != nil {
+			fatal(exitError, err)
+		}
+		return
+	}
+
+	pkgs, err := packageArgs(args, os.Stdin)
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+	if len(pkgs) == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *jsonOutput {
+		*format = formatJSON
+	}
+	if *format != formatTable && *format != formatJSON && *format != formatCSV {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown format %q (table, json or csv)\n", *format)
+		os.Exit(exitUsage)
+	}
 
-	baseURL := "https://packages.gauthier.uk"
+	if *watchMode && (*sourcesFormat != "" || *urlList) {
+		fatal(exitUsage, "-watch can't be used with -sources or -url-list")
+	}
+	if *watchMode && *interval <= 0 {
+		fatal(exitUsage, "-interval must be positive")
+	}
+	if (pullArgs != nil || copyArgs != nil || uploads != nil) && (*watchMode || *compare != "" || *sourcesFormat != "" || *urlList) {
+		fatal(exitUsage, "the subcommands can't be used with -watch, -compare, -sources or -url-list")
+	}
 
-	queryURL := fmt.Sprintf("%v/%v", baseURL, pkg)
+	color := useColor(*noColor)
+	archiveFilter := splitList(*archiveNames)
+	var sides [2]compareSide
+	if *compare != "" {
+		if *watchMode || *sourcesFormat != "" || *urlList {
+			fatal(exitUsage, "-compare can't be used with -watch, -sources or -url-list")
+		}
+		sides, err = parseCompare(*compare)
+		if err != nil {
+			fatal(exitUsage, err)
+		}
+		// only the archives compared are looked up
+		archiveFilter = nil
+		for _, side := range sides {
+			if side.Archive != "" {
+				archiveFilter = append(archiveFilter, side.Archive)
+			}
+		}
+	}
 
-	var pkgInfo []debianpkg.PackageInfo
-	resp, err := client.R().
-		SetResult(&pkgInfo).
-		Get(queryURL)
+	// the filters of the lookups on the servers
+	serverQuery := make(map[string]string)
+	for param, value := range map[string]string{"arch": *arch, "suite": *suite, "component": *component} {
+		if value != "" {
+			serverQuery[param] = value
+		}
+	}
+	if *sourceAndBinary {
+		serverQuery["source_and_binary"] = "true"
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	// the filters of the lookups without a server
+	query := directQuery{
+		Architectures:   splitList(*arch),
+		Suites:          splitList(*suite),
+		Components:      splitList(*component),
+		SourceAndBinary: *sourceAndBinary,
+	}
+
+	// lookup returns the versions of each package
+	var lookup func() (map[string][]debianpkg.PackageInfo, error)
+	switch {
+	case *direct || *offline:
+		if *sourcesFormat != "" {
+			fatal(exitUsage, "-sources needs a server")
+		}
+		if pullArgs != nil {
+			fatal(exitUsage, "pull-source needs a server")
+		}
+
+		archives := conf.Archives
+		if len(archives) == 0 {
+			archives = defaultDirectArchives
+		}
+		if len(archiveFilter) != 0 {
+			selected := make([]directArchive, 0, len(archiveFilter))
+			for _, directConf := range archives {
+				if contains(directConf.Name, archiveFilter) {
+					selected = append(selected, directConf)
+				}
+			}
+			archives = selected
+		}
+		if *watchMode && *interval < *maxAge {
+			// the indexes are downloaded again at every lookup
+			*maxAge = *interval
+		}
+		lookup = func() (map[string][]debianpkg.PackageInfo, error) {
+			return queryDirect(client, archives, pkgs, query, *maxAge, *offline)
+		}
+	case *snapshotPaths != "":
+		if *sourcesFormat != "" {
+			fatal(exitUsage, "-sources needs a server")
+		}
+		if pullArgs != nil {
+			fatal(exitUsage, "pull-source needs a server")
+		}
+
+		snapshots, err := parseSnapshots(*snapshotPaths)
+		if err != nil {
+			fatal(exitUsage, err)
+		}
+		if len(archiveFilter) != 0 {
+			selected := make([]snapshotFile, 0, len(archiveFilter))
+			for _, snapshot := range snapshots {
+				if contains(snapshot.Archive, archiveFilter) {
+					selected = append(selected, snapshot)
+				}
+			}
+			snapshots = selected
+		}
+		lookup = func() (map[string][]debianpkg.PackageInfo, error) {
+			return querySnapshots(snapshots, pkgs, query)
+		}
+	default:
+		servers := rankServers(serverList(*flagServers, conf))
+
+		if *sourcesFormat != "" {
+			missing := make([]string, 0)
+			for _, pkg := range pkgs {
+				err := printSources(client, servers, pkg, *sourcesFormat)
+				if errors.Is(err, errNotFound) {
+					missing = append(missing, pkg)
+					continue
+				}
+				if err != nil {
+					fatal(exitError, err)
+				}
+			}
+			exitMissing(missing)
+			return
+		}
+
+		if pullArgs != nil {
+			err := pullSource(client, servers, os.Stdout, pullArgs, archiveFilter)
+			if errors.Is(err, errNotFound) {
+				exitMissing(pkgs)
+			}
+			if err != nil {
+				fatal(exitError, err)
+			}
+			return
+		}
+
+		lookup = serverLookup(client, servers, pkgs, serverQuery)
 	}
-	if resp.IsError() {
-		log.Fatal(resp.Status())
+
+	if copyArgs != nil {
+		results, err := lookup()
+		if err != nil {
+			fatal(exitError, err)
+		}
+		checks := make([]copyCheck, len(pkgs))
+		for i, pkg := range pkgs {
+			checks[i] = checkCopy(pkg, copyArgs.From, copyArgs.To, filterArchives(results[pkg], archiveFilter))
+		}
+		ok, err := writeCopyChecks(os.Stdout, *format, copyArgs, checks)
+		if err != nil {
+			fatal(exitError, err)
+		}
+		if !ok {
+			os.Exit(exitNotFound)
+		}
+		return
+	}
+
+	if uploads != nil {
+		results, err := lookup()
+		if err != nil {
+			fatal(exitError, err)
+		}
+		verifyResults := make([]verifyResult, len(uploads))
+		for i, u := range uploads {
+			verifyResults[i] = verifyUpload(u, filterArchives(results[u.Name], archiveFilter))
+		}
+		ok, err := writeVerifyResults(os.Stdout, *format, verifyResults)
+		if err != nil {
+			fatal(exitError, err)
+		}
+		if !ok {
+			os.Exit(exitNotFound)
+		}
+		return
 	}
 
-	widths := make([]int, 4)
-	lines := make([][]string, 0)
-	for _, info := range pkgInfo {
-		formatedComponent := ""
-		if info.Component != "main" {
-			formatedComponent = "/" + info.Component
+	if *compare != "" {
+		sidePkgs, missing, err := lookupSides(pkgs, sides, lookup, func(server string) (map[string][]debianpkg.PackageInfo, error) {
+			return serverLookup(client, []string{server}, pkgs, serverQuery)()
+		})
+		if err != nil {
+			fatal(exitError, err)
 		}
-		line := []string{info.Name, info.Version, info.Suite + info.Pocket + formatedComponent, info.Architecture}
-		for i, word := range line {
-			if len(word) > widths[i] {
-				widths[i] = len(word)
+		err = writeComparison(os.Stdout, *format, color, sides, compareSides(sidePkgs[0], sidePkgs[1]))
+		if err != nil {
+			fatal(exitError, err)
+		}
+		exitMissing(missing)
+		return
+	}
+
+	if *watchMode {
+		err := watch(os.Stdout, pkgs, func() ([]debianpkg.PackageInfo, error) {
+			results, err := lookup()
+			if err != nil {
+				return nil, err
 			}
+			pkgInfo, _ := collectResults(pkgs, results, archiveFilter)
+			return pkgInfo, nil
+		}, watchOptions{Format: *format, Color: color, Interval: *interval, Until: *until})
+		if err != nil {
+			fatal(exitError, err)
 		}
-		lines = append(lines, line)
+		return
 	}
 
-	lines = groupByComponent(lines)
-	sort.Slice(lines, func(i, j int) bool {
-		return lines[i][2] < lines[j][2]
-	})
+	results, err := lookup()
+	if err != nil {
+		fatal(exitError, err)
+	}
+	pkgInfo, missing := collectResults(pkgs, results, archiveFilter)
+	if *urlList {
+		for _, pkg := range writeURLList(os.Stdout, pkgInfo) {
+			fmt.Fprintf(os.Stderr, "no download URL for %v %v (%v, %v)\n", pkg.Name, pkg.Version, pkg.Suite+pkg.Pocket, pkg.Architecture)
+		}
+		exitMissing(missing)
+		return
+	}
 
-	lineFormat := fmt.Sprintf(" %%-%vv | %%-%vv | %%-%vv | %%-%vv\n", widths[0], widths[1], widths[2], widths[3])
-	for _, line := range lines {
-		fmt.Printf(lineFormat, line[0], line[1], line[2], line[3])
+	err = writeOutput(os.Stdout, *format, color, pkgInfo)
+	if err != nil {
+		fatal(exitError, err)
 	}
+	exitMissing(missing)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPackageArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		expected []string
+	}{
+		{name: "arguments", args: []string{"bash", "curl"}, expected: []string{"bash", "curl"}},
+		{name: "duplicates", args: []string{"bash", "curl", "bash"}, expected: []string{"bash", "curl"}},
+		{name: "no arguments", args: []string{}, expected: []string{}},
+		{
+			name:     "stdin",
+			args:     []string{"-"},
+			stdin:    "# the base packages\nbash coreutils\n\n  curl\t wget\n #indented comment\n",
+			expected: []string{"bash", "coreutils", "curl", "wget"},
+		},
+		{
+			// stdin is read where "-" is, without the duplicates
+			name:     "arguments and stdin",
+			args:     []string{"openssl", "-", "zlib1g", "curl"},
+			stdin:    "curl\nopenssl\nlibssl3t64",
+			expected: []string{"openssl", "curl", "libssl3t64", "zlib1g"},
+		},
+		{name: "empty stdin", args: []string{"-"}, stdin: "", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		pkgs, err := packageArgs(test.args, strings.NewReader(test.stdin))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(pkgs) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, pkgs)
+		}
+	}
+
+	// stdin isn't read without "-"
+	_, err := packageArgs([]string{"bash"}, iotest.ErrReader(errors.New("closed")))
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = packageArgs([]string{"-"}, iotest.ErrReader(errors.New("closed")))
+	if err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("expected the error of stdin, got %v", err)
+	}
+}
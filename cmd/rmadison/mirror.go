@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultServerURL = "https://packages.gauthier.uk"
+
+// probeTimeout is how long we wait for a server to answer the probe
+// before considering it unhealthy
+const probeTimeout = 3 * time.Second
+
+// clientConfig is the configuration of the rmadison client
+type clientConfig struct {
+	Servers []string `yaml:"servers"`
+	// Archives are queried by the direct mode instead of
+	// defaultDirectArchives
+	Archives []directArchive `yaml:"archives"`
+	// Snapshot are the snapshots queried instead of the servers, see
+	// parseSnapshots
+	Snapshot string `yaml:"snapshot"`
+
+	// the defaults of the filters and of the output format, the command
+	// line options override them
+	Archive      string `yaml:"archive"`
+	Suite        string `yaml:"suite"`
+	Architecture string `yaml:"architecture"`
+	Component    string `yaml:"component"`
+	Format       string `yaml:"format"`
+}
+
+// applyEnv overrides the config with the environment variables
+// RMADISON_SERVER (comma separated), RMADISON_ARCHIVE, RMADISON_SUITE,
+// RMADISON_ARCHITECTURE, RMADISON_COMPONENT, RMADISON_FORMAT and
+// RMADISON_SNAPSHOT
+func (conf *clientConfig) applyEnv() {
+	if servers := os.Getenv("RMADISON_SERVER"); servers != "" {
+		conf.Servers = strings.Split(servers, ",")
+	}
+
+	for name, value := range map[string]*string{
+		"RMADISON_ARCHIVE":      &conf.Archive,
+		"RMADISON_SUITE":        &conf.Suite,
+		"RMADISON_ARCHITECTURE": &conf.Architecture,
+		"RMADISON_COMPONENT":    &conf.Component,
+		"RMADISON_FORMAT":       &conf.Format,
+		"RMADISON_SNAPSHOT":     &conf.Snapshot,
+	} {
+		if env := os.Getenv(name); env != "" {
+			*value = env
+		}
+	}
+}
+
+func clientConfigPath() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(userConfigDir, "rmadison", "client.yaml"), nil
+}
+
+// readClientConfig reads the client config file if it exists, and the
+// environment variables. A missing config file is not an error.
+func readClientConfig() (*clientConfig, error) {
+	conf := new(clientConfig)
+	defer conf.applyEnv()
+
+	configPath, err := clientConfigPath()
+	if err != nil {
+		return conf, nil
+	}
+
+	configBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conf, nil
+		}
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(configBytes, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", configPath, err)
+	}
+
+	return conf, nil
+}
+
+// serverList merges the servers given on the command line (comma separated)
+// with the ones from the config file. Command line servers come first.
+func serverList(flagServers string, conf *clientConfig) []string {
+	servers := make([]string, 0)
+	for _, server := range strings.Split(flagServers, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			servers = append(servers, strings.TrimRight(server, "/"))
+		}
+	}
+
+	for _, server := range conf.Servers {
+		server = strings.TrimRight(strings.TrimSpace(server), "/")
+		if server != "" && !contains(server, servers) {
+			servers = append(servers, server)
+		}
+	}
+
+	if len(servers) == 0 {
+		servers = append(servers, defaultServerURL)
+	}
+
+	return servers
+}
+
+type probeResult struct {
+	server  string
+	latency time.Duration
+	healthy bool
+}
+
+// rankServers probes all the servers concurrently and returns them ordered
+// by latency, healthy servers first. Unhealthy servers are kept at the end
+// of the list so they can still be used as a last resort.
+func rankServers(servers []string) []string {
+	if len(servers) == 1 {
+		return servers
+	}
+
+	client := resty.New().SetTimeout(probeTimeout)
+
+	results := make([]probeResult, len(servers))
+	wg := new(sync.WaitGroup)
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			results[i] = probe(client, server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].healthy != results[j].healthy {
+			return results[i].healthy
+		}
+		return results[i].healthy && results[i].latency < results[j].latency
+	})
+
+	ranked := make([]string, len(results))
+	for i, result := range results {
+		ranked[i] = result.server
+	}
+
+	return ranked
+}
+
+// probe checks that the server answers to an empty lookup
+func probe(client *resty.Client, server string) probeResult {
+	now := time.Now()
+	resp, err := client.R().Get(server + "/")
+	latency := time.Now().Sub(now)
+
+	return probeResult{
+		server:  server,
+		latency: latency,
+		healthy: err == nil && !resp.IsError(),
+	}
+}
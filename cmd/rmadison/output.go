@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// output formats of the CLI
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// record is a package in the machine-readable outputs, the field names are
+// stable
+type record struct {
+	Archive      string `json:"archive"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Suite        string `json:"suite"`
+	Component    string `json:"component"`
+	Architecture string `json:"architecture"`
+	// Size is the size of the .deb in bytes, InstalledSize is the
+	// Installed-Size field in kB
+	Size          int `json:"size"`
+	InstalledSize int `json:"installed_size"`
+}
+
+// recordHeader is the header of the CSV output, in the order of the fields
+// of record
+var recordHeader = []string{"archive", "name", "version", "suite", "component", "architecture", "size", "installed_size"}
+
+// newRecords returns a record for each package, sorted by name, suite,
+// architecture and version
+func newRecords(pkgs []debianpkg.PackageInfo) []record {
+	records := make([]record, len(pkgs))
+	for i, pkg := range pkgs {
+		records[i] = record{
+			Archive:      pkg.Archive,
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Suite:        pkg.Suite + pkg.Pocket,
+			Component:    pkg.Component,
+			Architecture: pkg.Architecture,
+
+			Size:          pkg.Size,
+			InstalledSize: pkg.InstalledSize,
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Suite != b.Suite {
+			return a.Suite < b.Suite
+		}
+		if a.Architecture != b.Architecture {
+			return a.Architecture < b.Architecture
+		}
+		return version.Compare(a.Version, b.Version) < 0
+	})
+
+	return records
+}
+
+// writeURLList writes the download URLs of the packages, once each. It
+// returns the packages without URL (their Filename is redacted or the
+// server is too old).
+func writeURLList(w io.Writer, pkgs []debianpkg.PackageInfo) []debianpkg.PackageInfo {
+	seen := make(map[string]bool)
+	missing := make([]debianpkg.PackageInfo, 0)
+	for _, pkg := range pkgs {
+		if pkg.URL == "" {
+			missing = append(missing, pkg)
+			continue
+		}
+		if !seen[pkg.URL] {
+			seen[pkg.URL] = true
+			fmt.Fprintln(w, pkg.URL)
+		}
+	}
+
+	return missing
+}
+
+// writeOutput writes the packages in the table of rmadison (colored with
+// color) or in one of the machine-readable formats
+func writeOutput(w io.Writer, format string, color bool, pkgs []debianpkg.PackageInfo) error {
+	switch format {
+	case formatTable:
+		writeMadisonTable(w, madisonRows(pkgs), color)
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newRecords(pkgs))
+	case formatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(recordHeader)
+		for _, r := range newRecords(pkgs) {
+			writer.Write([]string{r.Archive, r.Name, r.Version, r.Suite, r.Component, r.Architecture, strconv.Itoa(r.Size), strconv.Itoa(r.InstalledSize)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown format %q (table, json or csv)", format)
+	}
+
+	return nil
+}
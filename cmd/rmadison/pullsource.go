@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/version"
+	"github.com/go-resty/resty/v2"
+)
+
+// pullSourceCommand prints (or downloads) the files of a source package,
+// like pull-lp-source or dget
+const pullSourceCommand = "pull-source"
+
+// pullSourceArgs are the arguments of pull-source
+type pullSourceArgs struct {
+	Source   string
+	Suite    string
+	Download bool
+}
+
+// parsePullSourceArgs parses [-download] SOURCE SUITE
+func parsePullSourceArgs(args []string, output io.Writer) (*pullSourceArgs, error) {
+	flags := flag.NewFlagSet(pullSourceCommand, flag.ContinueOnError)
+	flags.SetOutput(output)
+	download := flags.Bool("download", false, "download the files in the current directory instead of printing their URLs")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %v [options] %v [-download] SOURCE SUITE\n\nPrint the URLs of the .dsc and of the files of a source package.\n\n", os.Args[0], pullSourceCommand)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return nil, fmt.Errorf("expected SOURCE SUITE, got %q", flags.Args())
+	}
+
+	return &pullSourceArgs{Source: flags.Arg(0), Suite: flags.Arg(1), Download: *download}, nil
+}
+
+// sourceFile is a file of a source package
+type sourceFile struct {
+	Name   string
+	URL    string
+	SHA256 string
+}
+
+// sourcePackage is the part of the responses of /pkg/<name>/source used
+// by pull-source: a source package of the Sources indexes of an archive
+type sourcePackage struct {
+	Archive string `json:"archive"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Suite   string `json:"suite"`
+	Pocket  string `json:"pocket"`
+	Files   []struct {
+		Name   string `json:"name"`
+		SHA256 string `json:"sha256"`
+	} `json:"files"`
+	// DSC is the URL of the .dsc
+	DSC string `json:"dsc"`
+}
+
+// findDSC returns the .dsc of the highest version of a source package in
+// a suite, with its checksum from the Sources index. archives restricts
+// the archives searched when it's not empty.
+func findDSC(source, suite string, sources []sourcePackage, archives []string) (*sourceFile, string, error) {
+	var found *sourcePackage
+	for i, src := range sources {
+		if src.Name != source || src.Suite+src.Pocket != suite || (len(archives) != 0 && !contains(src.Archive, archives)) {
+			continue
+		}
+		if found == nil || version.Compare(src.Version, found.Version) > 0 {
+			found = &sources[i]
+		}
+	}
+	if found == nil {
+		return nil, "", errNotFound
+	}
+	if found.DSC == "" {
+		return nil, "", fmt.Errorf("no .dsc URL for %v %v (redacted by the server)", source, found.Version)
+	}
+
+	dsc := &sourceFile{Name: path.Base(found.DSC), URL: found.DSC}
+	for _, file := range found.Files {
+		if file.Name == dsc.Name {
+			dsc.SHA256 = file.SHA256
+		}
+	}
+	if dsc.SHA256 == "" {
+		return nil, "", fmt.Errorf("no checksum for %v (redacted by the server)", dsc.Name)
+	}
+
+	return dsc, found.Version, nil
+}
+
+// parseDSC returns the files listed in the Checksums-Sha256 field of a
+// .dsc, they are in the same directory as the .dsc
+func parseDSC(r io.Reader, dscURL string) ([]*sourceFile, error) {
+	baseURL := dscURL[:strings.LastIndex(dscURL, "/")+1]
+
+	files := make([]*sourceFile, 0)
+	inChecksums := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inChecksums = strings.HasPrefix(line, "Checksums-Sha256:")
+			continue
+		}
+		if !inChecksums {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid Checksums-Sha256 line %q", line)
+		}
+		// the names are only file names, never paths
+		name := path.Base(fields[2])
+		files = append(files, &sourceFile{Name: name, URL: baseURL + name, SHA256: fields[0]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Checksums-Sha256 in %v", dscURL)
+	}
+
+	return files, nil
+}
+
+// checkSHA256 returns an error if the content doesn't match the checksum
+// of the file
+func checkSHA256(file *sourceFile, content []byte) error {
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != file.SHA256 {
+		return fmt.Errorf("%v: sha256 %x, expected %v", file.Name, sum, file.SHA256)
+	}
+
+	return nil
+}
+
+// downloadSourceFile downloads a file in the current directory and checks
+// its checksum
+func downloadSourceFile(client *resty.Client, file *sourceFile) error {
+	resp, err := client.R().SetOutput(file.Name).Get(file.URL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		os.Remove(file.Name)
+		return fmt.Errorf("%v: %v", file.URL, resp.Status())
+	}
+
+	f, err := os.Open(file.Name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 {
+		return fmt.Errorf("%v: sha256 %v, expected %v", file.Name, sum, file.SHA256)
+	}
+
+	return nil
+}
+
+// pullSource prints the URLs of the .dsc of a source package and of the
+// files it lists, or downloads them. The .dsc is found in the Sources
+// indexes of the servers and checked against their checksum before its
+// files are read.
+func pullSource(client *resty.Client, servers []string, w io.Writer, args *pullSourceArgs, archives []string) error {
+	var sources []sourcePackage
+	_, err := get(client, servers, "pkg/"+args.Source+"/source", map[string]string{"suite": args.Suite}, &sources)
+	if err != nil {
+		return err
+	}
+	dsc, sourceVersion, err := findDSC(args.Source, args.Suite, sources, archives)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.R().Get(dsc.URL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%v %v: %v: %v", args.Source, sourceVersion, dsc.URL, resp.Status())
+	}
+	err = checkSHA256(dsc, resp.Body())
+	if err != nil {
+		return err
+	}
+	files, err := parseDSC(strings.NewReader(resp.String()), dsc.URL)
+	if err != nil {
+		return err
+	}
+
+	if !args.Download {
+		for _, file := range append([]*sourceFile{dsc}, files...) {
+			fmt.Fprintln(w, file.URL)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "downloading %v\n", dsc.URL)
+	err = os.WriteFile(dsc.Name, resp.Body(), 0o644)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Fprintf(os.Stderr, "downloading %v\n", file.URL)
+		err := downloadSourceFile(client, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const testDSC = `Format: 3.0 (quilt)
+Source: hello
+Version: 2.10-3
+Checksums-Sha256:
+ 5a3e325e5e4b75bb8d3e77a3419f5e4b2ac5d6f1346cd6b5e5c0e8a1c5f2c9e3 725946 hello_2.10.orig.tar.gz
+ 9b1e8e3c3d5a0d5e3f2c1b0a9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d 12688 hello_2.10-3.debian.tar.xz
+`
+
+func TestPullSource(t *testing.T) {
+	sum := sha256.Sum256([]byte(testDSC))
+	dscSHA256 := hex.EncodeToString(sum[:])
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pkg/hello/source":
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("suite") != "noble" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			pool := server.URL + "/ubuntu/pool/main/h/hello/"
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"archive": "ubuntu", "name": "hello", "version": "2.10-2", "suite": "noble", "dsc": pool + "hello_2.10-2.dsc",
+					"files": []map[string]string{{"name": "hello_2.10-2.dsc", "sha256": dscSHA256}}},
+				{"archive": "ubuntu", "name": "hello", "version": "2.10-3", "suite": "noble", "dsc": pool + "hello_2.10-3.dsc",
+					"files": []map[string]string{{"name": "hello_2.10-3.dsc", "sha256": dscSHA256}}},
+				// a copy with a .dsc that doesn't match its checksum
+				{"archive": "mirror", "name": "hello", "version": "2.10-3", "suite": "noble", "dsc": pool + "tampered/hello_2.10-3.dsc",
+					"files": []map[string]string{{"name": "hello_2.10-3.dsc", "sha256": dscSHA256}}},
+			})
+		case "/ubuntu/pool/main/h/hello/hello_2.10-3.dsc":
+			w.Write([]byte(testDSC))
+		case "/ubuntu/pool/main/h/hello/tampered/hello_2.10-3.dsc":
+			w.Write([]byte(strings.Replace(testDSC, "5a3e", "0000", 1)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "not_found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := resty.New()
+	servers := []string{server.URL}
+	pool := server.URL + "/ubuntu/pool/main/h/hello/"
+
+	out := new(bytes.Buffer)
+	err := pullSource(client, servers, out, &pullSourceArgs{Source: "hello", Suite: "noble"}, []string{"ubuntu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := pool + "hello_2.10-3.dsc\n" + pool + "hello_2.10.orig.tar.gz\n" + pool + "hello_2.10-3.debian.tar.xz\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, out.String())
+	}
+
+	err = pullSource(client, servers, out, &pullSourceArgs{Source: "hello", Suite: "noble"}, []string{"mirror"})
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	err = pullSource(client, servers, out, &pullSourceArgs{Source: "hello", Suite: "jammy"}, nil)
+	if err != errNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestFindDSCRedacted(t *testing.T) {
+	sources := []sourcePackage{{Archive: "private", Name: "hello", Version: "1.0", Suite: "noble", DSC: "http://example.com/hello_1.0.dsc"}}
+	_, _, err := findDSC("hello", "noble", sources, nil)
+	if err == nil || !strings.Contains(err.Error(), "redacted") {
+		t.Errorf("expected an error without the checksum, got %v", err)
+	}
+
+	sources[0].DSC = ""
+	_, _, err = findDSC("hello", "noble", sources, nil)
+	if err == nil || !strings.Contains(err.Error(), "redacted") {
+		t.Errorf("expected an error without the URL, got %v", err)
+	}
+}
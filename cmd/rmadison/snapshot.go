@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/resolver"
+)
+
+// snapshotStore is a snapshot seen as a packageStore
+type snapshotStore struct {
+	*resolver.Resolver
+}
+
+func (s snapshotStore) GetPackage(name string) ([]*debianpkg.PackageInfo, error) {
+	return s.Lookup(name)
+}
+
+func (s snapshotStore) GetPackagesBySource(source string) ([]*debianpkg.PackageInfo, error) {
+	return s.BuiltFrom(source)
+}
+
+// snapshotFile is a snapshot of the database of an archive, downloaded
+// from /api/snapshot
+type snapshotFile struct {
+	Archive string
+	Path    string
+}
+
+// parseSnapshots parses a comma separated list of snapshots, given as
+// ARCHIVE=PATH or PATH (the archive is then the name of the file without
+// its extension, ubuntu for ubuntu.db)
+func parseSnapshots(value string) ([]snapshotFile, error) {
+	snapshots := make([]snapshotFile, 0)
+	for _, elmt := range splitList(value) {
+		name, filePath, ok := strings.Cut(elmt, "=")
+		if !ok {
+			filePath = elmt
+			name = strings.TrimSuffix(path.Base(filePath), path.Ext(filePath))
+		}
+		if name == "" || filePath == "" {
+			return nil, fmt.Errorf("invalid snapshot %q (ARCHIVE=PATH or PATH)", elmt)
+		}
+		snapshots = append(snapshots, snapshotFile{name, filePath})
+	}
+
+	return snapshots, nil
+}
+
+// querySnapshots looks up the packages in the snapshots, without any
+// network access. The versions are returned by package.
+func querySnapshots(snapshots []snapshotFile, pkgs []string, query directQuery) (map[string][]debianpkg.PackageInfo, error) {
+	results := make(map[string][]debianpkg.PackageInfo, len(pkgs))
+	for _, snapshot := range snapshots {
+		// opened for each lookup, the file can be replaced by a newer
+		// snapshot between two lookups of -watch
+		r, err := resolver.New(snapshot.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		for _, pkg := range pkgs {
+			found, err := lookupDirect(snapshotStore{r}, pkg, query.SourceAndBinary)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", snapshot.Path, err)
+			}
+
+			for _, info := range found {
+				if query.matches(info) {
+					info.Archive = snapshot.Archive
+					results[pkg] = append(results[pkg], *info)
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// pocketOrder is the order of the pockets of a series in the output of
+// rmadison, the other pockets come after them
+var pocketOrder = map[string]int{
+	"":           0,
+	"-security":  1,
+	"-updates":   2,
+	"-proposed":  3,
+	"-backports": 4,
+}
+
+// madisonRow is a line of the rmadison table: the architectures where a
+// version of a package is published in a suite and a component
+type madisonRow struct {
+	name      string
+	version   string
+	series    string
+	pocket    string
+	component string
+	archs     []string
+}
+
+// suite is the suite column, with the component when it's not main
+// ("noble-updates/universe")
+func (row *madisonRow) suite() string {
+	if row.component == "main" || row.component == "" {
+		return row.series + row.pocket
+	}
+
+	return row.series + row.pocket + "/" + row.component
+}
+
+// madisonRows groups the architectures of each version in rows, sorted as
+// rmadison does: by package, series, pocket, component and version
+func madisonRows(pkgs []debianpkg.PackageInfo) []*madisonRow {
+	byKey := make(map[string]*madisonRow)
+	for _, pkg := range pkgs {
+		key := strings.Join([]string{pkg.Name, pkg.Version, pkg.Suite, pkg.Pocket, pkg.Component}, "\x00")
+		row := byKey[key]
+		if row == nil {
+			row = &madisonRow{
+				name:      pkg.Name,
+				version:   pkg.Version,
+				series:    pkg.Suite,
+				pocket:    pkg.Pocket,
+				component: pkg.Component,
+			}
+			byKey[key] = row
+		}
+		// the same architecture can come from several archives
+		if !contains(pkg.Architecture, row.archs) {
+			row.archs = append(row.archs, pkg.Architecture)
+		}
+	}
+
+	rows := make([]*madisonRow, 0, len(byKey))
+	for _, row := range byKey {
+		sortArchs(row.archs)
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return lessRow(rows[i], rows[j])
+	})
+
+	return rows
+}
+
+func lessRow(a, b *madisonRow) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	// the series with numbers (v9, v10) are sorted like versions
+	if a.series != b.series {
+		return version.Compare(a.series, b.series) < 0
+	}
+	if a.pocket != b.pocket {
+		orderA, knownA := pocketOrder[a.pocket]
+		orderB, knownB := pocketOrder[b.pocket]
+		if knownA != knownB {
+			return knownA
+		}
+		if orderA != orderB {
+			return orderA < orderB
+		}
+		return a.pocket < b.pocket
+	}
+	if a.component != b.component {
+		if a.component == "main" || b.component == "main" {
+			return a.component == "main"
+		}
+		return a.component < b.component
+	}
+
+	return version.Compare(a.version, b.version) < 0
+}
+
+// sortArchs sorts the architectures as dak does: source, all, then the
+// others in alphabetical order
+func sortArchs(archs []string) {
+	rank := func(arch string) int {
+		switch arch {
+		case "source":
+			return 0
+		case "all":
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(archs, func(i, j int) bool {
+		if rank(archs[i]) != rank(archs[j]) {
+			return rank(archs[i]) < rank(archs[j])
+		}
+		return archs[i] < archs[j]
+	})
+}
+
+// rowHighlights returns the rows with the newest version of their package,
+// and the rows superseded by a higher version in another pocket (but
+// -proposed) of the same series
+func rowHighlights(rows []*madisonRow) (map[*madisonRow]bool, map[*madisonRow]bool) {
+	newestVersions := make(map[string]string)
+	for _, row := range rows {
+		if newest, ok := newestVersions[row.name]; !ok || version.Compare(row.version, newest) > 0 {
+			newestVersions[row.name] = row.version
+		}
+	}
+
+	newest := make(map[*madisonRow]bool)
+	superseded := make(map[*madisonRow]bool)
+	for _, row := range rows {
+		newest[row] = row.version == newestVersions[row.name]
+		for _, other := range rows {
+			if other.name == row.name && other.series == row.series && other.pocket != "-proposed" &&
+				version.Compare(other.version, row.version) > 0 {
+				superseded[row] = true
+				break
+			}
+		}
+	}
+
+	return newest, superseded
+}
+
+// writeMadisonTable writes the rows in the format of rmadison: the
+// columns are aligned on the longest value, except the last one that is
+// never padded. With color, the newest versions are highlighted, the
+// superseded ones dimmed and -proposed is in yellow.
+func writeMadisonTable(w io.Writer, rows []*madisonRow, color bool) {
+	var nameWidth, versionWidth, suiteWidth int
+	for _, row := range rows {
+		nameWidth = max(nameWidth, len(row.name))
+		versionWidth = max(versionWidth, len(row.version))
+		suiteWidth = max(suiteWidth, len(row.suite()))
+	}
+
+	var newest, superseded map[*madisonRow]bool
+	if color {
+		newest, superseded = rowHighlights(rows)
+	}
+
+	for _, row := range rows {
+		var rowStyles, versionStyles, suiteStyles []string
+		if superseded[row] {
+			rowStyles = []string{styleDim}
+		}
+		versionStyles = append(versionStyles, rowStyles...)
+		if newest[row] {
+			versionStyles = append(versionStyles, styleBold, styleGreen)
+		}
+		suiteStyles = append(suiteStyles, rowStyles...)
+		if color && row.pocket == "-proposed" {
+			suiteStyles = append(suiteStyles, styleYellow)
+		}
+
+		fmt.Fprintf(w, " %s | %s | %s | %s\n",
+			paint(fmt.Sprintf("%-*s", nameWidth, row.name), rowStyles...),
+			paint(fmt.Sprintf("%-*s", versionWidth, row.version), versionStyles...),
+			paint(fmt.Sprintf("%-*s", suiteWidth, row.suite()), suiteStyles...),
+			paint(strings.Join(row.archs, ", "), rowStyles...))
+	}
+}
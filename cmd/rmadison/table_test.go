@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// binaries returns the packages of a version published for archs
+func binaries(name, version, suite, pocket, component string, archs ...string) []debianpkg.PackageInfo {
+	pkgs := make([]debianpkg.PackageInfo, len(archs))
+	for i, arch := range archs {
+		pkgs[i] = debianpkg.PackageInfo{
+			Name:         name,
+			Version:      version,
+			Suite:        suite,
+			Pocket:       pocket,
+			Component:    component,
+			Architecture: arch,
+		}
+	}
+
+	return pkgs
+}
+
+func concat(lists ...[]debianpkg.PackageInfo) []debianpkg.PackageInfo {
+	pkgs := make([]debianpkg.PackageInfo, 0)
+	for _, list := range lists {
+		pkgs = append(pkgs, list...)
+	}
+
+	return pkgs
+}
+
+// TestMadisonTable compares the table with the output of devscripts
+// rmadison (e.g. rmadison -u ubuntu hello) for the same packages
+func TestMadisonTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkgs     []debianpkg.PackageInfo
+		expected string
+	}{
+		{
+			name: "architectures collapsed",
+			pkgs: concat(
+				binaries("hello", "2.10-3build1", "noble", "", "main", "s390x", "amd64", "source", "arm64", "armhf"),
+				// the same architecture from another archive (ports)
+				binaries("hello", "2.10-3build1", "noble", "", "main", "arm64", "riscv64"),
+				binaries("hello", "2.10-2ubuntu4", "jammy", "", "main", "amd64", "source", "i386"),
+			),
+			expected: "" +
+				" hello | 2.10-2ubuntu4 | jammy | source, amd64, i386\n" +
+				" hello | 2.10-3build1  | noble | source, amd64, arm64, armhf, riscv64, s390x\n",
+		},
+		{
+			name: "arch all",
+			pkgs: concat(
+				binaries("tzdata", "2024a-2ubuntu1", "noble", "-updates", "main", "all", "source"),
+				binaries("tzdata", "2024a-1ubuntu1", "noble", "", "main", "all", "source"),
+			),
+			expected: "" +
+				" tzdata | 2024a-1ubuntu1 | noble         | source, all\n" +
+				" tzdata | 2024a-2ubuntu1 | noble-updates | source, all\n",
+		},
+		{
+			name: "component suffix",
+			pkgs: concat(
+				binaries("htop", "3.3.0-4build1", "noble", "", "main", "source", "amd64"),
+				binaries("htop", "3.3.0-4build2", "noble", "-updates", "universe", "source", "amd64"),
+				binaries("htop", "3.3.0-4build1", "noble", "", "universe", "amd64"),
+			),
+			expected: "" +
+				" htop | 3.3.0-4build1 | noble                  | source, amd64\n" +
+				" htop | 3.3.0-4build1 | noble/universe         | amd64\n" +
+				" htop | 3.3.0-4build2 | noble-updates/universe | source, amd64\n",
+		},
+		{
+			name: "pocket order",
+			pkgs: concat(
+				binaries("openssl", "3.0.13-0ubuntu3.2", "noble", "-updates", "main", "source"),
+				binaries("openssl", "3.0.13-0ubuntu3.3", "noble", "-proposed", "main", "source"),
+				binaries("openssl", "3.0.13-0ubuntu3.1", "noble", "-security", "main", "source"),
+				binaries("openssl", "3.0.13-0ubuntu3", "noble", "", "main", "source"),
+				binaries("openssl", "3.2.1-3ubuntu1", "noble", "-backports", "main", "source"),
+				binaries("openssl", "3.0.2-0ubuntu1", "jammy", "", "main", "source"),
+			),
+			expected: "" +
+				" openssl | 3.0.2-0ubuntu1    | jammy           | source\n" +
+				" openssl | 3.0.13-0ubuntu3   | noble           | source\n" +
+				" openssl | 3.0.13-0ubuntu3.1 | noble-security  | source\n" +
+				" openssl | 3.0.13-0ubuntu3.2 | noble-updates   | source\n" +
+				" openssl | 3.0.13-0ubuntu3.3 | noble-proposed  | source\n" +
+				" openssl | 3.2.1-3ubuntu1    | noble-backports | source\n",
+		},
+		{
+			// v10 < v9 in lexicographic order
+			name: "numbered series",
+			pkgs: concat(
+				binaries("agent", "10.0-1", "v10", "", "main", "amd64"),
+				binaries("agent", "9.4-1", "v9", "", "main", "amd64"),
+				binaries("agent", "9.4-2", "v9", "-updates", "main", "amd64"),
+			),
+			expected: "" +
+				" agent | 9.4-1  | v9         | amd64\n" +
+				" agent | 9.4-2  | v9-updates | amd64\n" +
+				" agent | 10.0-1 | v10        | amd64\n",
+		},
+		{
+			name: "several packages",
+			pkgs: concat(
+				binaries("libc6", "2.39-0ubuntu8", "noble", "", "main", "amd64", "i386"),
+				binaries("libc-bin", "2.39-0ubuntu8", "noble", "", "main", "amd64"),
+				binaries("libc6", "2.39-0ubuntu8.3", "noble", "-updates", "main", "amd64"),
+			),
+			expected: "" +
+				" libc-bin | 2.39-0ubuntu8   | noble         | amd64\n" +
+				" libc6    | 2.39-0ubuntu8   | noble         | amd64, i386\n" +
+				" libc6    | 2.39-0ubuntu8.3 | noble-updates | amd64\n",
+		},
+	}
+
+	for _, test := range tests {
+		out := new(bytes.Buffer)
+		writeMadisonTable(out, madisonRows(test.pkgs), false)
+		if out.String() != test.expected {
+			t.Errorf("%v: expected\n%v\ngot\n%v", test.name, test.expected, out.String())
+		}
+	}
+}
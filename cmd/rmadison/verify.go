@@ -0,0 +1,371 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// verifyCommand checks that local .changes or .deb files are not already
+// published, before an upload
+const verifyCommand = "verify"
+
+// verify statuses of an upload
+const (
+	verifyNew       = "new"
+	verifyDuplicate = "duplicate"
+	verifyOutdated  = "outdated"
+)
+
+// verifyArgs are the arguments of verify
+type verifyArgs struct {
+	// Suites are the targeted suites, they override the Distribution of
+	// the .changes files and are required for the .deb files
+	Suites []string
+	Files  []string
+}
+
+// parseVerifyArgs parses [-suite SUITES] FILE...
+func parseVerifyArgs(args []string, output io.Writer) (*verifyArgs, error) {
+	flags := flag.NewFlagSet(verifyCommand, flag.ContinueOnError)
+	flags.SetOutput(output)
+	suites := flags.String("suite", "", "targeted suites, comma separated (the Distribution of the .changes files by default)")
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %v [options] %v [-suite SUITES] FILE.changes|FILE.deb...\n\nCheck that the versions of the uploads are not already published (or\noutdated) in the targeted suites.\n\n", os.Args[0], verifyCommand)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return nil, fmt.Errorf("no files")
+	}
+
+	return &verifyArgs{Suites: splitList(*suites), Files: flags.Args()}, nil
+}
+
+// upload is a local .changes (of a source package) or .deb (of a binary
+// package)
+type upload struct {
+	File    string
+	Name    string
+	Version string
+	// Architecture is the architecture of a .deb, empty for a .changes
+	Architecture string
+	Suites       []string
+}
+
+// isSource tells if the upload is a .changes, whose version is the one
+// of the source package
+func (u *upload) isSource() bool {
+	return u.Architecture == ""
+}
+
+// parseControl returns the fields of the first paragraph of a control file
+// (a PGP signature is ignored), the continuation lines are joined with
+// newlines
+func parseControl(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var key string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN PGP SIGNED MESSAGE"):
+			// the armor headers end with an empty line
+			for scanner.Scan() && scanner.Text() != "" {
+			}
+			continue
+		case line == "" && len(fields) == 0:
+			continue
+		case line == "" || strings.HasPrefix(line, "-----BEGIN PGP SIGNATURE"):
+			return fields, nil
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+			if key == "" {
+				return nil, fmt.Errorf("continuation line without field: %q", line)
+			}
+			fields[key] += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		key = name
+		fields[key] = strings.TrimSpace(value)
+	}
+
+	return fields, scanner.Err()
+}
+
+// parseChanges reads the source package and the suites of a .changes
+func parseChanges(filePath string) (*upload, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields, err := parseControl(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filePath, err)
+	}
+	if fields["Source"] == "" || fields["Version"] == "" {
+		return nil, fmt.Errorf("%v: no Source or Version", filePath)
+	}
+
+	// the Source field can contain the version, like in the Packages files
+	source := (&debianpkg.PackageInfo{Source: fields["Source"]})
+	name, _ := source.SourceNameVersion()
+
+	return &upload{
+		File:    filePath,
+		Name:    name,
+		Version: fields["Version"],
+		Suites:  strings.Fields(fields["Distribution"]),
+	}, nil
+}
+
+// arHeaderSize is the size of the headers of the members of an ar archive
+const arHeaderSize = 60
+
+// debControl returns the control file of a .deb: an ar archive with a
+// control.tar (possibly compressed) member
+func debControl(r io.Reader) ([]byte, error) {
+	magic := make([]byte, 8)
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != "!<arch>\n" {
+		return nil, errors.New("not a .deb (ar archive)")
+	}
+
+	header := make([]byte, arHeaderSize)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, errors.New("no control.tar in the .deb")
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of %v", name)
+		}
+
+		member := io.LimitReader(r, size)
+		if strings.HasPrefix(name, "control.tar") {
+			return tarControl(member, path.Ext(name))
+		}
+
+		// the members are aligned on 2 bytes
+		_, err = io.CopyN(io.Discard, r, size+size%2)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tarControl returns the control file of a control.tar compressed as
+// given by its extension
+func tarControl(r io.Reader, ext string) ([]byte, error) {
+	var err error
+	switch ext {
+	case ".gz":
+		r, err = gzip.NewReader(r)
+	case ".xz":
+		r, err = xz.NewReader(r)
+	case ".zst":
+		var decoder *zstd.Decoder
+		decoder, err = zstd.NewReader(r)
+		if err == nil {
+			defer decoder.Close()
+			r = decoder
+		}
+	case ".tar":
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			return nil, errors.New("no control file in control.tar")
+		}
+		if path.Clean(header.Name) == "control" {
+			return io.ReadAll(archive)
+		}
+	}
+}
+
+// parseDeb reads the binary package of a .deb
+func parseDeb(filePath string) (*upload, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	control, err := debControl(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filePath, err)
+	}
+	fields, err := parseControl(bytes.NewReader(control))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filePath, err)
+	}
+	if fields["Package"] == "" || fields["Version"] == "" || fields["Architecture"] == "" {
+		return nil, fmt.Errorf("%v: no Package, Version or Architecture", filePath)
+	}
+
+	return &upload{
+		File:         filePath,
+		Name:         fields["Package"],
+		Version:      fields["Version"],
+		Architecture: fields["Architecture"],
+	}, nil
+}
+
+// parseUpload reads a .changes or a .deb, suites override the suites of
+// the file
+func parseUpload(filePath string, suites []string) (*upload, error) {
+	var u *upload
+	var err error
+	switch path.Ext(filePath) {
+	case ".changes":
+		u, err = parseChanges(filePath)
+	case ".deb", ".ddeb", ".udeb":
+		u, err = parseDeb(filePath)
+	default:
+		return nil, fmt.Errorf("%v: not a .changes or a .deb", filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(suites) != 0 {
+		u.Suites = suites
+	}
+	if len(u.Suites) == 0 {
+		return nil, fmt.Errorf("%v: no suite, give them with -suite", filePath)
+	}
+
+	return u, nil
+}
+
+// verifyResult is the check of an upload, Published are the versions
+// equal or higher in the targeted suites
+type verifyResult struct {
+	File      string             `json:"file"`
+	Name      string             `json:"name"`
+	Version   string             `json:"version"`
+	Status    string             `json:"status"`
+	Published []publishedVersion `json:"published"`
+}
+
+// publishedVersion is a version published in a suite
+type publishedVersion struct {
+	Version string `json:"version"`
+	Suite   string `json:"suite"`
+}
+
+// targets tells if a package is in one of the suites, a series without
+// pocket (noble) targets all its pockets
+func targets(suites []string, pkg *debianpkg.PackageInfo) bool {
+	return contains(pkg.Suite+pkg.Pocket, suites) || contains(pkg.Suite, suites)
+}
+
+// verifyUpload compares the version of an upload with the versions
+// published in its suites: the ones of the source package for a .changes,
+// of the binary package and its architecture for a .deb
+func verifyUpload(u *upload, pkgs []debianpkg.PackageInfo) verifyResult {
+	result := verifyResult{File: u.File, Name: u.Name, Version: u.Version, Status: verifyNew, Published: make([]publishedVersion, 0)}
+
+	seen := make(map[publishedVersion]bool)
+	for _, pkg := range pkgs {
+		if !targets(u.Suites, &pkg) {
+			continue
+		}
+
+		pkgVersion := pkg.Version
+		if u.isSource() {
+			var name string
+			name, pkgVersion = pkg.SourceNameVersion()
+			if name != u.Name {
+				continue
+			}
+		} else if pkg.Name != u.Name || (pkg.Architecture != u.Architecture && pkg.Architecture != "all" && u.Architecture != "all") {
+			continue
+		}
+
+		cmp := version.Compare(pkgVersion, u.Version)
+		if cmp < 0 {
+			continue
+		}
+		published := publishedVersion{pkgVersion, pkg.Suite + pkg.Pocket}
+		if !seen[published] {
+			seen[published] = true
+			result.Published = append(result.Published, published)
+		}
+		if cmp == 0 {
+			result.Status = verifyDuplicate
+		} else if result.Status == verifyNew {
+			result.Status = verifyOutdated
+		}
+	}
+
+	return result
+}
+
+// writeVerifyResults writes the results of the checks, as text or as
+// JSON. It returns false if an upload is already published or outdated.
+func writeVerifyResults(w io.Writer, format string, results []verifyResult) (bool, error) {
+	ok := true
+	for _, result := range results {
+		ok = ok && result.Status == verifyNew
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return ok, encoder.Encode(results)
+	}
+
+	for _, result := range results {
+		if result.Status == verifyNew {
+			fmt.Fprintf(w, "%v: ok, %v %v is not published\n", result.File, result.Name, result.Version)
+			continue
+		}
+		for _, published := range result.Published {
+			switch published.Version {
+			case result.Version:
+				fmt.Fprintf(w, "%v: %v %v is already published in %v\n", result.File, result.Name, result.Version, published.Suite)
+			default:
+				fmt.Fprintf(w, "%v: %v %v is outdated, %v is published in %v\n", result.File, result.Name, result.Version, published.Version, published.Suite)
+			}
+		}
+	}
+
+	return ok, nil
+}
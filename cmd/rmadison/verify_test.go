@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const testControl = "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nDescription: example package\n based on GNU hello\n"
+
+const testChanges = `-----BEGIN PGP SIGNED MESSAGE-----
+Hash: SHA512
+
+Format: 1.8
+Source: hello (2.10-3)
+Version: 2.10-3
+Distribution: noble-proposed
+Changes:
+ hello (2.10-3) noble; urgency=medium
+ .
+   * New upload.
+-----BEGIN PGP SIGNATURE-----
+
+iQIzBAEBCgAdFiEE
+-----END PGP SIGNATURE-----
+`
+
+// testTar returns a tar archive with the files
+func testTar(t *testing.T, files map[string]string) []byte {
+	buffer := new(bytes.Buffer)
+	archive := tar.NewWriter(buffer)
+	for name, content := range files {
+		err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(content))
+	}
+	archive.Close()
+
+	return buffer.Bytes()
+}
+
+// testCompress compresses content as given by the extension of a member
+// of a .deb
+func testCompress(t *testing.T, ext string, content []byte) []byte {
+	buffer := new(bytes.Buffer)
+	var writer io.WriteCloser
+	var err error
+	switch ext {
+	case ".gz":
+		writer = gzip.NewWriter(buffer)
+	case ".xz":
+		writer, err = xz.NewWriter(buffer)
+	case ".zst":
+		writer, err = zstd.NewWriter(buffer)
+	default:
+		return content
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(content)
+	writer.Close()
+
+	return buffer.Bytes()
+}
+
+// testAr returns an ar archive with the members, in order
+func testAr(members ...[2]string) []byte {
+	buffer := bytes.NewBufferString("!<arch>\n")
+	for _, member := range members {
+		fmt.Fprintf(buffer, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", member[0], "0", "0", "0", "100644", len(member[1]))
+		buffer.WriteString(member[1])
+		if len(member[1])%2 == 1 {
+			buffer.WriteString("\n")
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// testDeb returns a .deb with the control file in a control.tar member
+// compressed as ext
+func testDeb(t *testing.T, ext string, control string) []byte {
+	controlTar := testCompress(t, ext, testTar(t, map[string]string{"./control": control, "./md5sums": ""}))
+
+	return testAr(
+		[2]string{"debian-binary", "2.0\n"},
+		[2]string{"control.tar" + ext, string(controlTar)},
+		[2]string{"data.tar.xz", "data"},
+	)
+}
+
+func TestDebControl(t *testing.T) {
+	valid := testDeb(t, ".xz", testControl)
+	tests := []struct {
+		name     string
+		deb      []byte
+		expected string
+		err      string
+	}{
+		{name: "gzip", deb: testDeb(t, ".gz", testControl), expected: testControl},
+		{name: "xz", deb: valid, expected: testControl},
+		{name: "zstd", deb: testDeb(t, ".zst", testControl), expected: testControl},
+		{name: "uncompressed", deb: testDeb(t, "", testControl), expected: testControl},
+		// the odd members are padded
+		{name: "padding", deb: testAr([2]string{"debian-binary", "2.0"}, [2]string{"control.tar", string(testTar(t, map[string]string{"control": testControl}))}), expected: testControl},
+		{name: "not an ar archive", deb: []byte("PK\x03\x04"), err: "not a .deb"},
+		{name: "empty", deb: []byte{}, err: "not a .deb"},
+		{name: "truncated header", deb: valid[:40], err: "no control.tar"},
+		{name: "truncated member", deb: valid[:len(valid)/2], err: "no control file"},
+		{name: "no control.tar", deb: testAr([2]string{"debian-binary", "2.0\n"}, [2]string{"data.tar.xz", "data"}), err: "no control.tar"},
+		{name: "no control file", deb: testAr([2]string{"control.tar", string(testTar(t, map[string]string{"md5sums": ""}))}), err: "no control file"},
+		{name: "unsupported compression", deb: testAr([2]string{"control.tar.bz2", "BZh"}), err: "unsupported compression .bz2"},
+		{name: "corrupt compression", deb: testAr([2]string{"control.tar.gz", "not a gzip stream"}), err: "invalid header"},
+		{name: "invalid size", deb: []byte("!<arch>\n" + strings.Repeat("x", arHeaderSize)), err: "invalid size"},
+	}
+
+	for _, test := range tests {
+		control, err := debControl(bytes.NewReader(test.deb))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: expected %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if string(control) != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.name, test.expected, control)
+		}
+	}
+}
+
+func TestTarControl(t *testing.T) {
+	// the members of the control.tar can be ./control or control
+	for _, name := range []string{"control", "./control"} {
+		control, err := tarControl(bytes.NewReader(testTar(t, map[string]string{name: testControl})), ".tar")
+		if err != nil || string(control) != testControl {
+			t.Errorf("%v: unexpected control %q (%v)", name, control, err)
+		}
+	}
+
+	_, err := tarControl(strings.NewReader("not a tar"), ".tar")
+	if err == nil {
+		t.Error("expected an error for an invalid tar")
+	}
+}
+
+func TestParseControl(t *testing.T) {
+	tests := []struct {
+		name     string
+		control  string
+		expected map[string]string
+		err      string
+	}{
+		{
+			name:     "binary",
+			control:  testControl,
+			expected: map[string]string{"Package": "hello", "Version": "2.10-3", "Architecture": "amd64", "Description": "example package\nbased on GNU hello"},
+		},
+		{
+			name:     "signed changes",
+			control:  testChanges,
+			expected: map[string]string{"Format": "1.8", "Source": "hello (2.10-3)", "Version": "2.10-3", "Distribution": "noble-proposed", "Changes": "\nhello (2.10-3) noble; urgency=medium\n.\n* New upload."},
+		},
+		{
+			name:     "first paragraph only",
+			control:  "\n\nPackage: hello\n\nPackage: other\n",
+			expected: map[string]string{"Package": "hello"},
+		},
+		{name: "empty", control: "", expected: map[string]string{}},
+		{name: "continuation without field", control: " orphan\nPackage: hello\n", err: "continuation line"},
+		{name: "invalid line", control: "Package: hello\nnot a field\n", err: "invalid line"},
+		{name: "line too long", control: "Description: " + strings.Repeat("x", 2*1024*1024) + "\n", err: "too long"},
+	}
+
+	for _, test := range tests {
+		fields, err := parseControl(strings.NewReader(test.control))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: expected %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(fields) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %q, got %q", test.name, test.expected, fields)
+		}
+	}
+}
+
+func TestParseUpload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		filePath := path.Join(dir, name)
+		err := os.WriteFile(filePath, content, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	deb := testDeb(t, ".xz", testControl)
+
+	tests := []struct {
+		name     string
+		file     string
+		suites   []string
+		expected upload
+		err      string
+	}{
+		{
+			name:     "changes",
+			file:     write("hello_2.10-3_source.changes", []byte(testChanges)),
+			expected: upload{Name: "hello", Version: "2.10-3", Suites: []string{"noble-proposed"}},
+		},
+		{
+			name:     "changes with suites",
+			file:     path.Join(dir, "hello_2.10-3_source.changes"),
+			suites:   []string{"noble", "oracular"},
+			expected: upload{Name: "hello", Version: "2.10-3", Suites: []string{"noble", "oracular"}},
+		},
+		{
+			name:     "deb",
+			file:     write("hello_2.10-3_amd64.deb", deb),
+			suites:   []string{"noble"},
+			expected: upload{Name: "hello", Version: "2.10-3", Architecture: "amd64", Suites: []string{"noble"}},
+		},
+		{name: "deb without suite", file: path.Join(dir, "hello_2.10-3_amd64.deb"), err: "no suite"},
+		{name: "changes without version", file: write("noversion.changes", []byte("Source: hello\nDistribution: noble\n")), err: "no Source or Version"},
+		{name: "malformed changes", file: write("malformed.changes", []byte("Source: hello\nVersion 2.10-3\n")), err: "invalid line"},
+		{name: "truncated deb", file: write("truncated.deb", deb[:len(deb)/2]), suites: []string{"noble"}, err: "no control file"},
+		{name: "deb without architecture", file: write("noarch.udeb", testDeb(t, ".gz", "Package: hello\nVersion: 2.10-3\n")), suites: []string{"noble"}, err: "no Package, Version or Architecture"},
+		{name: "not an upload", file: write("hello.dsc", []byte(testChanges)), err: "not a .changes or a .deb"},
+		{name: "missing", file: path.Join(dir, "missing.changes"), err: "no such file"},
+	}
+
+	for _, test := range tests {
+		u, err := parseUpload(test.file, test.suites)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: expected %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		test.expected.File = test.file
+		if fmt.Sprint(*u) != fmt.Sprint(test.expected) {
+			t.Errorf("%v: expected %+v, got %+v", test.name, test.expected, *u)
+		}
+	}
+}
+
+func TestVerifyUpload(t *testing.T) {
+	pkgs := []debianpkg.PackageInfo{
+		{Name: "hello", Version: "2.10-3", Source: "hello", Suite: "noble", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-3", Source: "hello", Suite: "noble", Architecture: "arm64"},
+		{Name: "hello", Version: "2.10-4", Source: "hello", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+		{Name: "hello-doc", Version: "2.10-3", Source: "hello", Suite: "noble", Architecture: "all"},
+		// binNMU, the version of the source is in the Source field
+		{Name: "hello", Version: "2.10-3+b1", Source: "hello (2.10-3)", Suite: "oracular", Architecture: "amd64"},
+		{Name: "hello", Version: "2.12-1", Source: "hello", Suite: "plucky", Architecture: "amd64"},
+	}
+
+	tests := []struct {
+		name      string
+		upload    upload
+		status    string
+		published []publishedVersion
+	}{
+		{
+			name:   "new source",
+			upload: upload{Name: "hello", Version: "2.11-1", Suites: []string{"noble"}},
+			status: verifyNew,
+		},
+		{
+			// a series targets all its pockets
+			name:      "outdated source",
+			upload:    upload{Name: "hello", Version: "2.10-3", Suites: []string{"noble"}},
+			status:    verifyDuplicate,
+			published: []publishedVersion{{"2.10-3", "noble"}, {"2.10-4", "noble-updates"}},
+		},
+		{
+			name:      "newer in the pocket",
+			upload:    upload{Name: "hello", Version: "2.10-3ubuntu1", Suites: []string{"noble-updates"}},
+			status:    verifyOutdated,
+			published: []publishedVersion{{"2.10-4", "noble-updates"}},
+		},
+		{
+			name:      "source of a binNMU",
+			upload:    upload{Name: "hello", Version: "2.10-3", Suites: []string{"oracular"}},
+			status:    verifyDuplicate,
+			published: []publishedVersion{{"2.10-3", "oracular"}},
+		},
+		{
+			name:      "binary",
+			upload:    upload{Name: "hello", Version: "2.10-3", Architecture: "arm64", Suites: []string{"noble-proposed", "noble"}},
+			status:    verifyDuplicate,
+			published: []publishedVersion{{"2.10-3", "noble"}},
+		},
+		{
+			name:   "binary of another architecture",
+			upload: upload{Name: "hello", Version: "2.10-4", Architecture: "arm64", Suites: []string{"noble-updates"}},
+			status: verifyNew,
+		},
+		{
+			name:      "binary for all the architectures",
+			upload:    upload{Name: "hello-doc", Version: "2.10-3", Architecture: "amd64", Suites: []string{"noble"}},
+			status:    verifyDuplicate,
+			published: []publishedVersion{{"2.10-3", "noble"}},
+		},
+		{
+			name:      "several suites",
+			upload:    upload{Name: "hello", Version: "2.10-3+b1", Architecture: "amd64", Suites: []string{"oracular", "plucky"}},
+			status:    verifyDuplicate,
+			published: []publishedVersion{{"2.10-3+b1", "oracular"}, {"2.12-1", "plucky"}},
+		},
+	}
+
+	for _, test := range tests {
+		result := verifyUpload(&test.upload, pkgs)
+		if result.Status != test.status {
+			t.Errorf("%v: expected %v, got %v", test.name, test.status, result.Status)
+		}
+		if fmt.Sprint(result.Published) != fmt.Sprint(test.published) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.published, result.Published)
+		}
+	}
+}
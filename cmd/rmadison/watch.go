@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// defaultWatchInterval is the time between two lookups of -watch
+const defaultWatchInterval = time.Minute
+
+// watchChange is a version that appeared, changed or was removed between
+// two lookups of -watch, OldVersion is empty for new versions and Version
+// for removed ones
+type watchChange struct {
+	Time         time.Time `json:"time"`
+	Archive      string    `json:"archive"`
+	Name         string    `json:"name"`
+	Suite        string    `json:"suite"`
+	Component    string    `json:"component"`
+	Architecture string    `json:"architecture"`
+	OldVersion   string    `json:"old_version,omitempty"`
+	Version      string    `json:"version,omitempty"`
+}
+
+// String formats the change as a line of the output of -watch
+func (c watchChange) String() string {
+	location := fmt.Sprintf("%v %v %v/%v", c.Archive, c.Suite, c.Component, c.Architecture)
+	switch {
+	case c.OldVersion == "":
+		return fmt.Sprintf("%v %v %v: new %v", c.Time.Format(time.RFC3339), c.Name, location, c.Version)
+	case c.Version == "":
+		return fmt.Sprintf("%v %v %v: removed %v", c.Time.Format(time.RFC3339), c.Name, location, c.OldVersion)
+	}
+
+	return fmt.Sprintf("%v %v %v: %v -> %v", c.Time.Format(time.RFC3339), c.Name, location, c.OldVersion, c.Version)
+}
+
+// watchState is the version of each package by archive, suite, component
+// and architecture
+type watchState map[watchChange]string
+
+// newWatchState returns the state of the packages found by a lookup
+func newWatchState(pkgs []debianpkg.PackageInfo) watchState {
+	state := make(watchState, len(pkgs))
+	for _, pkg := range pkgs {
+		state[watchChange{
+			Archive:      pkg.Archive,
+			Name:         pkg.Name,
+			Suite:        pkg.Suite + pkg.Pocket,
+			Component:    pkg.Component,
+			Architecture: pkg.Architecture,
+		}] = pkg.Version
+	}
+
+	return state
+}
+
+// diffWatchStates returns the changes between two states, sorted by
+// package, suite and architecture
+func diffWatchStates(previous, current watchState, now time.Time) []watchChange {
+	changes := make([]watchChange, 0)
+	for key, newVersion := range current {
+		if oldVersion := previous[key]; oldVersion != newVersion {
+			change := key
+			change.Time = now
+			change.OldVersion = oldVersion
+			change.Version = newVersion
+			changes = append(changes, change)
+		}
+	}
+	for key, oldVersion := range previous {
+		if _, ok := current[key]; !ok {
+			change := key
+			change.Time = now
+			change.OldVersion = oldVersion
+			changes = append(changes, change)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Archive != b.Archive {
+			return a.Archive < b.Archive
+		}
+		if a.Suite != b.Suite {
+			return a.Suite < b.Suite
+		}
+		return a.Architecture < b.Architecture
+	})
+
+	return changes
+}
+
+// reached tells if all the packages have a version at least as high as
+// target
+func reached(pkgs []string, state watchState, target string) bool {
+	for _, pkg := range pkgs {
+		found := false
+		for key, pkgVersion := range state {
+			if key.Name == pkg && version.Compare(pkgVersion, target) >= 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watchOptions are the options of -watch
+type watchOptions struct {
+	Format   string
+	Color    bool
+	Interval time.Duration
+	// Until stops the watch when all the packages have this version or a
+	// higher one, in one of the suites
+	Until string
+}
+
+// writeWatchChanges writes the changes, one JSON object by line with the
+// JSON format
+func writeWatchChanges(w io.Writer, format string, changes []watchChange) error {
+	encoder := json.NewEncoder(w)
+	for _, change := range changes {
+		var err error
+		if format == formatJSON {
+			err = encoder.Encode(change)
+		} else {
+			_, err = fmt.Fprintln(w, change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watch looks up the packages every interval and prints the versions that
+// changed, until the version of opts.Until is published. The first lookup
+// is printed like without -watch, or as new versions with the JSON format
+// so that the output is only JSON lines. The lookups that fail are
+// reported and retried at the next interval.
+func watch(w io.Writer, pkgs []string, lookup func() ([]debianpkg.PackageInfo, error), opts watchOptions) error {
+	pkgInfo, err := lookup()
+	if err != nil {
+		return err
+	}
+	state := newWatchState(pkgInfo)
+	if opts.Format == formatJSON {
+		err = writeWatchChanges(w, opts.Format, diffWatchStates(watchState{}, state, time.Now().UTC()))
+	} else {
+		err = writeOutput(w, opts.Format, opts.Color, pkgInfo)
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if opts.Until != "" && reached(pkgs, state, opts.Until) {
+			return nil
+		}
+
+		time.Sleep(opts.Interval)
+		pkgInfo, err := lookup()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lookup failed, retrying in %v: %v\n", opts.Interval, err)
+			continue
+		}
+
+		current := newWatchState(pkgInfo)
+		err = writeWatchChanges(w, opts.Format, diffWatchStates(state, current, time.Now().UTC()))
+		if err != nil {
+			return err
+		}
+		state = current
+	}
+}
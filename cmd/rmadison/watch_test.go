@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestDiffWatchStates(t *testing.T) {
+	now := time.Date(2024, 12, 3, 10, 15, 0, 0, time.UTC)
+	previous := newWatchState([]debianpkg.PackageInfo{
+		{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "arm64"},
+		{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-proposed", Component: "main", Architecture: "amd64"},
+		{Archive: "ubuntu", Name: "curl", Version: "8.5.0-2ubuntu10", Suite: "noble", Component: "main", Architecture: "amd64"},
+	})
+	current := newWatchState([]debianpkg.PackageInfo{
+		{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "arm64"},
+		{Archive: "ubuntu", Name: "curl", Version: "8.5.0-2ubuntu10", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Archive: "ubuntu", Name: "curl", Version: "8.5.0-2ubuntu10.1", Suite: "noble", Pocket: "-security", Component: "main", Architecture: "amd64"},
+	})
+
+	expected := []string{
+		"2024-12-03T10:15:00Z curl ubuntu noble-security main/amd64: new 8.5.0-2ubuntu10.1",
+		"2024-12-03T10:15:00Z openssl ubuntu noble-proposed main/amd64: removed 3.0.13-0ubuntu3.2",
+		"2024-12-03T10:15:00Z openssl ubuntu noble-updates main/amd64: 3.0.13-0ubuntu3.1 -> 3.0.13-0ubuntu3.2",
+	}
+	changes := diffWatchStates(previous, current, now)
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v changes, got %v", expected, changes)
+	}
+	for i, change := range changes {
+		if change.String() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], change.String())
+		}
+	}
+
+	if changes := diffWatchStates(current, current, now); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestReached(t *testing.T) {
+	state := newWatchState([]debianpkg.PackageInfo{
+		{Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+		{Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-proposed", Architecture: "amd64"},
+		{Name: "libssl3t64", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+	})
+
+	tests := []struct {
+		pkgs     []string
+		target   string
+		expected bool
+	}{
+		// in one of the suites
+		{[]string{"openssl"}, "3.0.13-0ubuntu3.2", true},
+		{[]string{"openssl"}, "3.0.13-0ubuntu3.1", true},
+		{[]string{"openssl", "libssl3t64"}, "3.0.13-0ubuntu3.2", false},
+		{[]string{"openssl", "libssl3t64"}, "3.0.13-0ubuntu3", true},
+		{[]string{"openssl"}, "3.0.13-0ubuntu4", false},
+		{[]string{"curl"}, "1.0", false},
+	}
+
+	for _, test := range tests {
+		if found := reached(test.pkgs, state, test.target); found != test.expected {
+			t.Errorf("%v %v: expected %v, got %v", test.pkgs, test.target, test.expected, found)
+		}
+	}
+}
+
+func TestWatchJSONLines(t *testing.T) {
+	lookups := [][]debianpkg.PackageInfo{
+		{{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.1", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"}},
+		nil,
+		{{Archive: "ubuntu", Name: "openssl", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"}},
+	}
+	lookup := func() ([]debianpkg.PackageInfo, error) {
+		pkgs := lookups[0]
+		lookups = lookups[1:]
+		if pkgs == nil {
+			return nil, errors.New("server unavailable")
+		}
+		return pkgs, nil
+	}
+
+	out := new(bytes.Buffer)
+	err := watch(out, []string{"openssl"}, lookup, watchOptions{Format: formatJSON, Interval: time.Millisecond, Until: "3.0.13-0ubuntu3.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first lookup is printed as new versions, every line is an object
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out.String())
+	}
+	var changes [2]watchChange
+	for i, line := range lines {
+		err = json.Unmarshal([]byte(line), &changes[i])
+		if err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+	}
+	if changes[0].OldVersion != "" || changes[0].Version != "3.0.13-0ubuntu3.1" {
+		t.Errorf("unexpected first change %+v", changes[0])
+	}
+	if changes[1].OldVersion != "3.0.13-0ubuntu3.1" || changes[1].Version != "3.0.13-0ubuntu3.2" {
+		t.Errorf("unexpected second change %+v", changes[1])
+	}
+}
@@ -3,14 +3,42 @@ module github.com/gjolly/go-rmadison
 go 1.21
 
 require (
+	github.com/ProtonMail/go-crypto v1.0.0
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/go-resty/resty/v2 v2.10.0
+	github.com/graph-gophers/graphql-go v1.5.0
+	github.com/klauspost/compress v1.16.7
 	github.com/mattn/go-sqlite3 v1.14.17
+	github.com/minio/minio-go/v7 v7.0.63
 	github.com/pkg/errors v0.9.1
+	github.com/ulikunitz/xz v0.5.11
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	go.uber.org/zap v1.26.0
+	golang.org/x/net v0.17.0
+	google.golang.org/grpc v1.58.3
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/cloudflare/circl v1.3.3 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
+	github.com/minio/md5-simd v1.1.2 // indirect
+	github.com/minio/sha256-simd v1.0.1 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/rs/xid v1.5.0 // indirect
+	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+	gopkg.in/ini.v1 v1.67.0 // indirect
 )
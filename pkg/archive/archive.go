@@ -1,10 +1,11 @@
 package archive
 
 import (
-	"compress/gzip"
+	"bufio"
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -14,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/gjolly/go-rmadison/pkg/database"
 	"github.com/gjolly/go-rmadison/pkg/debianpkg"
 	"github.com/go-resty/resty/v2"
@@ -29,6 +31,12 @@ func init() {
 	log = logger.Sugar()
 }
 
+// SetLogger replaces the logger of the package, e.g. to silence the
+// refreshes in command line tools
+func SetLogger(logger *zap.SugaredLogger) {
+	log = logger
+}
+
 // ReleaseFileEntry is a entry in a release file
 type ReleaseFileEntry struct {
 	Hash string
@@ -49,18 +57,241 @@ type ReleaseFile struct {
 	Description   string
 	PackageIndex  map[string]ReleaseFileEntry
 	Hash          string
+
+	// ValidUntil is the expiry of the Release file, zero if it has none
+	ValidUntil time.Time
+	// AcquireByHash tells if the indexes can be downloaded from the
+	// by-hash directories
+	AcquireByHash bool
+	// NotAutomatic and ButAutomaticUpgrades lower the default APT
+	// priority of the packages (experimental, backports)
+	NotAutomatic         bool
+	ButAutomaticUpgrades bool
+}
+
+// RefreshStatus describes the outcome of the last cache refresh,
+// durations are in seconds
+type RefreshStatus struct {
+	LastRefresh     time.Time  `json:"last_refresh"`
+	LastDuration    float64    `json:"last_refresh_duration"`
+	LastError       string     `json:"last_error"`
+	UpdatedPackages int        `json:"updated_packages"`
+	Parse           ParseStats `json:"parse"`
+}
+
+// PackageChange is a package that appeared or changed version in a
+// refresh, OldVersion is empty for new packages
+type PackageChange struct {
+	Archive      string    `json:"archive"`
+	Name         string    `json:"name"`
+	Suite        string    `json:"suite"`
+	Component    string    `json:"component"`
+	Architecture string    `json:"architecture"`
+	OldVersion   string    `json:"old_version,omitempty"`
+	Version      string    `json:"version"`
+	Time         time.Time `json:"time"`
 }
 
 // Archive is a debian archive
 type Archive struct {
+	Name        string
 	BaseURL     *url.URL
 	PortsURL    *url.URL
 	Client      *resty.Client
 	ReleaseInfo map[string]*ReleaseFile
-	Pockets     []string
-	CacheDir    string
-	Database    *database.DB
-	DBPath      string
+	// Pockets are the pockets to index, they can be patterns (e.g. noble*)
+	// matched against the suites listed in BaseURL
+	Pockets []string
+	// Discover indexes all the suites listed in BaseURL in addition to
+	// Pockets
+	Discover bool
+	// Releases are indexed with each of PocketSuffixes (see
+	// DefaultPocketSuffixes) in addition to Pockets
+	Releases       []string
+	PocketSuffixes []string
+	// Architectures and Components restrict the indexes downloaded, all
+	// the ones advertised by the Release files are indexed when empty
+	Architectures []string
+	Components    []string
+	// Contents enables the indexing of the Contents indexes (files shipped
+	// by each package), they are large so this is opt-in
+	Contents bool
+	// Sources enables the indexing of the Sources indexes (the source
+	// packages, their binaries and their files)
+	Sources  bool
+	CacheDir string
+	Database *database.DB
+	DBPath   string
+	// SignedBy is the keyring APT should use for this archive
+	SignedBy string
+	// Keyrings are the keyring files the signatures of the InRelease
+	// files are verified with, nothing is verified without keyrings.
+	// Verify is VerifyRefuse (the default) or VerifyFlag.
+	Keyrings []string
+	Verify   string
+	// ChangelogURL is the template of the URL of the changelogs, see
+	// UbuntuChangelogURL. It's guessed for Ubuntu and Debian.
+	ChangelogURL string
+	// Translations are the languages of the descriptions to index (de,
+	// fr...) from the Translation indexes, en included
+	Translations []string
+	// Layout is LayoutDists (the default) or LayoutFlat. The pockets of
+	// the flat repositories are their directories relative to BaseURL
+	// ("." for BaseURL itself).
+	Layout string
+	// Spill is SpillAuto (the default), SpillAlways or SpillNever, it
+	// tells when the packages of a refresh are written to disk before
+	// being inserted in the database
+	Spill string
+	// OnChange is called for every package added or whose version changed
+	// during a refresh. It's not called while the database is populated
+	// for the first time.
+	OnChange func(PackageChange)
+	// OnRefresh is called after every refresh with its status
+	OnRefresh func(RefreshStatus)
+
+	// refreshLock prevents concurrent refreshes of the same archive
+	refreshLock sync.Mutex
+	statusLock  sync.Mutex
+	status      RefreshStatus
+	report      *RefreshReport
+	pockets     []string
+	// releaseDates holds the Date of the last Release file of each pocket
+	releaseDates map[string]time.Time
+	// automatic holds the NotAutomatic and ButAutomaticUpgrades fields of
+	// the last Release file of each pocket
+	automatic map[string]automaticFlags
+	// byHash tells which pockets support Acquire-By-Hash
+	byHash map[string]bool
+	// pdiffIndexes are the Packages.diff/Index files of the Packages
+	// indexes of each pocket
+	pdiffIndexes map[string]map[string]ReleaseFileEntry
+	// packagesFormats are the compressions of the Packages indexes last
+	// downloaded, by pocket and directory (main/binary-amd64)
+	packagesFormats map[string]map[string]string
+	// skews holds the last clock skew measured for each mirror
+	skews map[string]MirrorSkew
+	// parseStats collects the anomalies of the refresh in progress
+	parseStats *parseStatsCollector
+}
+
+// Reset replaces the database and forgets the indexes seen, the next
+// refresh downloads and parses all of them again. It's used when the
+// database is recreated: reopen is called with the current database once
+// the refresh in progress is done, it can close it and returns the new
+// one.
+func (a *Archive) Reset(reopen func(old *database.DB) (*database.DB, error)) error {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	db, err := reopen(a.Database)
+	if err != nil {
+		return err
+	}
+	a.Database = db
+	a.ReleaseInfo = nil
+
+	return nil
+}
+
+// Close closes the database once the refresh in progress is done
+func (a *Archive) Close() error {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	if a.Database == nil {
+		return nil
+	}
+
+	return a.Database.Close()
+}
+
+// Status returns the status of the last cache refresh
+func (a *Archive) Status() RefreshStatus {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	return a.status
+}
+
+// LastReport returns the report of the last cache refresh, nil before the
+// first one
+func (a *Archive) LastReport() *RefreshReport {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	return a.report
+}
+
+// ReleaseDates returns the Date of the last Release file seen for each
+// pocket
+func (a *Archive) ReleaseDates() map[string]time.Time {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	dates := make(map[string]time.Time, len(a.releaseDates))
+	for pocket, date := range a.releaseDates {
+		dates[pocket] = date
+	}
+
+	return dates
+}
+
+// automaticFlags are the fields of a Release file setting its APT priority
+type automaticFlags struct {
+	notAutomatic         bool
+	butAutomaticUpgrades bool
+}
+
+// Automatic returns the NotAutomatic and ButAutomaticUpgrades fields of
+// the last Release file of a pocket
+func (a *Archive) Automatic(pocket string) (notAutomatic, butAutomaticUpgrades bool) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	flags := a.automatic[pocket]
+
+	return flags.notAutomatic, flags.butAutomaticUpgrades
+}
+
+func (a *Archive) setAutomatic(pocket string, release *ReleaseFile) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.automatic == nil {
+		a.automatic = make(map[string]automaticFlags)
+	}
+	a.automatic[pocket] = automaticFlags{release.NotAutomatic, release.ButAutomaticUpgrades}
+}
+
+func (a *Archive) setReleaseDate(pocket string, date time.Time) {
+	if date.IsZero() {
+		return
+	}
+
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.releaseDates == nil {
+		a.releaseDates = make(map[string]time.Time)
+	}
+	a.releaseDates[pocket] = date
+}
+
+func (a *Archive) setStatus(start time.Time, report *RefreshReport, err error) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	a.status = RefreshStatus{
+		LastRefresh:     start,
+		LastDuration:    now().Sub(start).Seconds(),
+		UpdatedPackages: report.UpdatedPackages,
+		Parse:           a.parseStats.snapshot(),
+	}
+	if err != nil {
+		a.status.LastError = err.Error()
+	}
+	a.report = report
 }
 
 func (a *Archive) getReleaseFileLocationsForPocket(pocket string) (url.URL, string) {
@@ -75,32 +306,73 @@ func (a *Archive) getReleaseFileLocationsForPocket(pocket string) (url.URL, stri
 
 // GetReleaseInfo downloads all the release files for the pockets and parses them
 func (a *Archive) GetReleaseInfo(local bool) (map[string]*ReleaseFile, error) {
+	releaseInfo, _, err := a.getReleaseInfo(local)
+
+	return releaseInfo, err
+}
+
+// getReleaseInfo is GetReleaseInfo, it also returns the errors of the
+// Release files refused by the verification of their signature
+func (a *Archive) getReleaseInfo(local bool) (map[string]*ReleaseFile, []string, error) {
+	var keyring openpgp.EntityList
+	if len(a.Keyrings) != 0 {
+		var err error
+		keyring, err = readKeyring(a.Keyrings)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	releaseInfo := make(map[string]*ReleaseFile)
-	for _, pocket := range a.Pockets {
+	refused := make([]string, 0)
+	aliases := make([]string, 0)
+	for _, pocket := range a.pocketList() {
 		fileURL, outputFilePath := a.getReleaseFileLocationsForPocket(pocket)
 
 		file, err := os.Open(outputFilePath)
 		if err != nil || !local {
+			if file != nil {
+				file.Close()
+			}
 			log.Debugf("[release] fetching %v", outputFilePath)
-			err := downloadFile(a.Client, fileURL, outputFilePath)
+			resp, err := downloadFile(a.Client, fileURL, outputFilePath)
+			if errors.Is(err, errFileNotFound) {
+				// some archives (often the flat repositories) only have
+				// a Release file, signed by its Release.gpg if at all
+				fileURL.Path = path.Join(path.Dir(fileURL.Path), "Release")
+				resp, err = downloadFile(a.Client, fileURL, outputFilePath)
+				if err == nil && keyring != nil {
+					a.downloadSignature(fileURL, outputFilePath)
+				}
+			}
+			if errors.Is(err, errFileNotFound) && !a.isRequiredPocket(pocket) {
+				log.Debugf("[release] no %v, skipping the pocket", fileURL.String())
+				continue
+			}
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			a.recordSkew(fileURL.Host, resp)
+
+			// the Release file didn't change, let's not re-parse it
+			if releaseFile, ok := a.ReleaseInfo[pocket]; ok && releaseFile.Hash != "" && resp.StatusCode() == http.StatusNotModified {
+				log.Debugf("[release] not modified %v", outputFilePath)
+				continue
 			}
 
 			file, err = os.Open(outputFilePath)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		} else {
 			log.Debugf("[release] local %v", outputFilePath)
 		}
 		defer file.Close()
 
-		shaSum := sha256.New()
-		if _, err := io.Copy(shaSum, file); err != nil {
-			return nil, fmt.Errorf("failed to compute hash for %v", outputFilePath)
+		shaSumStr, err := fileHash(outputFilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to compute hash for %v", outputFilePath)
 		}
-		shaSumStr := fmt.Sprintf("%x", shaSum)
 
 		// If the index file hasn't changed, let's not re-parse it
 		if releaseFile, ok := a.ReleaseInfo[pocket]; ok && shaSumStr == releaseFile.Hash {
@@ -109,19 +381,66 @@ func (a *Archive) GetReleaseInfo(local bool) (map[string]*ReleaseFile, error) {
 		}
 
 		log.Debugf("[release] parsing %v", outputFilePath)
-		releaseInfo[pocket], err = ParseReleaseFile(file)
+		if keyring != nil {
+			raw, err := io.ReadAll(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			// the detached signature of a Release file
+			signature, err := os.ReadFile(signaturePath(outputFilePath))
+			if err != nil {
+				signature = nil
+			}
+			// only the signed content is parsed
+			content, err := a.checkRelease(pocket, raw, signature, keyring)
+			if err != nil {
+				// the refused file is in the cache, without its
+				// validators the next refresh downloads and verifies
+				// it again instead of getting a 304
+				forgetValidators(outputFilePath)
+				forgetValidators(signaturePath(outputFilePath))
+				refused = append(refused, err.Error())
+				continue
+			}
+			releaseInfo[pocket], err = parseRelease(content)
+		} else {
+			releaseInfo[pocket], err = ParseReleaseFile(file)
+		}
 		if err != nil {
 			log.Errorf("failed to parse Release file (%v): %v", outputFilePath, err)
 			continue
 		}
+		if canonical, ok := releaseAlias(pocket, releaseInfo[pocket], a.pocketList()); ok && !a.isRequiredPocket(pocket) {
+			log.Debugf("[release] %v is an alias of %v, skipping the pocket", pocket, canonical)
+			delete(releaseInfo, pocket)
+			aliases = append(aliases, pocket)
+			continue
+		}
 		releaseInfo[pocket].Hash = shaSumStr
+		a.setReleaseDate(pocket, releaseInfo[pocket].Date)
+		a.setAcquireByHash(pocket, releaseInfo[pocket].AcquireByHash)
+		a.setAutomatic(pocket, releaseInfo[pocket])
+		if validUntil := releaseInfo[pocket].ValidUntil; !validUntil.IsZero() && a.Now().After(validUntil) {
+			log.Warnf("[release][%v] the Release file expired on %v", pocket, validUntil)
+			a.parseStats.error("the Release file of %v expired on %v", pocket, validUntil)
+		}
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+	}
+
+	if len(aliases) != 0 {
+		pockets := make([]string, 0)
+		for _, pocket := range a.pocketList() {
+			if !containsString(aliases, pocket) {
+				pockets = append(pockets, pocket)
+			}
 		}
+		a.setPockets(pockets)
 	}
 
-	return releaseInfo, nil
+	return releaseInfo, refused, nil
 }
 
 func parseIndexLine(line string) *ReleaseFileEntry {
@@ -144,6 +463,17 @@ func parseIndexLine(line string) *ReleaseFileEntry {
 	}
 }
 
+// parseReleaseDate parses the Date field of a Release file, archives
+// use either a numeric zone or UTC
+func parseReleaseDate(value string) (time.Time, error) {
+	date, err := time.Parse(time.RFC1123Z, value)
+	if err == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC1123, value)
+}
+
 // ParseReleaseFile parses the content of a release file
 func ParseReleaseFile(file *os.File) (*ReleaseFile, error) {
 	file.Seek(0, 0)
@@ -152,6 +482,12 @@ func ParseReleaseFile(file *os.File) (*ReleaseFile, error) {
 		return nil, err
 	}
 
+	return parseRelease(raw)
+}
+
+// parseRelease parses the content of a Release file, the signature of an
+// InRelease file is ignored
+func parseRelease(raw []byte) (*ReleaseFile, error) {
 	releaseFile := new(ReleaseFile)
 
 	txtFile := string(raw)
@@ -169,6 +505,30 @@ func ParseReleaseFile(file *os.File) (*ReleaseFile, error) {
 			key := keyValue[0]
 			value := keyValue[1]
 
+			if key == "Valid-Until" {
+				validUntil, err := parseReleaseDate(value)
+				if err == nil {
+					releaseFile.ValidUntil = validUntil
+				}
+
+				continue
+			}
+			if key == "Acquire-By-Hash" {
+				releaseFile.AcquireByHash = strings.TrimSpace(value) == "yes"
+
+				continue
+			}
+			if key == "NotAutomatic" {
+				releaseFile.NotAutomatic = strings.TrimSpace(value) == "yes"
+
+				continue
+			}
+			if key == "ButAutomaticUpgrades" {
+				releaseFile.ButAutomaticUpgrades = strings.TrimSpace(value) == "yes"
+
+				continue
+			}
+
 			v := reflect.Indirect(reflect.ValueOf(releaseFile))
 			field := v.FieldByName(key)
 			if field == (reflect.Value{}) {
@@ -177,7 +537,7 @@ func ParseReleaseFile(file *os.File) (*ReleaseFile, error) {
 			}
 
 			if key == "Date" {
-				date, err := time.Parse(time.RFC1123Z, value)
+				date, err := parseReleaseDate(value)
 				if err != nil {
 					continue
 				}
@@ -209,53 +569,102 @@ func ParseReleaseFile(file *os.File) (*ReleaseFile, error) {
 
 				iLine++
 			}
+			// the line ending the list is a field (Acquire-By-Hash)
+			iLine--
 		}
 	}
 
 	return releaseFile, nil
 }
 
-func downloadFile(client *resty.Client, fileURL url.URL, outputFilePath string) error {
-	resp, err := client.
+// fileHash returns the SHA256 of a file
+func fileHash(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	shaSum := sha256.New()
+	if _, err := io.Copy(shaSum, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", shaSum.Sum(nil)), nil
+}
+
+// errFileNotFound is the error of the downloads of files missing from the
+// mirror
+var errFileNotFound = errors.New("404 Not Found")
+
+// downloadFile downloads a file, the response is returned for its headers.
+// The request is conditional if the file was downloaded before, the status
+// of the response is 304 if the file didn't change. The file is replaced
+// only once it's completely downloaded.
+func downloadFile(client *resty.Client, fileURL url.URL, outputFilePath string) (*resty.Response, error) {
+	beforeFetch(fileURL.String())
+
+	partPath := outputFilePath + ".part"
+	req := client.
 		SetRetryCount(3).
 		SetRetryWaitTime(5 * time.Second).
 		SetRetryMaxWaitTime(20 * time.Second).
 		R().
-		SetOutput(outputFilePath).
-		Get(fileURL.String())
+		SetOutput(partPath)
+	readValidators(outputFilePath).setConditional(req)
+
+	resp, err := req.Get(fileURL.String())
 	if err != nil {
-		return errors.Wrap(err, "failed to fetch Release file")
+		os.Remove(partPath)
+		return nil, errors.Wrap(err, "failed to fetch Release file")
+	}
+	if resp.StatusCode() == http.StatusNotModified {
+		os.Remove(partPath)
+		log.Debugf("[download] not modified %v", fileURL.String())
+		return resp, nil
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		os.Remove(partPath)
+		return nil, fmt.Errorf("failed to fetch file from %v: %w", fileURL.String(), errFileNotFound)
 	}
-
 	if resp.IsError() {
-		return fmt.Errorf("failed to fetch file from %v (%v)", fileURL, resp.Status())
+		os.Remove(partPath)
+		return nil, fmt.Errorf("failed to fetch file from %v (%v)", fileURL, resp.Status())
 	}
 
-	return nil
+	err = os.Rename(partPath, outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeValidators(outputFilePath, resp); err != nil {
+		log.Warnf("failed to save the validators of %v: %v", outputFilePath, err)
+	}
+	afterDownload(outputFilePath)
+
+	return resp, nil
 }
 
 // DownloadIfNeeded downloads the package index files for the given pocket
 // if the hashes from filesToDownload are direrent from the ones in a.ReleaseInfo
 // returns the number of files downloaded
-func (a *Archive) DownloadIfNeeded(local bool, pocket string, filesToDownload map[string]ReleaseFileEntry, packagesChan chan *debianpkg.PackageInfo) (int, error) {
+func (a *Archive) DownloadIfNeeded(local bool, pocket string, filesToDownload map[string]ReleaseFileEntry, packagesChan chan *debianpkg.PackageInfo) ([]IndexResult, error) {
 	pocketBaseURL := url.URL(*a.BaseURL)
 	pocketBaseURL.Path = path.Join(pocketBaseURL.Path, pocket)
 
 	pocketPortsURL := url.URL(*a.PortsURL)
 	pocketPortsURL.Path = path.Join(pocketPortsURL.Path, pocket)
 
-	nbFile := 0
+	results := make([]IndexResult, 0)
+	resultsLock := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
 	for filePath, fileInfo := range filesToDownload {
-		if a.ReleaseInfo != nil {
-			if _, ok := a.ReleaseInfo[pocket]; ok && fileInfo.Hash == a.ReleaseInfo[pocket].PackageIndex[filePath].Hash {
-				continue
-			}
+		if a.indexUnchanged(pocket, filePath, fileInfo) {
+			log.Debugf("[package][%v] unchanged %v", pocket, filePath)
+			continue
 		}
 
-		nbFile++
 		fileURL := url.URL(pocketPortsURL)
-		if strings.Contains(filePath, "amd64") || strings.Contains(filePath, "i386") {
+		if strings.Contains(filePath, "amd64") || strings.Contains(filePath, "i386") || a.isFlat() {
 			fileURL = url.URL(pocketBaseURL)
 		}
 		fileURL.Path = path.Join(fileURL.Path, filePath)
@@ -263,67 +672,152 @@ func (a *Archive) DownloadIfNeeded(local bool, pocket string, filesToDownload ma
 		outputFileName := strings.ReplaceAll(fileURL.Hostname()+fileURL.Path, "/", "_")
 
 		wg.Add(1)
-		go func(fileURL url.URL, fileName string) {
+		go func(fileURL url.URL, fileName string, expectedHash string, result IndexResult) {
 			defer wg.Done()
+			defer func() {
+				resultsLock.Lock()
+				defer resultsLock.Unlock()
+				result.Success = result.Error == ""
+				results = append(results, result)
+			}()
+
 			filePath := path.Join(a.CacheDir, fileName)
 			if _, err := os.Stat(filePath); !local || errors.Is(err, os.ErrNotExist) {
-				err := downloadFile(a.Client, fileURL, filePath)
-				if err != nil {
-					log.Errorf("error downloading: %v: %v", fileURL.String(), err)
-					return
+				// the version of the previous refresh can be patched
+				result.Patched = err == nil && a.updateByPDiff(pocket, result.path, fileURL, filePath)
+				if !result.Patched {
+					err := a.downloadIndex(pocket, fileURL, expectedHash, filePath)
+					if err != nil {
+						result.notFound = errors.Is(err, errFileNotFound)
+						result.Error = fmt.Sprintf("failed to download %v: %v", fileURL.String(), err)
+						if result.notFound {
+							// reported by retryFormats if no other compression is found
+							log.Debugf("[package][%v] %v is missing", pocket, fileURL.String())
+							return
+						}
+						log.Errorf("error downloading: %v: %v", fileURL.String(), err)
+						a.parseStats.error("%v", result.Error)
+						return
+					}
+					log.Debugf("[package][%v] Downloaded %v", pocket, filePath)
 				}
-				log.Debugf("[package][%v] Downloaded %v", pocket, filePath)
 			}
 
-			err := a.parsePackageIndex(packagesChan, fileName)
+			// the patched indexes are verified uncompressed, against the
+			// Packages.diff/Index
+			hash, err := fileHash(filePath)
+			if err != nil {
+				log.Errorf("failed to compute hash of %v: %v", filePath, err)
+				result.Error = fmt.Sprintf("failed to compute hash: %v", err)
+				return
+			}
+			if hash != expectedHash && !result.Patched {
+				// the file is downloaded again by the next refresh
+				forgetValidators(filePath)
+				log.Errorf("[package][%v] checksum mismatch for %v", pocket, fileURL.String())
+				a.parseStats.checksumFailure(fileURL.String())
+				result.Error = fmt.Sprintf("checksum mismatch for %v", fileURL.String())
+				return
+			}
+
+			result.Packages, err = a.parsePackageIndex(packagesChan, pocket, fileName)
 			if err != nil {
 				log.Errorf("failed to parse package index %v: %v", fileName, err)
+				result.Error = fmt.Sprintf("failed to parse: %v", err)
 			}
-		}(fileURL, outputFileName)
+		}(fileURL, outputFileName, fileInfo.Hash, newIndexResult(pocket, filePath))
 	}
 
 	wg.Wait()
 
-	return nbFile, nil
+	return results, nil
 }
 
-func (a *Archive) refreshCacheForPocket(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry, packagesChan chan *debianpkg.PackageInfo) (int, error) {
-	filesToDownload := make(map[string]ReleaseFileEntry)
+// indexUnchanged tells if an index is the same as in the previous refresh,
+// it's not downloaded again
+func (a *Archive) indexUnchanged(pocket, filePath string, fileInfo ReleaseFileEntry) bool {
+	if a.ReleaseInfo == nil {
+		return false
+	}
+	_, ok := a.ReleaseInfo[pocket]
+
+	return ok && fileInfo.Hash == a.ReleaseInfo[pocket].PackageIndex[filePath].Hash
+}
 
-	for filePath, info := range releaseInfo {
-		if strings.Contains(filePath, "Packages.gz") && !strings.Contains(filePath, "installer") {
-			filesToDownload[filePath] = info
+func (a *Archive) refreshCacheForPocket(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry, packagesChan chan *debianpkg.PackageInfo) ([]IndexResult, error) {
+	filesToDownload := a.selectPackagesIndexes(pocket, releaseInfo)
+	pdiffs := make(map[string]ReleaseFileEntry)
+
+	// the other compressions are downloaded if the index is missing
+	for filePath := range releaseInfo {
+		if !packagesRegexp.MatchString(filePath) {
+			continue
 		}
+		if index, ok := releaseInfo[path.Join(path.Dir(filePath), "Packages.diff", "Index")]; ok {
+			pdiffs[filePath] = index
+		}
+	}
+	a.setPDiffIndexes(pocket, pdiffs)
+
+	if len(filesToDownload) == 0 {
+		a.parseStats.emptySuite(pocket)
 	}
 
-	nbFile, err := a.DownloadIfNeeded(local, pocket, filesToDownload, packagesChan)
+	results, err := a.DownloadIfNeeded(local, pocket, filesToDownload, packagesChan)
 	if err != nil {
-		return nbFile, err
+		return results, err
 	}
-	return nbFile, nil
+
+	return a.retryFormats(local, pocket, releaseInfo, results, packagesChan)
 }
 
 // RefreshCache checks if the archive indexes have changed and
-// redownload them if needed
-func (a *Archive) RefreshCache(local bool) (int, int, error) {
-	newInfo, err := a.GetReleaseInfo(local)
+// redownload them if needed. Concurrent calls are serialized. The report
+// is never nil, the error lists all the failures of the report.
+func (a *Archive) RefreshCache(local bool) (*RefreshReport, error) {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	start := now()
+	a.parseStats = new(parseStatsCollector)
+	report := a.refreshCache(local)
+	err := report.Err()
+	a.setStatus(start, report, err)
+	if a.OnRefresh != nil {
+		a.OnRefresh(a.Status())
+	}
+
+	return report, err
+}
+
+func (a *Archive) refreshCache(local bool) *RefreshReport {
+	report := &RefreshReport{
+		Archive: a.Name,
+		Indexes: make([]IndexResult, 0),
+	}
+
+	a.resolvePockets()
+
+	newInfo, refused, err := a.getReleaseInfo(local)
 	if err != nil {
-		return 0, 0, err
+		report.Errors = append(report.Errors, err.Error())
+		return report
 	}
+	report.Errors = append(report.Errors, refused...)
 	log.Debug("[release] finished processing release indexes")
 
-	totalNbFile := 0
+	indexes := make(map[string]map[string]ReleaseFileEntry, len(newInfo))
+	for pocket, release := range newInfo {
+		indexes[pocket] = a.selectIndexes(pocket, release)
+	}
 
 	packages := make(chan *debianpkg.PackageInfo, 1000)
+	reportLock := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
-	for _, pocket := range a.Pockets {
+	for _, pocket := range a.pocketList() {
 		wg.Add(1)
 		go func(p string) {
 			defer wg.Done()
-			var (
-				nbFile int
-				err    error
-			)
 
 			// if newInfo[p] does not exists, it means it hasn't changed, there
 			// is nothing to refresh
@@ -331,99 +825,259 @@ func (a *Archive) RefreshCache(local bool) (int, int, error) {
 				return
 			}
 
-			nbFile, err = a.refreshCacheForPocket(local, p, newInfo[p].PackageIndex, packages)
+			results, err := a.refreshCacheForPocket(local, p, indexes[p], packages)
 			log.Debugf("[packages][%v] refreshed", p)
+
+			reportLock.Lock()
+			defer reportLock.Unlock()
+			report.Indexes = append(report.Indexes, results...)
 			if err != nil {
 				log.Error(err)
-				return
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", p, err))
 			}
-			totalNbFile += nbFile
 		}(pocket)
 	}
 
-	done := make(chan struct{})
-	stats := make(chan int)
-	go a.updatePackageInfo(packages, done, stats)
+	notify := a.OnChange != nil
+	if notify {
+		// don't report the whole archive when populating the database
+		nbPackages, err := a.Database.CountPackages()
+		notify = err == nil && nbPackages > 0
+	}
 
-	wg.Wait()
-	done <- struct{}{}
+	history := a.historyInfo(newInfo, indexes)
 
-	a.ReleaseInfo = newInfo
+	stats := make(chan packageStats)
+	go a.updatePackageInfo(packages, notify, history, stats)
 
-	return totalNbFile, <-stats, nil
-}
+	wg.Wait()
+	close(packages)
+	update := <-stats
+	report.UpdatedPackages = update.inserted
+	report.Files = len(report.Indexes)
+	report.sortIndexes()
+	if update.err != nil {
+		report.Errors = append(report.Errors, update.err.Error())
+		// the packages of these indexes may be missing from the database,
+		// they are restored by mergeReleaseInfo and retried
+		for i, index := range report.Indexes {
+			if index.Success && update.failed[index.Suite] && packagesRegexp.MatchString(index.path) {
+				report.Indexes[i].Success = false
+				report.Indexes[i].Error = update.err.Error()
+			}
+		}
+	}
 
-func uncompressFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+	// the pockets whose Contents, Translation or Sources indexes failed
+	extrasFailed := make(map[string]bool)
+	if a.Contents {
+		for _, pocket := range a.pocketList() {
+			if _, ok := newInfo[pocket]; !ok {
+				continue
+			}
+
+			nbFile, err := a.refreshContents(local, pocket, indexes[pocket])
+			if err != nil {
+				extrasFailed[pocket] = true
+				log.Errorf("[contents][%v] failed to refresh contents: %v", pocket, err)
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: failed to refresh contents: %v", pocket, err))
+			}
+			report.Files += nbFile
+		}
 	}
-	defer file.Close()
-	gzipReader, err := gzip.NewReader(file)
-	if err != nil {
-		return "", err
+
+	if len(a.Translations) != 0 {
+		for _, pocket := range a.pocketList() {
+			if _, ok := newInfo[pocket]; !ok {
+				continue
+			}
+
+			nbFile, err := a.refreshTranslations(local, pocket, indexes[pocket])
+			if err != nil {
+				extrasFailed[pocket] = true
+				log.Errorf("[translations][%v] failed to refresh translations: %v", pocket, err)
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: failed to refresh translations: %v", pocket, err))
+			}
+			report.Files += nbFile
+		}
 	}
-	defer gzipReader.Close()
 
-	result, err := io.ReadAll(gzipReader)
-	if err != nil {
-		return "", err
+	if a.Sources {
+		for _, pocket := range a.pocketList() {
+			if _, ok := newInfo[pocket]; !ok {
+				continue
+			}
+
+			nbFile, err := a.refreshSources(local, pocket, indexes[pocket])
+			if err != nil {
+				extrasFailed[pocket] = true
+				log.Errorf("[sources][%v] failed to refresh sources: %v", pocket, err)
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: failed to refresh sources: %v", pocket, err))
+			}
+			report.Files += nbFile
+		}
 	}
 
-	return string(result), nil
+	a.ReleaseInfo = mergeReleaseInfo(a.ReleaseInfo, newInfo, report, extrasFailed)
+
+	return report
 }
 
-// parsePackageIndexFile extracts the package information from an index of packages
-func parsePackageIndexFile(out chan *debianpkg.PackageInfo, rawBody, suite, pocket, component, arch string) error {
-	packageInfo := strings.Split(rawBody, "\n\n")
+// mergeReleaseInfo returns the Release files describing what is ingested
+// after a refresh: the pockets that didn't change keep their previous
+// Release file, and the indexes that failed keep their previous entry (or
+// none) so that the next refresh retries them. The Release files of the
+// pockets with failures have no hash, they are parsed again by the next
+// refresh even if they didn't change.
+func mergeReleaseInfo(previous, refreshed map[string]*ReleaseFile, report *RefreshReport, extrasFailed map[string]bool) map[string]*ReleaseFile {
+	merged := make(map[string]*ReleaseFile, len(previous)+len(refreshed))
+	for pocket, release := range previous {
+		merged[pocket] = release
+	}
 
-	for _, info := range packageInfo {
-		infoLines := strings.Split(info, "\n")
-		pkgName := ""
+	failed := make(map[string][]string)
+	for _, index := range report.Failed() {
+		failed[index.Suite] = append(failed[index.Suite], index.path)
+	}
 
-		var pkgInfo *debianpkg.PackageInfo
+	for pocket, release := range refreshed {
+		merged[pocket] = release
+		if len(failed[pocket]) == 0 && !extrasFailed[pocket] {
+			continue
+		}
 
-		for _, line := range infoLines {
-			cleanLine := strings.Trim(line, "\n")
-			if cleanLine == "" {
-				continue
+		old := map[string]ReleaseFileEntry{}
+		if previous[pocket] != nil {
+			old = previous[pocket].PackageIndex
+		}
+		restore := func(filePath string) {
+			if entry, ok := old[filePath]; ok {
+				release.PackageIndex[filePath] = entry
+			} else {
+				delete(release.PackageIndex, filePath)
 			}
+		}
 
-			rawAttribute := strings.Split(cleanLine, ": ")
-
-			if len(rawAttribute) < 2 {
-				//fmt.Printf("failed to parse property: %#v\n", rawAttribute)
-				continue
-			}
-			key := strings.Clone(rawAttribute[0])
-			value := strings.Clone(strings.TrimPrefix(cleanLine, fmt.Sprintf("%v: ", key)))
-
-			// here we assume that we see Package before any other field
-			// it makes sense but it's also not very safe
-			if key == "Package" {
-				pkgName = value
-				pkgInfo = &debianpkg.PackageInfo{
-					Name:         pkgName,
-					Component:    component,
-					Suite:        suite,
-					Pocket:       pocket,
-					Architecture: arch,
+		for _, filePath := range failed[pocket] {
+			restore(filePath)
+		}
+		if extrasFailed[pocket] {
+			// the Contents, Translation and Sources indexes are not tracked one
+			// by one, they are all retried
+			for filePath := range release.PackageIndex {
+				if !strings.Contains(filePath, "Packages") {
+					restore(filePath)
 				}
 			}
-			if pkgInfo != nil {
-				err := pkgInfo.Set(key, value)
-				if err != nil {
-					log.Debugf("[package] error reading maintainer info (%v): %v", pkgInfo.Name, err)
-				}
+		}
+		release.Hash = ""
+	}
+
+	return merged
+}
+
+// parsePackageIndexFile extracts the package information from an index of packages
+// anomalies are recorded in stats (which can be nil)
+func parsePackageIndexFile(out chan *debianpkg.PackageInfo, rawBody, suite, pocket, component, arch string, stats *parseStatsCollector) error {
+	_, err := parsePackageIndexReader(out, strings.NewReader(rawBody), suite, pocket, component, arch, stats)
+	return err
+}
+
+// parsePackageIndexReader is parsePackageIndexFile reading the index as a
+// stream, only one stanza is kept in memory. It returns the number of
+// packages sent to out.
+func parsePackageIndexReader(out chan *debianpkg.PackageInfo, r io.Reader, suite, pocket, component, arch string, stats *parseStatsCollector) (int, error) {
+	reader := bufio.NewReader(r)
+	stanza := make([]string, 0, 32)
+	nbPackages := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nbPackages, err
+		}
+		eof := err == io.EOF
+
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			stanza = append(stanza, line)
+		}
+		// stanzas are separated by empty lines
+		if (line == "" || eof) && len(stanza) != 0 {
+			if parseStanza(out, stanza, suite, pocket, component, arch, stats) {
+				nbPackages++
 			}
+			stanza = stanza[:0]
+		}
+
+		if eof {
+			return nbPackages, nil
 		}
+	}
+}
 
+// parseStanza sends the package described by the lines of a stanza to out,
+// it returns false if the stanza doesn't describe a package
+func parseStanza(out chan *debianpkg.PackageInfo, infoLines []string, suite, pocket, component, arch string, stats *parseStatsCollector) bool {
+	pkgName := ""
+
+	var pkgInfo *debianpkg.PackageInfo
+
+	for _, line := range infoLines {
+		cleanLine := strings.Trim(line, "\n")
+		if cleanLine == "" {
+			continue
+		}
+		// continuation of a multiline field
+		if cleanLine[0] == ' ' || cleanLine[0] == '\t' {
+			continue
+		}
+
+		rawAttribute := strings.Split(cleanLine, ": ")
+
+		if len(rawAttribute) < 2 {
+			//fmt.Printf("failed to parse property: %#v\n", rawAttribute)
+			continue
+		}
+		key := strings.Clone(rawAttribute[0])
+		value := strings.Clone(strings.TrimPrefix(cleanLine, fmt.Sprintf("%v: ", key)))
+
+		// here we assume that we see Package before any other field
+		// it makes sense but it's also not very safe
+		if key == "Package" {
+			pkgName = value
+			pkgInfo = &debianpkg.PackageInfo{
+				Name:         pkgName,
+				Component:    component,
+				Suite:        suite,
+				Pocket:       pocket,
+				Architecture: arch,
+			}
+		}
+		if key == "Architecture" && arch == "" && pkgInfo != nil {
+			// the indexes of the flat repositories mix the architectures
+			pkgInfo.Architecture = value
+		}
+		if !knownFields[key] {
+			stats.unknownField()
+		}
 		if pkgInfo != nil {
-			out <- pkgInfo
+			err := pkgInfo.Set(key, value)
+			if err != nil {
+				log.Debugf("[package] error reading maintainer info (%v): %v", pkgInfo.Name, err)
+				stats.error("%v%v/%v/%v: %v: %v", suite, pocket, component, arch, pkgInfo.Name, err)
+			}
 		}
 	}
 
-	return nil
+	if pkgInfo != nil {
+		out <- pkgInfo
+		return true
+	}
+	if strings.TrimSpace(strings.Join(infoLines, "")) != "" {
+		stats.skippedStanza()
+	}
+
+	return false
 }
 
 // getInfoFromIndexName parses the name of a local index file and returns
@@ -443,59 +1097,247 @@ func getInfoFromIndexName(name string) (string, string, string, string, error) {
 	component := parts[1]
 	binaryArch := parts[2]
 
-	suitePocketList := strings.Split(suitePocket, "-")
-	suite := suitePocketList[0]
-	pocket := ""
-	if len(suitePocketList) > 1 {
-		pocket = "-" + strings.Join(suitePocketList[1:], "-")
-	}
+	suite, pocket := splitSuitePocket(suitePocket)
 	arch := strings.Split(binaryArch, "-")[1]
 
 	return suite, pocket, component, arch, nil
 }
 
-func (a *Archive) parsePackageIndex(out chan *debianpkg.PackageInfo, file string) error {
-	filePath := path.Join(a.CacheDir, file)
-	textFile, err := uncompressFile(filePath)
-	if err != nil {
-		return err
+func (a *Archive) parsePackageIndex(out chan *debianpkg.PackageInfo, suitePocket, file string) (int, error) {
+	var suite, pocket, component, arch string
+	if a.isFlat() {
+		// no component, the architecture is the one of each package
+		suite, pocket = splitSuitePocket(suitePocket)
+	} else {
+		var err error
+		suite, pocket, component, arch, err = getInfoFromIndexName(file)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	suite, pocket, component, arch, err := getInfoFromIndexName(file)
+	indexReader, err := openIndex(path.Join(a.CacheDir, file))
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer indexReader.Close()
 
-	return parsePackageIndexFile(out, textFile, suite, pocket, component, arch)
+	return parsePackageIndexReader(out, indexReader, suite, pocket, component, arch, a.parseStats)
 }
 
-func (a *Archive) updatePackageInfo(packages chan *debianpkg.PackageInfo, done chan struct{}, stats chan int) {
+// pocketHistory tells when the packages of a pocket are seen
+type pocketHistory struct {
+	// seen is the date of the Release file
+	seen time.Time
+	// initialImport is true if the pocket has never been indexed
+	initialImport bool
+	// unchanged are the Packages indexes not parsed again, the last time
+	// their versions were seen is updated without them
+	unchanged []IndexResult
+}
+
+// historyInfo returns the history information of the pockets refreshed.
+// The pockets whose Release file didn't change have no new release, their
+// versions stay current without being seen again.
+func (a *Archive) historyInfo(releaseInfo map[string]*ReleaseFile, indexes map[string]map[string]ReleaseFileEntry) map[string]pocketHistory {
+	history := make(map[string]pocketHistory, len(releaseInfo))
+	for pocket, info := range releaseInfo {
+		seen := info.Date
+		if seen.IsZero() {
+			seen = a.Now()
+		}
+
+		suite, suffix := splitSuitePocket(pocket)
+		known, err := a.Database.HasHistory(suite, suffix)
+		if err != nil {
+			log.Errorf("[history][%v] failed to read history: %v", pocket, err)
+		}
+
+		unchanged := make([]IndexResult, 0)
+		for filePath, fileInfo := range a.selectPackagesIndexes(pocket, indexes[pocket]) {
+			if a.indexUnchanged(pocket, filePath, fileInfo) {
+				unchanged = append(unchanged, newIndexResult(pocket, filePath))
+			}
+		}
+
+		history[pocket] = pocketHistory{seen, !known, unchanged}
+	}
+
+	return history
+}
+
+// packageStats is the outcome of updatePackageInfo
+type packageStats struct {
+	inserted int
+	// err is set when packages were lost, the Packages indexes of the
+	// pockets in failed must be retried
+	err    error
+	failed map[string]bool
+}
+
+// updatePackageInfo inserts the packages in the database until the
+// channel is closed, then sends the number of packages inserted to stats.
+// If notify is set, OnChange is called for the new versions once they are
+// committed, so the lookups made on the changes see them. The versions
+// are recorded in the history of their pocket, and the Release files of
+// the pockets with packages in the releases.
+func (a *Archive) updatePackageInfo(packages chan *debianpkg.PackageInfo, notify bool, history map[string]pocketHistory, stats chan packageStats) {
+	result := packageStats{}
 	insertedPkg := 0
+	// the pockets of the packages recorded in the history
+	recorded := make(map[string]bool)
+	// the changes of the transaction in progress
+	changes := make([]PackageChange, 0)
+	// the transactions of the refresh make one generation
+	a.Database.BeginRefresh()
+	defer a.Database.EndRefresh()
+
+	commit := func() {
+		err := a.Database.InsertPrepared()
+		if err != nil {
+			log.Errorf("transaction failed: %v", err)
+			if len(changes) != 0 {
+				log.Errorf("%v changes not notified", len(changes))
+			}
+		} else {
+			for _, change := range changes {
+				a.OnChange(change)
+			}
+		}
+		changes = changes[:0]
+	}
 
-	for {
-		select {
-		case pkg := <-packages:
-			err := a.Database.PrepareInsertPackage(pkg)
+	insert := func(pkg *debianpkg.PackageInfo) {
+		var (
+			change  PackageChange
+			changed bool
+		)
+		if notify {
+			change, changed = a.packageChange(pkg)
+		}
+
+		if pocketInfo, ok := history[pkg.Suite+pkg.Pocket]; ok {
+			err := a.Database.PrepareInsertHistory(pkg, pocketInfo.seen, pocketInfo.initialImport)
+			if err != nil {
+				log.Errorf("failed to insert history of %v: %v", pkg.Name, err)
+			} else {
+				recorded[pkg.Suite+pkg.Pocket] = true
+			}
+		}
+
+		err := beforeDBWrite()
+		if err == nil {
+			err = a.Database.PrepareInsertPackage(pkg)
+		}
 
-			insertedPkg++
+		insertedPkg++
 
+		if err != nil {
+			log.Errorf("failed to insert package %v in db: %v", pkg.Name, err)
+		} else if changed {
+			changes = append(changes, change)
+		}
+		if insertedPkg%10000 == 0 {
+			log.Debugf("Inserted %v packages", insertedPkg)
+			commit()
+		}
+	}
+
+	// under memory pressure, the packages are written to disk so the
+	// parsers are not blocked by the database (holding their indexes in
+	// memory) and inserted at the end
+	var spill *spiller
+	spilling := false
+	// the pockets with packages in the runs
+	spilled := make(map[string]bool)
+	received := 0
+	for pkg := range packages {
+		if spill == nil && a.shouldSpill(received) {
+			var err error
+			spill, err = newSpiller(a.CacheDir)
 			if err != nil {
-				log.Errorf("failed to insert package %v in db: %v", pkg.Name, err)
+				log.Errorf("[spill][%v] cannot spill to disk: %v", a.Name, err)
+			} else {
+				log.Infof("[spill][%v] spilling packages to %v", a.Name, spill.dir)
+				spilling = true
 			}
-			if insertedPkg%10000 == 0 {
-				log.Debugf("Inserted %v packages", insertedPkg)
-				err := a.Database.InsertPrepared()
-				if err != nil {
-					log.Errorf("transaction failed: %v", err)
-				}
+		}
+		received++
+
+		if spilling {
+			spilled[pkg.Suite+pkg.Pocket] = true
+			err := spill.add(pkg)
+			if err != nil {
+				// the buffer (with pkg) is inserted now, the runs
+				// already written are merged at the end
+				log.Errorf("[spill][%v] failed to write run, spilling is disabled: %v", a.Name, err)
+				spill.drain(insert)
+				spilling = false
 			}
-		case <-done:
-			err := a.Database.InsertPrepared()
+			continue
+		}
+		insert(pkg)
+	}
+
+	if spill != nil {
+		err := spill.merge(insert)
+		if err != nil {
+			log.Errorf("[spill][%v] failed to merge runs: %v", a.Name, err)
+			result.err = fmt.Errorf("failed to merge the spilled packages: %v", err)
+			result.failed = spilled
+		}
+		spill.close()
+	}
+
+	bumped := false
+	for pocket, info := range history {
+		suite, suffix := splitSuitePocket(pocket)
+		for _, index := range info.unchanged {
+			err := a.Database.PrepareBumpHistory(suite, suffix, index.Component, index.Architecture, info.seen)
 			if err != nil {
-				log.Errorf("transaction failed: %v", err)
+				log.Errorf("[history][%v] failed to update history of %v: %v", pocket, index, err)
 			}
-			stats <- insertedPkg
-			return
+			bumped = true
 		}
 	}
+
+	// a pocket without packages is not recorded, the versions missing
+	// from a broken index would be seen as removed
+	for pocket := range recorded {
+		suite, suffix := splitSuitePocket(pocket)
+		err := a.Database.PrepareInsertRelease(suite, suffix, history[pocket].seen)
+		if err != nil {
+			log.Errorf("[history][%v] failed to insert release: %v", pocket, err)
+		}
+	}
+
+	if insertedPkg%10000 != 0 || len(recorded) != 0 || bumped {
+		commit()
+	}
+	result.inserted = insertedPkg
+	stats <- result
+}
+
+// packageChange returns the change to notify if the version of pkg differs
+// from the one in the database
+func (a *Archive) packageChange(pkg *debianpkg.PackageInfo) (PackageChange, bool) {
+	oldVersion, err := a.Database.PackageVersion(pkg)
+	if err != nil {
+		log.Errorf("failed to get current version of %v: %v", pkg.Name, err)
+		return PackageChange{}, false
+	}
+	if oldVersion == pkg.Version {
+		return PackageChange{}, false
+	}
+
+	return PackageChange{
+		Archive:      a.Name,
+		Name:         pkg.Name,
+		Suite:        pkg.Suite + pkg.Pocket,
+		Component:    pkg.Component,
+		Architecture: pkg.Architecture,
+		OldVersion:   oldVersion,
+		Version:      pkg.Version,
+		Time:         a.Now(),
+	}, true
 }
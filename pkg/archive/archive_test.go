@@ -2,9 +2,13 @@ package archive
 
 import (
 	"io"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gjolly/go-rmadison/pkg/database"
 	"github.com/gjolly/go-rmadison/pkg/debianpkg"
 )
 
@@ -30,10 +34,30 @@ func TestParseReleaseFile(t *testing.T) {
 		}
 	}
 
+	expectedDate := time.Date(2023, time.October, 26, 14, 32, 18, 0, time.UTC)
+	if !releaseFile.Date.Equal(expectedDate) {
+		t.Error("wrong date: expected", expectedDate, "got", releaseFile.Date)
+	}
+
 	if releaseFile.Codename != "noble" {
 		t.Error("wrong codename: expected noble, got ", releaseFile.Codename)
 	}
 
+	if !releaseFile.AcquireByHash {
+		t.Error("Acquire-By-Hash not detected")
+	}
+	if releaseFile.NotAutomatic {
+		t.Error("unexpected NotAutomatic")
+	}
+
+	backports, err := parseRelease([]byte("Suite: noble-backports\nNotAutomatic: yes\nButAutomaticUpgrades: yes\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backports.NotAutomatic || !backports.ButAutomaticUpgrades {
+		t.Error("NotAutomatic and ButAutomaticUpgrades not detected")
+	}
+
 	components := []string{"main", "restricted", "universe", "multiverse"}
 	for iComponent, component := range components {
 		if releaseFile.Components[iComponent] != component {
@@ -88,7 +112,7 @@ func TestParsePackageIndexFile(t *testing.T) {
 		}
 	}()
 
-	err = parsePackageIndexFile(pkgInfo, string(fileContent), "jammy", "", "main", "amd64")
+	err = parsePackageIndexFile(pkgInfo, string(fileContent), "jammy", "", "main", "amd64", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -158,3 +182,197 @@ func TestGetInfoFromIndexName(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandPockets(t *testing.T) {
+	suites := []string{"jammy", "jammy-updates", "jammy-proposed", "noble", "noble-updates", "devel"}
+
+	pockets := expandPockets([]string{"noble*", "jammy-updates", "xenial"}, suites)
+	expected := []string{"jammy-updates", "noble", "noble-updates", "xenial"}
+
+	if len(pockets) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, pockets)
+	}
+	for i := range expected {
+		if pockets[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], pockets[i])
+		}
+	}
+}
+
+func TestParseContents(t *testing.T) {
+	contents := `usr/bin/gcc                                             devel/gcc
+usr/share/doc/file with spaces                          universe/doc/foo,universe/doc/bar
+`
+
+	type entry struct {
+		Path      string
+		Package   string
+		Component string
+	}
+	entries := make([]entry, 0)
+	err := parseContents(strings.NewReader(contents), func(path, pkg, component string) error {
+		entries = append(entries, entry{path, pkg, component})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []entry{
+		{"usr/bin/gcc", "gcc", "main"},
+		{"usr/share/doc/file with spaces", "foo", "universe"},
+		{"usr/share/doc/file with spaces", "bar", "universe"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %v entries, got %v", len(expected), len(entries))
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], entries[i])
+		}
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	sources := `Package: hello
+Binary: hello
+Version: 2.10-3build1
+Maintainer: Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>
+Directory: pool/main/h/hello
+Checksums-Sha256:
+ 0a1e4b4e2c2e1b0b7e6a2b5f7b3c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c 1847 hello_2.10-3build1.dsc
+ 31e066137a962676e89f69d1b65382de95a7ef7d914b8cb956f41ea72e0f516b 725946 hello_2.10.orig.tar.gz
+
+Package: systemd
+Binary: systemd, udev,
+ libsystemd0
+Version: 255.4-1ubuntu8
+Directory: pool/main/s/systemd
+`
+
+	srcs := make([]*database.SourcePackage, 0)
+	err := parseSources(strings.NewReader(sources), func(src *database.SourcePackage) error {
+		srcs = append(srcs, src)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srcs) != 2 {
+		t.Fatalf("expected 2 source packages, got %v", len(srcs))
+	}
+	hello := srcs[0]
+	if hello.Name != "hello" || hello.Version != "2.10-3build1" || hello.Directory != "pool/main/h/hello" || len(hello.Files) != 2 {
+		t.Errorf("unexpected source package %+v", hello)
+	}
+	if dsc := hello.DSC(); dsc == nil || dsc.Name != "hello_2.10-3build1.dsc" || dsc.Size != 1847 {
+		t.Errorf("unexpected .dsc %+v", dsc)
+	}
+	if binaries := strings.Join(srcs[1].Binaries, " "); binaries != "systemd udev libsystemd0" {
+		t.Errorf("unexpected binaries %q", binaries)
+	}
+
+	a := &Archive{BaseURL: &url.URL{Scheme: "http", Host: "archive.ubuntu.com", Path: "/ubuntu/dists"}}
+	if fileURL := a.SourceFileURL(hello, "hello_2.10-3build1.dsc"); fileURL != "http://archive.ubuntu.com/ubuntu/pool/main/h/hello/hello_2.10-3build1.dsc" {
+		t.Errorf("unexpected URL %v", fileURL)
+	}
+}
+
+func TestMergeReleaseInfo(t *testing.T) {
+	previous := map[string]*ReleaseFile{
+		"noble": {Hash: "r1", PackageIndex: map[string]ReleaseFileEntry{
+			"main/binary-amd64/Packages.gz": {Hash: "a1"},
+		}},
+		"noble-updates": {Hash: "u1", PackageIndex: map[string]ReleaseFileEntry{
+			"main/binary-amd64/Packages.gz": {Hash: "b1"},
+			"main/binary-arm64/Packages.gz": {Hash: "c1"},
+		}},
+	}
+	refreshed := map[string]*ReleaseFile{
+		"noble-updates": {Hash: "u2", PackageIndex: map[string]ReleaseFileEntry{
+			"main/binary-amd64/Packages.gz": {Hash: "b2"},
+			"main/binary-arm64/Packages.gz": {Hash: "c2"},
+			"main/binary-s390x/Packages.gz": {Hash: "d2"},
+		}},
+	}
+	report := &RefreshReport{Indexes: []IndexResult{
+		newIndexResult("noble-updates", "main/binary-amd64/Packages.gz"),
+		newIndexResult("noble-updates", "main/binary-arm64/Packages.gz"),
+		newIndexResult("noble-updates", "main/binary-s390x/Packages.gz"),
+	}}
+	report.Indexes[0].Success = true
+	report.Indexes[1].Error = "failed to download"
+	report.Indexes[2].Error = "failed to download"
+
+	merged := mergeReleaseInfo(previous, refreshed, report, nil)
+
+	if merged["noble"] != previous["noble"] {
+		t.Error("the unchanged pocket wasn't kept")
+	}
+	updates := merged["noble-updates"]
+	if updates.Hash != "" {
+		t.Errorf("the pocket with failures kept its hash %q", updates.Hash)
+	}
+	expected := map[string]string{
+		"main/binary-amd64/Packages.gz": "b2",
+		"main/binary-arm64/Packages.gz": "c1",
+	}
+	if len(updates.PackageIndex) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, updates.PackageIndex)
+	}
+	for filePath, hash := range expected {
+		if updates.PackageIndex[filePath].Hash != hash {
+			t.Errorf("%v: expected %v, got %v", filePath, hash, updates.PackageIndex[filePath].Hash)
+		}
+	}
+}
+
+func TestByHashURL(t *testing.T) {
+	fileURL, err := url.Parse("http://archive.ubuntu.com/ubuntu/dists/noble/main/binary-amd64/Packages.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byHash := byHashURL(*fileURL, "0123abcd")
+	expected := "http://archive.ubuntu.com/ubuntu/dists/noble/main/binary-amd64/by-hash/SHA256/0123abcd"
+	if byHash.String() != expected {
+		t.Errorf("expected %v, got %v", expected, byHash.String())
+	}
+}
+
+func TestReleaseAlias(t *testing.T) {
+	pockets := []string{"bookworm", "bookworm-updates", "stable", "stable-updates", "noble", "noble-updates", "devel", "sid", "unstable"}
+	testCases := []struct {
+		pocket    string
+		release   ReleaseFile
+		canonical string
+	}{
+		{"stable", ReleaseFile{Suite: "stable", Codename: "bookworm"}, "bookworm"},
+		{"stable-updates", ReleaseFile{Suite: "stable-updates", Codename: "bookworm-updates"}, "bookworm-updates"},
+		{"bookworm-updates", ReleaseFile{Suite: "stable-updates", Codename: "bookworm-updates"}, ""},
+		{"noble-updates", ReleaseFile{Suite: "noble-updates", Codename: "noble"}, ""},
+		{"devel", ReleaseFile{Suite: "noble", Codename: "noble"}, "noble"},
+		{"unstable", ReleaseFile{Suite: "unstable", Codename: "sid"}, "sid"},
+		{"sid", ReleaseFile{Suite: "unstable", Codename: "sid"}, ""},
+		// the canonical pocket isn't indexed
+		{"testing", ReleaseFile{Suite: "testing", Codename: "trixie"}, ""},
+	}
+
+	for _, testCase := range testCases {
+		canonical, ok := releaseAlias(testCase.pocket, &testCase.release, pockets)
+		if canonical != testCase.canonical || ok != (testCase.canonical != "") {
+			t.Errorf("%v: expected %q, got %q", testCase.pocket, testCase.canonical, canonical)
+		}
+	}
+}
+
+func TestReleasePockets(t *testing.T) {
+	pockets := releasePockets([]string{"noble"}, []string{"", "-updates"})
+	if len(pockets) != 2 || pockets[0] != "noble" || pockets[1] != "noble-updates" {
+		t.Errorf("expected noble and noble-updates, got %v", pockets)
+	}
+	if pockets := releasePockets([]string{"jammy"}, nil); len(pockets) != len(DefaultPocketSuffixes) {
+		t.Errorf("expected the default suffixes, got %v", pockets)
+	}
+}
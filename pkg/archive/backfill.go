@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+// backfill sources
+const (
+	// BackfillSnapshot uses the machine-readable API of snapshot.debian.org
+	// (https://snapshot.debian.org/mr/), suites are unknown
+	BackfillSnapshot = "snapshot"
+	// BackfillLaunchpad uses the publishing history of a Launchpad archive
+	BackfillLaunchpad = "launchpad"
+)
+
+// default URLs of the backfill sources
+const (
+	DefaultSnapshotURL  = "https://snapshot.debian.org"
+	DefaultLaunchpadURL = "https://api.launchpad.net/1.0/ubuntu/+archive/primary"
+)
+
+// BackfillConfig configures the import of the history of packages from
+// before the archive was indexed
+type BackfillConfig struct {
+	// Source is BackfillSnapshot or BackfillLaunchpad
+	Source string `yaml:"source" json:"source"`
+	// URL overrides the default URL of the source
+	URL      string   `yaml:"url" json:"url"`
+	Packages []string `yaml:"packages" json:"packages"`
+	// Interval is the minimum time between two requests to the source
+	Interval time.Duration `yaml:"interval" json:"interval"`
+}
+
+// backfillInterval is the time between two requests when Interval is not
+// set, the snapshot services ask clients to be gentle
+const backfillInterval = 2 * time.Second
+
+// Backfill imports the history of the configured packages. Requests are
+// throttled and the packages already imported (in a previous run) are
+// skipped, so it can be interrupted and resumed.
+func (a *Archive) Backfill(conf *BackfillConfig) error {
+	interval := conf.Interval
+	if interval <= 0 {
+		interval = backfillInterval
+	}
+	throttle := time.NewTicker(interval)
+	defer throttle.Stop()
+	wait := func() { <-throttle.C }
+
+	var fetch func(name string, wait func()) ([]*database.HistoryEntry, error)
+	switch conf.Source {
+	case BackfillSnapshot:
+		baseURL := conf.URL
+		if baseURL == "" {
+			baseURL = DefaultSnapshotURL
+		}
+		fetch = func(name string, wait func()) ([]*database.HistoryEntry, error) {
+			return a.snapshotHistory(strings.TrimRight(baseURL, "/"), name, wait)
+		}
+	case BackfillLaunchpad:
+		baseURL := conf.URL
+		if baseURL == "" {
+			baseURL = DefaultLaunchpadURL
+		}
+		fetch = func(name string, wait func()) ([]*database.HistoryEntry, error) {
+			return a.launchpadHistory(strings.TrimRight(baseURL, "/"), name, wait)
+		}
+	default:
+		return fmt.Errorf("unknown backfill source %q", conf.Source)
+	}
+
+	for _, name := range conf.Packages {
+		done, err := a.Database.BackfillDone(name)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+
+		entries, err := fetch(name, wait)
+		if err != nil {
+			log.Errorf("[backfill][%v] failed to fetch history: %v", name, err)
+			continue
+		}
+
+		err = a.Database.InsertHistory(entries)
+		if err != nil {
+			return err
+		}
+		err = a.Database.SetBackfillDone(name, time.Now())
+		if err != nil {
+			return err
+		}
+		log.Infof("[backfill][%v] %v versions imported", name, len(entries))
+	}
+
+	return nil
+}
+
+// getJSON fetches a JSON document after waiting for the throttle
+func (a *Archive) getJSON(wait func(), rawURL string, result interface{}) error {
+	wait()
+
+	resp, err := a.Client.R().SetResult(result).Get(rawURL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("failed to fetch %v (%v)", rawURL, resp.Status())
+	}
+
+	return nil
+}
+
+// snapshotHistory returns when each version of a binary package was first
+// archived by snapshot.debian.org
+func (a *Archive) snapshotHistory(baseURL, name string, wait func()) ([]*database.HistoryEntry, error) {
+	versions := new(struct {
+		Result []struct {
+			BinaryVersion string `json:"binary_version"`
+			Source        string `json:"source"`
+			Version       string `json:"version"`
+		} `json:"result"`
+	})
+	err := a.getJSON(wait, fmt.Sprintf("%v/mr/binary/%v/", baseURL, url.PathEscape(name)), versions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*database.HistoryEntry, 0)
+	for _, version := range versions.Result {
+		files := new(struct {
+			Result []struct {
+				Architecture string `json:"architecture"`
+				Hash         string `json:"hash"`
+			} `json:"result"`
+			FileInfo map[string][]struct {
+				FirstSeen string `json:"first_seen"`
+				Path      string `json:"path"`
+			} `json:"fileinfo"`
+		})
+		filesURL := fmt.Sprintf("%v/mr/package/%v/%v/binfiles/%v/%v?fileinfo=1", baseURL,
+			url.PathEscape(version.Source), url.PathEscape(version.Version), url.PathEscape(name), url.PathEscape(version.BinaryVersion))
+		err = a.getJSON(wait, filesURL, files)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files.Result {
+			var firstSeen time.Time
+			component := ""
+			for _, info := range files.FileInfo[file.Hash] {
+				seen, err := time.Parse("20060102T150405Z", info.FirstSeen)
+				if err != nil {
+					continue
+				}
+				if firstSeen.IsZero() || seen.Before(firstSeen) {
+					firstSeen = seen
+					// /pool/main/c/curl
+					component = strings.Split(strings.TrimPrefix(info.Path, "/pool/"), "/")[0]
+				}
+			}
+			if firstSeen.IsZero() {
+				continue
+			}
+
+			entries = append(entries, &database.HistoryEntry{
+				Name:         name,
+				Version:      version.BinaryVersion,
+				Component:    component,
+				Architecture: file.Architecture,
+				FirstSeen:    firstSeen,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// launchpadHistory returns when each version of a binary package was
+// published in each suite of a Launchpad archive
+func (a *Archive) launchpadHistory(baseURL, name string, wait func()) ([]*database.HistoryEntry, error) {
+	query := url.Values{}
+	query.Set("ws.op", "getPublishedBinaries")
+	query.Set("binary_name", name)
+	query.Set("exact_match", "true")
+	nextURL := baseURL + "?" + query.Encode()
+
+	entries := make([]*database.HistoryEntry, 0)
+	for nextURL != "" {
+		page := new(struct {
+			Entries []struct {
+				Version              string `json:"binary_package_version"`
+				Component            string `json:"component_name"`
+				Pocket               string `json:"pocket"`
+				DistroArchSeriesLink string `json:"distro_arch_series_link"`
+				DatePublished        string `json:"date_published"`
+			} `json:"entries"`
+			NextCollectionLink string `json:"next_collection_link"`
+		})
+		err := a.getJSON(wait, nextURL, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page.Entries {
+			published, err := time.Parse(time.RFC3339, entry.DatePublished)
+			if err != nil {
+				// not published yet
+				continue
+			}
+
+			// https://api.launchpad.net/1.0/ubuntu/noble/amd64
+			parts := strings.Split(strings.TrimRight(entry.DistroArchSeriesLink, "/"), "/")
+			if len(parts) < 2 {
+				continue
+			}
+
+			pocket := ""
+			if entry.Pocket != "Release" {
+				pocket = "-" + strings.ToLower(entry.Pocket)
+			}
+
+			entries = append(entries, &database.HistoryEntry{
+				Name:         name,
+				Version:      entry.Version,
+				Component:    entry.Component,
+				Suite:        parts[len(parts)-2],
+				Pocket:       pocket,
+				Architecture: parts[len(parts)-1],
+				FirstSeen:    published,
+			})
+		}
+
+		nextURL = page.NextCollectionLink
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+)
+
+// setAcquireByHash records whether the Release file of a pocket advertises
+// the by-hash directories (Acquire-By-Hash: yes)
+func (a *Archive) setAcquireByHash(pocket string, byHash bool) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.byHash == nil {
+		a.byHash = make(map[string]bool)
+	}
+	a.byHash[pocket] = byHash
+}
+
+func (a *Archive) acquireByHash(pocket string) bool {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	return a.byHash[pocket]
+}
+
+// byHashURL returns the URL of an index in the by-hash directory next to
+// it (main/binary-amd64/by-hash/SHA256/HASH)
+func byHashURL(fileURL url.URL, hash string) url.URL {
+	fileURL.Path = path.Join(path.Dir(fileURL.Path), "by-hash", "SHA256", hash)
+
+	return fileURL
+}
+
+// downloadIndex downloads an index listed in the Release file of a pocket.
+// It's downloaded by hash when the pocket supports it: the file is the one
+// of the Release file even if the mirror is syncing and already replaced
+// the index. It falls back on the path of the index if the mirror doesn't
+// have the file by hash.
+func (a *Archive) downloadIndex(pocket string, fileURL url.URL, hash, outputFilePath string) error {
+	if hash != "" && a.acquireByHash(pocket) {
+		byHash := byHashURL(fileURL, hash)
+		_, err := downloadFile(a.Client, byHash, outputFilePath)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("[by-hash][%v] %v, falling back on %v", pocket, err, fileURL.String())
+	}
+
+	_, err := downloadFile(a.Client, fileURL, outputFilePath)
+
+	return err
+}
+
+// verifyIndex checks an index of the cache against the hash of the Release
+// file, a damaged index is downloaded again by the next refresh
+func (a *Archive) verifyIndex(fileURL url.URL, hash, outputFilePath string) error {
+	fileSum, err := fileHash(outputFilePath)
+	if err != nil {
+		return err
+	}
+	if fileSum != hash {
+		forgetValidators(outputFilePath)
+		a.parseStats.checksumFailure(fileURL.String())
+		return fmt.Errorf("checksum mismatch for %v", fileURL.String())
+	}
+
+	return nil
+}
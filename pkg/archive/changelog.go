@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// Changelog URL templates for the well-known archives, see ChangelogURL
+const (
+	UbuntuChangelogURL = "https://changelogs.ubuntu.com/changelogs/pool/{pool_dir}/{source}_{version}/changelog"
+	DebianChangelogURL = "https://metadata.ftp-master.debian.org/changelogs/{pool_dir}/{source}_{version}_changelog"
+)
+
+// stripEpoch removes the epoch of a version, it's not part of the file names
+func stripEpoch(version string) string {
+	if i := strings.Index(version, ":"); i != -1 {
+		return version[i+1:]
+	}
+
+	return version
+}
+
+// changelogURLTemplate returns the template configured for the archive or
+// guesses it from the archive URL
+func (a *Archive) changelogURLTemplate() string {
+	if a.ChangelogURL != "" {
+		return a.ChangelogURL
+	}
+
+	host := a.BaseURL.Hostname()
+	if strings.HasSuffix(host, "ubuntu.com") {
+		return UbuntuChangelogURL
+	}
+	if strings.HasSuffix(host, "debian.org") {
+		return DebianChangelogURL
+	}
+
+	return ""
+}
+
+// changelogLocation returns the URL of the changelog of the source of pkg
+// and the name of the file caching it
+func (a *Archive) changelogLocation(pkg *debianpkg.PackageInfo) (string, string, error) {
+	template := a.changelogURLTemplate()
+	if template == "" {
+		return "", "", fmt.Errorf("no changelog URL configured for archive %v", a.Name)
+	}
+
+	// pool/main/h/hello/hello_2.10-3_amd64.deb
+	poolDir := strings.TrimPrefix(path.Dir(pkg.FileName), "pool/")
+	if pkg.FileName == "" || poolDir == "." {
+		return "", "", fmt.Errorf("unknown pool location for %v", pkg.Name)
+	}
+
+	source, version := pkg.SourceNameVersion()
+	version = stripEpoch(version)
+
+	changelogURL := strings.NewReplacer(
+		"{pool_dir}", poolDir,
+		"{source}", source,
+		"{version}", version,
+	).Replace(template)
+
+	return changelogURL, fmt.Sprintf("%v_%v_changelog", source, version), nil
+}
+
+// Changelog returns the changelog of the source package pkg was built
+// from. Changelogs are downloaded once and cached in CacheDir.
+func (a *Archive) Changelog(pkg *debianpkg.PackageInfo) (string, error) {
+	changelogURL, fileName, err := a.changelogLocation(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir := path.Join(a.CacheDir, "changelogs")
+	filePath := path.Join(cacheDir, fileName)
+	if content, err := os.ReadFile(filePath); err == nil {
+		return string(content), nil
+	}
+
+	resp, err := a.Client.R().Get(changelogURL)
+	if err != nil {
+		return "", err
+	}
+	if resp.IsError() {
+		return "", fmt.Errorf("failed to fetch changelog from %v (%v)", changelogURL, resp.Status())
+	}
+
+	err = os.MkdirAll(cacheDir, 0o755)
+	if err == nil {
+		err = os.WriteFile(filePath, resp.Body(), 0o644)
+	}
+	if err != nil {
+		log.Errorf("failed to cache changelog %v: %v", fileName, err)
+	}
+
+	return resp.String(), nil
+}
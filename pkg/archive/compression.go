@@ -0,0 +1,267 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// packagesRegexp matches the Packages indexes listed in a Release file: the
+// directory of the index (main/binary-amd64, empty in the flat
+// repositories) and the compression
+var packagesRegexp = regexp.MustCompile(`^(?:(.+/binary-[^/]+)/)?Packages(\.gz|\.xz|\.bz2)?$`)
+
+// packagesCompressions are the compressions of the Packages, Sources and
+// Contents indexes by preference, "" is the uncompressed index. Gzip is the fastest to
+// decompress and the one of the pdiffs.
+var packagesCompressions = []string{".gz", ".xz", ".bz2", ""}
+
+// indexCandidates returns the files of an index by compression, in the
+// order of packagesCompressions
+func indexCandidates(files map[string]string) []string {
+	candidates := make([]string, 0, len(files))
+	for _, compression := range packagesCompressions {
+		if filePath, listed := files[compression]; listed {
+			candidates = append(candidates, filePath)
+		}
+	}
+
+	return candidates
+}
+
+// downloadCandidate downloads and verifies the first candidate of an index
+// found on the mirror, the other compressions are tried if it's missing.
+// It returns the candidate and the file of the cache.
+func (a *Archive) downloadCandidate(local bool, pocket string, baseURL url.URL, candidates []string, releaseInfo map[string]ReleaseFileEntry) (string, string, error) {
+	err := errors.Errorf("no index in %v", candidates)
+	for _, filePath := range candidates {
+		fileURL := baseURL
+		fileURL.Path = path.Join(fileURL.Path, pocket, filePath)
+		localFile := a.cachePath(fileURL)
+		if _, statErr := os.Stat(localFile); !local || os.IsNotExist(statErr) {
+			err = a.downloadIndex(pocket, fileURL, releaseInfo[filePath].Hash, localFile)
+			if errors.Is(err, errFileNotFound) {
+				log.Infof("[index][%v] %v is missing, trying the other compressions", pocket, filePath)
+				continue
+			}
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		return filePath, localFile, a.verifyIndex(fileURL, releaseInfo[filePath].Hash, localFile)
+	}
+
+	return "", "", err
+}
+
+// packagesIndexes returns the Packages indexes of a Release file by
+// directory and compression
+func packagesIndexes(releaseInfo map[string]ReleaseFileEntry) map[string]map[string]string {
+	available := make(map[string]map[string]string)
+	for filePath := range releaseInfo {
+		matches := packagesRegexp.FindStringSubmatch(filePath)
+		if matches == nil || strings.Contains(filePath, "installer") {
+			continue
+		}
+
+		if available[matches[1]] == nil {
+			available[matches[1]] = make(map[string]string)
+		}
+		available[matches[1]][matches[2]] = filePath
+	}
+
+	return available
+}
+
+// packagesCandidates returns the Packages indexes of a directory in the
+// order they are tried: the compression downloaded by the previous
+// refresh, then the ones of packagesCompressions
+func (a *Archive) packagesCandidates(pocket, dir string, files map[string]string) []string {
+	a.statusLock.Lock()
+	last, ok := a.packagesFormats[pocket][dir]
+	a.statusLock.Unlock()
+
+	candidates := make([]string, 0, len(files))
+	if filePath, listed := files[last]; ok && listed {
+		candidates = append(candidates, filePath)
+	}
+	for _, filePath := range indexCandidates(files) {
+		if !ok || filePath != files[last] {
+			candidates = append(candidates, filePath)
+		}
+	}
+
+	return candidates
+}
+
+// setPackagesFormat records the compression of a Packages index that was
+// downloaded, it's tried first by the next refreshes
+func (a *Archive) setPackagesFormat(pocket, filePath string) {
+	matches := packagesRegexp.FindStringSubmatch(filePath)
+	if matches == nil {
+		return
+	}
+
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.packagesFormats == nil {
+		a.packagesFormats = make(map[string]map[string]string)
+	}
+	if a.packagesFormats[pocket] == nil {
+		a.packagesFormats[pocket] = make(map[string]string)
+	}
+	a.packagesFormats[pocket][matches[1]] = matches[2]
+}
+
+// selectPackagesIndexes returns the Packages index to download in each
+// directory of a Release file, the first of its candidates
+func (a *Archive) selectPackagesIndexes(pocket string, releaseInfo map[string]ReleaseFileEntry) map[string]ReleaseFileEntry {
+	available := packagesIndexes(releaseInfo)
+
+	indexes := make(map[string]ReleaseFileEntry, len(available))
+	for dir, files := range available {
+		if candidates := a.packagesCandidates(pocket, dir, files); len(candidates) != 0 {
+			indexes[candidates[0]] = releaseInfo[candidates[0]]
+		}
+	}
+
+	return indexes
+}
+
+// nextPackagesIndex returns the candidate following filePath in its
+// directory, empty if all of them were tried
+func (a *Archive) nextPackagesIndex(pocket string, releaseInfo map[string]ReleaseFileEntry, filePath string, tried map[string]bool) string {
+	matches := packagesRegexp.FindStringSubmatch(filePath)
+	if matches == nil {
+		return ""
+	}
+
+	for _, candidate := range a.packagesCandidates(pocket, matches[1], packagesIndexes(releaseInfo)[matches[1]]) {
+		if !tried[candidate] {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// retryFormats downloads the Packages indexes missing from the mirror in
+// the other compressions listed by the Release file. The compressions
+// downloaded are recorded, the next refreshes try them first.
+func (a *Archive) retryFormats(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry, results []IndexResult, packagesChan chan *debianpkg.PackageInfo) ([]IndexResult, error) {
+	done := make([]IndexResult, 0, len(results))
+	tried := make(map[string]bool)
+	for len(results) != 0 {
+		retry := make(map[string]ReleaseFileEntry)
+		for _, result := range results {
+			tried[result.path] = true
+			if result.notFound {
+				if next := a.nextPackagesIndex(pocket, releaseInfo, result.path, tried); next != "" {
+					log.Infof("[package][%v] %v is missing, trying %v", pocket, result.path, next)
+					retry[next] = releaseInfo[next]
+					continue
+				}
+				log.Errorf("[package][%v] %v", pocket, result.Error)
+				a.parseStats.error("%v", result.Error)
+			}
+			if result.Success {
+				a.setPackagesFormat(pocket, result.path)
+			}
+			done = append(done, result)
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		var err error
+		results, err = a.DownloadIfNeeded(local, pocket, retry, packagesChan)
+		if err != nil {
+			return append(done, results...), err
+		}
+	}
+
+	return done, nil
+}
+
+// magic numbers of the compressed files
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	bzip2Magic = []byte("BZh")
+)
+
+// indexReader is the decompressed content of a file of the cache
+type indexReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *indexReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// decompress returns the content of a gzip, xz or bzip2 stream, or of an
+// uncompressed one. The compression is detected from the first bytes, not
+// from the name of the file: the patched indexes are always gzip.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return gzipReader, nil
+	case bytes.HasPrefix(header, xzMagic):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case bytes.HasPrefix(header, bzip2Magic):
+		return io.NopCloser(bzip2.NewReader(buffered)), nil
+	}
+
+	return io.NopCloser(buffered), nil
+}
+
+// openIndex opens a file of the cache and decompresses it
+func openIndex(filePath string) (io.ReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &indexReader{Reader: reader, closers: []io.Closer{file, reader}}, nil
+}
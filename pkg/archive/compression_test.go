@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/go-resty/resty/v2"
+	"github.com/ulikunitz/xz"
+)
+
+func TestSelectPackagesIndexes(t *testing.T) {
+	releaseInfo := map[string]ReleaseFileEntry{
+		"main/binary-amd64/Packages":                     {Hash: "a"},
+		"main/binary-amd64/Packages.gz":                  {Hash: "b"},
+		"main/binary-amd64/Packages.xz":                  {Hash: "c"},
+		"main/binary-arm64/Packages.xz":                  {Hash: "d"},
+		"main/binary-arm64/Packages.bz2":                 {Hash: "e"},
+		"main/binary-i386/Packages":                      {Hash: "f"},
+		"main/debian-installer/binary-amd64/Packages.gz": {Hash: "g"},
+		"main/i18n/Translation-en.gz":                    {Hash: "h"},
+	}
+
+	a := &Archive{}
+	check := func(expected []string) {
+		t.Helper()
+		indexes := a.selectPackagesIndexes("noble", releaseInfo)
+		if len(indexes) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, indexes)
+		}
+		for _, filePath := range expected {
+			if indexes[filePath] != releaseInfo[filePath] {
+				t.Errorf("%v is not selected", filePath)
+			}
+		}
+	}
+	check([]string{"main/binary-amd64/Packages.gz", "main/binary-arm64/Packages.xz", "main/binary-i386/Packages"})
+
+	// the other compressions are tried when an index is missing
+	tried := map[string]bool{"main/binary-amd64/Packages.gz": true}
+	next := a.nextPackagesIndex("noble", releaseInfo, "main/binary-amd64/Packages.gz", tried)
+	if next != "main/binary-amd64/Packages.xz" {
+		t.Errorf("unexpected fallback %q", next)
+	}
+	tried[next] = true
+	tried["main/binary-amd64/Packages"] = true
+	if next := a.nextPackagesIndex("noble", releaseInfo, "main/binary-amd64/Packages", tried); next != "" {
+		t.Errorf("unexpected fallback %q", next)
+	}
+
+	// and the one downloaded is tried first by the next refresh
+	a.setPackagesFormat("noble", "main/binary-amd64/Packages.xz")
+	check([]string{"main/binary-amd64/Packages.xz", "main/binary-arm64/Packages.xz", "main/binary-i386/Packages"})
+}
+
+func TestRetryFormats(t *testing.T) {
+	content := "Package: hello\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_1.0_amd64.deb\n"
+	xzed := new(bytes.Buffer)
+	xzWriter, err := xz.NewWriter(xzed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xzWriter.Write([]byte(content))
+	xzWriter.Close()
+
+	// the .gz is listed by the Release file but missing from the mirror
+	releaseInfo := map[string]ReleaseFileEntry{
+		"main/binary-amd64/Packages.gz": {Hash: fmt.Sprintf("%x", sha256.Sum256([]byte("missing")))},
+		"main/binary-amd64/Packages.xz": {Hash: fmt.Sprintf("%x", sha256.Sum256(xzed.Bytes()))},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ubuntu/dists/noble/main/binary-amd64/Packages.xz" {
+			w.Write(xzed.Bytes())
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/ubuntu/dists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archive{
+		BaseURL:    baseURL,
+		PortsURL:   baseURL,
+		Client:     resty.New(),
+		CacheDir:   t.TempDir(),
+		parseStats: new(parseStatsCollector),
+	}
+
+	pkgs := make(chan *debianpkg.PackageInfo, 10)
+	results, err := a.refreshCacheForPocket(false, "noble", releaseInfo, pkgs)
+	if err != nil || len(results) != 1 || !results[0].Success || results[0].Packages != 1 {
+		t.Fatalf("unexpected results %+v (%v)", results, err)
+	}
+	close(pkgs)
+	if pkg := <-pkgs; pkg == nil || pkg.Name != "hello" {
+		t.Errorf("unexpected package %+v", pkg)
+	}
+	// the missing .gz is not an error of the refresh
+	if errs := a.parseStats.snapshot().Errors; len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if format := a.packagesFormats["noble"]["main/binary-amd64"]; format != ".xz" {
+		t.Errorf("expected the .xz to be recorded, got %q", format)
+	}
+
+	// the index is reported once missing in all the compressions
+	delete(releaseInfo, "main/binary-amd64/Packages.xz")
+	results, err = a.refreshCacheForPocket(false, "noble", releaseInfo, make(chan *debianpkg.PackageInfo, 10))
+	if err != nil || len(results) != 1 || results[0].Success {
+		t.Fatalf("unexpected results %+v (%v)", results, err)
+	}
+	if errs := a.parseStats.snapshot().Errors; len(errs) != 1 {
+		t.Errorf("expected 1 error, got %v", errs)
+	}
+}
+
+func TestDownloadCandidate(t *testing.T) {
+	// every compression of the Sources and the Contents indexes is listed
+	for _, filePath := range []string{"main/source/Sources", "main/source/Sources.gz", "main/source/Sources.xz", "main/source/Sources.bz2"} {
+		if matches := sourceIndexRegexp.FindStringSubmatch(filePath); matches == nil || matches[1] != "main" {
+			t.Errorf("%v is not a Sources index", filePath)
+		}
+	}
+	for _, filePath := range []string{"Contents-amd64", "Contents-amd64.gz", "main/Contents-amd64.xz", "main/Contents-amd64.bz2"} {
+		if arch, ok := contentsArch(filePath); !ok || arch != "amd64" {
+			t.Errorf("%v is not a Contents index", filePath)
+		}
+	}
+
+	content := "Package: hello\nVersion: 1.0\n"
+	releaseInfo := map[string]ReleaseFileEntry{
+		"main/source/Sources.gz": {Hash: fmt.Sprintf("%x", sha256.Sum256([]byte("missing")))},
+		"main/source/Sources":    {Hash: fmt.Sprintf("%x", sha256.Sum256([]byte(content)))},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ubuntu/dists/noble/main/source/Sources" {
+			w.Write([]byte(content))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/ubuntu/dists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archive{
+		BaseURL:    baseURL,
+		Client:     resty.New(),
+		CacheDir:   t.TempDir(),
+		parseStats: new(parseStatsCollector),
+	}
+
+	// the .gz is preferred but missing from the mirror
+	candidates := indexCandidates(map[string]string{".gz": "main/source/Sources.gz", "": "main/source/Sources"})
+	filePath, localFile, err := a.downloadCandidate(false, "noble", *baseURL, candidates, releaseInfo)
+	if err != nil || filePath != "main/source/Sources" {
+		t.Fatalf("unexpected candidate %q (%v)", filePath, err)
+	}
+	names := make([]string, 0)
+	err = readSourcesFile(localFile, func(src *database.SourcePackage) error {
+		names = append(names, src.Name)
+		return nil
+	})
+	if err != nil || len(names) != 1 || names[0] != "hello" {
+		t.Errorf("unexpected sources %v (%v)", names, err)
+	}
+
+	// an error if none is found
+	if _, _, err := a.downloadCandidate(false, "noble", *baseURL, candidates[:1], releaseInfo); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	content := "Package: hello\n"
+
+	gzipped := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(gzipped)
+	gzipWriter.Write([]byte(content))
+	gzipWriter.Close()
+
+	xzed := new(bytes.Buffer)
+	xzWriter, err := xz.NewWriter(xzed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xzWriter.Write([]byte(content))
+	xzWriter.Close()
+
+	bzipped := []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x7f, 0x1c, 0xbf, 0xef, 0x00, 0x00,
+		0x01, 0xdb, 0x00, 0x00, 0x10, 0x40, 0x00, 0x00, 0x10, 0x40, 0x00, 0x2a, 0xcc, 0xa0, 0x00, 0x22,
+		0x00, 0x19, 0x04, 0x0d, 0x03, 0x43, 0xa3, 0x22, 0x8e, 0x02, 0xe0, 0xef, 0x27, 0x8b, 0xb9, 0x22,
+		0x9c, 0x28, 0x48, 0x3f, 0x8e, 0x5f, 0xf7, 0x80,
+	}
+
+	for name, compressed := range map[string][]byte{
+		"gzip":  gzipped.Bytes(),
+		"xz":    xzed.Bytes(),
+		"bzip2": bzipped,
+		"plain": []byte(content),
+	} {
+		reader, err := decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		decompressed, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if string(decompressed) != content {
+			t.Errorf("%v: expected %q, got %q", name, content, decompressed)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// validators are the Last-Modified and ETag headers of a downloaded file,
+// they are sent back in the conditional requests of the next downloads
+type validators struct {
+	LastModified string `json:"last_modified,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+// validatorsPath is where the validators of a file of the cache are saved,
+// next to it so that they are forgotten with the file
+func validatorsPath(filePath string) string {
+	return filePath + ".validators"
+}
+
+// readValidators returns the validators of a file of the cache, nil if the
+// file or its validators are missing
+func readValidators(filePath string) *validators {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil
+	}
+	content, err := os.ReadFile(validatorsPath(filePath))
+	if err != nil {
+		return nil
+	}
+
+	v := new(validators)
+	if err := json.Unmarshal(content, v); err != nil {
+		return nil
+	}
+
+	return v
+}
+
+// setConditional adds the headers making the request conditional, the
+// mirror answers 304 if the file didn't change
+func (v *validators) setConditional(req *resty.Request) {
+	if v == nil {
+		return
+	}
+	if v.ETag != "" {
+		req.SetHeader("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.SetHeader("If-Modified-Since", v.LastModified)
+	}
+}
+
+// writeValidators saves the validators of the response that downloaded a
+// file, the previous ones are removed if the mirror sent none
+func writeValidators(filePath string, resp *resty.Response) error {
+	v := validators{
+		LastModified: resp.Header().Get("Last-Modified"),
+		ETag:         resp.Header().Get("ETag"),
+	}
+	if v == (validators{}) {
+		forgetValidators(filePath)
+		return nil
+	}
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(validatorsPath(filePath), content, 0o644)
+}
+
+// forgetValidators makes the next download of a file unconditional, when
+// the file of the cache is damaged
+func forgetValidators(filePath string) {
+	err := os.Remove(validatorsPath(filePath))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("failed to remove the validators of %v: %v", filePath, err)
+	}
+}
@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestDownloadFileConditional(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	fileURL, err := url.Parse(server.URL + "/InRelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := path.Join(t.TempDir(), "InRelease")
+	client := resty.New()
+
+	for i, expected := range []int{http.StatusOK, http.StatusNotModified} {
+		resp, err := downloadFile(client, *fileURL, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode() != expected {
+			t.Errorf("download %v: expected %v, got %v", i, expected, resp.StatusCode())
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil || string(content) != "content" {
+			t.Errorf("download %v: expected content, got %q (%v)", i, content, err)
+		}
+	}
+
+	// without its validators, the file is downloaded again
+	forgetValidators(filePath)
+	resp, err := downloadFile(client, *fileURL, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusOK || requests != 3 {
+		t.Errorf("expected a new download, got %v after %v requests", resp.StatusCode(), requests)
+	}
+}
@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// contentsRegexp matches the Contents indexes listed in a Release file,
+// they are either at the root of the suite or in each component: the index
+// without its compression, the architecture and the compression
+var contentsRegexp = regexp.MustCompile(`^((?:[^/]+/)?Contents-([a-z0-9]+))(\.gz|\.xz|\.bz2)?$`)
+
+// contentsArch returns the architecture of a Contents index or false if
+// the file is not a Contents index of binary packages
+func contentsArch(filePath string) (string, bool) {
+	matches := contentsRegexp.FindStringSubmatch(filePath)
+	if matches == nil || matches[2] == "source" {
+		return "", false
+	}
+
+	return matches[2], true
+}
+
+// splitSuitePocket splits the name of a pocket (noble-updates) into the
+// suite (noble) and the pocket (-updates)
+func splitSuitePocket(suitePocket string) (string, string) {
+	suitePocketList := strings.Split(suitePocket, "-")
+	suite := suitePocketList[0]
+	pocket := ""
+	if len(suitePocketList) > 1 {
+		pocket = "-" + strings.Join(suitePocketList[1:], "-")
+	}
+
+	return suite, pocket
+}
+
+// parseContents reads a Contents index and calls insert for each
+// file/package pair. Lines are "path section/package,section/package..."
+// where section may be prefixed with the component.
+func parseContents(r io.Reader, insert func(path, pkg, component string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		sep := strings.LastIndexAny(line, " \t")
+		if sep == -1 {
+			continue
+		}
+		filePath := strings.TrimSpace(line[:sep])
+		locations := line[sep+1:]
+
+		// old Contents files start with a free text header
+		if filePath == "" || filePath == "FILE" {
+			continue
+		}
+
+		for _, location := range strings.Split(locations, ",") {
+			parts := strings.Split(location, "/")
+			component := "main"
+			if len(parts) == 3 {
+				component = parts[0]
+			}
+
+			err := insert(filePath, parts[len(parts)-1], component)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// refreshContents downloads the Contents indexes of the pocket that have
+// changed and replaces the files of each architecture in the DB. Indexes
+// are large so they are processed one architecture at a time.
+func (a *Archive) refreshContents(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry) (int, error) {
+	previous := map[string]ReleaseFileEntry{}
+	if oldInfo, ok := a.ReleaseInfo[pocket]; ok {
+		previous = oldInfo.PackageIndex
+	}
+
+	// the indexes of each architecture, by compression
+	filesByArch := make(map[string]map[string]map[string]string)
+	changedArchs := make(map[string]bool)
+	for filePath, info := range releaseInfo {
+		arch, ok := contentsArch(filePath)
+		if !ok {
+			continue
+		}
+
+		matches := contentsRegexp.FindStringSubmatch(filePath)
+		if filesByArch[arch] == nil {
+			filesByArch[arch] = make(map[string]map[string]string)
+		}
+		if filesByArch[arch][matches[1]] == nil {
+			filesByArch[arch][matches[1]] = make(map[string]string)
+		}
+		filesByArch[arch][matches[1]][matches[3]] = filePath
+		if previous[filePath].Hash != info.Hash {
+			changedArchs[arch] = true
+		}
+	}
+
+	suite, suitePocket := splitSuitePocket(pocket)
+	nbFile := 0
+	for arch := range changedArchs {
+		localFiles := make([]string, 0, len(filesByArch[arch]))
+		for _, files := range filesByArch[arch] {
+			baseURL := url.URL(*a.PortsURL)
+			if isPrimaryArch(arch) {
+				baseURL = url.URL(*a.BaseURL)
+			}
+
+			// the files of the architecture are replaced only if all its
+			// indexes are valid
+			_, localFile, err := a.downloadCandidate(local, pocket, baseURL, indexCandidates(files), releaseInfo)
+			if err != nil {
+				return nbFile, err
+			}
+			nbFile++
+			localFiles = append(localFiles, localFile)
+		}
+
+		n, err := a.Database.ReplaceContents(suite, suitePocket, arch, func(insert func(path, pkg, component string) error) error {
+			for _, localFile := range localFiles {
+				err := readContentsFile(localFile, insert)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nbFile, err
+		}
+		log.Debugf("[contents][%v] indexed %v files for %v", pocket, n, arch)
+	}
+
+	return nbFile, nil
+}
+
+func readContentsFile(filePath string, insert func(path, pkg, component string) error) error {
+	indexReader, err := openIndex(filePath)
+	if err != nil {
+		return err
+	}
+	defer indexReader.Close()
+
+	return parseContents(indexReader, insert)
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fault injection points of the refreshes, they do nothing unless faults
+// are installed (see faults_enabled.go)
+var (
+	// beforeFetch is called before every download from a mirror
+	beforeFetch = func(fileURL string) {}
+	// afterDownload can damage a file once it's downloaded
+	afterDownload = func(filePath string) {}
+	// beforeDBWrite can make a write to the database fail
+	beforeDBWrite = func() error { return nil }
+	// now is the clock of the refreshes
+	now = time.Now
+)
+
+// errInjected is returned by the database writes failed on purpose
+var errInjected = fmt.Errorf("injected database write error")
+
+// Faults are the failures simulated during the refreshes, to check how the
+// server behaves with bad mirrors or a failing disk
+type Faults struct {
+	// Delay is added before every download (slow mirror)
+	Delay time.Duration
+	// TruncateRate is the probability for a downloaded file to be cut in
+	// half
+	TruncateRate float64
+	// DBErrorRate is the probability for a package insertion to fail
+	DBErrorRate float64
+	// ClockSkew is added to the clock of the refreshes
+	ClockSkew time.Duration
+}
+
+// ParseFaults parses a comma separated list of faults, e.g.
+// "delay=2s,truncate=0.1,db_error=0.01,skew=-1h"
+func ParseFaults(spec string) (*Faults, error) {
+	faults := new(Faults)
+	for _, item := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q", item)
+		}
+
+		var err error
+		switch key {
+		case "delay":
+			faults.Delay, err = time.ParseDuration(value)
+		case "truncate":
+			faults.TruncateRate, err = strconv.ParseFloat(value, 64)
+		case "db_error":
+			faults.DBErrorRate, err = strconv.ParseFloat(value, 64)
+		case "skew":
+			faults.ClockSkew, err = time.ParseDuration(value)
+		default:
+			return nil, fmt.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for fault %q: %v", key, err)
+		}
+	}
+
+	return faults, nil
+}
+
+// install replaces the injection points
+func (f *Faults) install() {
+	beforeFetch = func(fileURL string) {
+		if f.Delay > 0 {
+			log.Debugf("[faults] delaying %v by %v", fileURL, f.Delay)
+			time.Sleep(f.Delay)
+		}
+	}
+
+	afterDownload = func(filePath string) {
+		if rand.Float64() >= f.TruncateRate {
+			return
+		}
+
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			return
+		}
+		log.Infof("[faults] truncating %v", filePath)
+		os.Truncate(filePath, stat.Size()/2)
+	}
+
+	beforeDBWrite = func() error {
+		if rand.Float64() < f.DBErrorRate {
+			return errInjected
+		}
+
+		return nil
+	}
+
+	now = func() time.Time {
+		return time.Now().Add(f.ClockSkew)
+	}
+}
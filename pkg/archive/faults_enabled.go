@@ -0,0 +1,22 @@
+//go:build faults
+
+package archive
+
+import "os"
+
+// RMADISON_FAULTS enables the fault injection in the binaries built with
+// -tags faults, see ParseFaults for the syntax
+func init() {
+	spec := os.Getenv("RMADISON_FAULTS")
+	if spec == "" {
+		return
+	}
+
+	faults, err := ParseFaults(spec)
+	if err != nil {
+		log.Fatalf("[faults] invalid RMADISON_FAULTS: %v", err)
+	}
+
+	log.Warnf("[faults] injecting faults in the refreshes: %+v", *faults)
+	faults.install()
+}
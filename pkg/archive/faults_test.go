@@ -0,0 +1,32 @@
+package archive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Faults
+		wantErr bool
+	}{
+		{"delay=2s", Faults{Delay: 2 * time.Second}, false},
+		{"truncate=0.1, db_error=0.01", Faults{TruncateRate: 0.1, DBErrorRate: 0.01}, false},
+		{"skew=-1h", Faults{ClockSkew: -time.Hour}, false},
+		{"delay", Faults{}, true},
+		{"delay=fast", Faults{}, true},
+		{"reboot=1", Faults{}, true},
+	}
+
+	for _, test := range tests {
+		faults, err := ParseFaults(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFaults(%q): unexpected error %v", test.spec, err)
+			continue
+		}
+		if err == nil && *faults != test.want {
+			t.Errorf("ParseFaults(%q) = %+v, want %+v", test.spec, *faults, test.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package archive
+
+// layouts of the archives, see Archive.Layout
+const (
+	// LayoutDists is the usual layout: the Release files of the suites
+	// are in BaseURL (the dists directory) and their indexes are in a
+	// directory per component and architecture
+	LayoutDists = "dists"
+	// LayoutFlat is the layout of the flat repositories: the pockets are
+	// directories of BaseURL with the Release file and the Packages
+	// index, without components nor architectures
+	LayoutFlat = "flat"
+)
+
+// isFlat tells if the archive is a flat repository
+func (a *Archive) isFlat() bool {
+	return a.Layout == LayoutFlat
+}
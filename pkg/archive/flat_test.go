@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestFlatRepository(t *testing.T) {
+	packages := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(packages)
+	fmt.Fprint(gzipWriter, "Package: hello\nVersion: 1.0\nArchitecture: amd64\nFilename: ./hello_1.0_amd64.deb\n\n")
+	fmt.Fprint(gzipWriter, "Package: hello\nVersion: 1.0\nArchitecture: arm64\nFilename: ./hello_1.0_arm64.deb\n")
+	gzipWriter.Close()
+	release := fmt.Sprintf("Origin: vendor\nSHA256:\n %x %v Packages.gz\n", sha256.Sum256(packages.Bytes()), packages.Len())
+
+	// no InRelease, like most flat repositories
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repo/Release":
+			w.Write([]byte(release))
+		case "/repo/Packages.gz":
+			w.Write(packages.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archive{
+		BaseURL:  baseURL,
+		PortsURL: baseURL,
+		Client:   resty.New(),
+		CacheDir: t.TempDir(),
+		Pockets:  []string{"."},
+		Layout:   LayoutFlat,
+	}
+	a.resolvePockets()
+
+	releaseInfo, err := a.GetReleaseInfo(false)
+	if err != nil || releaseInfo["."] == nil {
+		t.Fatalf("no Release file: %v", err)
+	}
+
+	pkgs := make(chan *debianpkg.PackageInfo, 10)
+	results, err := a.refreshCacheForPocket(false, ".", releaseInfo["."].PackageIndex, pkgs)
+	if err != nil || len(results) != 1 || !results[0].Success {
+		t.Fatalf("unexpected results %+v (%v)", results, err)
+	}
+	close(pkgs)
+
+	archs := make([]string, 0)
+	for pkg := range pkgs {
+		if pkg.Suite != "." || pkg.Component != "" {
+			t.Errorf("unexpected suite %q and component %q", pkg.Suite, pkg.Component)
+		}
+		archs = append(archs, pkg.Architecture)
+		if poolURL := a.PoolURL(pkg); poolURL != server.URL+"/repo/hello_1.0_"+pkg.Architecture+".deb" {
+			t.Errorf("unexpected URL %v", poolURL)
+		}
+		entries := a.SourcesEntries([]*debianpkg.PackageInfo{pkg})
+		if entry := entries[0].String(); entry != fmt.Sprintf("deb [arch=%v] %v/repo ./", pkg.Architecture, server.URL) {
+			t.Errorf("unexpected entry %q", entry)
+		}
+	}
+	if len(archs) != 2 || archs[0] == archs[1] {
+		t.Errorf("unexpected architectures %v", archs)
+	}
+}
@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"strings"
+)
+
+// indexFilter selects the indexes of a Release file by component and
+// architecture, a nil set allows everything
+type indexFilter struct {
+	components    map[string]bool
+	architectures map[string]bool
+}
+
+// newSet returns the set of the values, nil if there are none
+func newSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
+
+// intersect returns the values of configured in advertised, advertised if
+// nothing is configured and configured if nothing is advertised
+func intersect(configured, advertised map[string]bool) map[string]bool {
+	if configured == nil || advertised == nil {
+		if configured == nil {
+			return advertised
+		}
+		return configured
+	}
+
+	set := make(map[string]bool)
+	for value := range configured {
+		if advertised[value] {
+			set[value] = true
+		}
+	}
+
+	return set
+}
+
+// indexComponentArch returns the component and the architecture of an
+// index listed in a Release file, they are empty when they don't apply
+// (e.g. no architecture for the Translation indexes)
+func indexComponentArch(filePath string) (string, string) {
+	parts := strings.Split(filePath, "/")
+	if len(parts) >= 3 && strings.HasPrefix(parts[len(parts)-2], "binary-") {
+		return strings.Join(parts[:len(parts)-2], "/"), strings.TrimPrefix(parts[len(parts)-2], "binary-")
+	}
+	if arch, ok := contentsArch(filePath); ok {
+		if len(parts) == 2 {
+			return parts[0], arch
+		}
+		return "", arch
+	}
+	if matches := translationRegexp.FindStringSubmatch(filePath); matches != nil {
+		return matches[1], ""
+	}
+	if matches := sourceIndexRegexp.FindStringSubmatch(filePath); matches != nil {
+		return matches[1], ""
+	}
+
+	return "", ""
+}
+
+// allows tells if an index of the Release file should be downloaded. The
+// indexes of the packages for all the architectures (binary-all) are
+// always allowed, the other indexes duplicate them.
+func (f indexFilter) allows(filePath string) bool {
+	component, arch := indexComponentArch(filePath)
+	if component != "" && f.components != nil && !f.components[component] {
+		return false
+	}
+	if arch != "" && arch != "all" && f.architectures != nil && !f.architectures[arch] {
+		return false
+	}
+
+	return true
+}
+
+// missing returns the values of configured that are not in advertised
+func missing(configured, advertised []string) []string {
+	if len(advertised) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0)
+	for _, value := range configured {
+		if !containsString(advertised, value) {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// selectIndexes returns the indexes of a Release file in the components
+// and architectures it advertises, restricted to the ones configured. The
+// configured components and architectures that are not advertised are
+// reported, they are usually typos.
+func (a *Archive) selectIndexes(pocket string, release *ReleaseFile) map[string]ReleaseFileEntry {
+	if values := missing(a.Architectures, release.Architectures); len(values) != 0 {
+		log.Warnf("[release][%v][%v] architectures %v are not in the Release file (%v)", a.Name, pocket, strings.Join(values, " "), strings.Join(release.Architectures, " "))
+	}
+	if values := missing(a.Components, release.Components); len(values) != 0 {
+		log.Warnf("[release][%v][%v] components %v are not in the Release file (%v)", a.Name, pocket, strings.Join(values, " "), strings.Join(release.Components, " "))
+	}
+	if len(a.Architectures) != 0 {
+		if values := missing(release.Architectures, a.Architectures); len(values) != 0 {
+			log.Debugf("[release][%v][%v] not indexing the architectures %v", a.Name, pocket, strings.Join(values, " "))
+		}
+	}
+
+	filter := indexFilter{
+		components:    intersect(newSet(a.Components), newSet(release.Components)),
+		architectures: intersect(newSet(a.Architectures), newSet(release.Architectures)),
+	}
+
+	indexes := make(map[string]ReleaseFileEntry, len(release.PackageIndex))
+	for filePath, entry := range release.PackageIndex {
+		if filter.allows(filePath) {
+			indexes[filePath] = entry
+		}
+	}
+
+	return indexes
+}
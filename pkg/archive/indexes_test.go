@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSelectIndexes(t *testing.T) {
+	file, err := os.Open("./testdata/noble-release.txt")
+	if err != nil {
+		t.Fatal("failed to open test file", err)
+	}
+	defer file.Close()
+
+	release, err := ParseReleaseFile(file)
+	if err != nil {
+		t.Fatal("failed to parse release file", err)
+	}
+	// an index of an architecture that is not advertised
+	release.PackageIndex["main/binary-mips/Packages.gz"] = ReleaseFileEntry{}
+
+	tests := []struct {
+		name          string
+		architectures []string
+		components    []string
+		selected      []string
+		skipped       []string
+	}{
+		{
+			name:     "advertised",
+			selected: []string{"main/binary-amd64/Packages.gz", "universe/binary-s390x/Packages.gz", "Contents-arm64.gz", "universe/i18n/Translation-en.gz"},
+			skipped:  []string{"main/binary-mips/Packages.gz"},
+		},
+		{
+			name:          "configured",
+			architectures: []string{"amd64", "mips", "amd46"},
+			components:    []string{"main"},
+			selected:      []string{"main/binary-amd64/Packages.gz", "Contents-amd64.gz", "main/i18n/Translation-en.gz"},
+			skipped:       []string{"main/binary-mips/Packages.gz", "main/binary-arm64/Packages.gz", "universe/binary-amd64/Packages.gz", "Contents-arm64.gz", "universe/i18n/Translation-en.gz"},
+		},
+	}
+
+	for _, test := range tests {
+		a := &Archive{Name: "ubuntu", Architectures: test.architectures, Components: test.components}
+		indexes := a.selectIndexes("noble", release)
+
+		for _, filePath := range test.selected {
+			if _, ok := indexes[filePath]; !ok {
+				t.Errorf("%v: %v not selected", test.name, filePath)
+			}
+		}
+		for _, filePath := range test.skipped {
+			if _, ok := indexes[filePath]; ok {
+				t.Errorf("%v: %v selected", test.name, filePath)
+			}
+		}
+	}
+}
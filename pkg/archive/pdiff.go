@@ -0,0 +1,424 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pdiffIndex is a Packages.diff/Index file: the patches updating the
+// previous versions of a Packages index to the current one
+type pdiffIndex struct {
+	// Current is the SHA256 of the current (uncompressed) index
+	Current string
+	// History are the previous versions of the index, from the oldest,
+	// with the patch updating each one
+	History []pdiffEntry
+	// Downloads are the SHA256 of the compressed patches, by file name
+	Downloads map[string]string
+	// Merged tells if each patch updates its version of the index to the
+	// current one (X-Patch-Precedence: merged), the patches are applied
+	// one after the other otherwise
+	Merged bool
+}
+
+// pdiffEntry is a version of the index and the patch updating it
+type pdiffEntry struct {
+	Hash  string
+	Patch string
+}
+
+// parsePDiffIndex parses the SHA256 fields of a Packages.diff/Index file
+func parsePDiffIndex(r io.Reader) (*pdiffIndex, error) {
+	index := &pdiffIndex{Downloads: make(map[string]string)}
+
+	var field string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") {
+			// HASH SIZE NAME
+			parts := strings.Fields(line)
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("invalid %v line %q", field, line)
+			}
+			switch field {
+			case "SHA256-History":
+				index.History = append(index.History, pdiffEntry{Hash: parts[0], Patch: parts[2]})
+			case "SHA256-Download":
+				index.Downloads[parts[2]] = parts[0]
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		field = key
+		switch key {
+		case "SHA256-Current":
+			if parts := strings.Fields(value); len(parts) != 0 {
+				index.Current = parts[0]
+			}
+		case "X-Patch-Precedence":
+			index.Merged = strings.TrimSpace(value) == "merged"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if index.Current == "" {
+		return nil, fmt.Errorf("no SHA256-Current")
+	}
+
+	return index, nil
+}
+
+// patches returns the patches to apply, in order, to update the version
+// of the index with this hash. It returns false if the version is not in
+// the history.
+func (index *pdiffIndex) patches(hash string) ([]string, bool) {
+	for i, entry := range index.History {
+		if entry.Hash != hash {
+			continue
+		}
+		if index.Merged {
+			return []string{entry.Patch}, true
+		}
+
+		patches := make([]string, 0, len(index.History)-i)
+		for _, entry := range index.History[i:] {
+			patches = append(patches, entry.Patch)
+		}
+		return patches, true
+	}
+
+	return nil, false
+}
+
+// edCommand is a command of the ed scripts of the pdiffs (diff --ed),
+// start and end are lines of the file before the patch (from 1)
+type edCommand struct {
+	start int
+	end   int
+	// op is a (append after start), c (change) or d (delete)
+	op   byte
+	text []string
+}
+
+var edCommandRegexp = regexp.MustCompile(`^([0-9]+)(?:,([0-9]+))?([acd])$`)
+
+// parseEdScript returns the commands of an ed script, sorted by line. The
+// commands of the scripts are from the end of the file to its beginning,
+// they don't overlap.
+func parseEdScript(r io.Reader) ([]edCommand, error) {
+	commands := make([]edCommand, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		matches := edCommandRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			return nil, fmt.Errorf("unsupported ed command %q", line)
+		}
+
+		command := edCommand{op: matches[3][0]}
+		command.start, _ = strconv.Atoi(matches[1])
+		command.end = command.start
+		if matches[2] != "" {
+			command.end, _ = strconv.Atoi(matches[2])
+		}
+		if command.end < command.start || (command.op != 'a' && command.start == 0) {
+			return nil, fmt.Errorf("invalid ed command %q", line)
+		}
+
+		if command.op != 'd' {
+			terminated := false
+			for scanner.Scan() {
+				if scanner.Text() == "." {
+					terminated = true
+					break
+				}
+				command.text = append(command.text, scanner.Text())
+			}
+			if !terminated {
+				return nil, fmt.Errorf("unterminated ed command %q", line)
+			}
+		}
+		commands = append(commands, command)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(commands)-1; i < j; i, j = i+1, j-1 {
+		commands[i], commands[j] = commands[j], commands[i]
+	}
+
+	return commands, nil
+}
+
+// applyEd applies the commands (sorted by line) to the file read from in,
+// as a stream
+func applyEd(in io.Reader, out io.Writer, commands []edCommand) error {
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+	consumed := 0
+	// skipTo reads the lines of in up to line, they are copied to out if
+	// keep is set
+	skipTo := func(line int, keep bool) error {
+		if line < consumed {
+			return fmt.Errorf("overlapping ed commands at line %v", line)
+		}
+		for consumed < line {
+			text, err := reader.ReadString('\n')
+			if err != nil && (err != io.EOF || text == "") {
+				return fmt.Errorf("the patch goes past the end of the file (line %v): %w", line, err)
+			}
+			if keep {
+				writer.WriteString(text)
+			}
+			consumed++
+		}
+
+		return nil
+	}
+
+	for _, command := range commands {
+		if command.op == 'a' {
+			if err := skipTo(command.start, true); err != nil {
+				return err
+			}
+		} else {
+			if err := skipTo(command.start-1, true); err != nil {
+				return err
+			}
+			if err := skipTo(command.end, false); err != nil {
+				return err
+			}
+		}
+
+		for _, text := range command.text {
+			writer.WriteString(text)
+			writer.WriteByte('\n')
+		}
+	}
+
+	_, err := io.Copy(writer, reader)
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+// uncompressedHash returns the SHA256 of the content of a compressed file
+func uncompressedHash(filePath string) (string, error) {
+	indexReader, err := openIndex(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer indexReader.Close()
+
+	shaSum := sha256.New()
+	if _, err := io.Copy(shaSum, indexReader); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(shaSum.Sum(nil)), nil
+}
+
+// setPDiffIndexes records the Packages.diff/Index files of the Packages
+// indexes of a pocket
+func (a *Archive) setPDiffIndexes(pocket string, indexes map[string]ReleaseFileEntry) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.pdiffIndexes == nil {
+		a.pdiffIndexes = make(map[string]map[string]ReleaseFileEntry)
+	}
+	a.pdiffIndexes[pocket] = indexes
+}
+
+func (a *Archive) pdiffIndex(pocket, indexPath string) (ReleaseFileEntry, bool) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	entry, ok := a.pdiffIndexes[pocket][indexPath]
+
+	return entry, ok
+}
+
+// cachePath returns the file of the cache of a URL
+func (a *Archive) cachePath(fileURL url.URL) string {
+	return path.Join(a.CacheDir, strings.ReplaceAll(fileURL.Hostname()+fileURL.Path, "/", "_"))
+}
+
+// updateByPDiff updates the copy of a Packages index in the cache (the
+// version of a previous refresh) with the pdiffs of its Packages.diff
+// directory. It returns false if the pocket has no pdiffs or if they can't
+// be applied, the index is downloaded completely then.
+func (a *Archive) updateByPDiff(pocket, indexPath string, fileURL url.URL, filePath string) bool {
+	pdiff, ok := a.pdiffIndex(pocket, indexPath)
+	if !ok {
+		return false
+	}
+
+	err := a.applyPDiffs(pocket, pdiff, fileURL, filePath)
+	if err != nil {
+		log.Infof("[pdiff][%v] %v: %v, downloading the whole index", pocket, indexPath, err)
+		return false
+	}
+	log.Debugf("[pdiff][%v] updated %v", pocket, indexPath)
+
+	return true
+}
+
+// downloadPatch downloads a compressed patch of a Packages.diff directory
+// and returns its commands
+func (a *Archive) downloadPatch(patchURL url.URL, expectedHash string) ([]edCommand, error) {
+	patchPath := a.cachePath(patchURL)
+	defer os.Remove(patchPath)
+	defer forgetValidators(patchPath)
+
+	_, err := downloadFile(a.Client, patchURL, patchPath)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := fileHash(patchPath)
+	if err != nil {
+		return nil, err
+	}
+	if hash != expectedHash {
+		return nil, fmt.Errorf("checksum mismatch for %v", patchURL.String())
+	}
+
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	return parseEdScript(gzipReader)
+}
+
+// applyPDiffs downloads the patches from the version of the index in the
+// cache to the current one and applies them, the patched index is written
+// to the cache once its checksum is verified
+func (a *Archive) applyPDiffs(pocket string, pdiff ReleaseFileEntry, fileURL url.URL, filePath string) error {
+	oldHash, err := uncompressedHash(filePath)
+	if err != nil {
+		return err
+	}
+
+	diffURL := fileURL
+	diffURL.Path = path.Join(path.Dir(fileURL.Path), "Packages.diff")
+	indexURL := diffURL
+	indexURL.Path = path.Join(diffURL.Path, "Index")
+	indexFile := a.cachePath(indexURL)
+	err = a.downloadIndex(pocket, indexURL, pdiff.Hash, indexFile)
+	if err != nil {
+		return err
+	}
+	if hash, err := fileHash(indexFile); err != nil || hash != pdiff.Hash {
+		forgetValidators(indexFile)
+		return fmt.Errorf("checksum mismatch for %v", indexURL.String())
+	}
+
+	content, err := os.Open(indexFile)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	index, err := parsePDiffIndex(content)
+	if err != nil {
+		return err
+	}
+	if oldHash == index.Current {
+		return nil
+	}
+	patches, ok := index.patches(oldHash)
+	if !ok {
+		return fmt.Errorf("the index of the cache is not in the history of the pdiffs")
+	}
+
+	scripts := make([][]edCommand, len(patches))
+	for i, patch := range patches {
+		expectedHash, ok := index.Downloads[patch+".gz"]
+		if !ok {
+			return fmt.Errorf("no SHA256-Download for %v", patch)
+		}
+		patchURL := diffURL
+		patchURL.Path = path.Join(diffURL.Path, patch+".gz")
+		scripts[i], err = a.downloadPatch(patchURL, expectedHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return patchIndex(filePath, scripts, index.Current)
+}
+
+// patchIndex applies the scripts one after the other to a compressed
+// index, the index is replaced (compressed with gzip whatever its name) if
+// the checksum of the result is the expected one
+func patchIndex(filePath string, scripts [][]edCommand, expectedHash string) error {
+	oldReader, err := openIndex(filePath)
+	if err != nil {
+		return err
+	}
+	defer oldReader.Close()
+
+	partPath := filePath + ".part"
+	partFile, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partPath)
+	defer partFile.Close()
+
+	// the intermediate versions are streamed through pipes, the file is
+	// never completely in memory
+	var in io.Reader = oldReader
+	for _, commands := range scripts[:len(scripts)-1] {
+		pipeReader, pipeWriter := io.Pipe()
+		defer pipeReader.Close()
+		go func(in io.Reader, commands []edCommand) {
+			pipeWriter.CloseWithError(applyEd(in, pipeWriter, commands))
+		}(in, commands)
+		in = pipeReader
+	}
+
+	gzipWriter := gzip.NewWriter(partFile)
+	shaSum := sha256.New()
+	err = applyEd(in, io.MultiWriter(gzipWriter, shaSum), scripts[len(scripts)-1])
+	if err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	if err := partFile.Close(); err != nil {
+		return err
+	}
+	if hash := hex.EncodeToString(shaSum.Sum(nil)); hash != expectedHash {
+		return fmt.Errorf("checksum mismatch after patching (%v, expected %v)", hash, expectedHash)
+	}
+
+	// the validators are the ones of the index replaced
+	forgetValidators(filePath)
+
+	return os.Rename(partPath, filePath)
+}
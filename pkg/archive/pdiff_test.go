@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestParsePDiffIndex(t *testing.T) {
+	content := `SHA256-Current: cccc 300
+SHA256-History:
+ aaaa 100 T-2024-01-01-0000.00-F-2024-01-01-0000.00
+ bbbb 200 T-2024-01-01-0600.00-F-2024-01-01-0000.00
+SHA256-Patches:
+ 1111 10 T-2024-01-01-0000.00-F-2024-01-01-0000.00
+ 2222 20 T-2024-01-01-0600.00-F-2024-01-01-0000.00
+SHA256-Download:
+ 3333 10 T-2024-01-01-0000.00-F-2024-01-01-0000.00.gz
+ 4444 20 T-2024-01-01-0600.00-F-2024-01-01-0000.00.gz
+`
+	index, err := parsePDiffIndex(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Current != "cccc" || len(index.History) != 2 || index.Downloads["T-2024-01-01-0600.00-F-2024-01-01-0000.00.gz"] != "4444" {
+		t.Fatalf("wrong index %+v", index)
+	}
+
+	patches, ok := index.patches("aaaa")
+	if !ok || len(patches) != 2 {
+		t.Errorf("expected the 2 patches, got %v", patches)
+	}
+	index.Merged = true
+	patches, ok = index.patches("bbbb")
+	if !ok || len(patches) != 1 || patches[0] != "T-2024-01-01-0600.00-F-2024-01-01-0000.00" {
+		t.Errorf("expected the last patch, got %v", patches)
+	}
+	if _, ok := index.patches("dddd"); ok {
+		t.Error("unknown version found in the history")
+	}
+}
+
+func TestPatchIndex(t *testing.T) {
+	original := "a\nb\nc\nd\ne\n"
+	// c is changed, e deleted and f appended after a
+	first := "5d\n3c\nC\n.\n1a\nf\n.\n"
+	// the first line is deleted and g appended at the end
+	second := "5a\ng\n.\n1d\n"
+	expected := "f\nb\nC\nd\ng\n"
+
+	filePath := path.Join(t.TempDir(), "Packages.gz")
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte(original))
+	gzipWriter.Close()
+	if err := os.WriteFile(filePath, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := make([][]edCommand, 0)
+	for _, script := range []string{first, second} {
+		commands, err := parseEdScript(strings.NewReader(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts = append(scripts, commands)
+	}
+
+	err := patchIndex(filePath, scripts, "0000")
+	if err == nil {
+		t.Fatal("the checksum wasn't verified")
+	}
+
+	shaSum := sha256.Sum256([]byte(expected))
+	err = patchIndex(filePath, scripts, hex.EncodeToString(shaSum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := io.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patched) != expected {
+		t.Errorf("expected %q, got %q", expected, patched)
+	}
+}
@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dirEntryRegexp matches the sub-directories in an HTML directory listing
+var dirEntryRegexp = regexp.MustCompile(`href="([^"/?#:]+)/"`)
+
+// DefaultPocketSuffixes are the pockets of each of Archive.Releases when
+// Archive.PocketSuffixes is empty, the ones missing from the archive are
+// skipped
+var DefaultPocketSuffixes = []string{"", "-updates", "-security", "-proposed", "-backports"}
+
+// releasePockets returns the pockets of the releases with each suffix
+func releasePockets(releases, suffixes []string) []string {
+	if len(suffixes) == 0 {
+		suffixes = DefaultPocketSuffixes
+	}
+
+	pockets := make([]string, 0, len(releases)*len(suffixes))
+	for _, release := range releases {
+		for _, suffix := range suffixes {
+			pockets = append(pockets, release+suffix)
+		}
+	}
+
+	return pockets
+}
+
+// isRequiredPocket tells if a pocket is listed in a.Pockets, the other ones
+// (discovered, matching a pattern or from a.Releases) are skipped when they
+// have no Release file
+func (a *Archive) isRequiredPocket(pocket string) bool {
+	return containsString(a.Pockets, pocket)
+}
+
+// releaseAlias tells if a pocket is an alias (a symlink in dists) of
+// another pocket of the list, from the codename of its Release file: the
+// stable and testing suites of Debian, or devel on Ubuntu. The codenames
+// of the pockets have their suffix on Debian (bookworm-updates) but not on
+// Ubuntu (noble for noble-updates), it's taken from the suite then.
+func releaseAlias(pocket string, release *ReleaseFile, pockets []string) (string, bool) {
+	if release.Codename == "" {
+		return "", false
+	}
+
+	canonical := release.Codename
+	if _, suffix := splitSuitePocket(release.Suite); !strings.HasSuffix(canonical, suffix) {
+		canonical += suffix
+	}
+	if canonical == pocket || !containsString(pockets, canonical) {
+		return "", false
+	}
+
+	return canonical, true
+}
+
+func isPocketPattern(pocket string) bool {
+	return strings.ContainsAny(pocket, "*?[")
+}
+
+// ListSuites lists the suites available in the dists directory of the
+// archive by parsing the HTML directory listing of the web server
+func (a *Archive) ListSuites() ([]string, error) {
+	distsURL := *a.BaseURL
+	distsURL.Path = strings.TrimRight(distsURL.Path, "/") + "/"
+
+	beforeFetch(distsURL.String())
+	resp, err := a.Client.R().Get(distsURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("failed to list %v (%v)", distsURL.String(), resp.Status())
+	}
+
+	suites := make([]string, 0)
+	for _, match := range dirEntryRegexp.FindAllStringSubmatch(resp.String(), -1) {
+		suite := match[1]
+		if suite == "." || suite == ".." || containsString(suites, suite) {
+			continue
+		}
+		suites = append(suites, suite)
+	}
+
+	return suites, nil
+}
+
+// resolvePockets expands the patterns in a.Pockets (e.g. noble*) using the
+// list of suites published by the archive, or adds all of them if
+// a.Discover is set, and adds the pockets of a.Releases. If the archive
+// cannot be listed, the previously resolved pockets are kept.
+func (a *Archive) resolvePockets() {
+	patterns := append(append([]string{}, a.Pockets...), releasePockets(a.Releases, a.PocketSuffixes)...)
+	if a.Discover {
+		patterns = append([]string{"*"}, patterns...)
+	}
+
+	hasPattern := false
+	for _, pocket := range patterns {
+		if isPocketPattern(pocket) {
+			hasPattern = true
+			break
+		}
+	}
+	if !hasPattern {
+		a.setPockets(literalPockets(patterns))
+		return
+	}
+
+	suites, err := a.ListSuites()
+	if err != nil {
+		log.Errorf("[release] cannot expand pocket patterns: %v", err)
+		if a.CurrentPockets() == nil {
+			a.setPockets(literalPockets(patterns))
+		}
+		return
+	}
+
+	pockets := expandPockets(patterns, suites)
+	if a.Discover {
+		log.Debugf("[release] discovered %v suites", len(pockets))
+	}
+	a.setPockets(pockets)
+}
+
+func literalPockets(patterns []string) []string {
+	pockets := make([]string, 0, len(patterns))
+	for _, pocket := range patterns {
+		if !isPocketPattern(pocket) && !containsString(pockets, pocket) {
+			pockets = append(pockets, pocket)
+		}
+	}
+
+	return pockets
+}
+
+// expandPockets returns the pockets matching the patterns, literal pockets
+// are kept even if they are not in the list of suites
+func expandPockets(patterns []string, suites []string) []string {
+	pockets := literalPockets(patterns)
+	for _, pattern := range patterns {
+		if !isPocketPattern(pattern) {
+			continue
+		}
+
+		for _, suite := range suites {
+			if ok, _ := path.Match(pattern, suite); ok && !containsString(pockets, suite) {
+				pockets = append(pockets, suite)
+			}
+		}
+	}
+	sort.Strings(pockets)
+
+	return pockets
+}
+
+func (a *Archive) setPockets(pockets []string) {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	a.pockets = pockets
+}
+
+// CurrentPockets returns the pockets handled by the archive, once the
+// patterns from the configuration have been expanded. It returns nil if
+// the archive hasn't been refreshed yet.
+func (a *Archive) CurrentPockets() []string {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	return a.pockets
+}
+
+// pocketList returns the resolved list of pockets, or the configured ones
+func (a *Archive) pocketList() []string {
+	if pockets := a.CurrentPockets(); pockets != nil {
+		return pockets
+	}
+
+	return literalPockets(a.Pockets)
+}
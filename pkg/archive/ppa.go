@@ -0,0 +1,30 @@
+package archive
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PPAURL is the template of the dists directory of the Launchpad PPAs, with
+// the owner and the name of the PPA
+const PPAURL = "https://ppa.launchpadcontent.net/%v/%v/ubuntu/dists"
+
+// PPAComponents are the components of the PPAs, Launchpad only publishes
+// main
+var PPAComponents = []string{"main"}
+
+// ppaRegexp matches the owner and name of a PPA in the user/name or
+// ppa:user/name forms
+var ppaRegexp = regexp.MustCompile(`^(?:ppa:)?([a-z0-9][a-z0-9+.-]*)/([a-z0-9][a-z0-9+._-]*)$`)
+
+// PPABaseURL returns the dists directory of a PPA, given as user/name
+// (or ppa:user/name like add-apt-repository)
+func PPABaseURL(ppa string) (string, error) {
+	matches := ppaRegexp.FindStringSubmatch(strings.TrimSpace(ppa))
+	if matches == nil {
+		return "", fmt.Errorf("invalid PPA %q, expected user/name", ppa)
+	}
+
+	return fmt.Sprintf(PPAURL, matches[1], matches[2]), nil
+}
@@ -0,0 +1,22 @@
+package archive
+
+import "testing"
+
+func TestPPABaseURL(t *testing.T) {
+	expected := "https://ppa.launchpadcontent.net/deadsnakes/ppa/ubuntu/dists"
+	for _, ppa := range []string{"deadsnakes/ppa", "ppa:deadsnakes/ppa"} {
+		baseURL, err := PPABaseURL(ppa)
+		if err != nil {
+			t.Fatalf("%v: %v", ppa, err)
+		}
+		if baseURL != expected {
+			t.Errorf("%v: expected %v, got %v", ppa, expected, baseURL)
+		}
+	}
+
+	for _, ppa := range []string{"", "deadsnakes", "deadsnakes/ppa/extra", "https://example.com/ppa"} {
+		if _, err := PPABaseURL(ppa); err == nil {
+			t.Errorf("%q should be invalid", ppa)
+		}
+	}
+}
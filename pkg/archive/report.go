@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IndexResult is the outcome of the refresh of a Packages index
+type IndexResult struct {
+	// Suite is the suite and the pocket of the index (noble-updates)
+	Suite        string `json:"suite"`
+	Component    string `json:"component"`
+	Architecture string `json:"architecture"`
+	Success      bool   `json:"success"`
+	// Packages is the number of packages read from the index and written
+	// to the database
+	Packages int    `json:"packages"`
+	Error    string `json:"error,omitempty"`
+	// Patched tells if the index was updated with pdiffs rather than
+	// downloaded
+	Patched bool `json:"patched,omitempty"`
+	// Compression is the compression of the index downloaded (gz, xz...)
+	Compression string `json:"compression,omitempty"`
+
+	// path is the path of the index in the Release file
+	path string
+	// notFound tells if the index is missing from the mirror, it's tried
+	// in another compression
+	notFound bool
+}
+
+func (index IndexResult) String() string {
+	return fmt.Sprintf("%v/%v/%v", index.Suite, index.Component, index.Architecture)
+}
+
+// RefreshReport is the outcome of a refresh: the result of each index
+// downloaded and the errors that are not specific to an index
+type RefreshReport struct {
+	Archive string `json:"archive"`
+	// Files is the number of indexes (Packages and Contents) downloaded
+	Files           int           `json:"files"`
+	UpdatedPackages int           `json:"updated_packages"`
+	Indexes         []IndexResult `json:"indexes"`
+	// Errors are the failures that are not specific to a Packages index,
+	// e.g. a Release file that can't be fetched
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Failed returns the indexes that couldn't be refreshed
+func (report *RefreshReport) Failed() []IndexResult {
+	failed := make([]IndexResult, 0)
+	for _, index := range report.Indexes {
+		if !index.Success {
+			failed = append(failed, index)
+		}
+	}
+
+	return failed
+}
+
+// Err returns an error listing all the failures of the refresh, nil if
+// there are none
+func (report *RefreshReport) Err() error {
+	failures := append([]string{}, report.Errors...)
+	for _, index := range report.Failed() {
+		failures = append(failures, fmt.Sprintf("%v: %v", index, index.Error))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(failures, "; "))
+}
+
+func (report *RefreshReport) sortIndexes() {
+	sort.Slice(report.Indexes, func(i, j int) bool {
+		return report.Indexes[i].String() < report.Indexes[j].String()
+	})
+}
+
+// newIndexResult returns the result of the index at filePath in the
+// Release file of pocket (main/binary-amd64/Packages.gz)
+func newIndexResult(pocket, filePath string) IndexResult {
+	index := IndexResult{Suite: pocket, path: filePath, Compression: strings.TrimPrefix(path.Ext(filePath), ".")}
+	parts := strings.Split(filePath, "/")
+	if len(parts) >= 2 {
+		index.Component = parts[0]
+		index.Architecture = strings.TrimPrefix(parts[1], "binary-")
+	}
+
+	return index
+}
@@ -0,0 +1,51 @@
+package archive
+
+import "testing"
+
+func TestRefreshReportErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   RefreshReport
+		expected string
+	}{
+		{
+			name: "success",
+			report: RefreshReport{Indexes: []IndexResult{
+				{Suite: "noble", Component: "main", Architecture: "amd64", Success: true, Packages: 6000},
+			}},
+		},
+		{
+			name: "failed indexes",
+			report: RefreshReport{
+				Indexes: []IndexResult{
+					{Suite: "noble", Component: "main", Architecture: "amd64", Error: "checksum mismatch"},
+					{Suite: "noble", Component: "main", Architecture: "arm64", Success: true},
+				},
+				Errors: []string{"noble-updates: no Release file"},
+			},
+			expected: "noble-updates: no Release file; noble/main/amd64: checksum mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.report.Err()
+			if test.expected == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("expected %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewIndexResult(t *testing.T) {
+	index := newIndexResult("noble-updates", "universe/binary-arm64/Packages.gz")
+	if index.String() != "noble-updates/universe/arm64" {
+		t.Errorf("expected noble-updates/universe/arm64, got %v", index)
+	}
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+)
+
+// verification modes of the signatures of the Release files, see
+// Archive.Verify
+const (
+	// VerifyRefuse ignores the Release files that are unsigned or whose
+	// signature is invalid, the pocket keeps its previous packages
+	VerifyRefuse = "refuse"
+	// VerifyFlag reports the invalid Release files but indexes them
+	VerifyFlag = "flag"
+)
+
+// readKeyring reads keyring files, binary (like the ones of
+// /usr/share/keyrings) or ASCII armored
+func readKeyring(paths []string) (openpgp.EntityList, error) {
+	keyring := make(openpgp.EntityList, 0)
+	for _, keyringPath := range paths {
+		content, err := os.ReadFile(keyringPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var entities openpgp.EntityList
+		if bytes.Contains(content, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+			entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+		} else {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring %v: %v", keyringPath, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	return keyring, nil
+}
+
+// verifyRelease checks the signature of a Release file, clearsigned (an
+// InRelease file) or detached (the content of its Release.gpg, nil if
+// there is none), and returns the signed content, the only part of the
+// file that can be trusted
+func verifyRelease(raw, signature []byte, keyring openpgp.KeyRing) ([]byte, error) {
+	block, _ := clearsign.Decode(raw)
+	if block == nil {
+		if signature == nil {
+			return nil, fmt.Errorf("the Release file is not signed")
+		}
+
+		var err error
+		if bytes.Contains(signature, []byte("-----BEGIN PGP SIGNATURE-----")) {
+			_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(raw), bytes.NewReader(signature), nil)
+		} else {
+			_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(raw), bytes.NewReader(signature), nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature: %v", err)
+		}
+
+		return raw, nil
+	}
+
+	_, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+
+	return block.Plaintext, nil
+}
+
+// signaturePath is where the Release.gpg of a Release file is kept
+func signaturePath(releasePath string) string {
+	return releasePath + ".gpg"
+}
+
+// downloadSignature downloads the Release.gpg of a Release file, the one
+// of a previous refresh is removed if the mirror has none
+func (a *Archive) downloadSignature(releaseURL url.URL, releasePath string) {
+	releaseURL.Path += ".gpg"
+	_, err := downloadFile(a.Client, releaseURL, signaturePath(releasePath))
+	if err != nil {
+		log.Debugf("[release] no signature %v: %v", releaseURL.String(), err)
+		os.Remove(signaturePath(releasePath))
+	}
+}
+
+// checkRelease verifies the signature of the Release file of a pocket
+// (see verifyRelease) and returns the content to parse. It returns an
+// error if the Release file must not be indexed.
+func (a *Archive) checkRelease(pocket string, raw, signature []byte, keyring openpgp.KeyRing) ([]byte, error) {
+	signed, err := verifyRelease(raw, signature, keyring)
+	if err == nil {
+		return signed, nil
+	}
+
+	if a.Verify == VerifyFlag {
+		log.Warnf("[release][%v] %v, indexed anyway", pocket, err)
+		a.parseStats.error("the Release file of %v can't be verified: %v", pocket, err)
+		return raw, nil
+	}
+
+	log.Errorf("[release][%v] %v, the pocket is not refreshed", pocket, err)
+	a.parseStats.error("the Release file of %v was refused: %v", pocket, err)
+
+	return nil, fmt.Errorf("the Release file of %v was refused: %v", pocket, err)
+}
@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/go-resty/resty/v2"
+)
+
+func TestVerifyRelease(t *testing.T) {
+	entity, err := openpgp.NewEntity("Test Archive", "", "archive@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	keyringPath := path.Join(t.TempDir(), "archive.gpg")
+	if err := os.WriteFile(keyringPath, keyring.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	release := "Origin: Test\nSuite: noble\nCodename: noble\n"
+	var signed bytes.Buffer
+	writer, err := clearsign.Encode(&signed, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(release))
+	writer.Close()
+
+	keys, err := readKeyring([]string{keyringPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := verifyRelease(signed.Bytes(), nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != strings.TrimSpace(release) {
+		t.Errorf("expected %q, got %q", release, content)
+	}
+
+	tampered := bytes.Replace(signed.Bytes(), []byte("Suite: noble"), []byte("Suite: evil"), 1)
+	if _, err := verifyRelease(tampered, nil, keys); err == nil {
+		t.Error("the tampered Release file was verified")
+	}
+	if _, err := verifyRelease([]byte(release), nil, keys); err == nil {
+		t.Error("the unsigned Release file was verified")
+	}
+
+	a := &Archive{Name: "test", parseStats: new(parseStatsCollector)}
+	if _, err := a.checkRelease("noble", tampered, nil, keys); err == nil {
+		t.Error("the tampered Release file wasn't refused")
+	}
+	a.Verify = VerifyFlag
+	if content, err := a.checkRelease("noble", tampered, nil, keys); err != nil || !bytes.Equal(content, tampered) {
+		t.Errorf("the tampered Release file wasn't flagged: %v", err)
+	}
+}
+
+func TestVerifyDetachedRelease(t *testing.T) {
+	entity, err := openpgp.NewEntity("Test Archive", "", "archive@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	keyringPath := path.Join(t.TempDir(), "archive.gpg")
+	if err := os.WriteFile(keyringPath, keyring.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := readKeyring([]string{keyringPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := []byte("Origin: Test\nSuite: noble\nCodename: noble\n")
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(release), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := verifyRelease(release, signature.Bytes(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, release) {
+		t.Errorf("expected %q, got %q", release, content)
+	}
+
+	tampered := bytes.Replace(release, []byte("Suite: noble"), []byte("Suite: evil"), 1)
+	if _, err := verifyRelease(tampered, signature.Bytes(), keys); err == nil {
+		t.Error("the tampered Release file was verified")
+	}
+	if _, err := verifyRelease(release, nil, keys); err == nil {
+		t.Error("the Release file without its signature was verified")
+	}
+}
+
+// TestDetachedReleaseDownloaded checks that the Release.gpg is fetched
+// with the Release file when there is no InRelease
+func TestDetachedReleaseDownloaded(t *testing.T) {
+	entity, err := openpgp.NewEntity("Test Archive", "", "archive@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	keyringPath := path.Join(t.TempDir(), "archive.gpg")
+	if err := os.WriteFile(keyringPath, keyring.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	release := []byte("Origin: Test\nSuite: noble\nCodename: noble\n")
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(release), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/noble/Release":
+			w.Write(release)
+		case "/dists/noble/Release.gpg":
+			w.Write(signature.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/dists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archive{
+		Name:        "test",
+		BaseURL:     baseURL,
+		PortsURL:    baseURL,
+		Client:      resty.New(),
+		CacheDir:    t.TempDir(),
+		Pockets:     []string{"noble"},
+		Keyrings:    []string{keyringPath},
+		parseStats:  new(parseStatsCollector),
+		ReleaseInfo: map[string]*ReleaseFile{},
+	}
+	a.resolvePockets()
+
+	_, refused, err := a.getReleaseInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refused) != 0 {
+		t.Errorf("expected the Release file to be verified, got %v refused", refused)
+	}
+}
+
+func TestRefusedReleaseRetried(t *testing.T) {
+	entity, err := openpgp.NewEntity("Test Archive", "", "archive@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	keyringPath := path.Join(t.TempDir(), "archive.gpg")
+	if err := os.WriteFile(keyringPath, keyring.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// unsigned, with validators
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/noble/InRelease" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("Origin: Test\nSuite: noble\n"))
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL + "/dists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archive{
+		Name:       "test",
+		BaseURL:    baseURL,
+		PortsURL:   baseURL,
+		Client:     resty.New(),
+		CacheDir:   t.TempDir(),
+		Pockets:    []string{"noble"},
+		Keyrings:   []string{keyringPath},
+		parseStats: new(parseStatsCollector),
+		// the pocket was indexed before
+		ReleaseInfo: map[string]*ReleaseFile{"noble": {Hash: "previous"}},
+	}
+	a.resolvePockets()
+
+	for i := 0; i < 2; i++ {
+		_, refused, err := a.getReleaseInfo(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refused) != 1 {
+			t.Errorf("refresh %v: expected the Release file to be refused, got %v", i, refused)
+		}
+	}
+}
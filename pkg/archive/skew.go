@@ -0,0 +1,99 @@
+package archive
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// MaxClockSkew is the difference between the local clock and the clocks
+// of the mirrors above which the local clock is considered wrong and is
+// compensated
+const MaxClockSkew = 5 * time.Minute
+
+// MirrorSkew is the difference between the clock of a mirror, from the
+// Date header of its responses, and the local clock
+type MirrorSkew struct {
+	Mirror string `json:"mirror"`
+	// Skew is positive when the clock of the mirror is ahead
+	Skew       float64   `json:"skew_seconds"`
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// recordSkew measures the clock skew of the mirror that sent a response,
+// a warning is logged when it goes over MaxClockSkew
+func (a *Archive) recordSkew(mirror string, resp *resty.Response) {
+	if resp == nil {
+		return
+	}
+	date, err := http.ParseTime(resp.Header().Get("Date"))
+	if err != nil {
+		return
+	}
+	local := now()
+	skew := date.Sub(local)
+
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	if a.skews == nil {
+		a.skews = make(map[string]MirrorSkew)
+	}
+	previous, known := a.skews[mirror]
+	if isSkewed(skew) && (!known || !isSkewed(time.Duration(previous.Skew*float64(time.Second)))) {
+		log.Warnf("[%v] the clock of %v is %v away from the local clock, it's compensated", a.Name, mirror, skew.Round(time.Second))
+	}
+	a.skews[mirror] = MirrorSkew{
+		Mirror:     mirror,
+		Skew:       skew.Seconds(),
+		MeasuredAt: local,
+	}
+}
+
+func isSkewed(skew time.Duration) bool {
+	return skew > MaxClockSkew || skew < -MaxClockSkew
+}
+
+// ClockSkews returns the last clock skew measured for each mirror, sorted
+// by mirror
+func (a *Archive) ClockSkews() []MirrorSkew {
+	a.statusLock.Lock()
+	defer a.statusLock.Unlock()
+
+	skews := make([]MirrorSkew, 0, len(a.skews))
+	for _, skew := range a.skews {
+		skews = append(skews, skew)
+	}
+	sort.Slice(skews, func(i, j int) bool {
+		return skews[i].Mirror < skews[j].Mirror
+	})
+
+	return skews
+}
+
+// clockOffset is the correction of the local clock: the median of the
+// skews of the mirrors when it's over MaxClockSkew, 0 otherwise
+func (a *Archive) clockOffset() time.Duration {
+	skews := a.ClockSkews()
+	if len(skews) == 0 {
+		return 0
+	}
+	sort.Slice(skews, func(i, j int) bool {
+		return skews[i].Skew < skews[j].Skew
+	})
+
+	offset := time.Duration(skews[len(skews)/2].Skew * float64(time.Second))
+	if !isSkewed(offset) {
+		return 0
+	}
+
+	return offset
+}
+
+// Now returns the time according to the mirrors of the archive: the local
+// time, corrected when it's too far from the clocks of the mirrors
+func (a *Archive) Now() time.Time {
+	return now().Add(a.clockOffset())
+}
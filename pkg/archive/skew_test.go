@@ -0,0 +1,43 @@
+package archive
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestClockOffset(t *testing.T) {
+	response := func(skew time.Duration) *resty.Response {
+		header := http.Header{}
+		header.Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		return &resty.Response{RawResponse: &http.Response{Header: header}}
+	}
+
+	tests := []struct {
+		name     string
+		skews    []time.Duration
+		expected time.Duration
+	}{
+		{"no measure", nil, 0},
+		{"small skew", []time.Duration{time.Minute}, 0},
+		{"skewed mirrors", []time.Duration{time.Hour, time.Hour, 0}, time.Hour},
+		{"one skewed mirror", []time.Duration{-time.Hour, 0, 0}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &Archive{Name: "test"}
+			for i, skew := range test.skews {
+				a.recordSkew(string(rune('a'+i)), response(skew))
+			}
+
+			offset := a.clockOffset()
+			// the Date header has a resolution of one second
+			if diff := offset - test.expected; diff < -2*time.Second || diff > 2*time.Second {
+				t.Errorf("expected an offset of %v, got %v", test.expected, offset)
+			}
+		})
+	}
+}
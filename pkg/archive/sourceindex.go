@@ -0,0 +1,168 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+)
+
+// sourceIndexRegexp matches the Sources indexes listed in a Release file:
+// the component and the compression
+var sourceIndexRegexp = regexp.MustCompile(`^(.+)/source/Sources(\.gz|\.xz|\.bz2)?$`)
+
+// parseSourceStanza returns the source package of a paragraph of a
+// Sources index, nil if it has no name or version
+func parseSourceStanza(fields map[string]string) (*database.SourcePackage, error) {
+	if fields["Package"] == "" || fields["Version"] == "" {
+		return nil, nil
+	}
+
+	src := &database.SourcePackage{
+		Name:       fields["Package"],
+		Version:    fields["Version"],
+		Maintainer: fields["Maintainer"],
+		Binaries:   make([]string, 0),
+		Directory:  fields["Directory"],
+		Files:      make([]database.SourceFile, 0),
+	}
+	for _, binary := range strings.Split(fields["Binary"], ",") {
+		if binary = strings.TrimSpace(binary); binary != "" {
+			src.Binaries = append(src.Binaries, binary)
+		}
+	}
+	for _, line := range strings.Split(fields["Checksums-Sha256"], "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid checksum line %q of %v", line, src.Name)
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in %q of %v", line, src.Name)
+		}
+		src.Files = append(src.Files, database.SourceFile{Name: parts[2], Size: size, SHA256: parts[0]})
+	}
+
+	return src, nil
+}
+
+// parseSources reads a Sources index and calls insert with each source
+// package
+func parseSources(r io.Reader, insert func(src *database.SourcePackage) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	fields := make(map[string]string)
+	var key string
+	flush := func() error {
+		defer func() {
+			fields, key = make(map[string]string), ""
+		}()
+		src, err := parseSourceStanza(fields)
+		if err != nil || src == nil {
+			return err
+		}
+		return insert(src)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			err := flush()
+			if err != nil {
+				return err
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			if key != "" {
+				fields[key] += "\n" + strings.TrimSpace(line)
+			}
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				key = ""
+				continue
+			}
+			key = name
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// refreshSources downloads the Sources indexes that have changed and
+// replaces the source packages of each component in the DB
+func (a *Archive) refreshSources(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry) (int, error) {
+	previous := map[string]ReleaseFileEntry{}
+	if oldInfo, ok := a.ReleaseInfo[pocket]; ok {
+		previous = oldInfo.PackageIndex
+	}
+
+	// the file to download for each component, by compression
+	available := make(map[string]map[string]string)
+	for filePath := range releaseInfo {
+		matches := sourceIndexRegexp.FindStringSubmatch(filePath)
+		if matches == nil {
+			continue
+		}
+
+		if available[matches[1]] == nil {
+			available[matches[1]] = make(map[string]string)
+		}
+		available[matches[1]][matches[2]] = filePath
+	}
+
+	suite, suitePocket := splitSuitePocket(pocket)
+	nbFile := 0
+	for component, files := range available {
+		candidates := indexCandidates(files)
+		if previous[candidates[0]].Hash == releaseInfo[candidates[0]].Hash {
+			continue
+		}
+
+		_, localFile, err := a.downloadCandidate(local, pocket, url.URL(*a.BaseURL), candidates, releaseInfo)
+		if err != nil {
+			return nbFile, err
+		}
+		nbFile++
+
+		n, err := a.Database.ReplaceSources(suite, suitePocket, component, func(insert func(src *database.SourcePackage) error) error {
+			return readSourcesFile(localFile, insert)
+		})
+		if err != nil {
+			return nbFile, err
+		}
+		log.Debugf("[sources][%v] indexed %v source packages for %v", pocket, n, component)
+	}
+
+	return nbFile, nil
+}
+
+func readSourcesFile(filePath string, insert func(src *database.SourcePackage) error) error {
+	indexReader, err := openIndex(filePath)
+	if err != nil {
+		return err
+	}
+	defer indexReader.Close()
+
+	return parseSources(indexReader, insert)
+}
+
+// SourceFileURL returns the URL of a file of a source package, the
+// sources are on the main mirror
+func (a *Archive) SourceFileURL(src *database.SourcePackage, name string) string {
+	return strings.TrimSuffix(rootURL(a.BaseURL), "/") + "/" + strings.TrimPrefix(path.Join(src.Directory, name), "/")
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// SourcesEntry is an APT source for a suite and a component
+type SourcesEntry struct {
+	URI           string
+	Suite         string
+	Component     string
+	Architectures []string
+	SignedBy      string
+}
+
+// String formats the entry as a one-line sources.list entry
+func (e SourcesEntry) String() string {
+	options := make([]string, 0)
+	if len(e.Architectures) != 0 {
+		options = append(options, "arch="+strings.Join(e.Architectures, ","))
+	}
+	if e.SignedBy != "" {
+		options = append(options, "signed-by="+e.SignedBy)
+	}
+
+	formatedOptions := ""
+	if len(options) != 0 {
+		formatedOptions = fmt.Sprintf("[%v] ", strings.Join(options, " "))
+	}
+
+	// the entries of the flat repositories have no component
+	return strings.TrimSuffix(fmt.Sprintf("deb %v%v %v %v", formatedOptions, e.URI, e.Suite, e.Component), " ")
+}
+
+// Deb822 formats the entry as a deb822 stanza (for .sources files)
+func (e SourcesEntry) Deb822() string {
+	builder := new(strings.Builder)
+	fmt.Fprintln(builder, "Types: deb")
+	fmt.Fprintf(builder, "URIs: %v\n", e.URI)
+	fmt.Fprintf(builder, "Suites: %v\n", e.Suite)
+	if e.Component != "" {
+		fmt.Fprintf(builder, "Components: %v\n", e.Component)
+	}
+	if len(e.Architectures) != 0 {
+		fmt.Fprintf(builder, "Architectures: %v\n", strings.Join(e.Architectures, " "))
+	}
+	if e.SignedBy != "" {
+		fmt.Fprintf(builder, "Signed-By: %v\n", e.SignedBy)
+	}
+
+	return builder.String()
+}
+
+// rootURL returns the root of the archive (the URL APT expects) from the
+// URL of the dists directory
+func rootURL(distsURL *url.URL) string {
+	root := url.URL(*distsURL)
+	root.Path = strings.TrimSuffix(strings.TrimRight(root.Path, "/"), "/dists")
+	if root.Path == "" {
+		root.Path = "/"
+	}
+
+	return root.String()
+}
+
+// isPrimaryArch returns true if the architecture is hosted on the primary
+// archive instead of the ports archive
+func isPrimaryArch(arch string) bool {
+	return arch == "amd64" || arch == "i386"
+}
+
+// PoolURL returns the download URL of the .deb of a package from this
+// archive, "" if its Filename is unknown
+func (a *Archive) PoolURL(pkg *debianpkg.PackageInfo) string {
+	if pkg.FileName == "" {
+		return ""
+	}
+
+	// the ports architectures are downloaded from the ports mirror, the
+	// others (and the flat repositories) from the primary archive
+	root := rootURL(a.PortsURL)
+	if isPrimaryArch(pkg.Architecture) || pkg.Architecture == "all" || a.isFlat() {
+		root = rootURL(a.BaseURL)
+	}
+
+	// the file names of the flat repositories can start with ./
+	return strings.TrimSuffix(root, "/") + "/" + strings.TrimPrefix(strings.TrimPrefix(pkg.FileName, "./"), "/")
+}
+
+// SourcesEntries returns the APT sources needed to install the given
+// packages from this archive. Packages from the same suite, component and
+// mirror are grouped under a single entry.
+func (a *Archive) SourcesEntries(pkgs []*debianpkg.PackageInfo) []SourcesEntry {
+	entries := make(map[string]*SourcesEntry)
+	for _, pkg := range pkgs {
+		uri := rootURL(a.PortsURL)
+		if isPrimaryArch(pkg.Architecture) || a.isFlat() {
+			uri = rootURL(a.BaseURL)
+		}
+
+		suite := pkg.Suite + pkg.Pocket
+		if a.isFlat() {
+			// the directory of the repository (./)
+			suite += "/"
+		}
+		key := path.Join(uri, suite, pkg.Component)
+
+		entry, ok := entries[key]
+		if !ok {
+			entry = &SourcesEntry{
+				URI:       uri,
+				Suite:     suite,
+				Component: pkg.Component,
+				SignedBy:  a.SignedBy,
+			}
+			entries[key] = entry
+		}
+
+		if !containsString(entry.Architectures, pkg.Architecture) {
+			entry.Architectures = append(entry.Architectures, pkg.Architecture)
+		}
+	}
+
+	out := make([]SourcesEntry, 0, len(entries))
+	for _, entry := range entries {
+		sort.Strings(entry.Architectures)
+		out = append(out, *entry)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Suite != out[j].Suite {
+			return out[i].Suite < out[j].Suite
+		}
+		if out[i].Component != out[j].Component {
+			return out[i].Component < out[j].Component
+		}
+		return out[i].URI < out[j].URI
+	})
+
+	return out
+}
+
+func containsString(slice []string, elmt string) bool {
+	for _, e := range slice {
+		if e == elmt {
+			return true
+		}
+	}
+
+	return false
+}
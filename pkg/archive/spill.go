@@ -0,0 +1,249 @@
+package archive
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// spill modes, see Archive.Spill
+const (
+	// SpillAuto spills the packages to disk when memory pressure is
+	// detected during a refresh
+	SpillAuto   = "auto"
+	SpillAlways = "always"
+	SpillNever  = "never"
+)
+
+const (
+	// spillRunSize is the number of packages sorted in memory before
+	// being written to a run file
+	spillRunSize = 20000
+	// spillCheckInterval is the number of packages received between two
+	// checks of the memory pressure
+	spillCheckInterval = 1000
+	// memoryPressureRatio is the part of the memory limit the heap can use
+	// before the refresh spills to disk
+	memoryPressureRatio = 0.7
+)
+
+// memoryPressure is replaced in the tests
+var memoryPressure = underMemoryPressure
+
+// memoryLimit returns the memory limit of the process (GOMEMLIMIT or the
+// limit of its cgroup), 0 if there is none
+func memoryLimit() uint64 {
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return uint64(limit)
+	}
+
+	// cgroup v2 then v1, v1 reports a huge number without limit
+	for _, file := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err == nil && limit < 1<<60 {
+			return limit
+		}
+	}
+
+	return 0
+}
+
+// underMemoryPressure returns true when the heap uses a large part of the
+// memory limit
+func underMemoryPressure() bool {
+	limit := memoryLimit()
+	if limit == 0 {
+		return false
+	}
+
+	stats := new(runtime.MemStats)
+	runtime.ReadMemStats(stats)
+
+	return float64(stats.HeapInuse) > memoryPressureRatio*float64(limit)
+}
+
+// spillKey orders the packages in the run files, the database is indexed
+// by name
+func spillKey(pkg *debianpkg.PackageInfo) string {
+	return pkg.Name + "\x00" + pkg.Suite + pkg.Pocket + "\x00" + pkg.Architecture + "\x00" + pkg.Component
+}
+
+// spiller stores the packages of a refresh in sorted run files instead of
+// keeping them in memory while the database catches up, the runs are
+// merged when all the indexes are parsed
+type spiller struct {
+	dir  string
+	buf  []*debianpkg.PackageInfo
+	runs []string
+}
+
+func newSpiller(cacheDir string) (*spiller, error) {
+	dir, err := os.MkdirTemp(cacheDir, "spill")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spiller{dir: dir, buf: make([]*debianpkg.PackageInfo, 0, spillRunSize)}, nil
+}
+
+// add buffers a package, a run is written when the buffer is full
+func (s *spiller) add(pkg *debianpkg.PackageInfo) error {
+	s.buf = append(s.buf, pkg)
+	if len(s.buf) < spillRunSize {
+		return nil
+	}
+
+	return s.flush()
+}
+
+// flush writes the packages buffered to a new run file
+func (s *spiller) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	sort.Slice(s.buf, func(i, j int) bool { return spillKey(s.buf[i]) < spillKey(s.buf[j]) })
+
+	runPath := path.Join(s.dir, fmt.Sprintf("run-%v", len(s.runs)))
+	file, err := os.Create(runPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+	for _, pkg := range s.buf {
+		err = encoder.Encode(pkg)
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, runPath)
+	s.buf = s.buf[:0]
+
+	return file.Close()
+}
+
+// drain calls fn for the packages buffered and empties the buffer, when
+// they can't be written to a run
+func (s *spiller) drain(fn func(pkg *debianpkg.PackageInfo)) {
+	for _, pkg := range s.buf {
+		fn(pkg)
+	}
+	s.buf = s.buf[:0]
+}
+
+// runReader is the next package of a run being merged
+type runReader struct {
+	decoder *gob.Decoder
+	next    *debianpkg.PackageInfo
+}
+
+func (r *runReader) read() error {
+	r.next = new(debianpkg.PackageInfo)
+	err := r.decoder.Decode(r.next)
+	if err == io.EOF {
+		r.next = nil
+		return nil
+	}
+
+	return err
+}
+
+// runHeap orders the runs by their next package
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return spillKey(h[i].next) < spillKey(h[j].next) }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// merge calls fn for all the packages added, in order
+func (s *spiller) merge(fn func(pkg *debianpkg.PackageInfo)) error {
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+
+	runs := make(runHeap, 0, len(s.runs))
+	for _, runPath := range s.runs {
+		file, err := os.Open(runPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		r := &runReader{decoder: gob.NewDecoder(bufio.NewReader(file))}
+		err = r.read()
+		if err != nil {
+			return err
+		}
+		if r.next != nil {
+			runs = append(runs, r)
+		}
+	}
+	heap.Init(&runs)
+
+	for len(runs) != 0 {
+		r := runs[0]
+		fn(r.next)
+
+		err = r.read()
+		if err != nil {
+			return err
+		}
+		if r.next == nil {
+			heap.Pop(&runs)
+		} else {
+			heap.Fix(&runs, 0)
+		}
+	}
+
+	return nil
+}
+
+// close removes the run files
+func (s *spiller) close() {
+	os.RemoveAll(s.dir)
+}
+
+// shouldSpill tells if updatePackageInfo should start spilling, received
+// is the number of packages received so far
+func (a *Archive) shouldSpill(received int) bool {
+	switch a.Spill {
+	case SpillAlways:
+		return received == 0
+	case SpillNever:
+		return false
+	}
+
+	return received%spillCheckInterval == 0 && memoryPressure()
+}
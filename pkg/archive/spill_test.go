@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSpillMerge(t *testing.T) {
+	s, err := newSpiller(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// several runs, added out of order
+	n := 2*spillRunSize + 123
+	for i := 0; i < n; i++ {
+		err = s.add(&debianpkg.PackageInfo{Name: fmt.Sprintf("pkg%06d", (i*7919)%n), Suite: "noble", Architecture: "amd64"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.runs) != 2 {
+		t.Errorf("expected 2 runs written, got %v", len(s.runs))
+	}
+
+	merged := 0
+	previous := ""
+	err = s.merge(func(pkg *debianpkg.PackageInfo) {
+		if spillKey(pkg) < previous {
+			t.Fatalf("%v merged after %v", pkg.Name, previous)
+		}
+		previous = spillKey(pkg)
+		merged++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged != n {
+		t.Errorf("expected %v packages, got %v", n, merged)
+	}
+}
+
+func TestUpdatePackageInfoSpill(t *testing.T) {
+	db, err := database.NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// pressure is detected in the middle of the refresh
+	checks := 0
+	memoryPressure = func() bool {
+		checks++
+		return checks > 1
+	}
+	defer func() { memoryPressure = underMemoryPressure }()
+
+	a := &Archive{Name: "test", Database: db, CacheDir: t.TempDir()}
+	packages := make(chan *debianpkg.PackageInfo)
+	stats := make(chan packageStats)
+	go a.updatePackageInfo(packages, false, nil, stats)
+
+	n := 3 * spillCheckInterval
+	for i := 0; i < n; i++ {
+		packages <- &debianpkg.PackageInfo{Name: fmt.Sprintf("pkg%v", i), Version: "1.0", Suite: "noble", Component: "main", Architecture: "amd64"}
+	}
+	close(packages)
+
+	update := <-stats
+	if update.err != nil {
+		t.Fatal(update.err)
+	}
+	inserted := update.inserted
+	if inserted != n {
+		t.Errorf("expected %v packages inserted, got %v", n, inserted)
+	}
+	count, err := db.CountPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("expected %v packages in the database, got %v", n, count)
+	}
+}
+
+func TestUpdatePackageInfoNotify(t *testing.T) {
+	db, err := database.NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hello := &debianpkg.PackageInfo{Name: "hello", Version: "2.10-2", Suite: "noble", Component: "main", Architecture: "amd64"}
+	bash := &debianpkg.PackageInfo{Name: "bash", Version: "5.2", Suite: "noble", Component: "main", Architecture: "amd64"}
+	for _, pkg := range []*debianpkg.PackageInfo{hello, bash} {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lookup made on the change sees the new version
+	changes := 0
+	a := &Archive{Name: "test", Database: db, CacheDir: t.TempDir()}
+	a.OnChange = func(change PackageChange) {
+		changes++
+		if change.OldVersion != "2.10-2" || change.Version != "2.10-3" {
+			t.Errorf("unexpected change %+v", change)
+		}
+
+		pkgs, err := db.GetPackage(change.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkgs) != 1 || pkgs[0].Version != change.Version {
+			t.Errorf("change notified before the commit")
+		}
+	}
+
+	packages := make(chan *debianpkg.PackageInfo)
+	stats := make(chan packageStats)
+	go a.updatePackageInfo(packages, true, nil, stats)
+
+	updated := *hello
+	updated.Version = "2.10-3"
+	packages <- &updated
+	// unchanged
+	packages <- bash
+	close(packages)
+	<-stats
+
+	if changes != 1 {
+		t.Errorf("expected 1 change, got %v", changes)
+	}
+}
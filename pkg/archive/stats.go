@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxParseErrors is the number of parse errors kept in ParseStats
+const maxParseErrors = 20
+
+// knownFields are the fields found in Packages indexes
+// see deb-control(5) and https://wiki.debian.org/DebianRepository/Format
+var knownFields = map[string]bool{
+	"Package": true, "Source": true, "Version": true, "Section": true,
+	"Priority": true, "Architecture": true, "Essential": true,
+	"Depends": true, "Pre-Depends": true, "Recommends": true,
+	"Suggests": true, "Breaks": true, "Conflicts": true, "Provides": true,
+	"Replaces": true, "Enhances": true, "Built-Using": true,
+	"Static-Built-Using": true, "Installed-Size": true, "Maintainer": true,
+	"Original-Maintainer": true, "Description": true, "Description-md5": true,
+	"Homepage": true, "Filename": true, "Size": true, "MD5sum": true,
+	"SHA1": true, "SHA256": true, "SHA512": true, "Multi-Arch": true,
+	"Tag": true, "Task": true, "Origin": true, "Bugs": true,
+	"Supported": true, "Build-Essential": true, "Important": true,
+	"Protected": true, "Ruby-Versions": true, "Lua-Versions": true,
+	"Python-Version": true, "Python3-Version": true, "Go-Import-Path": true,
+	"Cnf-Extra-Commands": true, "Cnf-Priority-Bonus": true,
+	"Cnf-Visible-Pkgname": true, "Cnf-Ignore-Commands": true,
+	"Gstreamer-Version": true, "Gstreamer-Elements": true,
+	"Gstreamer-Uri-Sources": true, "Gstreamer-Uri-Sinks": true,
+	"Gstreamer-Encoders": true, "Gstreamer-Decoders": true,
+	"Postgresql-Catversion": true, "Ghc-Package": true, "Modaliases": true,
+	"Build-Ids": true, "Auto-Built-Package": true, "Xul-Appid": true,
+	"Efi-Vendor": true, "X-Cargo-Built-Using": true, "Phased-Update-Percentage": true,
+	"Npm-Package": true, "Javascript-Built-Using": true,
+}
+
+// ParseStats are the anomalies found while parsing the indexes during a
+// refresh
+type ParseStats struct {
+	SkippedStanzas   int      `json:"skipped_stanzas"`
+	UnknownFields    int      `json:"unknown_fields"`
+	EmptySuites      int      `json:"empty_suites"`
+	ChecksumFailures int      `json:"checksum_failures"`
+	Errors           []string `json:"errors"`
+}
+
+// parseStatsCollector collects ParseStats from concurrent parsers. A nil
+// collector discards everything.
+type parseStatsCollector struct {
+	lock  sync.Mutex
+	stats ParseStats
+}
+
+func (c *parseStatsCollector) update(fn func(stats *ParseStats)) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn(&c.stats)
+}
+
+func (c *parseStatsCollector) skippedStanza() {
+	c.update(func(stats *ParseStats) {
+		stats.SkippedStanzas++
+	})
+}
+
+func (c *parseStatsCollector) unknownField() {
+	c.update(func(stats *ParseStats) {
+		stats.UnknownFields++
+	})
+}
+
+func (c *parseStatsCollector) emptySuite(pocket string) {
+	c.update(func(stats *ParseStats) {
+		stats.EmptySuites++
+	})
+	c.error("pocket %v has no package index", pocket)
+}
+
+func (c *parseStatsCollector) checksumFailure(file string) {
+	c.update(func(stats *ParseStats) {
+		stats.ChecksumFailures++
+	})
+	c.error("checksum mismatch for %v", file)
+}
+
+// error records a parse error, only the last maxParseErrors are kept
+func (c *parseStatsCollector) error(format string, args ...interface{}) {
+	c.update(func(stats *ParseStats) {
+		stats.Errors = append(stats.Errors, fmt.Sprintf(format, args...))
+		if len(stats.Errors) > maxParseErrors {
+			stats.Errors = stats.Errors[len(stats.Errors)-maxParseErrors:]
+		}
+	})
+}
+
+// snapshot returns a copy of the stats
+func (c *parseStatsCollector) snapshot() ParseStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats := c.stats
+	stats.Errors = append([]string{}, c.stats.Errors...)
+
+	return stats
+}
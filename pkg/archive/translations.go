@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// translationRegexp matches the Translation indexes listed in a Release
+// file: the component, the language and the compression
+var translationRegexp = regexp.MustCompile(`^([^/]+)/i18n/Translation-([A-Za-z_]+)\.(gz|xz|bz2)$`)
+
+// translationCompressions are the compressions of the Translation indexes,
+// the first one available is downloaded
+var translationCompressions = []string{"gz", "xz", "bz2"}
+
+// translationIndex is a Translation index of a component in a language
+type translationIndex struct {
+	component string
+	lang      string
+}
+
+// parseTranslation reads a Translation index and calls insert with the
+// description of each package: the short description and the long one,
+// separated by a new line
+func parseTranslation(r io.Reader, lang string, insert func(name, description string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	descriptionField := "Description-" + lang + ": "
+	var (
+		name        string
+		description []string
+		inField     bool
+	)
+	flush := func() error {
+		defer func() {
+			name, description, inField = "", nil, false
+		}()
+		if name == "" || len(description) == 0 {
+			return nil
+		}
+		return insert(name, strings.Join(description, "\n"))
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			err := flush()
+			if err != nil {
+				return err
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			// continuation of the long description, " ." is an empty line
+			if inField {
+				paragraph := strings.TrimSpace(line)
+				if paragraph == "." {
+					paragraph = ""
+				}
+				description = append(description, paragraph)
+			}
+		case strings.HasPrefix(line, "Package: "):
+			name = strings.TrimPrefix(line, "Package: ")
+			inField = false
+		case strings.HasPrefix(line, descriptionField):
+			description = []string{strings.TrimPrefix(line, descriptionField)}
+			inField = true
+		default:
+			inField = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// refreshTranslations downloads the Translation indexes of the languages
+// of a.Translations that have changed and replaces the descriptions of
+// each component and language in the DB
+func (a *Archive) refreshTranslations(local bool, pocket string, releaseInfo map[string]ReleaseFileEntry) (int, error) {
+	previous := map[string]ReleaseFileEntry{}
+	if oldInfo, ok := a.ReleaseInfo[pocket]; ok {
+		previous = oldInfo.PackageIndex
+	}
+
+	// the file to download for each index, by compression
+	available := make(map[translationIndex]map[string]string)
+	for filePath := range releaseInfo {
+		matches := translationRegexp.FindStringSubmatch(filePath)
+		if matches == nil || !containsString(a.Translations, matches[2]) {
+			continue
+		}
+
+		index := translationIndex{component: matches[1], lang: matches[2]}
+		if available[index] == nil {
+			available[index] = make(map[string]string)
+		}
+		available[index][matches[3]] = filePath
+	}
+
+	suite, suitePocket := splitSuitePocket(pocket)
+	nbFile := 0
+	for index, files := range available {
+		var filePath string
+		for _, compression := range translationCompressions {
+			if filePath = files[compression]; filePath != "" {
+				break
+			}
+		}
+		if previous[filePath].Hash == releaseInfo[filePath].Hash {
+			continue
+		}
+
+		fileURL := url.URL(*a.BaseURL)
+		fileURL.Path = path.Join(fileURL.Path, pocket, filePath)
+		localFile := a.cachePath(fileURL)
+		if _, err := os.Stat(localFile); !local || os.IsNotExist(err) {
+			err := a.downloadIndex(pocket, fileURL, releaseInfo[filePath].Hash, localFile)
+			if err != nil {
+				return nbFile, err
+			}
+		}
+		nbFile++
+		err := a.verifyIndex(fileURL, releaseInfo[filePath].Hash, localFile)
+		if err != nil {
+			return nbFile, err
+		}
+
+		n, err := a.Database.ReplaceTranslations(suite, suitePocket, index.component, index.lang, func(insert func(name, description string) error) error {
+			return readTranslationFile(localFile, index.lang, insert)
+		})
+		if err != nil {
+			return nbFile, err
+		}
+		log.Debugf("[translations][%v] indexed %v descriptions for %v/%v", pocket, n, index.component, index.lang)
+	}
+
+	return nbFile, nil
+}
+
+func readTranslationFile(filePath, lang string, insert func(name, description string) error) error {
+	indexReader, err := openIndex(filePath)
+	if err != nil {
+		return err
+	}
+	defer indexReader.Close()
+
+	return parseTranslation(indexReader, lang, insert)
+}
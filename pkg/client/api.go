@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// maxWaitRequest is the timeout of each /api/wait request of WaitForVersion,
+// the servers cap it at 30 minutes
+const maxWaitRequest = 10 * time.Minute
+
+// ErrNotFound is matched (with errors.Is) by the errors of the lookups of
+// unknown packages
+var ErrNotFound = errors.New("not found")
+
+// Error is an error response of a server
+type Error struct {
+	Server string
+	Status int
+	// Code is the code of the API (not_found, bad_request...), clients
+	// should rely on it rather than on the message
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v: %v", e.Server, http.StatusText(e.Status))
+	}
+
+	return fmt.Sprintf("%v: %v", e.Server, e.Message)
+}
+
+// Is matches ErrNotFound for the not_found errors
+func (e *Error) Is(target error) bool {
+	return target == ErrNotFound && e.Code == "not_found"
+}
+
+// do sends a request to the servers following key on the ring until one
+// answers, and decodes the JSON response in result. The error responses
+// other than 429 and 5xx are returned without trying the other servers,
+// they index the same archives.
+func (c *Client) do(ctx context.Context, key, method, path string, query map[string]string, body, result interface{}) error {
+	var lastErr error
+	for _, server := range c.ring.lookup(key) {
+		errBody := new(struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		})
+		// the responses are decoded as JSON whatever their Content-Type
+		req := c.HTTP.R().SetContext(ctx).SetQueryParams(query).SetError(errBody).ForceContentType("application/json")
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+
+		resp, err := req.Execute(method, server+path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.IsError() {
+			lastErr = &Error{Server: server, Status: resp.StatusCode(), Code: errBody.Error.Code, Message: errBody.Error.Message}
+			if !retryable(resp, nil) {
+				return lastErr
+			}
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// Lookup returns the versions of a package, filters are the query
+// parameters of the lookup endpoint (suite, arch, latest...) but group.
+// The error matches ErrNotFound if the package is unknown.
+func (c *Client) Lookup(ctx context.Context, pkg string, filters map[string]string) ([]*debianpkg.PackageInfo, error) {
+	var pkgs []*debianpkg.PackageInfo
+	err := c.do(ctx, pkg, http.MethodGet, "/"+url.PathEscape(pkg), filters, nil, &pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkgs, nil
+}
+
+// SearchResult is the response of a search, Truncated is set when more
+// packages than the limit match
+type SearchResult struct {
+	Packages  []*debianpkg.PackageInfo `json:"results"`
+	Truncated bool                     `json:"truncated"`
+}
+
+// Search returns the packages whose name matches a glob pattern (e.g.
+// libssl*), filters are the query parameters of the search endpoint
+// (suite, arch, text, limit...) but group
+func (c *Client) Search(ctx context.Context, pattern string, filters map[string]string) (*SearchResult, error) {
+	query := make(map[string]string, len(filters)+1)
+	for param, value := range filters {
+		query[param] = value
+	}
+	if pattern != "" {
+		query["q"] = pattern
+	}
+
+	result := new(SearchResult)
+	err := c.do(ctx, pattern, http.MethodGet, "/api/search", query, nil, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// WaitForVersion blocks until a version of the package at least
+// minVersion (any version if empty) is published in a suite, and for arch
+// if not empty, and returns the matching packages. It waits until ctx is
+// done, with successive requests to /api/wait.
+func (c *Client) WaitForVersion(ctx context.Context, pkg, suite, arch, minVersion string) ([]*debianpkg.PackageInfo, error) {
+	query := map[string]string{"pkg": pkg}
+	if suite != "" {
+		query["suite"] = suite
+	}
+	if arch != "" {
+		query["arch"] = arch
+	}
+	if minVersion != "" {
+		query["min_version"] = minVersion
+	}
+
+	for {
+		timeout := maxWaitRequest
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = min(timeout, time.Until(deadline).Round(time.Second))
+		}
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		query["timeout"] = timeout.String()
+
+		var pkgs []*debianpkg.PackageInfo
+		err := c.do(ctx, pkg, http.MethodGet, "/api/wait", query, nil, &pkgs)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusGatewayTimeout {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return pkgs, nil
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	var failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			// the first request fails, it's retried
+			if failures.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`[{"name": "flaky", "version": "1.0"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "not_found", "message": "package missing not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	c, err := New([]string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.HTTP.SetRetryWaitTime(time.Millisecond)
+
+	pkgs, err := c.Lookup(context.Background(), "flaky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Version != "1.0" {
+		t.Errorf("unexpected packages %v", pkgs)
+	}
+
+	_, err = c.Lookup(context.Background(), "missing", nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestWaitForVersion(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/wait" || r.URL.Query().Get("min_version") != "2.0" || r.URL.Query().Get("timeout") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first wait expires
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.Write([]byte(`[{"name": "hello", "version": "2.1"}]`))
+	}))
+	defer server.Close()
+
+	c, err := New([]string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pkgs, err := c.WaitForVersion(ctx, "hello", "noble", "", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Version != "2.1" || requests.Load() != 2 {
+		t.Errorf("unexpected packages %v after %v requests", pkgs, requests.Load())
+	}
+}
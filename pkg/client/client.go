@@ -0,0 +1,169 @@
+// Package client queries a pool of rmadison servers. Each package is
+// looked up on the same server (with consistent hashing) so it stays in
+// its caches, the next servers are used when it fails. Batch lookups are
+// split across the servers and the results are merged.
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/go-resty/resty/v2"
+)
+
+// maxBatchSize is the maximum number of packages sent in one request,
+// larger shards are split
+const maxBatchSize = 1000
+
+// retries of the requests to a server that fails (errors, 429 and 5xx),
+// before trying the next one
+const (
+	defaultRetries      = 2
+	defaultRetryWait    = 500 * time.Millisecond
+	defaultRetryMaxWait = 5 * time.Second
+)
+
+// retryable tells if a request should be sent again to the same server
+func retryable(resp *resty.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	if expiredWait(resp) {
+		// WaitForVersion sends the next wait itself
+		return false
+	}
+
+	return resp.StatusCode() == http.StatusTooManyRequests || resp.StatusCode() >= http.StatusInternalServerError
+}
+
+// expiredWait tells if the response is a wait that timed out before the
+// version was published
+func expiredWait(resp *resty.Response) bool {
+	return resp.StatusCode() == http.StatusGatewayTimeout && resp.Request != nil && resp.Request.RawRequest != nil &&
+		strings.HasSuffix(resp.Request.RawRequest.URL.Path, "/wait")
+}
+
+// Client queries a pool of servers, its methods can be called
+// concurrently
+type Client struct {
+	// HTTP sends the requests, the retries can be tuned on it
+	HTTP *resty.Client
+
+	servers []string
+	ring    *ring
+}
+
+// New returns a client for the servers given (e.g.
+// https://packages.gauthier.uk)
+func New(servers []string) (*Client, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers")
+	}
+
+	cleanServers := make([]string, len(servers))
+	for i, server := range servers {
+		_, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		cleanServers[i] = strings.TrimSuffix(server, "/")
+	}
+
+	httpClient := resty.New().
+		SetRetryCount(defaultRetries).
+		SetRetryWaitTime(defaultRetryWait).
+		SetRetryMaxWaitTime(defaultRetryMaxWait).
+		AddRetryCondition(retryable)
+
+	return &Client{
+		HTTP:    httpClient,
+		servers: cleanServers,
+		ring:    newRing(cleanServers),
+	}, nil
+}
+
+// shards groups the packages by the server owning them
+func (c *Client) shards(pkgs []string) map[string][]string {
+	shards := make(map[string][]string)
+	for _, pkg := range pkgs {
+		server := c.ring.lookup(pkg)[0]
+		shards[server] = append(shards[server], pkg)
+	}
+
+	return shards
+}
+
+// batchWithFallback sends the lookup to the servers following the first
+// package on the ring until one answers
+func (c *Client) batchWithFallback(ctx context.Context, pkgs []string, filters map[string]string) (map[string][]*debianpkg.PackageInfo, error) {
+	result := make(map[string][]*debianpkg.PackageInfo)
+	err := c.do(ctx, pkgs[0], http.MethodPost, "/api/batch", filters, map[string][]string{"packages": pkgs}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// BatchLookup is Batch without a context
+func (c *Client) BatchLookup(pkgs []string, filters map[string]string) (map[string][]*debianpkg.PackageInfo, error) {
+	return c.Batch(context.Background(), pkgs, filters)
+}
+
+// Batch looks up all the packages, filters are the query parameters of the
+// lookup endpoint (suite, arch, latest...). The packages are split across
+// the servers and the lookups run in parallel. If a server fails, its
+// packages are sent to the next servers on the ring.
+func (c *Client) Batch(ctx context.Context, pkgs []string, filters map[string]string) (map[string][]*debianpkg.PackageInfo, error) {
+	type shardResult struct {
+		result map[string][]*debianpkg.PackageInfo
+		err    error
+	}
+
+	results := make(chan shardResult)
+	wg := new(sync.WaitGroup)
+	for _, shard := range c.shards(pkgs) {
+		for start := 0; start < len(shard); start += maxBatchSize {
+			end := start + maxBatchSize
+			if end > len(shard) {
+				end = len(shard)
+			}
+
+			wg.Add(1)
+			go func(chunk []string) {
+				defer wg.Done()
+				result, err := c.batchWithFallback(ctx, chunk, filters)
+				results <- shardResult{result, err}
+			}(shard[start:end])
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	merged := make(map[string][]*debianpkg.PackageInfo, len(pkgs))
+	var err error
+	for res := range results {
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		for pkg, info := range res.result {
+			merged[pkg] = info
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
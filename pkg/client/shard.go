@@ -0,0 +1,69 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points of each server on the ring, more
+// points spread the packages more evenly
+const virtualNodes = 128
+
+// ring is a consistent hash ring: each package is assigned to a server and
+// adding or removing a server only moves the packages of that server
+type ring struct {
+	points  []uint32
+	servers map[uint32]string
+}
+
+// hash spreads similar keys (e.g. server#1, server#2) over the ring
+func hash(key string) uint32 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func newRing(servers []string) *ring {
+	r := &ring{
+		points:  make([]uint32, 0, len(servers)*virtualNodes),
+		servers: make(map[uint32]string, len(servers)*virtualNodes),
+	}
+
+	for _, server := range servers {
+		for i := 0; i < virtualNodes; i++ {
+			point := hash(server + "#" + strconv.Itoa(i))
+			if _, ok := r.servers[point]; ok {
+				continue
+			}
+			r.points = append(r.points, point)
+			r.servers[point] = server
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r
+}
+
+// lookup returns the distinct servers in the order they follow key on the
+// ring, the first one owns the key and the others are fallbacks
+func (r *ring) lookup(key string) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	h := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+
+	seen := make(map[string]bool)
+	servers := make([]string, 0)
+	for i := 0; i < len(r.points); i++ {
+		server := r.servers[r.points[(start+i)%len(r.points)]]
+		if !seen[server] {
+			seen[server] = true
+			servers = append(servers, server)
+		}
+	}
+
+	return servers
+}
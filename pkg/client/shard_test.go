@@ -0,0 +1,38 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	servers := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}
+	r := newRing(servers)
+
+	owners := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		pkg := fmt.Sprintf("package%v", i)
+		lookup := r.lookup(pkg)
+		if len(lookup) != len(servers) {
+			t.Fatalf("expected %v servers for %v, got %v", len(servers), pkg, lookup)
+		}
+		owners[pkg] = lookup[0]
+		count[lookup[0]]++
+	}
+
+	for _, server := range servers {
+		if count[server] < 500 {
+			t.Errorf("%v owns only %v packages", server, count[server])
+		}
+	}
+
+	// removing a server only moves its packages
+	r = newRing(servers[:2])
+	for pkg, owner := range owners {
+		newOwner := r.lookup(pkg)[0]
+		if owner != servers[2] && newOwner != owner {
+			t.Errorf("%v moved from %v to %v", pkg, owner, newOwner)
+		}
+	}
+}
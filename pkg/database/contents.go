@@ -0,0 +1,103 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ContentsEntry is a file shipped by a package
+type ContentsEntry struct {
+	Path         string `json:"path"`
+	Package      string `json:"package"`
+	Component    string `json:"component"`
+	Suite        string `json:"suite"`
+	Pocket       string `json:"pocket"`
+	Architecture string `json:"architecture"`
+}
+
+func (db *DB) createContentsTableIfNeeded() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS contents (
+		'path' TEXT NOT NULL,
+		'package' VARCHAR(64) NOT NULL,
+		'component' VARCHAR(64) NOT NULL,
+		'suite' VARCHAR(64) NOT NULL,
+		'pocket' VARCHAR(64) NOT NULL,
+		'architecture' VARCHAR(10) NOT NULL
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create contents table")
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_contents_path ON contents (path)")
+	if err != nil {
+		return errors.Wrap(err, "failed to create index")
+	}
+
+	return nil
+}
+
+// ReplaceContents replaces the list of files of a suite, pocket and
+// architecture. read is called with a function inserting one entry, it is
+// expected to call it for every entry of the Contents index. Nothing is
+// changed if read returns an error.
+func (db *DB) ReplaceContents(suite, pocket, arch string, read func(insert func(path, pkg, component string) error) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM contents WHERE suite=? AND pocket=? AND architecture=?", suite, pocket, arch)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO contents VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+
+	n := 0
+	err = read(func(path, pkg, component string) error {
+		n++
+		_, err := stmt.Exec(path, pkg, component, suite, pocket, arch)
+		return err
+	})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// SearchFile returns the packages shipping the file at path (without the
+// leading /)
+func (db *DB) SearchFile(path string, filter Filter) ([]*ContentsEntry, error) {
+	where, args := filter.where()
+	if where == "" {
+		where = " WHERE path = ?"
+	} else {
+		where += " AND path = ?"
+	}
+	args = append(args, path)
+
+	rows, err := db.Query("SELECT path, package, component, suite, pocket, architecture FROM contents"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*ContentsEntry, 0)
+	for rows.Next() {
+		entry := new(ContentsEntry)
+		err = rows.Scan(&entry.Path, &entry.Package, &entry.Component, &entry.Suite, &entry.Pocket, &entry.Architecture)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/pkg/errors"
+)
+
+// HistoryEntry records when a version of a package was first seen in a
+// suite
+type HistoryEntry struct {
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Component    string    `json:"component"`
+	Suite        string    `json:"suite"`
+	Pocket       string    `json:"pocket"`
+	Architecture string    `json:"architecture"`
+	FirstSeen    time.Time `json:"first_seen"`
+	// LastSeen is the date of the last Release file the version was seen
+	// in, nil if unknown (backfilled or recorded by older versions)
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// InitialImport is true if the version was already there when the
+	// suite was indexed for the first time, it may have been published
+	// before FirstSeen
+	InitialImport bool `json:"initial_import"`
+}
+
+// historyAddedColumns are the columns added to the history table after
+// its creation, they are NULL for the versions gone before
+var historyAddedColumns = [][2]string{
+	{"last_seen", "INTEGER NULL"},
+	{"size", "INTEGER NULL"},
+	{"installed_size", "INTEGER NULL"},
+}
+
+func (db *DB) createHistoryTableIfNeeded() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS history (
+		'name' VARCHAR(64) NOT NULL,
+		'version' VARCHAR(64) NOT NULL,
+		'component' VARCHAR(64) NOT NULL,
+		'suite' VARCHAR(64) NOT NULL,
+		'pocket' VARCHAR(64) NOT NULL,
+		'architecture' VARCHAR(10) NOT NULL,
+		'first_seen' INTEGER NOT NULL,
+		'initial_import' BOOLEAN NOT NULL,
+		PRIMARY KEY ('name', 'version', 'component', 'suite', 'pocket', 'architecture')
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create history table")
+	}
+
+	for _, column := range historyAddedColumns {
+		var n int
+		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('history') WHERE name=?", column[0]).Scan(&n)
+		if err != nil {
+			return errors.Wrap(err, "failed to get columns of the history table")
+		}
+		if n == 0 {
+			_, err = db.Exec(fmt.Sprintf("ALTER TABLE history ADD COLUMN '%v' %v", column[0], column[1]))
+			if err != nil {
+				return errors.Wrapf(err, "failed to add column %v", column[0])
+			}
+		}
+	}
+
+	// the dates of the Release files indexed, a version not seen in a
+	// Release file after its last_seen has been removed
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS releases (
+		'suite' VARCHAR(64) NOT NULL,
+		'pocket' VARCHAR(64) NOT NULL,
+		'date' INTEGER NOT NULL,
+		PRIMARY KEY ('suite', 'pocket', 'date')
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create releases table")
+	}
+
+	// packages whose history has been backfilled
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS backfill (
+		'name' VARCHAR(64) NOT NULL PRIMARY KEY,
+		'done_at' INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create backfill table")
+	}
+
+	return nil
+}
+
+// HasHistory returns true if versions of packages have already been
+// recorded for the suite and pocket
+func (db *DB) HasHistory(suite, pocket string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM history WHERE suite=? AND pocket=? LIMIT 1)", suite, pocket).Scan(&n)
+
+	return n != 0, err
+}
+
+// PrepareInsertHistory records the version of the package (and its sizes)
+// if it's the first time it's seen in its suite, or updates the last time
+// it was seen, in the current transaction (see PrepareInsertPackage)
+func (db *DB) PrepareInsertHistory(pkgInfo *debianpkg.PackageInfo, seen time.Time, initialImport bool) error {
+	var err error
+
+	if db.transaction == nil {
+		db.transaction, err = db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "cannot start transaction, something is bad")
+		}
+	}
+
+	_, err = db.transaction.Exec(`INSERT INTO history (
+		name, version, component, suite, pocket, architecture, first_seen, initial_import, last_seen,
+		size, installed_size
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT DO UPDATE SET last_seen = excluded.last_seen,
+		size = COALESCE(history.size, excluded.size),
+		installed_size = COALESCE(history.installed_size, excluded.installed_size)
+	WHERE history.last_seen IS NULL OR excluded.last_seen > history.last_seen`,
+		pkgInfo.Name,
+		pkgInfo.Version,
+		pkgInfo.Component,
+		pkgInfo.Suite,
+		pkgInfo.Pocket,
+		pkgInfo.Architecture,
+		seen.Unix(),
+		initialImport,
+		seen.Unix(),
+		pkgInfo.Size,
+		pkgInfo.InstalledSize,
+	)
+
+	return err
+}
+
+// PrepareBumpHistory updates the last time the current versions of the
+// packages of an index (empty component and architecture match all of
+// them) were seen, for the indexes that didn't change and weren't parsed
+// again, in the current transaction
+func (db *DB) PrepareBumpHistory(suite, pocket, component, architecture string, seen time.Time) error {
+	var err error
+
+	if db.transaction == nil {
+		db.transaction, err = db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "cannot start transaction, something is bad")
+		}
+	}
+
+	query := `UPDATE history SET last_seen = ?1
+	WHERE suite = ?2 AND pocket = ?3 AND (?4 = '' OR component = ?4) AND (?5 = '' OR architecture = ?5)
+	AND (last_seen IS NULL OR last_seen < ?1)
+	AND EXISTS (
+		SELECT 1 FROM packages p
+		WHERE p.name = history.name AND p.version = history.version AND p.component = history.component
+		AND p.suite = history.suite AND p.pocket = history.pocket AND p.architecture = history.architecture
+	)`
+	_, err = db.transaction.Exec(query, seen.Unix(), suite, pocket, component, architecture)
+
+	return err
+}
+
+// PrepareInsertRelease records that the Release file of a suite and a
+// pocket dated date has been indexed, in the current transaction
+func (db *DB) PrepareInsertRelease(suite, pocket string, date time.Time) error {
+	var err error
+
+	if db.transaction == nil {
+		db.transaction, err = db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "cannot start transaction, something is bad")
+		}
+	}
+
+	_, err = db.transaction.Exec("INSERT OR IGNORE INTO releases (suite, pocket, date) VALUES (?, ?, ?)", suite, pocket, date.Unix())
+
+	return err
+}
+
+// GetHistoryAt returns the versions of a package that were current at a
+// given time in each suite, pocket, component and architecture: the last
+// version first seen before at, unless a Release file published between
+// its last_seen and at didn't contain it anymore
+func (db *DB) GetHistoryAt(name string, at time.Time) ([]*HistoryEntry, error) {
+	rows, err := db.Query(`SELECT h.name, h.version, h.component, h.suite, h.pocket, h.architecture, h.first_seen, h.initial_import, h.last_seen
+		FROM history h
+		WHERE h.name = ?1 AND h.suite != '' AND h.first_seen <= ?2
+		AND h.first_seen = (
+			SELECT MAX(h2.first_seen) FROM history h2
+			WHERE h2.name = h.name AND h2.suite = h.suite AND h2.pocket = h.pocket
+			AND h2.component = h.component AND h2.architecture = h.architecture AND h2.first_seen <= ?2
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM releases r
+			WHERE r.suite = h.suite AND r.pocket = h.pocket
+			AND r.date > COALESCE(h.last_seen, ?2) AND r.date <= ?2
+		)
+		ORDER BY h.suite, h.pocket, h.architecture`, name, at.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanHistory(rows)
+}
+
+// GetHistory returns where a version of a package has been seen, the
+// oldest first
+func (db *DB) GetHistory(name, version string) ([]*HistoryEntry, error) {
+	rows, err := db.Query(`SELECT name, version, component, suite, pocket, architecture, first_seen, initial_import, last_seen
+		FROM history WHERE name=? AND version=? ORDER BY first_seen`, name, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanHistory(rows)
+}
+
+// GetChanges returns the versions of a package first seen since a given
+// time (the ones already there when the suites were indexed excepted), the
+// newest first
+func (db *DB) GetChanges(name string, since time.Time) ([]*HistoryEntry, error) {
+	rows, err := db.Query(`SELECT name, version, component, suite, pocket, architecture, first_seen, initial_import, last_seen
+		FROM history WHERE name=? AND suite != '' AND first_seen >= ? AND NOT initial_import
+		ORDER BY first_seen DESC, suite, pocket, architecture`, name, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanHistory(rows)
+}
+
+func scanHistory(rows *sql.Rows) ([]*HistoryEntry, error) {
+	entries := make([]*HistoryEntry, 0)
+	for rows.Next() {
+		entry := new(HistoryEntry)
+		var (
+			firstSeen int64
+			lastSeen  sql.NullInt64
+		)
+		err := rows.Scan(&entry.Name, &entry.Version, &entry.Component, &entry.Suite, &entry.Pocket,
+			&entry.Architecture, &firstSeen, &entry.InitialImport, &lastSeen)
+		if err != nil {
+			return nil, err
+		}
+		entry.FirstSeen = time.Unix(firstSeen, 0).UTC()
+		if lastSeen.Valid {
+			t := time.Unix(lastSeen.Int64, 0).UTC()
+			entry.LastSeen = &t
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// InsertHistory adds entries found in other sources (e.g. snapshot
+// services) to the history, the first date seen is kept
+func (db *DB) InsertHistory(entries []*HistoryEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		_, err = tx.Exec(`INSERT INTO history (
+			name, version, component, suite, pocket, architecture, first_seen, initial_import
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO UPDATE SET first_seen = excluded.first_seen, initial_import = excluded.initial_import
+		WHERE excluded.first_seen < history.first_seen`,
+			entry.Name,
+			entry.Version,
+			entry.Component,
+			entry.Suite,
+			entry.Pocket,
+			entry.Architecture,
+			entry.FirstSeen.Unix(),
+			entry.InitialImport,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BackfillDone returns true if the history of the package has already
+// been backfilled
+func (db *DB) BackfillDone(name string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM backfill WHERE name=?", name).Scan(&n)
+
+	return n != 0, err
+}
+
+// SetBackfillDone records that the history of the package has been
+// backfilled
+func (db *DB) SetBackfillDone(name string, doneAt time.Time) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO backfill (name, done_at) VALUES (?, ?)", name, doneAt.Unix())
+
+	return err
+}
@@ -0,0 +1,279 @@
+package database
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestHistory(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pkg := &debianpkg.PackageInfo{Name: "curl", Version: "8.5.0-2ubuntu10.6", Component: "main", Suite: "noble", Pocket: "-security", Architecture: "amd64"}
+	first := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	known, err := db.HasHistory("noble", "-security")
+	if err != nil || known {
+		t.Fatalf("expected no history, got %v (%v)", known, err)
+	}
+
+	for i, seen := range []time.Time{first, first.Add(time.Hour)} {
+		err = db.PrepareInsertHistory(pkg, seen, i == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := db.GetHistory("curl", "8.5.0-2ubuntu10.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", len(entries))
+	}
+	if !entries[0].FirstSeen.Equal(first) || !entries[0].InitialImport {
+		t.Errorf("expected first seen on %v (initial import), got %v (%v)", first, entries[0].FirstSeen, entries[0].InitialImport)
+	}
+
+	known, err = db.HasHistory("noble", "-security")
+	if err != nil || !known {
+		t.Errorf("expected history, got %v (%v)", known, err)
+	}
+}
+
+func TestInsertHistory(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	first := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(seen time.Time) *HistoryEntry {
+		return &HistoryEntry{Name: "curl", Version: "7.68.0-1ubuntu2", Component: "main", Suite: "focal", Architecture: "amd64", FirstSeen: seen}
+	}
+
+	// the earliest date wins, whatever the order of the imports
+	for _, seen := range []time.Time{first.Add(time.Hour), first, first.Add(2 * time.Hour)} {
+		err = db.InsertHistory([]*HistoryEntry{entry(seen)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := db.GetHistory("curl", "7.68.0-1ubuntu2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].FirstSeen.Equal(first) {
+		t.Errorf("expected 1 entry first seen on %v, got %v", first, entries)
+	}
+
+	done, err := db.BackfillDone("curl")
+	if err != nil || done {
+		t.Fatalf("expected backfill not done, got %v (%v)", done, err)
+	}
+	err = db.SetBackfillDone("curl", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err = db.BackfillDone("curl")
+	if err != nil || !done {
+		t.Errorf("expected backfill done, got %v (%v)", done, err)
+	}
+}
+
+func TestGetHistoryAt(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+	pkg := func(version, pocket string) *debianpkg.PackageInfo {
+		return &debianpkg.PackageInfo{Name: "curl", Version: version, Component: "main", Suite: "noble", Pocket: pocket, Architecture: "amd64"}
+	}
+
+	// the version in -proposed migrates to -updates on day 5 and is
+	// removed from -proposed
+	releases := []struct {
+		date     time.Time
+		pocket   string
+		packages []*debianpkg.PackageInfo
+	}{
+		{day(1), "", []*debianpkg.PackageInfo{pkg("8.5.0-2ubuntu10", "")}},
+		{day(1), "-proposed", []*debianpkg.PackageInfo{pkg("8.5.0-2ubuntu10.1", "-proposed")}},
+		{day(3), "-proposed", []*debianpkg.PackageInfo{pkg("8.5.0-2ubuntu10.1", "-proposed")}},
+		{day(5), "-updates", []*debianpkg.PackageInfo{pkg("8.5.0-2ubuntu10.1", "-updates")}},
+		{day(5), "-proposed", []*debianpkg.PackageInfo{}},
+	}
+	for _, release := range releases {
+		for _, p := range release.packages {
+			err = db.PrepareInsertHistory(p, release.date, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = db.PrepareInsertRelease("noble", release.pocket, release.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		at       time.Time
+		expected []string
+	}{
+		{day(1).Add(-time.Hour), []string{}},
+		{day(2), []string{"noble 8.5.0-2ubuntu10", "noble-proposed 8.5.0-2ubuntu10.1"}},
+		{day(4), []string{"noble 8.5.0-2ubuntu10", "noble-proposed 8.5.0-2ubuntu10.1"}},
+		{day(6), []string{"noble 8.5.0-2ubuntu10", "noble-updates 8.5.0-2ubuntu10.1"}},
+	}
+	for _, test := range tests {
+		entries, err := db.GetHistoryAt("curl", test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		versions := make([]string, len(entries))
+		for i, entry := range entries {
+			versions[i] = entry.Suite + entry.Pocket + " " + entry.Version
+		}
+		if strings.Join(versions, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("at %v: expected %v, got %v", test.at, test.expected, versions)
+		}
+	}
+}
+
+func TestBumpHistory(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+	curl := &debianpkg.PackageInfo{Name: "curl", Version: "8.5.0-2ubuntu10", Component: "main", Suite: "noble", Architecture: "amd64"}
+	// the arm64 index changes on day 3, the amd64 one is not parsed again
+	hello := &debianpkg.PackageInfo{Name: "hello", Version: "2.10-3", Component: "main", Suite: "noble", Architecture: "arm64"}
+	for _, pkg := range []*debianpkg.PackageInfo{curl, hello} {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.PrepareInsertHistory(pkg, day(1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.PrepareInsertRelease("noble", "", day(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.PrepareInsertHistory(hello, day(3), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.PrepareBumpHistory("noble", "", "main", "amd64", day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.PrepareInsertRelease("noble", "", day(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.GetHistoryAt("curl", day(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].LastSeen == nil || !entries[0].LastSeen.Equal(day(3)) {
+		t.Errorf("expected curl seen on day 3, got %+v", entries)
+	}
+}
+
+func TestGetChanges(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+	entries := []struct {
+		version       string
+		pocket        string
+		seen          time.Time
+		initialImport bool
+	}{
+		{"8.5.0-2ubuntu10", "", day(1), true},
+		{"8.5.0-2ubuntu10.1", "-proposed", day(2), false},
+		{"8.5.0-2ubuntu10.1", "-updates", day(5), false},
+	}
+	for _, entry := range entries {
+		pkg := &debianpkg.PackageInfo{Name: "curl", Version: entry.version, Component: "main", Suite: "noble", Pocket: entry.pocket, Architecture: "amd64"}
+		err = db.PrepareInsertHistory(pkg, entry.seen, entry.initialImport)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		since    time.Time
+		expected []string
+	}{
+		{day(1), []string{"noble-updates 8.5.0-2ubuntu10.1", "noble-proposed 8.5.0-2ubuntu10.1"}},
+		{day(3), []string{"noble-updates 8.5.0-2ubuntu10.1"}},
+		{day(6), []string{}},
+	}
+	for _, test := range tests {
+		changes, err := db.GetChanges("curl", test.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		versions := make([]string, len(changes))
+		for i, change := range changes {
+			versions[i] = change.Suite + change.Pocket + " " + change.Version
+		}
+		if strings.Join(versions, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("since %v: expected %v, got %v", test.since, test.expected, versions)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/version"
+	"github.com/pkg/errors"
+)
+
+// packagesTableColumns are the columns expected in the packages table
+var packagesTableColumns = []string{
+	"name", "version", "component", "suite", "pocket", "architecture",
+	"source", "section", "maintainer_name", "maintainer_email", "sha256",
+	"size", "install_size", "file_name", "depends", "pre_depends", "replace",
+	"conflicts", "suggests", "description", "priority", "homepage",
+	"recommends", "provides", "breaks", "enhances", "built_using",
+	"multi_arch", "essential", "package_architecture",
+}
+
+// packagesTableIndexes are the indexes expected on the packages table and
+// their columns
+var packagesTableIndexes = map[string]string{
+	"idx_name":   "name",
+	"idx_source": "source",
+}
+
+// IntegrityIssue is a problem found in the database by CheckIntegrity
+type IntegrityIssue struct {
+	Check  string `json:"check"`
+	Detail string `json:"detail"`
+	// Repaired is true if the issue has been fixed
+	Repaired bool `json:"repaired"`
+}
+
+func (issue IntegrityIssue) String() string {
+	if issue.Repaired {
+		return fmt.Sprintf("%v: %v (repaired)", issue.Check, issue.Detail)
+	}
+
+	return fmt.Sprintf("%v: %v", issue.Check, issue.Detail)
+}
+
+// IntegrityError lists the issues of the database that are not repaired
+type IntegrityError struct {
+	Issues []IntegrityIssue
+	// Corrupt is true if the SQLite integrity check failed, the file
+	// can't be trusted at all
+	Corrupt bool
+}
+
+func (e *IntegrityError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+
+	return "integrity check failed: " + strings.Join(issues, "; ")
+}
+
+// CheckIntegrity verifies the schema (tables, columns and indexes) and the
+// invariants of the rows (no empty keys, parseable versions, no broken
+// foreign keys). With repair, the missing indexes are created and the
+// invalid rows are deleted, they are ingested again by the next full
+// refresh. All the issues found are returned, the error is an
+// *IntegrityError if some are not repaired.
+func (db *DB) CheckIntegrity(repair bool) ([]IntegrityIssue, error) {
+	issues := make([]IntegrityIssue, 0)
+
+	report, err := db.quickCheck()
+	if err != nil {
+		return nil, err
+	}
+	if report != "" {
+		issue := IntegrityIssue{Check: "sqlite", Detail: report}
+		return []IntegrityIssue{issue}, &IntegrityError{Issues: []IntegrityIssue{issue}, Corrupt: true}
+	}
+
+	checks := []func(bool) ([]IntegrityIssue, error){
+		db.checkColumns,
+		db.checkIndexes,
+		db.checkKeys,
+		db.checkVersions,
+		db.checkForeignKeys,
+	}
+	for _, check := range checks {
+		found, err := check(repair)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+
+	unrepaired := make([]IntegrityIssue, 0)
+	repaired := false
+	for _, issue := range issues {
+		if !issue.Repaired {
+			unrepaired = append(unrepaired, issue)
+		} else {
+			repaired = true
+		}
+	}
+	// the deleted rows make a new generation
+	if repaired {
+		err = nextGeneration(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(unrepaired) != 0 {
+		return issues, &IntegrityError{Issues: unrepaired}
+	}
+
+	return issues, nil
+}
+
+// quickCheck runs the SQLite integrity check, it returns an empty string
+// if the database is fine
+func (db *DB) quickCheck() (string, error) {
+	rows, err := db.Query("PRAGMA quick_check")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to check the database")
+	}
+	defer rows.Close()
+
+	messages := make([]string, 0)
+	for rows.Next() {
+		var message string
+		err = rows.Scan(&message)
+		if err != nil {
+			return "", err
+		}
+		if message != "ok" {
+			messages = append(messages, message)
+		}
+	}
+
+	return strings.Join(messages, ", "), rows.Err()
+}
+
+func (db *DB) checkColumns(repair bool) ([]IntegrityIssue, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", db.tableName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get columns from DB")
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		err = rows.Scan(&column)
+		if err != nil {
+			return nil, err
+		}
+		columns[column] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	issues := make([]IntegrityIssue, 0)
+	for _, column := range packagesTableColumns {
+		if !columns[column] {
+			// the columns that can be added are added by NewConn, the
+			// others mean the schema is from another version
+			issues = append(issues, IntegrityIssue{Check: "schema", Detail: fmt.Sprintf("column %v.%v is missing", db.tableName, column)})
+		}
+	}
+
+	return issues, nil
+}
+
+func (db *DB) checkIndexes(repair bool) ([]IntegrityIssue, error) {
+	issues := make([]IntegrityIssue, 0)
+	for index, column := range packagesTableIndexes {
+		var n int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name=? AND tbl_name=?", index, db.tableName).Scan(&n)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get indexes from DB")
+		}
+		if n != 0 {
+			continue
+		}
+
+		issue := IntegrityIssue{Check: "schema", Detail: fmt.Sprintf("index %v is missing", index)}
+		if repair {
+			_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (%v)", index, db.tableName, column))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create index %v", index)
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+// checkKeys looks for packages with an empty name, version, suite or
+// architecture
+func (db *DB) checkKeys(repair bool) ([]IntegrityIssue, error) {
+	where := " WHERE COALESCE(name, '') = '' OR COALESCE(version, '') = '' OR COALESCE(suite, '') = '' OR COALESCE(architecture, '') = ''"
+
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM " + db.tableName + where).Scan(&n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check the keys of the packages")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	issue := IntegrityIssue{Check: "rows", Detail: fmt.Sprintf("%v packages without a name, a version, a suite or an architecture", n)}
+	if repair {
+		_, err = db.Exec("DELETE FROM " + db.tableName + where)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to delete the invalid packages")
+		}
+		issue.Repaired = true
+	}
+
+	return []IntegrityIssue{issue}, nil
+}
+
+// checkVersions looks for packages whose version can't be parsed
+func (db *DB) checkVersions(repair bool) ([]IntegrityIssue, error) {
+	rows, err := db.Query("SELECT DISTINCT version FROM " + db.tableName + " WHERE version != ''")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check the versions of the packages")
+	}
+
+	invalid := make([]interface{}, 0)
+	for rows.Next() {
+		var v string
+		err = rows.Scan(&v)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if _, err := version.Parse(v); err != nil {
+			invalid = append(invalid, v)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(invalid) == 0 {
+		return nil, nil
+	}
+
+	examples := make([]string, 0, 3)
+	for _, v := range invalid[:min(len(invalid), 3)] {
+		examples = append(examples, fmt.Sprintf("%q", v))
+	}
+	issue := IntegrityIssue{Check: "rows", Detail: fmt.Sprintf("%v invalid versions (%v)", len(invalid), strings.Join(examples, ", "))}
+	if repair {
+		for _, v := range invalid {
+			_, err = db.Exec("DELETE FROM "+db.tableName+" WHERE version = ?", v)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to delete the invalid packages")
+			}
+		}
+		issue.Repaired = true
+	}
+
+	return []IntegrityIssue{issue}, nil
+}
+
+// checkForeignKeys looks for rows referencing missing rows, the broken
+// rows are deleted on repair
+func (db *DB) checkForeignKeys(repair bool) ([]IntegrityIssue, error) {
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check the foreign keys")
+	}
+
+	type brokenRow struct {
+		table string
+		rowid int64
+	}
+	broken := make([]brokenRow, 0)
+	byTable := make(map[string]int)
+	for rows.Next() {
+		var (
+			row    brokenRow
+			parent string
+			fkid   int
+		)
+		err = rows.Scan(&row.table, &row.rowid, &parent, &fkid)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		broken = append(broken, row)
+		byTable[row.table]++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	issues := make([]IntegrityIssue, 0)
+	for table, n := range byTable {
+		issues = append(issues, IntegrityIssue{Check: "foreign_keys", Detail: fmt.Sprintf("%v rows of %v reference missing rows", n, table), Repaired: repair})
+	}
+	if repair {
+		for _, row := range broken {
+			_, err = db.Exec(fmt.Sprintf("DELETE FROM %q WHERE rowid = ?", row.table), row.rowid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to delete the broken rows of %v", row.table)
+			}
+		}
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "hello", Version: "2.10-3", Suite: "noble", Architecture: "amd64", Component: "main"},
+		{Name: "broken", Version: "not a version!", Suite: "noble", Architecture: "amd64", Component: "main"},
+		{Name: "nosuite", Version: "1.0-1", Architecture: "amd64", Component: "main"},
+	}
+	for _, pkg := range pkgs {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("DROP INDEX idx_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := db.CheckIntegrity(false)
+	if _, ok := err.(*IntegrityError); !ok {
+		t.Fatalf("expected an integrity error, got %v", err)
+	}
+	if len(issues) != 3 {
+		t.Errorf("expected 3 issues, got %v", issues)
+	}
+	if IsCorrupt(err) {
+		t.Errorf("the database is not corrupt")
+	}
+
+	issues, err = db.CheckIntegrity(true)
+	if err != nil {
+		t.Fatalf("expected the issues to be repaired, got %v", err)
+	}
+	if len(issues) != 3 {
+		t.Errorf("expected 3 repaired issues, got %v", issues)
+	}
+
+	n, err := db.CountPackages()
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 package left, got %v (%v)", n, err)
+	}
+	issues, err = db.CheckIntegrity(false)
+	if err != nil || len(issues) != 0 {
+		t.Errorf("expected no issue after the repair, got %v (%v)", issues, err)
+	}
+}
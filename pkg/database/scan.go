@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/pkg/errors"
+)
+
+// ErrGenerationChanged is returned by ScanAll when the packages were
+// modified since the generation requested
+var ErrGenerationChanged = errors.New("the packages changed since this generation")
+
+// CurrentGeneration makes ScanAll read the current generation, whatever
+// it is. The generations start at 1.
+const CurrentGeneration int64 = 0
+
+// Scanner walks all the packages of a database, for the bulk consumers
+// (exports, snapshots...). It's implemented by DB and resolver.Resolver.
+type Scanner interface {
+	// Generation returns the current generation of the packages
+	Generation() (int64, error)
+	// ScanAll calls fn for every package of a generation matching the
+	// filter
+	ScanAll(ctx context.Context, generation int64, filter Filter, fn func(*debianpkg.PackageInfo) error) error
+}
+
+// queryRower and execer are a DB or a transaction
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (db *DB) createGenerationTableIfNeeded() error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS generation ('value' INTEGER NOT NULL)")
+	if err != nil {
+		return errors.Wrap(err, "failed to create generation table")
+	}
+
+	_, err = db.Exec("INSERT INTO generation (value) SELECT 1 WHERE NOT EXISTS (SELECT 1 FROM generation)")
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize generation")
+	}
+
+	// the databases created when the first generation was 0, it would
+	// be taken for CurrentGeneration
+	_, err = db.Exec("UPDATE generation SET value = 1 WHERE value = 0")
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize generation")
+	}
+
+	return nil
+}
+
+// nextGeneration increments the generation, in the transaction modifying
+// the packages when there is one
+func nextGeneration(e execer) error {
+	_, err := e.Exec("UPDATE generation SET value = value + 1")
+	if err != nil {
+		return errors.Wrap(err, "failed to update generation")
+	}
+
+	return nil
+}
+
+func readGeneration(q queryRower) (int64, error) {
+	var generation int64
+	err := q.QueryRow("SELECT value FROM generation").Scan(&generation)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read generation")
+	}
+
+	return generation, nil
+}
+
+// Generation returns the generation of the packages, it's incremented by
+// every refresh modifying them (see InsertPrepared and BeginRefresh)
+func (db *DB) Generation() (int64, error) {
+	return readGeneration(db)
+}
+
+// ScanAll calls fn for every package of the given generation (or
+// CurrentGeneration) matching the filter, ordered by name. The packages
+// are read one at a time in a read-only transaction: the refreshes are not
+// blocked and the changes they make during the scan are not seen.
+// ErrGenerationChanged is returned, before calling fn, if the generation is
+// not the current one anymore. Iteration stops when ctx is done or at the
+// first error returned by fn. Several scans can run concurrently.
+func (db *DB) ScanAll(ctx context.Context, generation int64, filter Filter, fn func(*debianpkg.PackageInfo) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return errors.Wrap(err, "failed to start the scan")
+	}
+	// nothing is written, the transaction only pins the data read
+	defer tx.Rollback()
+
+	// the first read of the transaction sets the data it sees
+	current, err := readGeneration(tx)
+	if err != nil {
+		return err
+	}
+	if generation != CurrentGeneration && generation != current {
+		return ErrGenerationChanged
+	}
+
+	where, args := filter.where()
+	rows, err := tx.QueryContext(ctx, "SELECT "+packageColumns+" FROM "+db.tableName+where+" ORDER BY name", args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to scan the packages")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestScanAll(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	generation, err := db.Generation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CurrentGeneration is never a generation
+	if generation != 1 {
+		t.Errorf("expected the first generation to be 1, got %v", generation)
+	}
+
+	insert := func(names ...string) {
+		for _, name := range names {
+			err := db.PrepareInsertPackage(&debianpkg.PackageInfo{
+				Name: name, Version: "1.0", Component: "main", Suite: "noble", Architecture: "amd64",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("bash", "curl")
+
+	generation, err = db.Generation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generation != 2 {
+		t.Errorf("expected generation 2, got %v", generation)
+	}
+
+	// a refresh writing during the scan is not blocked and not seen
+	names := make([]string, 0)
+	err = db.ScanAll(context.Background(), generation, Filter{}, func(pkg *debianpkg.PackageInfo) error {
+		if len(names) == 0 {
+			insert("apt")
+		}
+		names = append(names, pkg.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "bash" || names[1] != "curl" {
+		t.Errorf("expected [bash curl], got %v", names)
+	}
+
+	err = db.ScanAll(context.Background(), generation, Filter{}, func(*debianpkg.PackageInfo) error { return nil })
+	if err != ErrGenerationChanged {
+		t.Errorf("expected ErrGenerationChanged, got %v", err)
+	}
+
+	n := 0
+	err = db.ScanAll(context.Background(), CurrentGeneration, Filter{}, func(*debianpkg.PackageInfo) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Errorf("expected 3 packages of the current generation, got %v (%v)", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.ScanAll(ctx, CurrentGeneration, Filter{}, func(*debianpkg.PackageInfo) error { return nil })
+	if err == nil {
+		t.Error("expected an error with a cancelled context")
+	}
+}
+
+func TestScanAllFilter(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	packages := []*debianpkg.PackageInfo{
+		{Name: "bash", Version: "1.0", Component: "main", Suite: "noble", Architecture: "amd64"},
+		{Name: "bash", Version: "1.1", Component: "main", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+		{Name: "curl", Version: "1.0", Component: "universe", Suite: "noble", Architecture: "arm64"},
+	}
+	for _, pkg := range packages {
+		err := db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.InsertPrepared(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{}, []string{"bash 1.0", "bash 1.1", "curl 1.0"}},
+		{Filter{Suite: "noble-updates"}, []string{"bash 1.1"}},
+		{Filter{Component: "universe"}, []string{"curl 1.0"}},
+		{Filter{Suite: "noble", Architecture: "amd64"}, []string{"bash 1.0"}},
+		{Filter{Architecture: "riscv64"}, []string{}},
+	}
+
+	for _, test := range tests {
+		found := make([]string, 0)
+		err := db.ScanAll(context.Background(), CurrentGeneration, test.filter, func(pkg *debianpkg.PackageInfo) error {
+			found = append(found, pkg.Name+" "+pkg.Version)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(found)
+		if strings.Join(found, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%+v: expected %v, got %v", test.filter, test.expected, found)
+		}
+	}
+}
+
+func TestRefreshGeneration(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	commit := func(name string) {
+		err := db.PrepareInsertPackage(&debianpkg.PackageInfo{
+			Name: name, Version: "1.0", Component: "main", Suite: "noble", Architecture: "amd64",
+		})
+		if err == nil {
+			err = db.InsertPrepared()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the transactions of a refresh make one generation
+	for i := 0; i < 2; i++ {
+		db.BeginRefresh()
+		commit("bash")
+		commit("curl")
+		commit("apt")
+		db.EndRefresh()
+
+		generation, err := db.Generation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generation != int64(i+2) {
+			t.Errorf("refresh %v: expected generation %v, got %v", i, i+2, generation)
+		}
+	}
+
+	// the databases created with a generation 0 start at 1
+	if _, err := db.Exec("UPDATE generation SET value = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.createGenerationTableIfNeeded(); err != nil {
+		t.Fatal(err)
+	}
+	if generation, err := db.Generation(); err != nil || generation != 1 {
+		t.Errorf("expected generation 1, got %v (%v)", generation, err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	pkg := &debianpkg.PackageInfo{
+		Name: "bash", Version: "1.0", Component: "main", Suite: "noble", Pocket: "-updates", Architecture: "amd64",
+	}
+
+	tests := []struct {
+		filter   Filter
+		expected bool
+	}{
+		{Filter{}, true},
+		{Filter{Suite: "noble-updates", Component: "main", Architecture: "amd64"}, true},
+		{Filter{Suite: "noble"}, false},
+		{Filter{Component: "universe"}, false},
+		{Filter{Architecture: "arm64"}, false},
+	}
+
+	for _, test := range tests {
+		if test.filter.Match(pkg) != test.expected {
+			t.Errorf("%+v: expected %v", test.filter, test.expected)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// rough fraction of the packages kept by each filter, used to estimate
+// the cost of a query without running it
+const (
+	suiteSelectivity        = 0.1
+	componentSelectivity    = 0.25
+	architectureSelectivity = 0.2
+	// fraction of the names matching a pattern without literal prefix
+	patternSelectivity = 0.05
+)
+
+// patternPrefix returns the literal part of a glob pattern before the
+// first wildcard
+func patternPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i != -1 {
+		return pattern[:i]
+	}
+
+	return pattern
+}
+
+// Estimate is the expected size of the result of a query
+type Estimate struct {
+	Rows int
+	// IndexScan is true if the query can use the index on the names,
+	// otherwise the whole table is read
+	IndexScan bool
+}
+
+// selectivity returns the fraction of the packages kept by the filter
+func (f Filter) selectivity() float64 {
+	selectivity := 1.0
+	if f.Suite != "" {
+		selectivity *= suiteSelectivity
+	}
+	if f.Component != "" {
+		selectivity *= componentSelectivity
+	}
+	if f.Architecture != "" {
+		selectivity *= architectureSelectivity
+	}
+
+	return selectivity
+}
+
+// EstimateSearch estimates the number of packages SearchPackages would
+// return. Only the names matching the literal prefix of the pattern are
+// counted (with the index), filters are accounted for with fixed
+// selectivities.
+func (db *DB) EstimateSearch(pattern string, filter Filter) (*Estimate, error) {
+	prefix := patternPrefix(pattern)
+
+	var (
+		n   int
+		err error
+	)
+	if prefix == "" {
+		n, err = db.CountPackages()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Estimate{Rows: int(float64(n) * patternSelectivity * filter.selectivity())}, nil
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM packages WHERE name GLOB ?", prefix+"*").Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+
+	selectivity := filter.selectivity()
+	if prefix != pattern {
+		selectivity *= 0.5
+	}
+
+	return &Estimate{Rows: int(float64(n) * selectivity), IndexScan: true}, nil
+}
+
+// SearchPackages returns at most limit packages whose name match the glob
+// pattern (e.g. libssl*) and the filter
+func (db *DB) SearchPackages(pattern string, filter Filter, limit int) ([]*debianpkg.PackageInfo, error) {
+	where, args := filter.where()
+	if where == "" {
+		where = " WHERE name GLOB ?"
+	} else {
+		where += " AND name GLOB ?"
+	}
+	args = append(args, pattern, limit)
+
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages"+where+" ORDER BY name LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pkgInfo := make([]*debianpkg.PackageInfo, 0)
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		pkgInfo = append(pkgInfo, info)
+	}
+
+	return pkgInfo, rows.Err()
+}
+
+// SearchSources returns the packages of all the pockets of a suite (e.g.
+// jammy) built from the source packages whose name match the glob
+// pattern
+func (db *DB) SearchSources(pattern, suite string) ([]*debianpkg.PackageInfo, error) {
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages WHERE suite = ? AND "+sourceNameColumn+" GLOB ?", suite, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pkgInfo := make([]*debianpkg.PackageInfo, 0)
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		pkgInfo = append(pkgInfo, info)
+	}
+
+	return pkgInfo, rows.Err()
+}
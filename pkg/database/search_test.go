@@ -0,0 +1,135 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSearchDB returns a database with packages of the libssl family in
+// several suites, components and architectures
+func newSearchDB(t *testing.T) *DB {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, pkg := range []*debianpkg.PackageInfo{
+		{Name: "openssl", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Name: "libssl3t64", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Name: "libssl3t64", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "arm64"},
+		{Name: "libssl3t64", Version: "3.0.13-0ubuntu3.2", Suite: "noble", Pocket: "-updates", Component: "main", Architecture: "amd64"},
+		{Name: "libssl-dev", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Name: "libssl-doc", Version: "3.0.13-0ubuntu3", Suite: "noble", Component: "main", Architecture: "all"},
+		{Name: "libssl3", Version: "3.0.2-0ubuntu1", Suite: "jammy", Component: "main", Architecture: "amd64"},
+		{Name: "libsslcommon2", Version: "0.16-12", Suite: "noble", Component: "universe", Architecture: "amd64"},
+		{Name: "bash", Version: "5.2.21-2ubuntu4", Suite: "noble", Component: "main", Architecture: "amd64"},
+		{Name: "xlibssl", Version: "1.0", Suite: "noble", Component: "universe", Architecture: "amd64"},
+	} {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSearchPackages(t *testing.T) {
+	db := newSearchDB(t)
+
+	tests := []struct {
+		pattern  string
+		filter   Filter
+		limit    int
+		expected []string
+	}{
+		// sorted by name
+		{"libssl*", Filter{}, 10, []string{"libssl-dev", "libssl-doc", "libssl3", "libssl3t64", "libssl3t64", "libssl3t64", "libsslcommon2"}},
+		{"libssl*", Filter{}, 3, []string{"libssl-dev", "libssl-doc", "libssl3"}},
+		{"libssl*", Filter{Suite: "noble-updates"}, 10, []string{"libssl3t64"}},
+		{"libssl*", Filter{Suite: "noble", Architecture: "arm64"}, 10, []string{"libssl3t64"}},
+		{"libssl*", Filter{Component: "universe"}, 10, []string{"libsslcommon2"}},
+		{"*ssl*", Filter{Component: "universe"}, 10, []string{"libsslcommon2", "xlibssl"}},
+		{"libssl?", Filter{}, 10, []string{"libssl3"}},
+		{"libssl[0-9]*", Filter{Suite: "noble"}, 10, []string{"libssl3t64", "libssl3t64"}},
+		// the patterns are case sensitive, without the LIKE wildcards
+		{"LIBSSL*", Filter{}, 10, []string{}},
+		{"libssl%", Filter{}, 10, []string{}},
+		{"openssl", Filter{}, 10, []string{"openssl"}},
+	}
+
+	for _, test := range tests {
+		found, err := db.SearchPackages(test.pattern, test.filter, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(found))
+		for i, pkg := range found {
+			names[i] = pkg.Name
+		}
+		if fmt.Sprint(names) != fmt.Sprint(test.expected) {
+			t.Errorf("%v %+v (limit %v): expected %v, got %v", test.pattern, test.filter, test.limit, test.expected, names)
+		}
+	}
+}
+
+func TestEstimateSearch(t *testing.T) {
+	db := newSearchDB(t)
+
+	tests := []struct {
+		pattern  string
+		filter   Filter
+		expected Estimate
+	}{
+		// the names with the prefix are counted with the index
+		{"libssl3t64", Filter{}, Estimate{Rows: 3, IndexScan: true}},
+		{"libssl*", Filter{}, Estimate{Rows: 3, IndexScan: true}},
+		{"libssl*", Filter{Suite: "noble"}, Estimate{Rows: 0, IndexScan: true}},
+		{"bash", Filter{}, Estimate{Rows: 1, IndexScan: true}},
+		// all the packages are read without prefix
+		{"*ssl*", Filter{}, Estimate{Rows: 0}},
+	}
+
+	for _, test := range tests {
+		estimate, err := db.EstimateSearch(test.pattern, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *estimate != test.expected {
+			t.Errorf("%v %+v: expected %+v, got %+v", test.pattern, test.filter, test.expected, *estimate)
+		}
+	}
+
+	// the filters are accounted for with their selectivities
+	filters := []Filter{{}, {Suite: "noble"}, {Suite: "noble", Component: "main"}, {Suite: "noble", Component: "main", Architecture: "amd64"}}
+	for i, expected := range []float64{1, 0.1, 0.025, 0.005} {
+		if selectivity := filters[i].selectivity(); fmt.Sprintf("%.4f", selectivity) != fmt.Sprintf("%.4f", expected) {
+			t.Errorf("%+v: expected %v, got %v", filters[i], expected, selectivity)
+		}
+	}
+}
+
+func TestPatternPrefix(t *testing.T) {
+	tests := map[string]string{
+		"libssl*":      "libssl",
+		"libssl3":      "libssl3",
+		"lib?sl":       "lib",
+		"libssl[0-9]*": "libssl",
+		"*ssl":         "",
+		"":             "",
+	}
+	for pattern, expected := range tests {
+		if prefix := patternPrefix(pattern); prefix != expected {
+			t.Errorf("%v: expected %q, got %q", pattern, expected, prefix)
+		}
+	}
+}
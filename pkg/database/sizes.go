@@ -0,0 +1,80 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SizeChange is the difference between the sizes of the current version
+// of a package and the version that was current at a given time
+type SizeChange struct {
+	Name             string `json:"name"`
+	Component        string `json:"component"`
+	Suite            string `json:"suite"`
+	Pocket           string `json:"pocket"`
+	Architecture     string `json:"architecture"`
+	OldVersion       string `json:"old_version"`
+	Version          string `json:"version"`
+	OldSize          int    `json:"old_size"`
+	Size             int    `json:"size"`
+	OldInstalledSize int    `json:"old_installed_size"`
+	InstalledSize    int    `json:"installed_size"`
+}
+
+// sizeOrders are the expressions the size changes can be sorted by
+var sizeOrders = map[string]string{
+	"size":           "p.size - h.size",
+	"installed_size": "CAST(p.install_size AS INTEGER) - h.installed_size",
+}
+
+// GetSizeChanges returns the packages whose version changed since a given
+// time, with the sizes of both versions, the ones that grew the most (by
+// size or installed_size) first. The versions recorded before the sizes
+// were kept in the history are ignored.
+func (db *DB) GetSizeChanges(filter Filter, since time.Time, by string, limit int) ([]*SizeChange, error) {
+	order, ok := sizeOrders[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown size %q", by)
+	}
+
+	where, args := filter.where()
+	args = append(args, since.Unix())
+	limitClause := ""
+	if limit > 0 {
+		limitClause = "LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT p.name, p.component, p.suite, p.pocket, p.architecture,
+		h.version, p.version, h.size, p.size, h.installed_size, CAST(p.install_size AS INTEGER)
+		FROM (SELECT name, version, component, suite, pocket, architecture, size, install_size FROM %v %v) p
+		JOIN history h ON h.name = p.name AND h.suite = p.suite AND h.pocket = p.pocket
+			AND h.component = p.component AND h.architecture = p.architecture
+		WHERE h.version != p.version AND h.size IS NOT NULL AND h.installed_size IS NOT NULL
+		AND h.first_seen = (
+			SELECT MAX(h2.first_seen) FROM history h2
+			WHERE h2.name = p.name AND h2.suite = p.suite AND h2.pocket = p.pocket
+			AND h2.component = p.component AND h2.architecture = p.architecture AND h2.first_seen <= ?
+		)
+		ORDER BY %v DESC, p.name, p.architecture %v`, db.tableName, where, order, limitClause), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the size changes")
+	}
+	defer rows.Close()
+
+	changes := make([]*SizeChange, 0)
+	for rows.Next() {
+		change := new(SizeChange)
+		err := rows.Scan(&change.Name, &change.Component, &change.Suite, &change.Pocket, &change.Architecture,
+			&change.OldVersion, &change.Version, &change.OldSize, &change.Size, &change.OldInstalledSize, &change.InstalledSize)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestGetSizeChanges(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	first := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	refreshes := []struct {
+		seen time.Time
+		pkgs []*debianpkg.PackageInfo
+	}{
+		{first, []*debianpkg.PackageInfo{
+			{Name: "linux-firmware", Version: "1.0", Size: 1000, InstalledSize: 5000},
+			{Name: "curl", Version: "8.5", Size: 300, InstalledSize: 400},
+			{Name: "bash", Version: "5.2", Size: 100, InstalledSize: 200},
+		}},
+		{second, []*debianpkg.PackageInfo{
+			{Name: "linux-firmware", Version: "1.1", Size: 1100, InstalledSize: 9000},
+			{Name: "curl", Version: "8.6", Size: 500, InstalledSize: 300},
+			{Name: "bash", Version: "5.2", Size: 100, InstalledSize: 200},
+		}},
+	}
+	for _, refresh := range refreshes {
+		for _, pkg := range refresh.pkgs {
+			pkg.Component = "main"
+			pkg.Suite = "noble"
+			pkg.Pocket = "-updates"
+			pkg.Architecture = "amd64"
+			err = db.PrepareInsertHistory(pkg, refresh.seen, refresh.seen == first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.PrepareInsertPackage(pkg)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = db.InsertPrepared()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		by       string
+		since    time.Time
+		expected []string
+	}{
+		{"installed_size", first.Add(time.Hour), []string{"linux-firmware", "curl"}},
+		{"size", first.Add(time.Hour), []string{"curl", "linux-firmware"}},
+		// the versions current before the first refresh are unknown
+		{"size", first.Add(-time.Hour), []string{}},
+	}
+
+	for _, test := range tests {
+		changes, err := db.GetSizeChanges(Filter{Suite: "noble-updates"}, test.since, test.by, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := make([]string, len(changes))
+		for i, change := range changes {
+			names[i] = change.Name
+		}
+		if len(names) != len(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.by, test.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.expected[i] {
+				t.Errorf("%v: expected %v, got %v", test.by, test.expected, names)
+				break
+			}
+		}
+	}
+
+	changes, err := db.GetSizeChanges(Filter{Suite: "noble-updates"}, first.Add(time.Hour), "size", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].OldVersion != "8.5" || changes[0].OldSize != 300 || changes[0].Size != 500 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
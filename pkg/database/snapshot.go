@@ -0,0 +1,54 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshot writes a compact copy of the database to path, it can be opened
+// with OpenSnapshot. The copy is consistent even if the database is being
+// refreshed.
+func (db *DB) Snapshot(path string) error {
+	// VACUUM INTO refuses to overwrite a file
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err = db.Exec("VACUUM INTO ?", path)
+	if err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	return nil
+}
+
+// OpenSnapshot opens a snapshot of a database in read-only mode, the
+// schema is expected to be up to date and is never modified
+func OpenSnapshot(driver, path string) (*DB, error) {
+	dsn := path
+	if driver == "sqlite3" {
+		dsn = "file:" + path + "?mode=ro&immutable=1"
+	}
+
+	rawdb, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		DB:        rawdb,
+		tableName: "packages",
+	}
+
+	// sql.Open doesn't check that the file is a valid database
+	_, err = db.CountPackages()
+	if err != nil {
+		rawdb.Close()
+		return nil, errors.Wrapf(err, "invalid snapshot %v", path)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SourcePackage is a source package of a Sources index
+type SourcePackage struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version"`
+	Maintainer string   `json:"maintainer"`
+	Binaries   []string `json:"binaries"`
+	// Directory is the pool directory of the files, relative to the root
+	// of the archive
+	Directory string       `json:"directory"`
+	Files     []SourceFile `json:"files"`
+	Component string       `json:"component"`
+	Suite     string       `json:"suite"`
+	Pocket    string       `json:"pocket"`
+}
+
+// SourceFile is a file of a source package (.dsc, tarballs...)
+type SourceFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// DSC returns the .dsc of the source package, nil if it's not listed
+func (s *SourcePackage) DSC() *SourceFile {
+	for i := range s.Files {
+		if strings.HasSuffix(s.Files[i].Name, ".dsc") {
+			return &s.Files[i]
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) createSourcesTableIfNeeded() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS source_packages (
+		'name' VARCHAR(64) NOT NULL,
+		'version' VARCHAR(64) NOT NULL,
+		'maintainer' TEXT NOT NULL,
+		'binaries' TEXT NOT NULL,
+		'directory' TEXT NOT NULL,
+		'files' TEXT NOT NULL,
+		'component' VARCHAR(64) NOT NULL,
+		'suite' VARCHAR(64) NOT NULL,
+		'pocket' VARCHAR(64) NOT NULL,
+		PRIMARY KEY ('name', 'version', 'suite', 'pocket', 'component')
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create source_packages table")
+	}
+
+	return nil
+}
+
+// formatSourceFiles returns the files as the lines of a Checksums-Sha256
+// field: SHA256 SIZE NAME
+func formatSourceFiles(files []SourceFile) string {
+	lines := make([]string, len(files))
+	for i, file := range files {
+		lines[i] = fmt.Sprintf("%v %v %v", file.SHA256, file.Size, file.Name)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func parseSourceFiles(value string) ([]SourceFile, error) {
+	files := make([]SourceFile, 0)
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid source file %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of source file %q", line)
+		}
+		files = append(files, SourceFile{Name: fields[2], Size: size, SHA256: fields[0]})
+	}
+
+	return files, nil
+}
+
+// ReplaceSources replaces the source packages of a suite, pocket and
+// component. read is called with a function inserting one source package,
+// it is expected to call it for every package of the Sources index.
+// Nothing is changed if read returns an error.
+func (db *DB) ReplaceSources(suite, pocket, component string, read func(insert func(src *SourcePackage) error) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM source_packages WHERE suite=? AND pocket=? AND component=?", suite, pocket, component)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO source_packages VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+
+	n := 0
+	err = read(func(src *SourcePackage) error {
+		n++
+		_, err := stmt.Exec(src.Name, src.Version, src.Maintainer, strings.Join(src.Binaries, ", "),
+			src.Directory, formatSourceFiles(src.Files), component, suite, pocket)
+		return err
+	})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// GetSources returns the source packages named name in all the suites,
+// restricted to a suite and pocket (noble-updates) if suite isn't empty
+func (db *DB) GetSources(name, suite string) ([]*SourcePackage, error) {
+	query := "SELECT name, version, maintainer, binaries, directory, files, component, suite, pocket FROM source_packages WHERE name=?"
+	args := []interface{}{name}
+	if suite != "" {
+		query += " AND suite || pocket = ?"
+		args = append(args, suite)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make([]*SourcePackage, 0)
+	for rows.Next() {
+		src := new(SourcePackage)
+		var binaries, files string
+		err = rows.Scan(&src.Name, &src.Version, &src.Maintainer, &binaries, &src.Directory, &files, &src.Component, &src.Suite, &src.Pocket)
+		if err != nil {
+			return nil, err
+		}
+		src.Binaries = strings.Split(binaries, ", ")
+		if binaries == "" {
+			src.Binaries = []string{}
+		}
+		src.Files, err = parseSourceFiles(files)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, rows.Err()
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"path"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSources(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hello := &SourcePackage{
+		Name:       "hello",
+		Version:    "2.10-3build1",
+		Maintainer: "Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>",
+		Binaries:   []string{"hello"},
+		Directory:  "pool/main/h/hello",
+		Files:      []SourceFile{{Name: "hello_2.10-3build1.dsc", Size: 1847, SHA256: "0a1e"}, {Name: "hello_2.10.orig.tar.gz", Size: 725946, SHA256: "31e0"}},
+	}
+	// the second import replaces the first one
+	for _, suite := range []string{"noble", "noble", "jammy"} {
+		n, err := db.ReplaceSources(suite, "", "main", func(insert func(src *SourcePackage) error) error {
+			return insert(hello)
+		})
+		if err != nil || n != 1 {
+			t.Fatalf("expected 1 source package, got %v (%v)", n, err)
+		}
+	}
+
+	srcs, err := db.GetSources("hello", "")
+	if err != nil || len(srcs) != 2 {
+		t.Fatalf("expected 2 source packages, got %v (%v)", len(srcs), err)
+	}
+	srcs, err = db.GetSources("hello", "noble")
+	if err != nil || len(srcs) != 1 {
+		t.Fatalf("expected 1 source package, got %v (%v)", len(srcs), err)
+	}
+	src := srcs[0]
+	if src.Suite != "noble" || src.Component != "main" || src.Maintainer != hello.Maintainer || len(src.Binaries) != 1 || src.Binaries[0] != "hello" {
+		t.Errorf("unexpected source package %+v", src)
+	}
+	if len(src.Files) != 2 || src.Files[1] != hello.Files[1] {
+		t.Errorf("unexpected files %+v", src.Files)
+	}
+}
@@ -2,9 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"strings"
 
 	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
@@ -14,6 +16,10 @@ type DB struct {
 
 	tableName   string
 	transaction *sql.Tx
+	// refresh is set between BeginRefresh and EndRefresh, bumped once
+	// the refresh started a new generation
+	refresh bool
+	bumped  bool
 }
 
 // NewConn initialize a connection to the DB
@@ -23,18 +29,51 @@ func NewConn(driver, path string) (*DB, error) {
 		return nil, err
 	}
 	db := &DB{
-		rawdb,
-		"packages",
-		nil,
+		DB:        rawdb,
+		tableName: "packages",
 	}
 
 	err = db.setupDB(driver)
+	if err != nil {
+		rawdb.Close()
+		return nil, err
+	}
 
 	err = db.createTableIfNeeded()
 	if err != nil {
 		return nil, err
 	}
 
+	err = db.addColumnsIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.createContentsTableIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.createHistoryTableIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.createTranslationsTableIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.createSourcesTableIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.createGenerationTableIfNeeded()
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -88,6 +127,16 @@ func (db *DB) createTableIfNeeded() error {
 		'conflicts' VARCHAR(200) NULL,
 		'suggests' VARCHAR(200) NULL,
 		'description' VARCHAR(64) NULL,
+		'priority' VARCHAR(64) NULL,
+		'homepage' VARCHAR(200) NULL,
+		'recommends' VARCHAR(200) NULL,
+		'provides' VARCHAR(200) NULL,
+		'breaks' VARCHAR(200) NULL,
+		'enhances' VARCHAR(200) NULL,
+		'built_using' VARCHAR(200) NULL,
+		'multi_arch' VARCHAR(16) NULL,
+		'essential' VARCHAR(8) NULL,
+		'package_architecture' VARCHAR(10) NULL,
 		PRIMARY KEY ('name', 'component', 'suite', 'pocket', 'architecture')
 	)`)
 	if err != nil {
@@ -104,9 +153,72 @@ func (db *DB) createTableIfNeeded() error {
 	return tx.Commit()
 }
 
+// addedColumns are the columns added to the packages table after its
+// creation, they are added to existing databases on startup
+var addedColumns = [][2]string{
+	{"priority", "VARCHAR(64) NULL"},
+	{"homepage", "VARCHAR(200) NULL"},
+	{"recommends", "VARCHAR(200) NULL"},
+	{"provides", "VARCHAR(200) NULL"},
+	{"breaks", "VARCHAR(200) NULL"},
+	{"enhances", "VARCHAR(200) NULL"},
+	{"built_using", "VARCHAR(200) NULL"},
+	{"multi_arch", "VARCHAR(16) NULL"},
+	{"essential", "VARCHAR(8) NULL"},
+	{"package_architecture", "VARCHAR(10) NULL"},
+}
+
+// packageColumns are the columns read by scanPackage, columns added after
+// the creation of the table may be NULL for old rows
+const packageColumns = `name, version, component, suite, pocket, architecture,
+	source, section, maintainer_name, maintainer_email, sha256, size,
+	install_size, file_name, depends, pre_depends, replace, conflicts,
+	suggests, description, COALESCE(priority, ''), COALESCE(homepage, ''),
+	COALESCE(recommends, ''), COALESCE(provides, ''), COALESCE(breaks, ''),
+	COALESCE(enhances, ''), COALESCE(built_using, ''), COALESCE(multi_arch, ''),
+	COALESCE(essential, ''), COALESCE(package_architecture, '')`
+
+func (db *DB) addColumnsIfNeeded() error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", db.tableName)
+	if err != nil {
+		return errors.Wrap(err, "failed to get columns from DB")
+	}
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		err = rows.Scan(&column)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		columns[column] = true
+	}
+	rows.Close()
+
+	for _, column := range addedColumns {
+		if columns[column[0]] {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN '%v' %v", db.tableName, column[0], column[1]))
+		if err != nil {
+			return errors.Wrapf(err, "failed to add column %v", column[0])
+		}
+	}
+
+	// indexes created after the table, for existing databases as well
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_source ON %v (source)", db.tableName))
+	if err != nil {
+		return errors.Wrap(err, "failed to create index")
+	}
+
+	return nil
+}
+
 // GetPackage from the db
 func (db *DB) GetPackage(pkgName string) ([]*debianpkg.PackageInfo, error) {
-	rows, err := db.Query("SELECT * FROM packages WHERE name=?", pkgName)
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages WHERE name=?", pkgName)
 	if err != nil {
 		return nil, err
 	}
@@ -115,48 +227,35 @@ func (db *DB) GetPackage(pkgName string) ([]*debianpkg.PackageInfo, error) {
 	pkgInfo := make([]*debianpkg.PackageInfo, 0)
 
 	for rows.Next() {
-		info := new(debianpkg.PackageInfo)
-		info.Maintainer = new(debianpkg.PackageMaintainer)
-
-		var (
-			depends    string
-			predepends string
-			replaces   string
-			conflicts  string
-			suggests   string
-		)
-
-		err = rows.Scan(
-			&info.Name,
-			&info.Version,
-			&info.Component,
-			&info.Suite,
-			&info.Pocket,
-			&info.Architecture,
-			&info.Source,
-			&info.Section,
-			&info.Maintainer.Name,
-			&info.Maintainer.Email,
-			&info.SHA256,
-			&info.Size,
-			&info.InstalledSize,
-			&info.FileName,
-			&depends,
-			&predepends,
-			&replaces,
-			&conflicts,
-			&suggests,
-			&info.Description,
-		)
+		info, err := scanPackage(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		info.Depends = strings.Split(depends, ", ")
-		info.PreDepends = strings.Split(predepends, ", ")
-		info.Suggests = strings.Split(suggests, ", ")
-		info.Replaces = strings.Split(replaces, ", ")
-		info.Conflicts = strings.Split(conflicts, ", ")
+		pkgInfo = append(pkgInfo, info)
+	}
+
+	return pkgInfo, rows.Err()
+}
+
+// GetPackagesBySource returns the binary packages built from a source
+// package, whatever its version
+func (db *DB) GetPackagesBySource(source string) ([]*debianpkg.PackageInfo, error) {
+	// the Source field is empty when the source has the name of the binary
+	// and contains the version when it differs from the one of the binary
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages WHERE source = ? OR source GLOB ? OR (source = '' AND name = ?)",
+		source, source+" (*", source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pkgInfo := make([]*debianpkg.PackageInfo, 0)
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return nil, err
+		}
 
 		pkgInfo = append(pkgInfo, info)
 	}
@@ -164,6 +263,216 @@ func (db *DB) GetPackage(pkgName string) ([]*debianpkg.PackageInfo, error) {
 	return pkgInfo, rows.Err()
 }
 
+// PackageVersion returns the version of the package stored for the same
+// component, suite, pocket and architecture, or an empty string. Pending
+// inserts are taken into account.
+func (db *DB) PackageVersion(pkgInfo *debianpkg.PackageInfo) (string, error) {
+	query := "SELECT version FROM packages WHERE name=? AND component=? AND suite=? AND pocket=? AND architecture=?"
+	args := []interface{}{pkgInfo.Name, pkgInfo.Component, pkgInfo.Suite, pkgInfo.Pocket, pkgInfo.Architecture}
+
+	var row *sql.Row
+	if db.transaction != nil {
+		row = db.transaction.QueryRow(query, args...)
+	} else {
+		row = db.QueryRow(query, args...)
+	}
+
+	var version string
+	err := row.Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return version, err
+}
+
+// Filter restricts the packages returned by a query, empty fields match
+// everything
+type Filter struct {
+	// Suite is the suite and the pocket, e.g. noble-updates
+	Suite        string
+	Component    string
+	Architecture string
+}
+
+// where returns the WHERE clause (possibly empty) and its arguments
+func (f Filter) where() (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if f.Suite != "" {
+		conditions = append(conditions, "suite || pocket = ?")
+		args = append(args, f.Suite)
+	}
+	if f.Component != "" {
+		conditions = append(conditions, "component = ?")
+		args = append(args, f.Component)
+	}
+	if f.Architecture != "" {
+		conditions = append(conditions, "architecture = ?")
+		args = append(args, f.Architecture)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Match reports whether a package matches the filter, for the packages
+// that are not read from a database
+func (f Filter) Match(pkg *debianpkg.PackageInfo) bool {
+	if f.Suite != "" && pkg.Suite+pkg.Pocket != f.Suite {
+		return false
+	}
+	if f.Component != "" && pkg.Component != f.Component {
+		return false
+	}
+	if f.Architecture != "" && pkg.Architecture != f.Architecture {
+		return false
+	}
+
+	return true
+}
+
+// ForEachPackage calls fn for every package matching the filter. Rows are
+// read one at a time from the DB so the whole result is never loaded in
+// memory. Iteration stops at the first error returned by fn.
+func (db *DB) ForEachPackage(filter Filter, fn func(*debianpkg.PackageInfo) error) error {
+	where, args := filter.where()
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages"+where+" ORDER BY name", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// scanPackage reads a row of the packages table
+func scanPackage(rows *sql.Rows) (*debianpkg.PackageInfo, error) {
+	info := new(debianpkg.PackageInfo)
+	info.Maintainer = new(debianpkg.PackageMaintainer)
+
+	var (
+		depends    string
+		predepends string
+		replaces   string
+		conflicts  string
+		suggests   string
+		recommends string
+		provides   string
+		breaks     string
+		enhances   string
+	)
+
+	err := rows.Scan(
+		&info.Name,
+		&info.Version,
+		&info.Component,
+		&info.Suite,
+		&info.Pocket,
+		&info.Architecture,
+		&info.Source,
+		&info.Section,
+		&info.Maintainer.Name,
+		&info.Maintainer.Email,
+		&info.SHA256,
+		&info.Size,
+		&info.InstalledSize,
+		&info.FileName,
+		&depends,
+		&predepends,
+		&replaces,
+		&conflicts,
+		&suggests,
+		&info.Description,
+		&info.Priority,
+		&info.Homepage,
+		&recommends,
+		&provides,
+		&breaks,
+		&enhances,
+		&info.BuiltUsing,
+		&info.MultiArch,
+		&info.Essential,
+		&info.PackageArchitecture,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Depends = strings.Split(depends, ", ")
+	info.PreDepends = strings.Split(predepends, ", ")
+	info.Suggests = strings.Split(suggests, ", ")
+	info.Replaces = strings.Split(replaces, ", ")
+	info.Conflicts = strings.Split(conflicts, ", ")
+	// the fields added later are omitted when empty
+	info.Recommends = splitList(recommends)
+	info.Provides = splitList(provides)
+	info.Breaks = splitList(breaks)
+	info.Enhances = splitList(enhances)
+
+	return info, nil
+}
+
+// splitList splits the value of a list field, nil if it's empty
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ", ")
+}
+
+// CountPackages returns the number of packages in the db
+func (db *DB) CountPackages() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM packages").Scan(&n)
+
+	return n, err
+}
+
+// ListDistinct returns the distinct values of a column of the packages
+// table. Only suite, pocket, component and architecture can be listed.
+func (db *DB) ListDistinct(column string) ([]string, error) {
+	switch column {
+	case "suite", "pocket", "component", "architecture":
+	default:
+		return nil, fmt.Errorf("cannot list values of column %v", column)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT DISTINCT %v FROM packages ORDER BY %v", column, column))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
 // PrepareInsertPackage add a statement in the prepared list
 // but do not commit anything to the db
 func (db *DB) PrepareInsertPackage(pkgInfo *debianpkg.PackageInfo) error {
@@ -186,7 +495,14 @@ func (db *DB) PrepareInsertPackage(pkgInfo *debianpkg.PackageInfo) error {
 		maintainerEmail = pkgInfo.Maintainer.Email
 	}
 
-	_, err = db.transaction.Exec("INSERT OR REPLACE INTO packages VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	_, err = db.transaction.Exec(`INSERT OR REPLACE INTO packages (
+		name, version, component, suite, pocket, architecture, source,
+		section, maintainer_name, maintainer_email, sha256, size,
+		install_size, file_name, depends, pre_depends, replace, conflicts,
+		suggests, description, priority, homepage, recommends, provides,
+		breaks, enhances, built_using, multi_arch, essential,
+		package_architecture
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		pkgInfo.Name,
 		pkgInfo.Version,
 		pkgInfo.Component,
@@ -207,23 +523,68 @@ func (db *DB) PrepareInsertPackage(pkgInfo *debianpkg.PackageInfo) error {
 		strings.Join(pkgInfo.Conflicts, ", "),
 		strings.Join(pkgInfo.Suggests, ", "),
 		pkgInfo.Description,
+		pkgInfo.Priority,
+		pkgInfo.Homepage,
+		strings.Join(pkgInfo.Recommends, ", "),
+		strings.Join(pkgInfo.Provides, ", "),
+		strings.Join(pkgInfo.Breaks, ", "),
+		strings.Join(pkgInfo.Enhances, ", "),
+		pkgInfo.BuiltUsing,
+		pkgInfo.MultiArch,
+		pkgInfo.Essential,
+		pkgInfo.PackageArchitecture,
 	)
 
 	return err
 }
 
-// InsertPrepared commit the current transaction
+// BeginRefresh groups the transactions committed until EndRefresh in one
+// generation, the refreshes commit every few thousand packages
+func (db *DB) BeginRefresh() {
+	db.refresh = true
+	db.bumped = false
+}
+
+// EndRefresh ends the refresh started by BeginRefresh
+func (db *DB) EndRefresh() {
+	db.refresh = false
+}
+
+// InsertPrepared commit the current transaction, as a new generation of
+// the packages unless the refresh in progress already started one
 func (db *DB) InsertPrepared() error {
 	if db.transaction == nil {
 		return errors.New("no transaction in progress")
 	}
 
-	err := db.transaction.Commit()
+	var err error
+	if !db.refresh || !db.bumped {
+		err = nextGeneration(db.transaction)
+	}
+	if err == nil {
+		err = db.transaction.Commit()
+	}
 	if err != nil {
 		db.transaction.Rollback()
 		return err
 	}
 
 	db.transaction = nil
+	db.bumped = true
 	return nil
 }
+
+// IsCorrupt returns true if the error means that the database file is
+// corrupt or is not a database
+func IsCorrupt(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+	}
+	var integrityErr *IntegrityError
+	if errors.As(err, &integrityErr) {
+		return integrityErr.Corrupt
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sourceNameColumn is the name of the source package of a row, the Source
+// field is empty when the source has the same name as the binary and can
+// contain a version ("glibc (2.39-0ubuntu8)")
+const sourceNameColumn = `CASE
+	WHEN source = '' THEN name
+	WHEN instr(source, ' (') > 0 THEN substr(source, 1, instr(source, ' (') - 1)
+	ELSE source
+END`
+
+// SuiteStats is the content of a suite for an architecture
+type SuiteStats struct {
+	Suite        string `json:"suite"`
+	Pocket       string `json:"pocket"`
+	Architecture string `json:"architecture"`
+	Packages     int    `json:"packages"`
+	Sources      int    `json:"sources"`
+	// LastChange is when the newest version of the suite was first seen,
+	// nil if the history of the suite is empty
+	LastChange *time.Time `json:"last_change,omitempty"`
+}
+
+// CountSources returns the number of distinct source packages in the db
+func (db *DB) CountSources() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(DISTINCT " + sourceNameColumn + ") FROM packages").Scan(&n)
+
+	return n, err
+}
+
+// GetSuiteStats returns the number of packages and source packages, and the
+// last change of each suite and architecture
+func (db *DB) GetSuiteStats() ([]*SuiteStats, error) {
+	rows, err := db.Query(`SELECT p.suite, p.pocket, p.architecture, p.packages, p.sources, h.last_change FROM (
+		SELECT suite, pocket, architecture, COUNT(*) AS packages, COUNT(DISTINCT ` + sourceNameColumn + `) AS sources
+		FROM packages GROUP BY suite, pocket, architecture
+	) p LEFT JOIN (
+		SELECT suite, pocket, architecture, MAX(first_seen) AS last_change
+		FROM history GROUP BY suite, pocket, architecture
+	) h USING (suite, pocket, architecture)
+	ORDER BY p.suite, p.pocket, p.architecture`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the stats of the suites")
+	}
+	defer rows.Close()
+
+	allStats := make([]*SuiteStats, 0)
+	for rows.Next() {
+		stats := new(SuiteStats)
+		var lastChange sql.NullInt64
+		err = rows.Scan(&stats.Suite, &stats.Pocket, &stats.Architecture, &stats.Packages, &stats.Sources, &lastChange)
+		if err != nil {
+			return nil, err
+		}
+		if lastChange.Valid {
+			t := time.Unix(lastChange.Int64, 0).UTC()
+			stats.LastChange = &t
+		}
+		allStats = append(allStats, stats)
+	}
+
+	return allStats, rows.Err()
+}
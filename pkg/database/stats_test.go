@@ -0,0 +1,63 @@
+package database
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSuiteStats(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	seen := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "libc6", Version: "2.39-0ubuntu8", Source: "glibc", Suite: "noble", Architecture: "amd64", Component: "main"},
+		{Name: "libc-bin", Version: "2.39-0ubuntu8", Source: "glibc (2.39-0ubuntu8)", Suite: "noble", Architecture: "amd64", Component: "main"},
+		{Name: "hello", Version: "2.10-3build1", Suite: "noble", Architecture: "amd64", Component: "main"},
+		{Name: "hello", Version: "2.10-3build1", Suite: "noble", Architecture: "arm64", Component: "main"},
+	}
+	for _, pkg := range pkgs {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.PrepareInsertHistory(pkgs[2], seen, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := db.CountSources()
+	if err != nil || sources != 2 {
+		t.Errorf("expected 2 sources, got %v (%v)", sources, err)
+	}
+
+	stats, err := db.GetSuiteStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 suites, got %v", len(stats))
+	}
+	amd64 := stats[0]
+	if amd64.Architecture != "amd64" || amd64.Packages != 3 || amd64.Sources != 2 {
+		t.Errorf("unexpected stats for amd64: %+v", amd64)
+	}
+	if amd64.LastChange == nil || !amd64.LastChange.Equal(seen) {
+		t.Errorf("expected last change on %v, got %v", seen, amd64.LastChange)
+	}
+	if stats[1].LastChange != nil {
+		t.Errorf("expected no last change for arm64, got %v", stats[1].LastChange)
+	}
+}
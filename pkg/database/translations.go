@@ -0,0 +1,169 @@
+package database
+
+import (
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/pkg/errors"
+)
+
+func (db *DB) createTranslationsTableIfNeeded() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS translations (
+		'name' VARCHAR(64) NOT NULL,
+		'suite' VARCHAR(64) NOT NULL,
+		'pocket' VARCHAR(64) NOT NULL,
+		'component' VARCHAR(64) NOT NULL,
+		'lang' VARCHAR(16) NOT NULL,
+		'description' TEXT NOT NULL,
+		PRIMARY KEY ('name', 'suite', 'pocket', 'lang')
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create translations table")
+	}
+
+	// the full text index of the descriptions, its docids are the rowids of
+	// the translations
+	_, err = db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS translations_fts USING fts4(description)")
+	if err != nil {
+		return errors.Wrap(err, "failed to create translations index")
+	}
+
+	return nil
+}
+
+// ReplaceTranslations replaces the descriptions in a language of the
+// packages of a suite, pocket and component. read is called with a
+// function inserting one description, it is expected to call it for every
+// package of the Translation index. Nothing is changed if read returns an
+// error.
+func (db *DB) ReplaceTranslations(suite, pocket, component, lang string, read func(insert func(name, description string) error) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	where := " WHERE suite=? AND pocket=? AND component=? AND lang=?"
+	_, err = tx.Exec("DELETE FROM translations_fts WHERE docid IN (SELECT rowid FROM translations"+where+")", suite, pocket, component, lang)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM translations"+where, suite, pocket, component, lang)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO translations VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+	ftsStmt, err := tx.Prepare("INSERT OR REPLACE INTO translations_fts (docid, description) VALUES (?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer ftsStmt.Close()
+
+	n := 0
+	err = read(func(name, description string) error {
+		n++
+		res, err := stmt.Exec(name, suite, pocket, component, lang, description)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = ftsStmt.Exec(id, description)
+		return err
+	})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// GetDescriptions returns the descriptions of a package in a language, by
+// suite and pocket (noble-updates)
+func (db *DB) GetDescriptions(name, lang string) (map[string]string, error) {
+	rows, err := db.Query("SELECT suite, pocket, description FROM translations WHERE name=? AND lang=?", name, lang)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	descriptions := make(map[string]string)
+	for rows.Next() {
+		var suite, pocket, description string
+		err = rows.Scan(&suite, &pocket, &description)
+		if err != nil {
+			return nil, err
+		}
+		descriptions[suite+pocket] = description
+	}
+
+	return descriptions, rows.Err()
+}
+
+// SearchDescriptions returns the packages whose description in one of the
+// languages matches a full text query (see the syntax of the SQLite FTS4
+// MATCH), and whose name matches the glob pattern if it's not empty. The
+// packages of the other pockets fall back on the descriptions of the
+// release pocket, the translations are usually only published there.
+func (db *DB) SearchDescriptions(text string, langs []string, pattern string, filter Filter, limit int) ([]*debianpkg.PackageInfo, error) {
+	if len(langs) == 0 {
+		return []*debianpkg.PackageInfo{}, nil
+	}
+
+	where, args := filter.where()
+	if where == "" {
+		where = " WHERE "
+	} else {
+		where += " AND "
+	}
+	if pattern != "" {
+		where += "name GLOB ? AND "
+		args = append(args, pattern)
+	}
+
+	placeholders := "?"
+	for range langs[1:] {
+		placeholders += ", ?"
+	}
+	matching := `SELECT t.name, t.suite, t.pocket FROM translations_fts f JOIN translations t ON t.rowid = f.docid
+		WHERE f.description MATCH ? AND t.lang IN (` + placeholders + `)`
+	where += `((name, suite, pocket) IN (` + matching + `)
+		OR (pocket != '' AND (name, suite, '') IN (` + matching + `)
+			AND NOT EXISTS (SELECT 1 FROM translations p WHERE p.name = packages.name AND p.suite = packages.suite
+				AND p.pocket = packages.pocket AND p.lang IN (` + placeholders + `))))`
+	for i := 0; i < 2; i++ {
+		args = append(args, text)
+		for _, lang := range langs {
+			args = append(args, lang)
+		}
+	}
+	for _, lang := range langs {
+		args = append(args, lang)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query("SELECT "+packageColumns+" FROM packages"+where+" ORDER BY name LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pkgInfo := make([]*debianpkg.PackageInfo, 0)
+	for rows.Next() {
+		info, err := scanPackage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		pkgInfo = append(pkgInfo, info)
+	}
+
+	return pkgInfo, rows.Err()
+}
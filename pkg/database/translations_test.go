@@ -0,0 +1,95 @@
+package database
+
+import (
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTranslations(t *testing.T) {
+	db, err := NewConn("sqlite3", path.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "ufw", Version: "0.36.2-6", Suite: "noble", Architecture: "all", Component: "main", Description: "program for managing a Netfilter firewall"},
+		{Name: "hello", Version: "2.10-3build1", Suite: "noble", Architecture: "amd64", Component: "main", Description: "example package based on GNU hello"},
+		// no translation of its own, it falls back on the release pocket
+		{Name: "ufw", Version: "0.36.2-6ubuntu1", Suite: "noble", Pocket: "-updates", Architecture: "all", Component: "main", Description: "program for managing a Netfilter firewall"},
+		// its own translation takes precedence
+		{Name: "hello", Version: "2.10-3ubuntu1", Suite: "noble", Pocket: "-updates", Architecture: "amd64", Component: "main", Description: "example package based on GNU hello"},
+	}
+	for _, pkg := range pkgs {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	translations := map[string]map[string]string{
+		"de": {"ufw": "Programm zur Verwaltung einer Netfilter-Firewall\nufw bietet eine einfache Oberfläche."},
+		"en": {"ufw": "program for managing a Netfilter firewall", "hello": "example package based on GNU hello"},
+	}
+	// the second import replaces the first one
+	for i := 0; i < 2; i++ {
+		for lang, descriptions := range translations {
+			n, err := db.ReplaceTranslations("noble", "", "main", lang, func(insert func(name, description string) error) error {
+				for name, description := range descriptions {
+					err := insert(name, description)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil || n != len(descriptions) {
+				t.Fatalf("expected %v translations, got %v (%v)", len(descriptions), n, err)
+			}
+		}
+	}
+
+	_, err = db.ReplaceTranslations("noble", "-updates", "main", "en", func(insert func(name, description string) error) error {
+		return insert("hello", "greeting program")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descriptions, err := db.GetDescriptions("ufw", "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descriptions["noble"] != translations["de"]["ufw"] {
+		t.Errorf("unexpected description %q", descriptions["noble"])
+	}
+
+	tests := []struct {
+		text     string
+		langs    []string
+		expected int
+	}{
+		{"Oberfläche", []string{"de"}, 2},
+		{"oberfläche", []string{"en"}, 0},
+		{"firewall", []string{"de", "en"}, 2},
+		{"hello", []string{"de", "en"}, 1},
+		{"hello", []string{"de"}, 0},
+		{"greeting", []string{"en"}, 1},
+	}
+	for _, test := range tests {
+		found, err := db.SearchDescriptions(test.text, test.langs, "", Filter{Component: "main"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != test.expected {
+			t.Errorf("%v in %v: expected %v packages, got %v", test.text, test.langs, test.expected, len(found))
+		}
+	}
+}
@@ -2,6 +2,7 @@ package debianpkg
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -23,6 +24,7 @@ type PackageInfo struct {
 	Architecture  string             `json:"architecture"`
 	Source        string             `json:"source"`
 	Section       string             `json:"section"`
+	Priority      string             `json:"priority"`
 	Maintainer    *PackageMaintainer `json:"maintainer"`
 	SHA256        string             `json:"sha256"`
 	Size          int                `json:"size"`
@@ -33,7 +35,44 @@ type PackageInfo struct {
 	Replaces      []string           `json:"replaces"`
 	Conflicts     []string           `json:"conflicts"`
 	Suggests      []string           `json:"suggests"`
+	Recommends    []string           `json:"recommends,omitempty"`
+	Provides      []string           `json:"provides,omitempty"`
+	Breaks        []string           `json:"breaks,omitempty"`
+	Enhances      []string           `json:"enhances,omitempty"`
+	BuiltUsing    string             `json:"built-using,omitempty"`
+	MultiArch     string             `json:"multi-arch,omitempty"`
+	Essential     string             `json:"essential,omitempty"`
 	Description   string             `json:"description"`
+	Homepage      string             `json:"homepage"`
+	// PackageArchitecture is the Architecture field of the package, all
+	// for the packages listed in the indexes of every architecture
+	PackageArchitecture string `json:"package-architecture,omitempty"`
+	// Archive is the name of the archive the package was found in, it's
+	// set by the server
+	Archive string `json:"archive,omitempty"`
+	// URL is the download URL of the .deb in the pool of the archive, it's
+	// set by the server
+	URL string `json:"url,omitempty"`
+	// Annotations are added by the server from an overlay, they do not
+	// come from the archive
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// sourceVersionRegexp matches the Source field when the version of the
+// source differs from the version of the binary: "glibc (2.39-0ubuntu8)"
+var sourceVersionRegexp = regexp.MustCompile(`^(\S+) \((\S+)\)$`)
+
+// SourceNameVersion returns the name and the version of the source package
+// the binary package was built from
+func (pkgInfo *PackageInfo) SourceNameVersion() (string, string) {
+	if matches := sourceVersionRegexp.FindStringSubmatch(pkgInfo.Source); matches != nil {
+		return matches[1], matches[2]
+	}
+	if pkgInfo.Source != "" {
+		return pkgInfo.Source, pkgInfo.Version
+	}
+
+	return pkgInfo.Name, pkgInfo.Version
 }
 
 // Set sets a field on the object
@@ -50,6 +89,14 @@ func (pkgInfo *PackageInfo) Set(key, value string) error {
 		pkgInfo.Section = value
 		return nil
 	}
+	if key == "Priority" {
+		pkgInfo.Priority = value
+		return nil
+	}
+	if key == "Homepage" {
+		pkgInfo.Homepage = value
+		return nil
+	}
 	if key == "Size" {
 		pkgInfo.Size, _ = strconv.Atoi(value)
 		return nil
@@ -78,6 +125,38 @@ func (pkgInfo *PackageInfo) Set(key, value string) error {
 		pkgInfo.Suggests = strings.Split(value, ", ")
 		return nil
 	}
+	if key == "Recommends" {
+		pkgInfo.Recommends = strings.Split(value, ", ")
+		return nil
+	}
+	if key == "Provides" {
+		pkgInfo.Provides = strings.Split(value, ", ")
+		return nil
+	}
+	if key == "Breaks" {
+		pkgInfo.Breaks = strings.Split(value, ", ")
+		return nil
+	}
+	if key == "Enhances" {
+		pkgInfo.Enhances = strings.Split(value, ", ")
+		return nil
+	}
+	if key == "Built-Using" {
+		pkgInfo.BuiltUsing = value
+		return nil
+	}
+	if key == "Multi-Arch" {
+		pkgInfo.MultiArch = value
+		return nil
+	}
+	if key == "Essential" {
+		pkgInfo.Essential = value
+		return nil
+	}
+	if key == "Architecture" {
+		pkgInfo.PackageArchitecture = value
+		return nil
+	}
 	if key == "SHA256" {
 		pkgInfo.SHA256 = value
 		return nil
@@ -107,3 +186,92 @@ func (pkgInfo *PackageInfo) Set(key, value string) error {
 
 	return nil
 }
+
+// WriteDeb822 writes the package as a deb822 stanza, using the field
+// names of the archive Packages indexes. Empty fields are omitted.
+func (pkgInfo *PackageInfo) WriteDeb822(w io.Writer) error {
+	return writeFields(w, pkgInfo.fields(true))
+}
+
+// WritePackagesStanza writes the package as a stanza of a Packages index,
+// without the fields telling where the package is published. The
+// Architecture is the one of the package (all for the architecture
+// independent packages).
+func (pkgInfo *PackageInfo) WritePackagesStanza(w io.Writer) error {
+	return writeFields(w, pkgInfo.fields(false))
+}
+
+// fields returns the deb822 fields of the package, with its archive, suite
+// and component when withLocation is true
+func (pkgInfo *PackageInfo) fields(withLocation bool) [][2]string {
+	maintainer := ""
+	if pkgInfo.Maintainer != nil && pkgInfo.Maintainer.Name != "" {
+		maintainer = fmt.Sprintf("%v <%v>", pkgInfo.Maintainer.Name, pkgInfo.Maintainer.Email)
+	}
+
+	architecture := pkgInfo.Architecture
+	if !withLocation && pkgInfo.PackageArchitecture != "" {
+		architecture = pkgInfo.PackageArchitecture
+	}
+
+	fields := [][2]string{
+		{"Package", pkgInfo.Name},
+		{"Architecture", architecture},
+		{"Version", pkgInfo.Version},
+	}
+	if withLocation {
+		fields = append(fields, [][2]string{
+			{"Archive", pkgInfo.Archive},
+			{"Suite", pkgInfo.Suite + pkgInfo.Pocket},
+			{"Component", pkgInfo.Component},
+		}...)
+	}
+
+	return append(fields, [][2]string{
+		{"Source", pkgInfo.Source},
+		{"Section", pkgInfo.Section},
+		{"Priority", pkgInfo.Priority},
+		{"Multi-Arch", pkgInfo.MultiArch},
+		{"Essential", pkgInfo.Essential},
+		{"Maintainer", maintainer},
+		{"Installed-Size", formatSize(pkgInfo.InstalledSize)},
+		{"Provides", strings.Join(pkgInfo.Provides, ", ")},
+		{"Pre-Depends", strings.Join(pkgInfo.PreDepends, ", ")},
+		{"Depends", strings.Join(pkgInfo.Depends, ", ")},
+		{"Recommends", strings.Join(pkgInfo.Recommends, ", ")},
+		{"Suggests", strings.Join(pkgInfo.Suggests, ", ")},
+		{"Conflicts", strings.Join(pkgInfo.Conflicts, ", ")},
+		{"Breaks", strings.Join(pkgInfo.Breaks, ", ")},
+		{"Replaces", strings.Join(pkgInfo.Replaces, ", ")},
+		{"Enhances", strings.Join(pkgInfo.Enhances, ", ")},
+		{"Built-Using", pkgInfo.BuiltUsing},
+		{"Filename", pkgInfo.FileName},
+		{"Size", formatSize(pkgInfo.Size)},
+		{"SHA256", pkgInfo.SHA256},
+		{"Homepage", pkgInfo.Homepage},
+		{"Description", pkgInfo.Description},
+	}...)
+}
+
+// writeFields writes the non-empty fields
+func writeFields(w io.Writer, fields [][2]string) error {
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%v: %v\n", field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatSize(size int) string {
+	if size == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(size)
+}
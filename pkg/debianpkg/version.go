@@ -0,0 +1,11 @@
+package debianpkg
+
+import "github.com/gjolly/go-rmadison/pkg/version"
+
+// CompareVersions compares two Debian versions, it returns a negative
+// number if a < b, 0 if a == b and a positive number if a > b
+//
+// Deprecated: use version.Compare
+func CompareVersions(a, b string) int {
+	return version.Compare(a, b)
+}
@@ -0,0 +1,243 @@
+// Package pinning evaluates APT preferences (see apt_preferences(5)) to
+// find the version of a package APT would install
+package pinning
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+)
+
+// default priorities of the versions, see apt_preferences(5)
+const (
+	DefaultPriority       = 500
+	TargetReleasePriority = 990
+	// NotAutomaticPriority is the priority of the suites with
+	// NotAutomatic (experimental), AutomaticUpgradesPriority the one of
+	// the suites with ButAutomaticUpgrades as well (backports)
+	NotAutomaticPriority      = 1
+	AutomaticUpgradesPriority = 100
+)
+
+// Pin is a record of a preferences file
+type Pin struct {
+	// Packages are names, glob patterns or /regexps/, * matches all the
+	// packages
+	Packages []string
+	// Type is the first word of the Pin field: release, version or origin
+	Type string
+	// Value is the rest of the Pin field
+	Value    string
+	Priority int
+}
+
+// Candidate is a version of a package available in a suite
+type Candidate struct {
+	*debianpkg.PackageInfo
+	// Origin is the host of the archive serving the package
+	Origin string
+	// NotAutomatic and ButAutomaticUpgrades are the fields of the
+	// Release file of the suite
+	NotAutomatic         bool
+	ButAutomaticUpgrades bool
+}
+
+// Parse reads a preferences file: records are separated by empty lines,
+// comments (Explanation fields and lines starting with #) are ignored
+func Parse(preferences string) ([]*Pin, error) {
+	pins := make([]*Pin, 0)
+	for i, record := range strings.Split(strings.ReplaceAll(preferences, "\r\n", "\n"), "\n\n") {
+		pin := new(Pin)
+		hasFields := false
+		for _, line := range strings.Split(record, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("record %v: invalid line %q", i+1, line)
+			}
+			hasFields = true
+			value = strings.TrimSpace(value)
+
+			switch strings.ToLower(key) {
+			case "package":
+				pin.Packages = strings.Fields(value)
+			case "pin":
+				pin.Type, pin.Value, _ = strings.Cut(value, " ")
+				pin.Value = strings.TrimSpace(pin.Value)
+			case "pin-priority":
+				priority, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("record %v: invalid priority %q", i+1, value)
+				}
+				pin.Priority = priority
+			}
+		}
+
+		if !hasFields {
+			continue
+		}
+		if len(pin.Packages) == 0 || pin.Type == "" {
+			return nil, fmt.Errorf("record %v: Package and Pin are required", i+1)
+		}
+		switch pin.Type {
+		case "release":
+			err := checkRelease(pin.Value)
+			if err != nil {
+				return nil, fmt.Errorf("record %v: %v", i+1, err)
+			}
+		case "version", "origin":
+		default:
+			return nil, fmt.Errorf("record %v: unknown pin type %q", i+1, pin.Type)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	return pins, nil
+}
+
+// matchName matches a package name against a name, a glob or a /regexp/
+func matchName(pattern, name string) bool {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		return err == nil && re.MatchString(name)
+	}
+
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// releaseCondition splits a release condition, release noble-updates is
+// short for release a=noble-updates
+func releaseCondition(condition string) (string, string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(condition), "=")
+	if !ok {
+		return "a", strings.TrimSpace(condition)
+	}
+
+	return key, value
+}
+
+// checkRelease returns an error for the release conditions that can't be
+// matched: only archive (a), codename (n) and component (c) are known
+func checkRelease(conditions string) error {
+	for _, condition := range strings.Split(conditions, ",") {
+		switch key, _ := releaseCondition(condition); key {
+		case "a", "n", "c":
+		default:
+			return fmt.Errorf("unsupported release condition %q, only a, n and c are known", strings.TrimSpace(condition))
+		}
+	}
+
+	return nil
+}
+
+// matchRelease matches the release conditions (e.g. a=noble-updates,c=main)
+// against the suite of a package, see checkRelease
+func matchRelease(conditions string, candidate *Candidate) bool {
+	for _, condition := range strings.Split(conditions, ",") {
+		key, value := releaseCondition(condition)
+
+		switch key {
+		case "a":
+			if candidate.Suite+candidate.Pocket != value {
+				return false
+			}
+		case "n":
+			if candidate.Suite != value {
+				return false
+			}
+		case "c":
+			if candidate.Component != value {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// matches returns true if the pin applies to the candidate
+func (p *Pin) matches(candidate *Candidate) bool {
+	found := false
+	for _, pattern := range p.Packages {
+		if pattern == "*" || matchName(pattern, candidate.Name) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	switch p.Type {
+	case "release":
+		return matchRelease(p.Value, candidate)
+	case "version":
+		matched, err := path.Match(p.Value, candidate.Version)
+		return err == nil && matched
+	case "origin":
+		return strings.Trim(p.Value, `"`) == candidate.Origin
+	}
+
+	return false
+}
+
+// Priority returns the priority of a candidate: the one of the first pin
+// matching it, or the default priority (higher for the target release,
+// as set with APT::Default-Release, if any, and lower for the suites with
+// NotAutomatic)
+func Priority(pins []*Pin, targetRelease string, candidate *Candidate) int {
+	for _, pin := range pins {
+		if pin.matches(candidate) {
+			return pin.Priority
+		}
+	}
+
+	if targetRelease != "" && (candidate.Suite+candidate.Pocket == targetRelease || candidate.Suite == targetRelease) {
+		return TargetReleasePriority
+	}
+	if candidate.NotAutomatic && candidate.ButAutomaticUpgrades {
+		return AutomaticUpgradesPriority
+	}
+	if candidate.NotAutomatic {
+		return NotAutomaticPriority
+	}
+
+	return DefaultPriority
+}
+
+// Select returns the candidate APT would install (the version with the
+// highest priority, then the highest version) and its priority, or nil if
+// all the candidates have a negative priority
+func Select(pins []*Pin, targetRelease string, candidates []*Candidate) (*Candidate, int) {
+	var (
+		selected *Candidate
+		priority int
+	)
+	for _, candidate := range candidates {
+		p := Priority(pins, targetRelease, candidate)
+		if p < 0 {
+			continue
+		}
+
+		if selected == nil || p > priority ||
+			(p == priority && version.Compare(candidate.Version, selected.Version) > 0) {
+			selected = candidate
+			priority = p
+		}
+	}
+
+	return selected, priority
+}
@@ -0,0 +1,148 @@
+package pinning
+
+import (
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+const preferences = `
+Explanation: never install curl from proposed
+Package: curl
+Pin: release a=noble-proposed
+Pin-Priority: -1
+
+# hold openssl
+Package: openssl libssl*
+Pin: version 3.0.13-0ubuntu3
+Pin-Priority: 1001
+
+Package: *
+Pin: release n=noble,c=universe
+Pin-Priority: 100
+`
+
+func candidate(name, version, suite, pocket, component string) *Candidate {
+	return &Candidate{
+		PackageInfo: &debianpkg.PackageInfo{
+			Name:      name,
+			Version:   version,
+			Suite:     suite,
+			Pocket:    pocket,
+			Component: component,
+		},
+	}
+}
+
+// backports marks the suite of the candidate with NotAutomatic
+func backports(c *Candidate, automaticUpgrades bool) *Candidate {
+	c.NotAutomatic = true
+	c.ButAutomaticUpgrades = automaticUpgrades
+
+	return c
+}
+
+func TestSelect(t *testing.T) {
+	pins, err := Parse(preferences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 3 {
+		t.Fatalf("expected 3 pins, got %v", len(pins))
+	}
+
+	tests := []struct {
+		Name          string
+		TargetRelease string
+		Candidates    []*Candidate
+		Version       string
+		Priority      int
+	}{
+		{
+			Name: "negative priority",
+			Candidates: []*Candidate{
+				candidate("curl", "8.5.0-2ubuntu10", "noble", "", "main"),
+				candidate("curl", "8.5.0-2ubuntu10.1", "noble", "-updates", "main"),
+				candidate("curl", "8.5.0-2ubuntu10.2", "noble", "-proposed", "main"),
+			},
+			Version:  "8.5.0-2ubuntu10.1",
+			Priority: DefaultPriority,
+		},
+		{
+			Name: "version pin",
+			Candidates: []*Candidate{
+				candidate("libssl3", "3.0.13-0ubuntu3", "noble", "", "main"),
+				candidate("libssl3", "3.0.13-0ubuntu3.1", "noble", "-updates", "main"),
+			},
+			Version:  "3.0.13-0ubuntu3",
+			Priority: 1001,
+		},
+		{
+			Name:          "target release",
+			TargetRelease: "noble",
+			Candidates: []*Candidate{
+				candidate("hello", "2.10-3", "noble", "", "main"),
+				candidate("hello", "2.10-3ubuntu1", "noble", "-updates", "main"),
+				candidate("hello", "2.10-4", "oracular", "", "main"),
+			},
+			// the codename of noble-updates is noble as well
+			Version:  "2.10-3ubuntu1",
+			Priority: TargetReleasePriority,
+		},
+		{
+			Name: "release with component",
+			Candidates: []*Candidate{
+				candidate("htop", "3.3.0-4", "noble", "", "universe"),
+				candidate("htop", "3.3.0-5", "oracular", "", "universe"),
+				candidate("htop", "3.3.0-4build1", "noble", "-updates", "main"),
+			},
+			Version:  "3.3.0-5",
+			Priority: DefaultPriority,
+		},
+		{
+			Name: "backports",
+			Candidates: []*Candidate{
+				candidate("hello", "2.10-3", "noble", "", "main"),
+				backports(candidate("hello", "2.10-5~bpo", "noble", "-backports", "main"), true),
+				backports(candidate("hello", "2.11-1", "experimental", "", "main"), false),
+			},
+			Version:  "2.10-3",
+			Priority: DefaultPriority,
+		},
+		{
+			Name: "only in backports",
+			Candidates: []*Candidate{
+				backports(candidate("hello", "2.10-5~bpo", "noble", "-backports", "main"), true),
+				backports(candidate("hello", "2.11-1", "experimental", "", "main"), false),
+			},
+			Version:  "2.10-5~bpo",
+			Priority: AutomaticUpgradesPriority,
+		},
+	}
+
+	for _, test := range tests {
+		selected, priority := Select(pins, test.TargetRelease, test.Candidates)
+		if selected == nil {
+			t.Errorf("%v: no version selected", test.Name)
+			continue
+		}
+		if selected.Version != test.Version || priority != test.Priority {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", test.Name, test.Version, test.Priority, selected.Version, priority)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, prefs := range []string{
+		"Package: curl\nPin-Priority: 100",
+		"Package: curl\nPin: release a=noble\nPin-Priority: high",
+		"Package: curl\nPin: madeup a=noble\nPin-Priority: 100",
+		"Package: curl\nPin: release o=Ubuntu\nPin-Priority: 100",
+		"Package: curl\nPin: release n=noble,l=Ubuntu\nPin-Priority: 100",
+	} {
+		_, err := Parse(prefs)
+		if err == nil {
+			t.Errorf("expected an error for %q", prefs)
+		}
+	}
+}
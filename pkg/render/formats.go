@@ -0,0 +1,159 @@
+package render
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	Register("json", JSON{}, "application/json")
+	Register("msgpack", Msgpack{}, "application/msgpack", "application/x-msgpack", "application/vnd.msgpack")
+	Register("cbor", CBOR{}, "application/cbor")
+	Register("csv", Table{"text/csv; charset=utf-8", ','}, "text/csv")
+	Register("tsv", Table{"text/tab-separated-values; charset=utf-8", '\t'}, "text/tab-separated-values")
+	Register("text", Text{}, "text/plain")
+	Register("html", HTML{}, "text/html")
+	Register("deb822", Deb822{})
+}
+
+// JSON encodes the value of the responses
+type JSON struct{}
+
+// ContentType implements Renderer
+func (JSON) ContentType() string { return "application/json" }
+
+// Render implements Renderer
+func (JSON) Render(w io.Writer, resp *Response) error {
+	return json.NewEncoder(w).Encode(resp.Value)
+}
+
+// Msgpack encodes the value of the responses, with the JSON field names
+type Msgpack struct{}
+
+// ContentType implements Renderer
+func (Msgpack) ContentType() string { return "application/msgpack" }
+
+// Render implements Renderer
+func (Msgpack) Render(w io.Writer, resp *Response) error {
+	encoder := msgpack.NewEncoder(w)
+	// use the same field names as JSON
+	encoder.SetCustomStructTag("json")
+	return encoder.Encode(resp.Value)
+}
+
+// CBOR encodes the value of the responses
+type CBOR struct{}
+
+// ContentType implements Renderer
+func (CBOR) ContentType() string { return "application/cbor" }
+
+// Render implements Renderer
+func (CBOR) Render(w io.Writer, resp *Response) error {
+	return cbor.NewEncoder(w).Encode(resp.Value)
+}
+
+// Table writes the table representation with a separator (CSV, TSV)
+type Table struct {
+	Type      string
+	Separator rune
+}
+
+// ContentType implements Renderer
+func (t Table) ContentType() string { return t.Type }
+
+// Render implements Renderer
+func (t Table) Render(w io.Writer, resp *Response) error {
+	if resp.Header == nil {
+		return ErrUnsupported
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = t.Separator
+	writer.Write(resp.Header)
+	return writer.WriteAll(resp.Records)
+}
+
+// Text writes the table representation with aligned columns
+type Text struct{}
+
+// ContentType implements Renderer
+func (Text) ContentType() string { return "text/plain; charset=utf-8" }
+
+// Render implements Renderer
+func (Text) Render(w io.Writer, resp *Response) error {
+	if resp.Header == nil {
+		return ErrUnsupported
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(resp.Header, "\t"))
+	for _, record := range resp.Records {
+		fmt.Fprintln(writer, strings.Join(record, "\t"))
+	}
+	return writer.Flush()
+}
+
+// HTML writes the table representation as an HTML table
+type HTML struct{}
+
+// ContentType implements Renderer
+func (HTML) ContentType() string { return "text/html; charset=utf-8" }
+
+// Render implements Renderer
+func (HTML) Render(w io.Writer, resp *Response) error {
+	if resp.Header == nil {
+		return ErrUnsupported
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("<!DOCTYPE html>\n<html><body><table>\n<tr>")
+	for _, column := range resp.Header {
+		fmt.Fprintf(buf, "<th>%v</th>", html.EscapeString(column))
+	}
+	buf.WriteString("</tr>\n")
+	for _, record := range resp.Records {
+		buf.WriteString("<tr>")
+		for _, value := range record {
+			fmt.Fprintf(buf, "<td>%v</td>", html.EscapeString(value))
+		}
+		buf.WriteString("</tr>\n")
+	}
+	buf.WriteString("</table></body></html>\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Deb822 writes packages as stanzas, like the Packages indexes
+type Deb822 struct{}
+
+// ContentType implements Renderer
+func (Deb822) ContentType() string { return "text/plain; charset=utf-8" }
+
+// Render implements Renderer
+func (Deb822) Render(w io.Writer, resp *Response) error {
+	if resp.Packages == nil {
+		return ErrUnsupported
+	}
+
+	for i, pkg := range resp.Packages {
+		if i != 0 {
+			io.WriteString(w, "\n")
+		}
+		err := pkg.WriteDeb822(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Package render writes the responses of the rmadison server in the
+// formats negotiated with the clients. Formats are registered by name (the
+// format query parameter) and media types (the Accept header), embedders
+// can register their own.
+package render
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+// ErrUnsupported is returned by the renderers that can't represent a
+// response, e.g. CSV for a single object
+var ErrUnsupported = errors.New("format not supported for this response")
+
+// Response is the result of a handler, each renderer uses the
+// representation it supports
+type Response struct {
+	// Value is encoded by the structured formats (JSON, msgpack, CBOR)
+	Value interface{}
+	// Header and Records are the table representation, used by CSV, TSV,
+	// text and HTML. They are optional.
+	Header  []string
+	Records [][]string
+	// Packages are set by the package lookups, for deb822
+	Packages []*debianpkg.PackageInfo
+}
+
+// Renderer writes a response in a format
+type Renderer interface {
+	ContentType() string
+	// Render returns ErrUnsupported if the response can't be represented
+	// in the format
+	Render(w io.Writer, resp *Response) error
+}
+
+// DefaultFormat is used when the client accepts anything
+const DefaultFormat = "json"
+
+var (
+	lock       sync.RWMutex
+	renderers  = map[string]Renderer{}
+	mediaTypes = map[string]string{}
+)
+
+// Register makes a renderer available as format, and for the media types
+// given. A format already registered is replaced.
+func Register(format string, renderer Renderer, types ...string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	renderers[format] = renderer
+	for _, mediaType := range types {
+		mediaTypes[strings.ToLower(mediaType)] = format
+	}
+}
+
+// Lookup returns the renderer registered for a format
+func Lookup(format string) (Renderer, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	renderer, ok := renderers[format]
+	return renderer, ok
+}
+
+// Formats returns the names of the registered formats
+func Formats() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	formats := make([]string, 0, len(renderers))
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	return formats
+}
+
+// Negotiate returns the registered formats accepted by an Accept header,
+// the preferred first
+func Negotiate(accept string) []string {
+	type accepted struct {
+		format string
+		q      float64
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	formats := make([]accepted, 0)
+	for _, value := range strings.Split(accept, ",") {
+		parts := strings.Split(value, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			key, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		format, ok := mediaTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			format, ok = DefaultFormat, true
+		}
+		if ok {
+			formats = append(formats, accepted{format, q})
+		}
+	}
+
+	// the order of the header breaks ties
+	sort.SliceStable(formats, func(i, j int) bool { return formats[i].q > formats[j].q })
+
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = format.format
+	}
+
+	return names
+}
@@ -0,0 +1,55 @@
+package render
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type yamlRenderer struct{}
+
+func (yamlRenderer) ContentType() string { return "application/yaml" }
+
+func (yamlRenderer) Render(w io.Writer, resp *Response) error {
+	_, err := io.WriteString(w, "name: hello\n")
+	return err
+}
+
+func TestNegotiate(t *testing.T) {
+	Register("yaml", yamlRenderer{}, "application/yaml")
+
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{"", []string{}},
+		{"application/json", []string{"json"}},
+		{"text/csv;q=0.5, application/msgpack", []string{"msgpack", "csv"}},
+		{"text/html, */*;q=0.1", []string{"html", "json"}},
+		{"image/png, text/plain;q=0", []string{}},
+		{"application/yaml", []string{"yaml"}},
+	}
+
+	for _, test := range tests {
+		got := Negotiate(test.accept)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Negotiate(%q) = %v, expected %v", test.accept, got, test.want)
+		}
+	}
+
+	renderer, ok := Lookup("yaml")
+	if !ok {
+		t.Fatal("yaml renderer not registered")
+	}
+	buf := new(bytes.Buffer)
+	renderer.Render(buf, &Response{})
+	if buf.String() != "name: hello\n" {
+		t.Errorf("unexpected yaml output %q", buf.String())
+	}
+
+	err := Text{}.Render(buf, &Response{Value: 1})
+	if err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported for a value without table, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package resolver answers package lookups from a snapshot of the index
+// exported by the rmadison server (see /api/snapshot), without any server. It
+// lets build tools ship a snapshot and resolve versions offline.
+package resolver
+
+import (
+	"context"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+	"github.com/gjolly/go-rmadison/pkg/version"
+
+	// snapshots are SQLite databases
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Resolver looks up packages in a snapshot
+type Resolver struct {
+	db *database.DB
+}
+
+// New opens the snapshot at dbPath
+func New(dbPath string) (*Resolver, error) {
+	db, err := database.OpenSnapshot("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{db}, nil
+}
+
+// Close closes the snapshot
+func (r *Resolver) Close() error {
+	return r.db.Close()
+}
+
+// Lookup returns the package in all the suites, components and
+// architectures of the snapshot
+func (r *Resolver) Lookup(name string) ([]*debianpkg.PackageInfo, error) {
+	return r.db.GetPackage(name)
+}
+
+// BuiltFrom returns the binary packages built from a source package,
+// whatever its version
+func (r *Resolver) BuiltFrom(source string) ([]*debianpkg.PackageInfo, error) {
+	return r.db.GetPackagesBySource(source)
+}
+
+// Resolve returns the version of the package in a suite (e.g.
+// noble-updates) for an architecture, or nil if it's not published there.
+// When several components ship it, the highest version is returned.
+func (r *Resolver) Resolve(name, suite, arch string) (*debianpkg.PackageInfo, error) {
+	pkgs, err := r.db.GetPackage(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *debianpkg.PackageInfo
+	for _, pkg := range pkgs {
+		if pkg.Suite+pkg.Pocket != suite || pkg.Architecture != arch {
+			continue
+		}
+		if found == nil || version.Compare(pkg.Version, found.Version) > 0 {
+			found = pkg
+		}
+	}
+
+	return found, nil
+}
+
+// ForEachPackage calls fn for every package of the snapshot matching the
+// filter
+func (r *Resolver) ForEachPackage(filter database.Filter, fn func(*debianpkg.PackageInfo) error) error {
+	return r.db.ForEachPackage(filter, fn)
+}
+
+// Generation returns the generation of the packages of the snapshot
+func (r *Resolver) Generation() (int64, error) {
+	return r.db.Generation()
+}
+
+// ScanAll calls fn for every package of the snapshot matching the filter,
+// see database.DB.ScanAll
+func (r *Resolver) ScanAll(ctx context.Context, generation int64, filter database.Filter, fn func(*debianpkg.PackageInfo) error) error {
+	return r.db.ScanAll(ctx, generation, filter, fn)
+}
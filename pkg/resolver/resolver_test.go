@@ -0,0 +1,71 @@
+package resolver
+
+import (
+	"path"
+	"testing"
+
+	"github.com/gjolly/go-rmadison/pkg/database"
+	"github.com/gjolly/go-rmadison/pkg/debianpkg"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewConn("sqlite3", path.Join(dir, "archive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pkgs := []*debianpkg.PackageInfo{
+		{Name: "hello", Version: "2.10-3", Component: "main", Suite: "noble", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-3ubuntu1", Component: "main", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-3ubuntu2", Component: "universe", Suite: "noble", Pocket: "-updates", Architecture: "amd64"},
+	}
+	for _, pkg := range pkgs {
+		err = db.PrepareInsertPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.InsertPrepared()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := path.Join(dir, "snapshot.db")
+	err = db.Snapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		Suite   string
+		Arch    string
+		Version string
+	}{
+		{"noble", "amd64", "2.10-3"},
+		{"noble-updates", "amd64", "2.10-3ubuntu2"},
+		{"noble", "arm64", ""},
+	}
+
+	for _, test := range tests {
+		pkg, err := r.Resolve("hello", test.Suite, test.Arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version := ""
+		if pkg != nil {
+			version = pkg.Version
+		}
+		if version != test.Version {
+			t.Errorf("hello in %v/%v: expected %q, got %q", test.Suite, test.Arch, test.Version, version)
+		}
+	}
+}
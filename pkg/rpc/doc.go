@@ -0,0 +1,5 @@
+// Package rpc contains the gRPC service of rmadison-server, generated from
+// rmadison.proto
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rmadison.proto
@@ -0,0 +1,310 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: rmadison.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Rmadison_Lookup_FullMethodName       = "/rmadison.v1.Rmadison/Lookup"
+	Rmadison_Search_FullMethodName       = "/rmadison.v1.Rmadison/Search"
+	Rmadison_Dump_FullMethodName         = "/rmadison.v1.Rmadison/Dump"
+	Rmadison_WatchUpdates_FullMethodName = "/rmadison.v1.Rmadison/WatchUpdates"
+)
+
+// RmadisonClient is the client API for Rmadison service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RmadisonClient interface {
+	// Lookup returns the packages with the given names
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+	// Search streams the packages whose name matches a glob pattern
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (Rmadison_SearchClient, error)
+	// Dump streams all the packages matching the filter
+	Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (Rmadison_DumpClient, error)
+	// WatchUpdates streams the packages added or updated by the refreshes
+	WatchUpdates(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Rmadison_WatchUpdatesClient, error)
+}
+
+type rmadisonClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRmadisonClient(cc grpc.ClientConnInterface) RmadisonClient {
+	return &rmadisonClient{cc}
+}
+
+func (c *rmadisonClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, Rmadison_Lookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rmadisonClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (Rmadison_SearchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Rmadison_ServiceDesc.Streams[0], Rmadison_Search_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rmadisonSearchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Rmadison_SearchClient interface {
+	Recv() (*Package, error)
+	grpc.ClientStream
+}
+
+type rmadisonSearchClient struct {
+	grpc.ClientStream
+}
+
+func (x *rmadisonSearchClient) Recv() (*Package, error) {
+	m := new(Package)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rmadisonClient) Dump(ctx context.Context, in *DumpRequest, opts ...grpc.CallOption) (Rmadison_DumpClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Rmadison_ServiceDesc.Streams[1], Rmadison_Dump_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rmadisonDumpClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Rmadison_DumpClient interface {
+	Recv() (*Package, error)
+	grpc.ClientStream
+}
+
+type rmadisonDumpClient struct {
+	grpc.ClientStream
+}
+
+func (x *rmadisonDumpClient) Recv() (*Package, error) {
+	m := new(Package)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *rmadisonClient) WatchUpdates(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Rmadison_WatchUpdatesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Rmadison_ServiceDesc.Streams[2], Rmadison_WatchUpdates_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &rmadisonWatchUpdatesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Rmadison_WatchUpdatesClient interface {
+	Recv() (*PackageChange, error)
+	grpc.ClientStream
+}
+
+type rmadisonWatchUpdatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *rmadisonWatchUpdatesClient) Recv() (*PackageChange, error) {
+	m := new(PackageChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RmadisonServer is the server API for Rmadison service.
+// All implementations must embed UnimplementedRmadisonServer
+// for forward compatibility
+type RmadisonServer interface {
+	// Lookup returns the packages with the given names
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	// Search streams the packages whose name matches a glob pattern
+	Search(*SearchRequest, Rmadison_SearchServer) error
+	// Dump streams all the packages matching the filter
+	Dump(*DumpRequest, Rmadison_DumpServer) error
+	// WatchUpdates streams the packages added or updated by the refreshes
+	WatchUpdates(*WatchRequest, Rmadison_WatchUpdatesServer) error
+	mustEmbedUnimplementedRmadisonServer()
+}
+
+// UnimplementedRmadisonServer must be embedded to have forward compatible implementations.
+type UnimplementedRmadisonServer struct {
+}
+
+func (UnimplementedRmadisonServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedRmadisonServer) Search(*SearchRequest, Rmadison_SearchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedRmadisonServer) Dump(*DumpRequest, Rmadison_DumpServer) error {
+	return status.Errorf(codes.Unimplemented, "method Dump not implemented")
+}
+func (UnimplementedRmadisonServer) WatchUpdates(*WatchRequest, Rmadison_WatchUpdatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchUpdates not implemented")
+}
+func (UnimplementedRmadisonServer) mustEmbedUnimplementedRmadisonServer() {}
+
+// UnsafeRmadisonServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RmadisonServer will
+// result in compilation errors.
+type UnsafeRmadisonServer interface {
+	mustEmbedUnimplementedRmadisonServer()
+}
+
+func RegisterRmadisonServer(s grpc.ServiceRegistrar, srv RmadisonServer) {
+	s.RegisterService(&Rmadison_ServiceDesc, srv)
+}
+
+func _Rmadison_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RmadisonServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Rmadison_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RmadisonServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Rmadison_Search_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RmadisonServer).Search(m, &rmadisonSearchServer{stream})
+}
+
+type Rmadison_SearchServer interface {
+	Send(*Package) error
+	grpc.ServerStream
+}
+
+type rmadisonSearchServer struct {
+	grpc.ServerStream
+}
+
+func (x *rmadisonSearchServer) Send(m *Package) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Rmadison_Dump_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DumpRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RmadisonServer).Dump(m, &rmadisonDumpServer{stream})
+}
+
+type Rmadison_DumpServer interface {
+	Send(*Package) error
+	grpc.ServerStream
+}
+
+type rmadisonDumpServer struct {
+	grpc.ServerStream
+}
+
+func (x *rmadisonDumpServer) Send(m *Package) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Rmadison_WatchUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RmadisonServer).WatchUpdates(m, &rmadisonWatchUpdatesServer{stream})
+}
+
+type Rmadison_WatchUpdatesServer interface {
+	Send(*PackageChange) error
+	grpc.ServerStream
+}
+
+type rmadisonWatchUpdatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *rmadisonWatchUpdatesServer) Send(m *PackageChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Rmadison_ServiceDesc is the grpc.ServiceDesc for Rmadison service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Rmadison_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rmadison.v1.Rmadison",
+	HandlerType: (*RmadisonServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _Rmadison_Lookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Search",
+			Handler:       _Rmadison_Search_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Dump",
+			Handler:       _Rmadison_Dump_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchUpdates",
+			Handler:       _Rmadison_WatchUpdates_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rmadison.proto",
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AccessLogConfig configures the access log
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SamplePaths maps a path prefix to N: only one request out of N
+	// matching the prefix is logged. The longest matching prefix wins.
+	// Requests that fail (status >= 500) are always logged.
+	SamplePaths map[string]uint64 `yaml:"sample_paths"`
+}
+
+type accessLogger struct {
+	next     http.Handler
+	conf     AccessLogConfig
+	logger   *zap.SugaredLogger
+	counters map[string]*uint64
+}
+
+// AccessLog returns a middleware logging every request, with the request
+// logger or logger if there is none
+func AccessLog(conf AccessLogConfig, logger *zap.SugaredLogger) Middleware {
+	return func(next http.Handler) http.Handler {
+		if !conf.Enabled {
+			return next
+		}
+
+		counters := make(map[string]*uint64, len(conf.SamplePaths))
+		for prefix := range conf.SamplePaths {
+			counters[prefix] = new(uint64)
+		}
+
+		return &accessLogger{
+			next:     next,
+			conf:     conf,
+			logger:   logger,
+			counters: counters,
+		}
+	}
+}
+
+// sampled returns true if the request for this path should be logged
+func (l *accessLogger) sampled(path string) bool {
+	prefix := ""
+	found := false
+	for p := range l.conf.SamplePaths {
+		if strings.HasPrefix(path, p) && len(p) >= len(prefix) {
+			prefix = p
+			found = true
+		}
+	}
+	if !found || l.conf.SamplePaths[prefix] <= 1 {
+		return true
+	}
+
+	n := atomic.AddUint64(l.counters[prefix], 1)
+	return n%l.conf.SamplePaths[prefix] == 1
+}
+
+func (l *accessLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	sw := NewStatusWriter(w)
+
+	l.next.ServeHTTP(sw, r)
+
+	if sw.Status() < http.StatusInternalServerError && !l.sampled(r.URL.Path) {
+		return
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	RequestLogger(r, l.logger).Infow("request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", sw.Status(),
+		"latency", time.Now().Sub(now),
+		"bytes", sw.Bytes(),
+		"client_ip", clientIP,
+		"user_agent", r.UserAgent(),
+	)
+}
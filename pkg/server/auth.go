@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+type privilegedKey struct{}
+
+// Authorizer tells if the credentials of a request are privileged
+type Authorizer func(r *http.Request) bool
+
+// Auth returns a middleware marking the requests accepted by authorize as
+// privileged, see Privileged
+func Auth(authorize Authorizer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorize(r) {
+				r = r.WithContext(WithPrivileges(r.Context()))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithPrivileges returns a copy of ctx marked as privileged, for the
+// calls authenticated outside of Auth (e.g. gRPC)
+func WithPrivileges(ctx context.Context) context.Context {
+	return context.WithValue(ctx, privilegedKey{}, true)
+}
+
+// Privileged returns true for the contexts marked by Auth or
+// WithPrivileges
+func Privileged(ctx context.Context) bool {
+	privileged, _ := ctx.Value(privilegedKey{}).(bool)
+
+	return privileged
+}
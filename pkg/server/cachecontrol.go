@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CacheControlConfig is the max-age of the responses by class of endpoint,
+// e.g. "lookups": 5m. 0 forbids caching, the classes not configured have
+// no Cache-Control header.
+type CacheControlConfig map[string]time.Duration
+
+// cacheControl sets the Cache-Control and Expires headers of the
+// successful GET responses, so a CDN or a reverse proxy can serve the
+// repeated queries between two refreshes
+type cacheControl struct {
+	next    http.Handler
+	classes map[string]string
+	maxAge  map[string]time.Duration
+}
+
+// CacheControl returns the middleware adding the caching headers. classes
+// are the classes of the endpoints by path prefix (the longest prefix
+// wins, on whole path segments), the paths of the class "" are never
+// cached.
+func CacheControl(classes map[string]string, conf CacheControlConfig) (Middleware, error) {
+	known := make(map[string]bool)
+	for _, class := range classes {
+		known[class] = true
+	}
+	for class := range conf {
+		if class == "" || !known[class] {
+			return nil, fmt.Errorf("unknown endpoint class %q", class)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return &cacheControl{
+			next:    next,
+			classes: classes,
+			maxAge:  conf,
+		}
+	}, nil
+}
+
+// class returns the class of the longest prefix matching path
+func (c *cacheControl) class(path string) string {
+	prefix := ""
+	for p := range c.classes {
+		if matchPrefix(path, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+
+	return c.classes[prefix]
+}
+
+func (c *cacheControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	maxAge, ok := c.maxAge[c.class(r.URL.Path)]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	c.next.ServeHTTP(&cacheControlWriter{
+		ResponseWriter: w,
+		maxAge:         maxAge,
+		// the responses to requests with a token may not be the same
+		// for everyone
+		private: r.Header.Get("Authorization") != "",
+	}, r)
+}
+
+// cacheControlWriter adds the caching headers when the status is sent,
+// unless the handler set them
+type cacheControlWriter struct {
+	http.ResponseWriter
+
+	maxAge      time.Duration
+	private     bool
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setHeaders(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the flushing and deadline
+// methods of the original writer
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheControlWriter) setHeaders(status int) {
+	header := w.Header()
+	if header.Get("Cache-Control") != "" {
+		return
+	}
+
+	// errors are not cached, they may be transient
+	if status != http.StatusOK || w.maxAge <= 0 {
+		header.Set("Cache-Control", "no-store")
+		return
+	}
+
+	scope := "public"
+	if w.private {
+		scope = "private"
+	}
+	header.Set("Cache-Control", fmt.Sprintf("%v, max-age=%d", scope, int(w.maxAge.Seconds())))
+	header.Set("Expires", time.Now().Add(w.maxAge).UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lists the origins allowed to query the server from a browser
+type CORSConfig struct {
+	// AllowedOrigins are the origins (e.g. https://example.com) allowed,
+	// "*" allows all of them. CORS is disabled if empty.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedHeaders are the request headers allowed in addition to the
+	// simple ones
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// ExposedHeaders are the response headers readable by the scripts
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// MaxAge is how long the browsers cache the answers to the preflight
+	// requests
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// allowed returns the value of Access-Control-Allow-Origin for an origin,
+// "" if it's not allowed
+func (c CORSConfig) allowed(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// CORS returns a middleware adding the CORS headers to the responses to
+// the allowed origins and answering their preflight requests
+func CORS(conf CORSConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(conf.AllowedOrigins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			allowOrigin := conf.allowed(origin)
+			if origin == "" || allowOrigin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Set("Access-Control-Allow-Origin", allowOrigin)
+			if len(conf.ExposedHeaders) != 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(conf.ExposedHeaders, ", "))
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+			if len(conf.AllowedHeaders) != 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(conf.AllowedHeaders, ", "))
+			}
+			if conf.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(conf.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQueueTimeout is the maximum time a request waits for a slot when
+// QueueTimeout is not set
+const defaultQueueTimeout = 5 * time.Second
+
+// LoadConfig caps the number of requests processed concurrently
+type LoadConfig struct {
+	// MaxInFlight is the maximum number of requests processed at the same
+	// time, 0 disables the limit
+	MaxInFlight int `yaml:"max_in_flight"`
+	// MaxQueue is the maximum number of requests waiting for a slot, the
+	// others are rejected immediately
+	MaxQueue int `yaml:"max_queue"`
+	// QueueTimeout is the maximum time a request waits for a slot
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+// ErrOverloaded is returned by LoadShedder.Acquire when the request must
+// be rejected
+var ErrOverloaded = errors.New("server overloaded, retry later")
+
+// ErrorHandler answers a request with an error
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// LoadShedder rejects requests with a 503 when too many are in flight and
+// waiting, so expensive requests can't exhaust the resources of the
+// server
+type LoadShedder struct {
+	conf      LoadConfig
+	slots     chan struct{}
+	unlimited map[string]bool
+	onReject  ErrorHandler
+
+	queued uint64
+	shed   uint64
+}
+
+// NewLoadShedder returns a load shedder. The paths of unlimited are not
+// counted in the requests in flight (e.g. the event streams), onReject
+// answers the rejected requests (with a plain 503 if nil).
+func NewLoadShedder(conf LoadConfig, unlimited map[string]bool, onReject ErrorHandler) *LoadShedder {
+	if conf.QueueTimeout <= 0 {
+		conf.QueueTimeout = defaultQueueTimeout
+	}
+	if onReject == nil {
+		onReject = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			http.Error(w, err.Error(), status)
+		}
+	}
+
+	l := &LoadShedder{
+		conf:      conf,
+		unlimited: unlimited,
+		onReject:  onReject,
+	}
+	if conf.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, conf.MaxInFlight)
+	}
+
+	return l
+}
+
+// Enabled tells if the number of requests in flight is limited
+func (l *LoadShedder) Enabled() bool {
+	return l != nil && l.slots != nil
+}
+
+// Shed returns the number of requests rejected since the start
+func (l *LoadShedder) Shed() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&l.shed)
+}
+
+// Handler wraps next with the limits
+func (l *LoadShedder) Handler(next http.Handler) http.Handler {
+	if !l.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.unlimited[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := l.Acquire(r.Context())
+		if err == ErrOverloaded {
+			w.Header().Set("Retry-After", strconv.Itoa(int(l.conf.QueueTimeout.Seconds())+1))
+			l.onReject(w, r, http.StatusServiceUnavailable, err)
+		}
+		if err != nil {
+			return
+		}
+		defer l.Release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Acquire waits for a slot, freed by Release. It returns ErrOverloaded
+// when the queue is full or the wait too long, or the error of ctx.
+func (l *LoadShedder) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		// no slot available, wait in the queue if it's not full
+		if atomic.AddUint64(&l.queued, 1) > uint64(l.conf.MaxQueue) {
+			atomic.AddUint64(&l.queued, ^uint64(0))
+			atomic.AddUint64(&l.shed, 1)
+			return ErrOverloaded
+		}
+
+		timer := time.NewTimer(l.conf.QueueTimeout)
+		select {
+		case l.slots <- struct{}{}:
+			timer.Stop()
+			atomic.AddUint64(&l.queued, ^uint64(0))
+		case <-timer.C:
+			atomic.AddUint64(&l.queued, ^uint64(0))
+			atomic.AddUint64(&l.shed, 1)
+			return ErrOverloaded
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddUint64(&l.queued, ^uint64(0))
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Release frees the slot taken by Acquire
+func (l *LoadShedder) Release() {
+	<-l.slots
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StatusCount is the number of responses sent with a status and the time
+// spent answering them
+type StatusCount struct {
+	Status   int
+	Requests uint64
+	Duration time.Duration
+}
+
+// RequestMetrics counts the requests by status, the paths are not recorded
+// as they contain the package names
+type RequestMetrics struct {
+	lock   sync.Mutex
+	counts map[int]*StatusCount
+}
+
+// Middleware returns the middleware counting the requests
+func (m *RequestMetrics) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := NewStatusWriter(w)
+			defer func() {
+				if err := recover(); err != nil {
+					// the panic is answered by the recovery stage
+					if !sw.Written() {
+						sw.status = http.StatusInternalServerError
+					}
+					m.record(sw.Status(), time.Since(start))
+					panic(err)
+				}
+				m.record(sw.Status(), time.Since(start))
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+func (m *RequestMetrics) record(status int, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.counts == nil {
+		m.counts = make(map[int]*StatusCount)
+	}
+	count, ok := m.counts[status]
+	if !ok {
+		count = &StatusCount{Status: status}
+		m.counts[status] = count
+	}
+	count.Requests++
+	count.Duration += duration
+}
+
+// Counts returns the counters of each status, sorted by status
+func (m *RequestMetrics) Counts() []StatusCount {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counts := make([]StatusCount, 0, len(m.counts))
+	for _, count := range m.counts {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Status < counts[j].Status
+	})
+
+	return counts
+}
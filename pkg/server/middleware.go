@@ -0,0 +1,172 @@
+// Package server contains the building blocks of the HTTP middleware chain
+// of rmadison-server. The middleware are grouped in stages applied in a
+// fixed order, so programs embedding the server can insert their own (e.g.
+// a company-specific authentication) at a well defined place.
+package server
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// Stage is the place of a middleware in the chain, the stages are applied
+// in the order of their values: StageRecovery sees the requests first
+type Stage int
+
+const (
+	// StageRecovery turns the panics of the inner handlers into errors
+	StageRecovery Stage = iota
+	// StageRequestID assigns an ID (and a trace context) to each request
+	StageRequestID
+	// StageLogging logs the requests
+	StageLogging
+	// StageMetrics counts the requests and their latency
+	StageMetrics
+	// StageCORS answers the preflight requests of the browsers
+	StageCORS
+	// StageCache adds the caching headers to the responses
+	StageCache
+	// StageTimeout sets the deadlines of the requests
+	StageTimeout
+	// StageRateLimit rejects the requests when the server is overloaded
+	StageRateLimit
+	// StageAuth identifies the clients from their credentials
+	StageAuth
+)
+
+// Stack is an ordered middleware chain, the zero value is an empty stack
+type Stack struct {
+	stages map[Stage][]Middleware
+}
+
+// Use adds middleware to a stage. In a stage, the middleware added first
+// see the requests first.
+func (s *Stack) Use(stage Stage, middleware ...Middleware) {
+	if s.stages == nil {
+		s.stages = make(map[Stage][]Middleware)
+	}
+
+	s.stages[stage] = append(s.stages[stage], middleware...)
+}
+
+// Handler wraps next with the middleware of the stack
+func (s *Stack) Handler(next http.Handler) http.Handler {
+	handler := next
+	for stage := StageAuth; stage >= StageRecovery; stage-- {
+		middleware := s.stages[stage]
+		for i := len(middleware) - 1; i >= 0; i-- {
+			handler = middleware[i](handler)
+		}
+	}
+
+	return handler
+}
+
+// DefaultConfig configures the middleware of the default stack, the ones
+// left unset (nil) are skipped
+type DefaultConfig struct {
+	// Logger is the base of the request loggers
+	Logger *zap.SugaredLogger
+	// OnPanic answers the requests whose handler panicked
+	OnPanic      PanicHandler
+	AccessLog    AccessLogConfig
+	Metrics      *RequestMetrics
+	CORS         CORSConfig
+	CacheClasses map[string]string
+	CacheControl CacheControlConfig
+	Timeouts     *RouteTimeouts
+	Limiter      *LoadShedder
+	Authorize    Authorizer
+	Tracker      *RequestTracker
+}
+
+// NewDefaultStack returns the stack of rmadison-server, with one
+// middleware by stage. More can be added with Use.
+func NewDefaultStack(conf DefaultConfig) (*Stack, error) {
+	cacheControl, err := CacheControl(conf.CacheClasses, conf.CacheControl)
+	if err != nil {
+		return nil, err
+	}
+
+	stack := new(Stack)
+	stack.Use(StageRecovery, Recovery(conf.OnPanic))
+	stack.Use(StageRequestID, RequestID(conf.Logger), TraceContext)
+	stack.Use(StageLogging, AccessLog(conf.AccessLog, conf.Logger))
+	if conf.Metrics != nil {
+		stack.Use(StageMetrics, conf.Metrics.Middleware())
+	}
+	stack.Use(StageCORS, CORS(conf.CORS))
+	stack.Use(StageCache, cacheControl)
+	if conf.Timeouts != nil {
+		stack.Use(StageTimeout, conf.Timeouts.Handler)
+	}
+	if conf.Limiter != nil {
+		stack.Use(StageRateLimit, conf.Limiter.Handler)
+	}
+	if conf.Authorize != nil {
+		stack.Use(StageAuth, Auth(conf.Authorize))
+	}
+	if conf.Tracker != nil {
+		stack.Use(StageAuth, conf.Tracker.Track)
+	}
+
+	return stack, nil
+}
+
+// StatusWriter records the status and the size of a response
+type StatusWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int
+}
+
+// NewStatusWriter wraps w
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+func (w *StatusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the flushing and deadline
+// methods of the original writer
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Status returns the status sent, 200 if the handler sent nothing
+func (w *StatusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Written tells if the status was sent
+func (w *StatusWriter) Written() bool {
+	return w.status != 0
+}
+
+// Bytes returns the size of the body sent
+func (w *StatusWriter) Bytes() int {
+	return w.bytes
+}
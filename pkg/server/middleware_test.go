@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tag returns a middleware appending name to the X-Order header, before
+// calling the next handler
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestStack(t *testing.T) {
+	stack := new(Stack)
+	stack.Use(StageAuth, tag("auth"), tag("custom-auth"))
+	stack.Use(StageRecovery, tag("recovery"))
+	stack.Use(StageLogging, tag("logging"))
+	stack.Use(StageAuth, tag("last"))
+
+	w := httptest.NewRecorder()
+	stack.Handler(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	order := strings.Join(w.Header().Values("X-Order"), ",")
+	if order != "recovery,logging,auth,custom-auth,last" {
+		t.Errorf("unexpected order %v", order)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", w.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	handler := CORS(CORSConfig{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         time.Hour,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		status      int
+		allowOrigin string
+	}{
+		{"no origin", http.MethodGet, "", false, http.StatusTeapot, ""},
+		{"allowed", http.MethodGet, "https://example.com", false, http.StatusTeapot, "https://example.com"},
+		{"not allowed", http.MethodGet, "https://example.org", false, http.StatusTeapot, ""},
+		{"preflight", http.MethodOptions, "https://example.com", true, http.StatusNoContent, "https://example.com"},
+		{"options without preflight", http.MethodOptions, "https://example.com", false, http.StatusTeapot, "https://example.com"},
+		{"preflight not allowed", http.MethodOptions, "https://example.org", true, http.StatusTeapot, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/hello", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.preflight {
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status %v, got %v", test.name, test.status, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+			t.Errorf("%v: expected allowed origin %q, got %q", test.name, test.allowOrigin, got)
+		}
+		if test.status == http.StatusNoContent && w.Header().Get("Access-Control-Max-Age") != "3600" {
+			t.Errorf("%v: unexpected max age %q", test.name, w.Header().Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	metrics := new(RequestMetrics)
+	stack := new(Stack)
+	stack.Use(StageRecovery, Recovery(nil))
+	stack.Use(StageMetrics, metrics.Middleware())
+	handler := stack.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+	}))
+
+	for _, path := range []string{"/", "/panic", "/"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		expected := http.StatusOK
+		if path == "/panic" {
+			expected = http.StatusInternalServerError
+		}
+		if w.Code != expected {
+			t.Errorf("%v: expected %v, got %v", path, expected, w.Code)
+		}
+	}
+
+	counts := metrics.Counts()
+	if len(counts) != 2 || counts[0].Status != http.StatusOK || counts[0].Requests != 2 ||
+		counts[1].Status != http.StatusInternalServerError || counts[1].Requests != 1 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+func TestDefaultStack(t *testing.T) {
+	limiter := NewLoadShedder(LoadConfig{MaxInFlight: 1}, map[string]bool{"/events": true}, nil)
+	tracker := new(RequestTracker)
+	stack, err := NewDefaultStack(DefaultConfig{
+		Metrics:      new(RequestMetrics),
+		CacheClasses: map[string]string{"/": "lookups"},
+		CacheControl: CacheControlConfig{"lookups": time.Minute},
+		Timeouts:     NewRouteTimeouts(TimeoutsConfig{"/": time.Second}, nil),
+		Limiter:      limiter,
+		Authorize: func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		},
+		Tracker: tracker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := stack.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("the request has no deadline")
+		}
+		if _, ok := RequestTrace(r.Context()); !ok {
+			t.Error("the request has no trace")
+		}
+		if Privileged(r.Context()) {
+			w.Header().Set("X-Privileged", "1")
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		requestID     string
+		privileged    bool
+		cacheControl  string
+	}{
+		{"anonymous", "", "", false, "public, max-age=60"},
+		{"privileged", "Bearer secret", "", true, "private, max-age=60"},
+		{"wrong token", "Bearer wrong", "", false, "private, max-age=60"},
+		{"request ID", "", "abc-123", false, "public, max-age=60"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if test.requestID != "" {
+			r.Header.Set(RequestIDHeader, test.requestID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if privileged := w.Header().Get("X-Privileged") == "1"; privileged != test.privileged {
+			t.Errorf("%v: expected privileged %v, got %v", test.name, test.privileged, privileged)
+		}
+		if got := w.Header().Get("Cache-Control"); got != test.cacheControl {
+			t.Errorf("%v: expected Cache-Control %q, got %q", test.name, test.cacheControl, got)
+		}
+		id := w.Header().Get(RequestIDHeader)
+		if test.requestID != "" && id != test.requestID {
+			t.Errorf("%v: expected request ID %q, got %q", test.name, test.requestID, id)
+		}
+		if !ValidRequestID(id) {
+			t.Errorf("%v: invalid request ID %q", test.name, id)
+		}
+	}
+
+	// the tracker saw the requests leave
+	tracker.Wait()
+
+	if _, err := NewDefaultStack(DefaultConfig{CacheControl: CacheControlConfig{"lookups": time.Minute}}); err == nil {
+		t.Error("the unknown cache class was accepted")
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	limiter := NewLoadShedder(LoadConfig{MaxInFlight: 1, QueueTimeout: time.Millisecond}, map[string]bool{"/events": true}, nil)
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/hello", http.StatusServiceUnavailable},
+		{"/events", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%v: expected %v, got %v", test.path, test.status, w.Code)
+		}
+	}
+	if limiter.Shed() != 1 {
+		t.Errorf("expected 1 request shed, got %v", limiter.Shed())
+	}
+
+	limiter.Release()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 once the slot is free, got %v", w.Code)
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	timeouts := NewRouteTimeouts(TimeoutsConfig{
+		"/":           10 * time.Second,
+		"/api/search": time.Minute,
+		"/api/dump":   0,
+		"/admin/":     time.Hour,
+	}, TimeoutsConfig{"/api/dump": 10 * time.Minute})
+
+	tests := []struct {
+		path     string
+		expected time.Duration
+	}{
+		{"/bash", 10 * time.Second},
+		{"/api/search", time.Minute},
+		{"/api/search/estimate", time.Minute},
+		// not a sub-path of /api/search
+		{"/api/searchable", 10 * time.Second},
+		{"/api/dump", 10 * time.Minute},
+		{"/api/dumpling", 10 * time.Second},
+		{"/admin", time.Hour},
+		{"/admin/archives", time.Hour},
+		{"/administrator", 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := timeouts.Timeout(test.path); got != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.path, test.expected, got)
+		}
+	}
+}
+
+func TestCacheControlClasses(t *testing.T) {
+	cacheControl, err := CacheControl(map[string]string{
+		"/":          "lookups",
+		"/api/dump":  "dumps",
+		"/api/wait":  "",
+		"/admin/":    "admin",
+		"/api/stats": "status",
+	}, CacheControlConfig{"lookups": time.Minute, "dumps": time.Hour, "admin": 0, "status": time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := cacheControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/bash", "public, max-age=60"},
+		{"/api/dump", "public, max-age=3600"},
+		{"/api/dump/noble", "public, max-age=3600"},
+		// not a sub-path of /api/dump nor /api/wait
+		{"/api/dumpling", "public, max-age=60"},
+		{"/api/waiting", "public, max-age=60"},
+		{"/api/wait", ""},
+		{"/admin", "no-store"},
+		{"/admin/archives", "no-store"},
+		{"/administrator", "public, max-age=60"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if got := w.Header().Get("Cache-Control"); got != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.path, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+)
+
+// PanicHandler answers a request whose handler panicked, err is the value
+// given to panic
+type PanicHandler func(w http.ResponseWriter, r *http.Request, err interface{})
+
+// Recovery returns a middleware recovering from the panics of the
+// handlers, onPanic answers the request (with a plain 500 if nil). The
+// panics aborting the response on purpose (http.ErrAbortHandler) are not
+// recovered.
+func Recovery(onPanic PanicHandler) Middleware {
+	if onPanic == nil {
+		onPanic = func(w http.ResponseWriter, r *http.Request, err interface{}) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := NewStatusWriter(w)
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				if sw.Written() {
+					// the response is already partially sent, the
+					// connection is closed
+					onPanic(discardWriter{w.Header()}, r, err)
+					panic(http.ErrAbortHandler)
+				}
+
+				onPanic(w, r, err)
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// discardWriter lets a PanicHandler log a panic when the response can't
+// be replaced anymore
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w discardWriter) WriteHeader(int) {}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	traceKey
+)
+
+// RequestIDHeader is the header used to receive and return request IDs
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger returns the logger for this request, every line logged
+// with it carries the request ID. fallback is returned for the requests
+// without one (a no-op logger if nil).
+func RequestLogger(r *http.Request, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if logger, ok := r.Context().Value(loggerKey).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	if fallback == nil {
+		return zap.NewNop().Sugar()
+	}
+
+	return fallback
+}
+
+// randomHex returns n random bytes encoded in hexadecimal
+func randomHex(n int) string {
+	id := make([]byte, n)
+	_, err := rand.Read(id)
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// RandomID returns a random identifier for requests and jobs
+func RandomID() string {
+	return randomHex(8)
+}
+
+// ValidRequestID checks that a request ID sent by a client is safe to log
+// and to send back
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestID returns a middleware assigning an ID to each request (or
+// reusing the one sent by the client), returning it in the response
+// headers and attaching logger, with the ID, to the request
+func RequestID(logger *zap.SugaredLogger) Middleware {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !ValidRequestID(id) {
+				id = RandomID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+			ctx := context.WithValue(r.Context(), loggerKey, logger.With("request_id", id))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// traceparentRegexp matches a version 00 W3C traceparent header
+// see https://www.w3.org/TR/trace-context/#traceparent-header
+var traceparentRegexp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// Trace identifies the span of a request in a distributed trace
+type Trace struct {
+	TraceID  string
+	ParentID string
+	SpanID   string
+	Flags    string
+}
+
+// parseTraceparent reads the traceparent header sent by the client, or
+// starts a new trace if there is none or if it's invalid
+func parseTraceparent(header string) Trace {
+	matches := traceparentRegexp.FindStringSubmatch(header)
+	if matches == nil || matches[1] == "00000000000000000000000000000000" || matches[2] == "0000000000000000" {
+		return Trace{
+			TraceID: randomHex(16),
+			SpanID:  randomHex(8),
+			Flags:   "00",
+		}
+	}
+
+	return Trace{
+		TraceID:  matches[1],
+		ParentID: matches[2],
+		SpanID:   randomHex(8),
+		Flags:    matches[3],
+	}
+}
+
+// RequestTrace returns the trace context of the request
+func RequestTrace(ctx context.Context) (Trace, bool) {
+	trace, ok := ctx.Value(traceKey).(Trace)
+	return trace, ok
+}
+
+// TraceContext is a middleware joining the trace of the caller (W3C
+// traceparent header) and adding the trace and span IDs to the request
+// logger
+func TraceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := parseTraceparent(r.Header.Get("traceparent"))
+
+		logger := RequestLogger(r, nil).With("trace_id", trace.TraceID, "span_id", trace.SpanID)
+		if trace.ParentID != "" {
+			logger = logger.With("parent_id", trace.ParentID)
+		}
+
+		ctx := context.WithValue(r.Context(), traceKey, trace)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// StartSpan starts a child span of the request (e.g. for a DB query), the
+// returned function ends it and logs its duration with logger
+func StartSpan(r *http.Request, name string, logger *zap.SugaredLogger) func() {
+	trace, ok := RequestTrace(r.Context())
+	if !ok || logger == nil {
+		return func() {}
+	}
+
+	spanID := randomHex(8)
+	start := time.Now()
+
+	return func() {
+		logger.Debugw("span",
+			"name", name,
+			"trace_id", trace.TraceID,
+			"span_id", spanID,
+			"parent_id", trace.SpanID,
+			"duration", time.Now().Sub(start),
+		)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimeoutsConfig is the maximum duration of the responses by path prefix,
+// e.g. "/api/dump": 10m. 0 means no limit, for the streaming routes.
+type TimeoutsConfig map[string]time.Duration
+
+// RouteTimeouts sets the write deadline and the context deadline of each
+// request according to its route, instead of one server-wide timeout
+type RouteTimeouts struct {
+	timeouts map[string]time.Duration
+}
+
+// NewRouteTimeouts returns the timeouts of the routes, the ones of conf
+// override the defaults
+func NewRouteTimeouts(defaults, conf TimeoutsConfig) *RouteTimeouts {
+	timeouts := make(map[string]time.Duration, len(defaults)+len(conf))
+	for prefix, timeout := range defaults {
+		timeouts[prefix] = timeout
+	}
+	for prefix, timeout := range conf {
+		timeouts[prefix] = timeout
+	}
+
+	return &RouteTimeouts{timeouts: timeouts}
+}
+
+// matchPrefix tells if path is prefix or one of its sub-paths, a
+// trailing "/" in prefix is ignored ("/admin/" matches "/admin")
+func matchPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Timeout returns the timeout of the longest prefix matching path
+func (t *RouteTimeouts) Timeout(path string) time.Duration {
+	prefix := ""
+	for p := range t.timeouts {
+		if matchPrefix(path, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+
+	return t.timeouts[prefix]
+}
+
+// Handler wraps next with the timeouts
+func (t *RouteTimeouts) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := t.Timeout(r.URL.Path)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deadline := time.Now().Add(timeout)
+		err := http.NewResponseController(w).SetWriteDeadline(deadline)
+		if err != nil {
+			RequestLogger(r, nil).Debugf("cannot set write deadline: %v", err)
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RequestTracker counts the requests in progress by epoch, a new epoch
+// starts on each Wait. The zero value is ready to use.
+type RequestTracker struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	epoch  uint64
+	active map[uint64]int
+}
+
+// Track is the middleware counting the requests in progress
+func (t *RequestTracker) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer t.Leave(t.Enter())
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// init must be called with t.lock held
+func (t *RequestTracker) init() {
+	if t.cond == nil {
+		t.cond = sync.NewCond(&t.lock)
+		t.active = make(map[uint64]int)
+	}
+}
+
+// Enter records a request, Leave must be called with the epoch returned
+func (t *RequestTracker) Enter() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.init()
+	t.active[t.epoch]++
+
+	return t.epoch
+}
+
+// Leave records the end of a request
+func (t *RequestTracker) Leave(epoch uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.active[epoch]--
+	if t.active[epoch] == 0 {
+		delete(t.active, epoch)
+		t.cond.Broadcast()
+	}
+}
+
+// Wait returns once the requests started before the call are done
+func (t *RequestTracker) Wait() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.init()
+	last := t.epoch
+	t.epoch++
+	for {
+		pending := false
+		for epoch := range t.active {
+			if epoch <= last {
+				pending = true
+			}
+		}
+		if !pending {
+			return
+		}
+		t.cond.Wait()
+	}
+}
@@ -0,0 +1,84 @@
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// relations of the constraints, see deb-control(5). "<" and ">" are the
+// deprecated forms of "<=" and ">=".
+var relations = map[string]func(cmp int) bool{
+	"<<": func(cmp int) bool { return cmp < 0 },
+	"<=": func(cmp int) bool { return cmp <= 0 },
+	"=":  func(cmp int) bool { return cmp == 0 },
+	">=": func(cmp int) bool { return cmp >= 0 },
+	">>": func(cmp int) bool { return cmp > 0 },
+	"<":  func(cmp int) bool { return cmp <= 0 },
+	">":  func(cmp int) bool { return cmp >= 0 },
+}
+
+// Constraint is a relation to a version, e.g. ">= 1.2-3"
+type Constraint struct {
+	Relation string
+	Version  Version
+}
+
+// ParseConstraint parses a relation followed by a version, the parentheses
+// of the Depends fields are optional
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+	s = strings.TrimSpace(s)
+
+	relation := strings.TrimRight(s[:len(s)-len(strings.TrimLeft(s, "<>="))], " ")
+	if _, ok := relations[relation]; !ok {
+		return Constraint{}, fmt.Errorf("invalid relation in constraint %q", s)
+	}
+
+	v, err := Parse(s[len(relation):])
+	if err != nil {
+		return Constraint{}, err
+	}
+
+	return Constraint{Relation: relation, Version: v}, nil
+}
+
+// Satisfies returns true if v satisfies the constraint
+func (c Constraint) Satisfies(v Version) bool {
+	return relations[c.Relation](v.Compare(c.Version))
+}
+
+// String returns the constraint as written in the Depends fields, without
+// parentheses
+func (c Constraint) String() string {
+	return c.Relation + " " + c.Version.String()
+}
+
+// Constraints are satisfied when all of them are
+type Constraints []Constraint
+
+// ParseConstraints parses a comma separated list of constraints, e.g.
+// ">= 1.2, << 2"
+func ParseConstraints(s string) (Constraints, error) {
+	constraints := make(Constraints, 0)
+	for _, part := range strings.Split(s, ",") {
+		c, err := ParseConstraint(part)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+
+	return constraints, nil
+}
+
+// Satisfies returns true if v satisfies all the constraints
+func (c Constraints) Satisfies(v Version) bool {
+	for _, constraint := range c {
+		if !constraint.Satisfies(v) {
+			return false
+		}
+	}
+
+	return true
+}
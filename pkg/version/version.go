@@ -0,0 +1,212 @@
+// Package version parses and compares Debian package versions the same way
+// dpkg does (see deb-version(7)), and evaluates version constraints like
+// the ones of the Depends fields.
+package version
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Version is a Debian version: [epoch:]upstream_version[-debian_revision]
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// Parse parses a version, it fails on the same errors as dpkg. Like dpkg,
+// versions with unexpected characters (e.g. not starting with a digit) are
+// accepted.
+func Parse(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Version{}, fmt.Errorf("version string is empty")
+	}
+	if strings.ContainsAny(s, " \t\n") {
+		return Version{}, fmt.Errorf("version string %q has embedded spaces", s)
+	}
+
+	v := Version{}
+	if i := strings.Index(s, ":"); i != -1 {
+		epoch := s[:i]
+		if epoch == "" {
+			return Version{}, fmt.Errorf("epoch in version %q is empty", s)
+		}
+		var err error
+		v.Epoch, err = strconv.Atoi(epoch)
+		if err != nil || v.Epoch < 0 || strings.ContainsAny(epoch, "+-") {
+			return Version{}, fmt.Errorf("epoch in version %q is not a valid number", s)
+		}
+		if v.Epoch > math.MaxInt32 {
+			return Version{}, fmt.Errorf("epoch in version %q is too big", s)
+		}
+		s = s[i+1:]
+		if s == "" {
+			return Version{}, fmt.Errorf("nothing after colon in version number")
+		}
+	}
+
+	if i := strings.LastIndex(s, "-"); i != -1 {
+		v.Revision = s[i+1:]
+		s = s[:i]
+		if v.Revision == "" {
+			return Version{}, fmt.Errorf("revision number is empty")
+		}
+	}
+	if s == "" {
+		return Version{}, fmt.Errorf("upstream version is empty")
+	}
+	v.Upstream = s
+
+	return v, nil
+}
+
+// String returns the version as written in the control files, the epoch
+// is omitted when it's 0
+func (v Version) String() string {
+	s := v.Upstream
+	if v.Epoch != 0 {
+		s = strconv.Itoa(v.Epoch) + ":" + s
+	}
+	if v.Revision != "" {
+		s += "-" + v.Revision
+	}
+
+	return s
+}
+
+// Compare compares v with other, it returns a negative number if
+// v < other, 0 if they are equal and a positive number if v > other
+func (v Version) Compare(other Version) int {
+	if v.Epoch != other.Epoch {
+		if v.Epoch < other.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if cmp := comparePart(v.Upstream, other.Upstream); cmp != 0 {
+		return cmp
+	}
+
+	return comparePart(v.Revision, other.Revision)
+}
+
+// Compare compares two versions without validating them, it returns a
+// negative number if a < b, 0 if a == b and a positive number if a > b
+func Compare(a, b string) int {
+	aEpoch, aUpstream, aRevision := split(a)
+	bEpoch, bUpstream, bRevision := split(b)
+
+	if cmp := compareEpoch(aEpoch, bEpoch); cmp != 0 {
+		return cmp
+	}
+	if cmp := comparePart(aUpstream, bUpstream); cmp != 0 {
+		return cmp
+	}
+
+	return comparePart(aRevision, bRevision)
+}
+
+// split splits a Debian version into epoch, upstream version and revision
+func split(version string) (string, string, string) {
+	epoch := "0"
+	if i := strings.Index(version, ":"); i != -1 {
+		epoch = version[:i]
+		version = version[i+1:]
+	}
+
+	revision := ""
+	if i := strings.LastIndex(version, "-"); i != -1 {
+		revision = version[i+1:]
+		version = version[:i]
+	}
+
+	return epoch, version, revision
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// order returns the weight of a character in the non-digit part of a
+// version: ~ sorts before everything (even the end of the part), then
+// letters, then the other characters
+func order(c byte) int {
+	switch {
+	case isDigit(c):
+		return 0
+	case isLetter(c):
+		return int(c)
+	case c == '~':
+		return -1
+	case c != 0:
+		return int(c) + 256
+	}
+
+	return 0
+}
+
+// comparePart compares an upstream version or a revision the same way
+// dpkg does (see verrevcmp in dpkg's lib/dpkg/version.c)
+func comparePart(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			var ac, bc int
+			if i < len(a) {
+				ac = order(a[i])
+			}
+			if j < len(b) {
+				bc = order(b[j])
+			}
+			if ac != bc {
+				return ac - bc
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+
+	return 0
+}
+
+func compareEpoch(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,188 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	// most cases come from the tests of dpkg (lib/dpkg/t/t-version.c) and
+	// apt (test/libapt/compareversion_test.cc)
+	testTable := []struct {
+		A        string
+		B        string
+		Expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0+dfsg", -1},
+		{"1:1.0", "2.0", 1},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"2.39-0ubuntu8", "2.39-0ubuntu8.1", -1},
+		{"8.5.0-2ubuntu10.6", "8.5.0-2ubuntu10", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0-0", "1.0", 0},
+		{"0:0-0", "0:0-0", 0},
+		{"0:0-0", "0:0-1", -1},
+		{"0:0-1", "0:0-0", 1},
+		{"0:0.0-0", "0:0-0", 1},
+		{"1:0-0", "0:0-0", 1},
+		{"0:0", "0:0-0", 0},
+		{"0:0-0-0", "0:0-0", 1},
+		{"0:0:0-0", "0:0-0", 1},
+		{"0:09", "0:9", 0},
+		{"0:0.09", "0:0.9", 0},
+		{"0:0~", "0:0", -1},
+		{"0:0~~", "0:0~", -1},
+		{"0:0~~a", "0:0~~", 1},
+		{"0:0a", "0:0", 1},
+		{"0:0~a", "0:0~b", -1},
+		{"0:0a.0", "0:0+0", -1},
+		{"7.6p2-4", "7.6-0", 1},
+		{"1.0.3-3", "1.0-1", 1},
+		{"1.3", "1.2.2-2", 1},
+		{"1.3", "1.2.2", 1},
+		{"0-pre", "0-pre", 0},
+		{"0-pre", "0-pree", -1},
+		{"1.1.6r2-2", "1.1.6r-1", 1},
+		{"2.6b2-1", "2.6b-2", 1},
+		{"98.1p5-1", "98.1-pre2-b6-2", -1},
+		{"0.4a6-2", "0.4-1", 1},
+		{"1:3.0.5-2", "1:3.0.5.1", -1},
+		{"1:0.4", "10.3", 1},
+		{"1:1.25-4", "1:1.25-8", -1},
+		{"0:1.18.36", "1.18.36", 0},
+		{"1.18.36", "1.18.35", 1},
+		{"0:1.18.36", "1.18.35", 1},
+		{"9:1.18.36:5.4-20", "10:0.5.1-22", -1},
+		{"9:1.18.36:5.4-20", "9:1.18.36:5.5-1", -1},
+		{"9:1.18.36:5.4-20", "9:1.18.37:4.3-22", -1},
+		{"1.18.36-0.17.35-18", "1.18.36-19", 1},
+		{"1:1.2.13-3", "1:1.2.13-3.1", -1},
+		{"2.0.7pre1-4", "2.0.7r-1", -1},
+		{"0.5.0~git", "0.5.0~git2", -1},
+		{"2a", "21", -1},
+		{"1.2a+~bCd3", "1.2a++", -1},
+		{"1.2a+~bCd3", "1.2a+~", 1},
+		{"57:1.2.3abYZ+~-4-5", "57:1.2.3abYZ+~-4-5", 0},
+		{"7:1-a:b-5", "7:1-a:b-6", -1},
+		{"009ab5", "9ab5", 0},
+		{"00:1", "0:1", 0},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.A+"_"+testCase.B, func(t *testing.T) {
+			cmp := Compare(testCase.A, testCase.B)
+			if sign(cmp) != testCase.Expected {
+				t.Errorf("expected %v, got %v", testCase.Expected, cmp)
+			}
+
+			a, errA := Parse(testCase.A)
+			b, errB := Parse(testCase.B)
+			if errA != nil || errB != nil {
+				t.Fatalf("failed to parse the versions: %v, %v", errA, errB)
+			}
+			if sign(a.Compare(b)) != testCase.Expected {
+				t.Errorf("expected %v comparing the parsed versions, got %v", testCase.Expected, a.Compare(b))
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	testTable := []struct {
+		Version  string
+		Expected Version
+		Fails    bool
+	}{
+		{Version: "0:0-0", Expected: Version{0, "0", "0"}},
+		{Version: "0:0.0-0.0", Expected: Version{0, "0.0", "0.0"}},
+		{Version: "1:2.39-0ubuntu8", Expected: Version{1, "2.39", "0ubuntu8"}},
+		{Version: "1.0", Expected: Version{0, "1.0", ""}},
+		{Version: "0:1.2-3-4", Expected: Version{0, "1.2-3", "4"}},
+		{Version: "2:1:5-1", Expected: Version{2, "1:5", "1"}},
+		// accepted with a warning by dpkg
+		{Version: "0:abc3-0", Expected: Version{0, "abc3", "0"}},
+		{Version: "", Fails: true},
+		{Version: "0:", Fails: true},
+		{Version: ":1.0", Fails: true},
+		{Version: "a:0-0", Fails: true},
+		{Version: "-1:0-0", Fails: true},
+		{Version: "999999999999999999999:0", Fails: true},
+		{Version: "0:0 0-1", Fails: true},
+		{Version: "0:0-", Fails: true},
+		{Version: "0:-0", Fails: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.Version, func(t *testing.T) {
+			v, err := Parse(testCase.Version)
+			if testCase.Fails {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != testCase.Expected {
+				t.Errorf("expected %+v, got %+v", testCase.Expected, v)
+			}
+		})
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	testTable := []struct {
+		Constraints string
+		Version     string
+		Expected    bool
+	}{
+		{">= 1.2-3", "1.2-3", true},
+		{">= 1.2-3", "1.2-2", false},
+		{"<< 2", "1.9", true},
+		{"<< 2", "2", false},
+		{"<<2", "2~rc1", true},
+		{"(= 1:1.0)", "1:1.0-0", true},
+		{">> 1.0", "1.0", false},
+		{"<= 1.0", "1.0", true},
+		// deprecated relations
+		{"< 1.0", "1.0", true},
+		{"> 1.0", "1.0", true},
+		{">= 1.2, << 2", "1.5", true},
+		{">= 1.2, << 2", "2.0", false},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.Constraints+"_"+testCase.Version, func(t *testing.T) {
+			constraints, err := ParseConstraints(testCase.Constraints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := Parse(testCase.Version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if constraints.Satisfies(v) != testCase.Expected {
+				t.Errorf("expected %v", testCase.Expected)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", "1.0", "=> 1.0", ">=", "!= 1"} {
+		_, err := ParseConstraints(invalid)
+		if err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	if n > 0 {
+		return 1
+	}
+	return 0
+}